@@ -1,14 +1,55 @@
 package access_log
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// FormatApache logs in the extended Apache Combined Log Format
+	FormatApache = "apache"
+	// FormatJSON logs one JSON object per request
+	FormatJSON = "json"
+)
+
+var logFormat = FormatApache
+
+// outputMu serializes the writes of the log lines, so concurrent requests do not interleave them
+var outputMu sync.Mutex
+
+// writeLine writes the log line to the output with a single write, stdout if out is nil
+func writeLine(out io.Writer, line []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if out == nil {
+		out = os.Stdout
+	}
+	out.Write(line)
+}
+
+// SetFormat sets the format of the access log lines, apache or json
+func SetFormat(format string) error {
+	switch format {
+	case FormatApache, FormatJSON:
+		logFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unsupported access log format %q", format)
+	}
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -29,10 +70,36 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
-func AccessLogMiddleware(next http.Handler) http.Handler {
+type requestIdKey struct{}
+
+// RequestId returns the identifier assigned to the request by AccessLogMiddleware
+func RequestId(r *http.Request) string {
+	requestId, _ := r.Context().Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// GenerateRequestId generates a random request identifier, as returned in X-Amz-Request-Id
+func GenerateRequestId() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return strings.ToUpper(hex.EncodeToString(bytes))
+}
+
+// AccessLogMiddleware logs every request to out, or to stdout if out is nil
+func AccessLogMiddleware(next http.Handler, out io.Writer) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		// Assign request id, returned to the client and included in the log line
+		requestId := GenerateRequestId()
+		hostId := sha256.Sum256([]byte(requestId))
+		r = r.WithContext(context.WithValue(r.Context(), requestIdKey{}, requestId))
+		w.Header().Set("X-Amz-Request-Id", requestId)
+		w.Header().Set("X-Amz-Id-2", base64.StdEncoding.EncodeToString(hostId[:]))
+		// Date of every response, which net/http adds only when serving through http.Server
+		w.Header().Set("Date", start.UTC().Format(http.TimeFormat))
+		AddLogContext(r, "request-id:%s", requestId)
+
 		// Wrap the ResponseWriter to capture status code and response size
 		wrapped := &responseWriter{
 			ResponseWriter: w,
@@ -46,12 +113,68 @@ func AccessLogMiddleware(next http.Handler) http.Handler {
 		// Calculate duration
 		duration := time.Since(start)
 
-		// Log in Apache Common Log Format with context
-		logApacheFormat(r, wrapped.statusCode, wrapped.size, duration)
+		if logFormat == FormatJSON {
+			logJSONFormat(out, r, wrapped.statusCode, wrapped.size, duration)
+		} else {
+			// Log in Apache Common Log Format with context
+			logApacheFormat(out, r, wrapped.statusCode, wrapped.size, duration)
+		}
 	})
 }
 
-func logApacheFormat(r *http.Request, statusCode int, responseSize int64, duration time.Duration) {
+// jsonLogLine is the access log line of the json format
+type jsonLogLine struct {
+	Time       string   `json:"time"`
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Query      string   `json:"query,omitempty"`
+	Proto      string   `json:"proto"`
+	Status     int      `json:"status"`
+	BytesIn    *int64   `json:"bytes_in"`
+	BytesOut   int64    `json:"bytes_out"`
+	DurationMs int64    `json:"duration_ms"`
+	ClientIP   string   `json:"client_ip"`
+	AccessKey  string   `json:"access_key,omitempty"`
+	UserAgent  string   `json:"user_agent,omitempty"`
+	Referer    string   `json:"referer,omitempty"`
+	RequestId  string   `json:"request_id"`
+	Context    []string `json:"context"`
+}
+
+func logJSONFormat(out io.Writer, r *http.Request, statusCode int, responseSize int64, duration time.Duration) {
+	line := jsonLogLine{
+		Time:       time.Now().Format(time.RFC3339Nano),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		Proto:      r.Proto,
+		Status:     statusCode,
+		BytesOut:   responseSize,
+		DurationMs: duration.Milliseconds(),
+		ClientIP:   getClientIP(r),
+		AccessKey:  getAccessKey(r),
+		UserAgent:  r.Header.Get("User-Agent"),
+		Referer:    r.Header.Get("Referer"),
+		RequestId:  RequestId(r),
+		Context:    r.Header.Values("X-Log"),
+	}
+
+	// Unknown size of the request body, like of chunked uploads, is logged as null
+	if r.ContentLength >= 0 {
+		line.BytesIn = &r.ContentLength
+	}
+	if line.Context == nil {
+		line.Context = []string{}
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	writeLine(out, append(data, '\n'))
+}
+
+func logApacheFormat(out io.Writer, r *http.Request, statusCode int, responseSize int64, duration time.Duration) {
 	// Extended Apache Common Log Format:
 	// remote_host - remote_user [timestamp] "request_line" status_code request_size/response_size "referer" "user_agent" duration_ms
 
@@ -65,18 +188,10 @@ func logApacheFormat(r *http.Request, statusCode int, responseSize int64, durati
 		requestSizeStr = strconv.FormatInt(requestContentLength, 10)
 	}
 
-	// Remote user (not available in basic setup, use -)
-	remoteUser := "-"
-
-	// Extract user from Authorization header if available
-	if auth := r.Header.Get("Authorization"); auth != "" {
-		if strings.HasPrefix(auth, "AWS ") {
-			// Extract access key as username
-			authParts := strings.SplitN(auth[4:], ":", 2)
-			if len(authParts) >= 1 && authParts[0] != "" {
-				remoteUser = authParts[0]
-			}
-		}
+	// Remote user is the access key the request was signed with, if any
+	remoteUser := getAccessKey(r)
+	if remoteUser == "" {
+		remoteUser = "-"
 	}
 
 	// Format timestamp [day/month/year:hour:minute:second zone]
@@ -123,8 +238,7 @@ func logApacheFormat(r *http.Request, statusCode int, responseSize int64, durati
 		contextInfo,
 	)
 
-	// Write to stdout
-	os.Stdout.WriteString(logLine)
+	writeLine(out, []byte(logLine))
 }
 
 // SetLogContext sets context information to be included in access logs via X-Log header
@@ -136,6 +250,32 @@ func AddLogContext(r *http.Request, context string, arg ...any) {
 	r.Header.Add("X-Log", fmt.Sprintf(context, arg...))
 }
 
+// getAccessKey returns the access key of the signature of the request, as given by the client,
+// from the Authorization header or the query of the presigned URL
+func getAccessKey(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "AWS "):
+		accessKey, _, _ := strings.Cut(auth[4:], ":")
+		return accessKey
+	case strings.HasPrefix(auth, "AWS4-HMAC-SHA256 "):
+		for _, part := range strings.Split(auth[len("AWS4-HMAC-SHA256 "):], ",") {
+			if credential, ok := strings.CutPrefix(strings.TrimSpace(part), "Credential="); ok {
+				accessKey, _, _ := strings.Cut(credential, "/")
+				return accessKey
+			}
+		}
+		return ""
+	}
+
+	query := r.URL.Query()
+	if credential := query.Get("X-Amz-Credential"); credential != "" {
+		accessKey, _, _ := strings.Cut(credential, "/")
+		return accessKey
+	}
+	return query.Get("AWSAccessKeyId")
+}
+
 func getClientIP(r *http.Request) string {
 	// Check for X-Forwarded-For header first (proxy/load balancer)
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
@@ -2,11 +2,16 @@ package access_log
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -240,7 +245,7 @@ func TestAccessLogMiddleware(t *testing.T) {
 				"X-Log": "operation=sync, bucket=test",
 			},
 			handlerStatusCode: 404,
-			expectedInLog:     []string{"GET /api HTTP/1.1", "404", "[operation=sync, bucket=test]"},
+			expectedInLog:     []string{"GET /api HTTP/1.1", "404", "operation=sync, bucket=test, request-id:"},
 		},
 		{
 			name:              "request with multiple X-Log headers",
@@ -268,7 +273,7 @@ func TestAccessLogMiddleware(t *testing.T) {
 				}
 			})
 
-			middleware := AccessLogMiddleware(handler)
+			middleware := AccessLogMiddleware(handler, nil)
 
 			req := httptest.NewRequest(tt.method, tt.path, nil)
 			for key, value := range tt.headers {
@@ -294,9 +299,14 @@ func TestAccessLogMiddleware(t *testing.T) {
 			}
 
 			if tt.name == "request with multiple X-Log headers" {
-				assert.Contains(t, logOutput, "[context1, context2]")
+				assert.Contains(t, logOutput, ", context1, context2]")
 			}
 
+			requestId := rec.Header().Get("X-Amz-Request-Id")
+			require.NotEmpty(t, requestId)
+			assert.NotEmpty(t, rec.Header().Get("X-Amz-Id-2"))
+			assert.Contains(t, logOutput, "request-id:"+requestId)
+
 			assert.Equal(t, tt.handlerStatusCode, rec.Code)
 			if tt.handlerResponse != "" {
 				assert.Equal(t, tt.handlerResponse, rec.Body.String())
@@ -304,3 +314,180 @@ func TestAccessLogMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestAccessLogMiddlewareJSON(t *testing.T) {
+	require.NoError(t, SetFormat(FormatJSON))
+	defer SetFormat(FormatApache)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddLogContext(r, "put:bucket/key")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+
+	req := httptest.NewRequest("PUT", "/bucket/key?tagging", strings.NewReader("content"))
+	req.RemoteAddr = "192.168.1.1:8080"
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=testkey/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc")
+	req.Header.Set("User-Agent", "test-client/1.0")
+	req.Header.Set("Referer", "http://example.com")
+
+	rec := httptest.NewRecorder()
+	AccessLogMiddleware(handler, nil).ServeHTTP(rec, req)
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+
+	requestId := rec.Header().Get("X-Amz-Request-Id")
+	assert.Equal(t, "PUT", line["method"])
+	assert.Equal(t, "/bucket/key", line["path"])
+	assert.Equal(t, "tagging", line["query"])
+	assert.Equal(t, float64(http.StatusCreated), line["status"])
+	assert.Equal(t, float64(7), line["bytes_in"])
+	assert.Equal(t, float64(7), line["bytes_out"])
+	assert.Contains(t, line, "duration_ms")
+	assert.Equal(t, "192.168.1.1", line["client_ip"])
+	assert.Equal(t, "testkey", line["access_key"])
+	assert.Equal(t, "test-client/1.0", line["user_agent"])
+	assert.Equal(t, "http://example.com", line["referer"])
+	assert.Equal(t, requestId, line["request_id"])
+	assert.Equal(t, []interface{}{"request-id:" + requestId, "put:bucket/key"}, line["context"])
+
+	assert.Error(t, SetFormat("xml"))
+}
+
+func TestGetAccessKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		auth     string
+		expected string
+	}{
+		{"signature v2", "/", "AWS testkey:signature", "testkey"},
+		{"signature v4", "/", "AWS4-HMAC-SHA256 Credential=testkey/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc", "testkey"},
+		{"presigned v2", "/?AWSAccessKeyId=testkey&Signature=abc", "", "testkey"},
+		{"presigned v4", "/?X-Amz-Credential=testkey%2F20240101%2Fus-east-1%2Fs3%2Faws4_request", "", "testkey"},
+		{"anonymous", "/", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			assert.Equal(t, tt.expected, getAccessKey(req))
+		})
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	var handlerRequestId string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestId = RequestId(r)
+		http.Error(w, "failed", http.StatusInternalServerError)
+	})
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	rec := httptest.NewRecorder()
+	AccessLogMiddleware(handler, nil).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotEmpty(t, handlerRequestId)
+	assert.Equal(t, handlerRequestId, rec.Header().Get("X-Amz-Request-Id"))
+	assert.NotEmpty(t, rec.Header().Get("X-Amz-Id-2"))
+	assert.Contains(t, buf.String(), "request-id:"+handlerRequestId)
+
+	date, err := http.ParseTime(rec.Header().Get("Date"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, time.Minute)
+	assert.True(t, strings.HasSuffix(rec.Header().Get("Date"), " GMT"))
+
+	assert.Empty(t, RequestId(httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestAccessLogMiddlewareWriter(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	var out bytes.Buffer
+	handler := AccessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}), &out)
+
+	// Concurrent requests write whole lines
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bucket/key", nil))
+		}()
+	}
+	wg.Wait()
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var stdout bytes.Buffer
+	io.Copy(&stdout, r)
+	assert.Empty(t, stdout.String(), "Access log should not be written to stdout")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 50)
+	for _, line := range lines {
+		assert.Regexp(t, `^192\.0\.2\.1 - - \[.*\] "GET /bucket/key HTTP/1\.1" 200 0/5 "-" "-" \d+ \[request-id:[0-9A-F]{16}\]$`, line)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	file, err := OpenFile(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	handler := AccessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), file)
+	request := func(target string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	request("/before-rotation")
+
+	// Rotated like logrotate does, the lines go to the moved file until it is reopened
+	require.NoError(t, os.Rename(path, path+".1"))
+	request("/before-reopen")
+	require.NoError(t, file.Reopen())
+	request("/after-reopen")
+
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Contains(t, string(rotated), "GET /before-rotation ")
+	assert.Contains(t, string(rotated), "GET /before-reopen ")
+	assert.NotContains(t, string(rotated), "GET /after-reopen ")
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(current), "\n"))
+	assert.Contains(t, string(current), "GET /after-reopen ")
+}
@@ -0,0 +1,54 @@
+package access_log
+
+import (
+	"os"
+	"sync"
+)
+
+// File is the access log file, which can be reopened after it was moved by log rotation
+type File struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenFile opens the file for appending, creating it if needed
+func OpenFile(path string) (*File, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, file: file}, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(p)
+}
+
+// Reopen opens the file at the path again, so the next lines are written to the new file
+// once the previous one was renamed. On failure the previous file is kept in use
+func (f *File) Reopen() error {
+	file, err := openLogFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file.Close()
+	f.file = file
+	return nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -108,6 +108,20 @@ func BenchmarkList(b *testing.B) {
 			}
 		})
 
+		b.Run("dir only with nested entries", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := cache.List("test-bucket/", "", true, 100)
+				require.NoError(b, err)
+			}
+		})
+
+		b.Run("dir only with invalid prefix", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := cache.List("test-bucket/folder-c/", "", true, 100)
+				require.NoError(b, err)
+			}
+		})
+
 		b.Run("traverse all", func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
 				marker := ""
@@ -191,3 +205,32 @@ func BenchmarkListDanglingDirs(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkInsertDirEntries compares uploads recording their parent directories, as the
+// server does, with inserting the files alone, in a flat and in a nested bucket
+func BenchmarkInsertDirEntries(b *testing.B) {
+	layouts := map[string]func(hex string) string{
+		"flat":   func(hex string) string { return "test-bucket/" + hex },
+		"nested": func(hex string) string { return fmt.Sprintf("test-bucket/%s/%s/%s", hex[0:2], hex[2:4], hex) },
+	}
+
+	for name, keyPath := range layouts {
+		for _, dirEntries := range []bool{true, false} {
+			b.Run(fmt.Sprintf("%s/dir entries %v", name, dirEntries), func(b *testing.B) {
+				forEachBenchmarkBackend(b, func(b *testing.B, cache Cache) {
+					now := time.Now().Unix()
+
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						entry := fs.EntryInfo{Path: keyPath(generateSHA256Hex()), Size: 1, LastModified: now, Processed: true}
+						entries := []fs.EntryInfo{entry}
+						if dirEntries {
+							entries = append(fs.BaseDirEntries(entry.Path), entry)
+						}
+						require.NoError(b, cache.Insert(entries...))
+					}
+				})
+			})
+		}
+	}
+}
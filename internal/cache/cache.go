@@ -1,17 +1,29 @@
 package cache
 
 import (
+	"database/sql"
+	"errors"
+
 	"s3-to-webdav/internal/fs"
 )
 
+// IsNotFound reports whether Stat failed as the path has no entry
+func IsNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
 type Cache interface {
 	Close() error
 	Optimise() error
+	Vacuum() error
 
 	Insert(objects ...fs.EntryInfo) error
 	List(prefix, marker string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error)
+	ListOwnedBy(owner, prefix, marker string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error)
 	Stat(path string) (fs.EntryInfo, error)
 	Delete(path string) error
+	// DeleteDir deletes the directory with all entries beneath it, returning their number
+	DeleteDir(prefix string) (int64, error)
 
 	GetStats(prefix string) (processed int, unprocessed int, totalSize int64, err error)
 
@@ -19,4 +31,16 @@ type Cache interface {
 	ListDanglingDirs(prefix string, limit int) ([]fs.EntryInfo, error)
 	DeleteDanglingFiles(prefix string) (int64, error)
 	SetProcessed(prefix string, recursive, processed bool) (int64, error)
+
+	SetAlias(path, target string) error
+	GetAlias(path string) (string, error)
+	// IsAliasTarget reports whether an alias resolves to the path
+	IsAliasTarget(path string) (bool, error)
+
+	SetTags(path string, tags map[string]string) error
+	GetTags(path string) (map[string]string, error)
+
+	AddBucket(name string) error
+	RemoveBucket(name string) error
+	ListBuckets() ([]string, error)
 }
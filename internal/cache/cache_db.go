@@ -2,7 +2,9 @@ package cache
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -12,15 +14,40 @@ import (
 	"s3-to-webdav/internal/fs"
 )
 
+const (
+	// DefaultBusyTimeout is how long a query waits for the lock of another connection
+	DefaultBusyTimeout = 5 * time.Second
+	// DefaultMaxOpenConns is the size of the connection pool
+	DefaultMaxOpenConns = 4
+)
+
+// Options configure the connections to the database
+type Options struct {
+	// BusyTimeout is how long a query waits for the lock of another connection,
+	// or process, before it fails with SQLITE_BUSY
+	BusyTimeout time.Duration
+	// MaxOpenConns limits the connections of the pool. Writes are serialized by the cache,
+	// so only reads run in parallel, and 1 serializes all queries
+	MaxOpenConns int
+}
+
 // cacheDB handles all database operations for the S3-to-WebDAV server
 type cacheDB struct {
 	db *sql.DB
 	mu sync.RWMutex
 }
 
-// NewCacheDB initializes a new database cache
+// NewCacheDB initializes a new database cache with the default options
 func NewCacheDB(dbPath string) (Cache, error) {
-	db, err := initDatabase(dbPath)
+	return NewCacheDBWithOptions(dbPath, Options{
+		BusyTimeout:  DefaultBusyTimeout,
+		MaxOpenConns: DefaultMaxOpenConns,
+	})
+}
+
+// NewCacheDBWithOptions initializes a new database cache
+func NewCacheDBWithOptions(dbPath string, options Options) (Cache, error) {
+	db, err := initDatabase(dbPath, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %v", err)
 	}
@@ -40,22 +67,38 @@ func (c *cacheDB) Close() error {
 	return nil
 }
 
+// connectionPragmas are set on every connection of the pool, as SQLite keeps them per connection
+var connectionPragmas = []string{
+	"synchronous(NORMAL)",
+	"cache_size(1000000)",
+	"temp_store(memory)",
+	"mmap_size(268435456)",
+	"foreign_keys(ON)",
+	"case_sensitive_like(ON)",
+}
+
 // initDatabase creates and configures the SQLite database
-func initDatabase(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+func initDatabase(dbPath string, options Options) (*sql.DB, error) {
+	query := url.Values{"_pragma": append([]string{
+		fmt.Sprintf("busy_timeout(%d)", options.BusyTimeout.Milliseconds()),
+	}, connectionPragmas...)}
+
+	db, err := sql.Open("sqlite", dbPath+"?"+query.Encode())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
-	// Enable performance optimizations
+	// Every connection to an in-memory database opens a database of its own
+	maxOpenConns := max(options.MaxOpenConns, 1)
+	if dbPath == ":memory:" {
+		maxOpenConns = 1
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+
+	// Enable performance optimizations, the journal mode is kept in the database file
 	pragmas := `
 	PRAGMA journal_mode = WAL;
-	PRAGMA synchronous = NORMAL;
-	PRAGMA cache_size = 1000000;
-	PRAGMA temp_store = memory;
-	PRAGMA mmap_size = 268435456;
-	PRAGMA foreign_keys = ON;
-	PRAGMA case_sensitive_like = ON;
 	PRAGMA optimize;
 	`
 	if _, err := db.Exec(pragmas); err != nil {
@@ -72,25 +115,154 @@ func initDatabase(dbPath string) (*sql.DB, error) {
 		last_modified INTEGER NOT NULL,
 		is_dir INTEGER NOT NULL,
 		updated_at INTEGER NOT NULL,
-		processed INTEGER NOT NULL
+		processed INTEGER NOT NULL,
+		owner TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '',
+		checksum TEXT NOT NULL DEFAULT '',
+		storage_class TEXT NOT NULL DEFAULT '',
+		md5 TEXT NOT NULL DEFAULT ''
+	);
+
+	-- Aliases map an object path to another object path
+	CREATE TABLE IF NOT EXISTS aliases (
+		path TEXT PRIMARY KEY,
+		target TEXT NOT NULL
+	);
+
+	-- Buckets created through the S3 API
+	CREATE TABLE IF NOT EXISTS buckets (
+		name TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL
 	);
 
 	-- Indexes for performance
 	CREATE INDEX IF NOT EXISTS idx_entries_path_dirname ON entries (rtrim(path, replace(path, '/', '')));
+	CREATE INDEX IF NOT EXISTS idx_entries_is_dir_path ON entries (is_dir, path);
+	CREATE INDEX IF NOT EXISTS idx_aliases_target ON aliases (target);
 	ANALYZE;
 	`
 
 	if _, err := db.Exec(schema); err != nil {
 		return nil, fmt.Errorf("failed to create schema: %v", err)
 	}
+
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate schema: %v", err)
+	}
 	return db, nil
 }
 
+// migrations upgrade the schema of databases created by older versions, in order.
+// The user_version of the database is the number of migrations applied to it, so new
+// columns are added by appending a migration, never by changing the existing ones
+var migrations = []func(tx *sql.Tx) error{
+	// Owner of the uploaded objects
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "entries", "owner", "TEXT NOT NULL DEFAULT ''")
+	},
+	// Tags of the objects
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "entries", "tags", "TEXT NOT NULL DEFAULT ''")
+	},
+	// Additional checksums given on upload
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "entries", "checksum", "TEXT NOT NULL DEFAULT ''")
+	},
+	// Storage classes given on upload
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "entries", "storage_class", "TEXT NOT NULL DEFAULT ''")
+	},
+	// MD5 of the content served as the ETag
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "entries", "md5", "TEXT NOT NULL DEFAULT ''")
+	},
+}
+
+// migrate applies the migrations missing in the database in a single transaction.
+// Databases created before the version was recorded start at 0, so every migration
+// must be safe to run again on a database that already has its change
+func migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Take the write lock up front, so concurrent upgrades of the same file wait for each other
+	if _, err := tx.Exec("UPDATE entries SET id = id WHERE 0"); err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := migrations[i](tx); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds the column to the table unless it already exists
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c *cacheDB) Optimise() error {
 	_, err := c.db.Exec("ANALYZE")
 	return err
 }
 
+// Vacuum rebuilds the database to return the pages freed by deletes to the filesystem,
+// holding the lock for its whole duration, as it rewrites every table
+func (c *cacheDB) Vacuum() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum: %v", err)
+	}
+	// The rebuilt database is written to the WAL first, so it shrinks only once checkpointed
+	if _, err := c.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint: %v", err)
+	}
+	return nil
+}
+
 // Insert inserts multiple objects in a single transaction
 func (c *cacheDB) Insert(objects ...fs.EntryInfo) error {
 	if len(objects) == 0 {
@@ -107,13 +279,26 @@ func (c *cacheDB) Insert(objects ...fs.EntryInfo) error {
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO entries (path, size, last_modified, is_dir, updated_at, processed)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO entries (path, size, last_modified, is_dir, updated_at, processed, owner, checksum, storage_class, md5)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT DO UPDATE SET
 			size = excluded.size,
 			is_dir = excluded.is_dir, updated_at = excluded.updated_at,
 			last_modified = MAX(excluded.last_modified, last_modified),
-			processed = MAX(excluded.processed, processed)
+			processed = MAX(excluded.processed, processed),
+			owner = COALESCE(NULLIF(excluded.owner, ''), owner),
+			checksum = CASE
+				WHEN excluded.checksum <> '' THEN excluded.checksum
+				WHEN excluded.size = size AND excluded.last_modified = last_modified THEN checksum
+				ELSE '' END,
+			storage_class = CASE
+				WHEN excluded.storage_class <> '' THEN excluded.storage_class
+				WHEN excluded.size = size AND excluded.last_modified = last_modified THEN storage_class
+				ELSE '' END,
+			md5 = CASE
+				WHEN excluded.md5 <> '' THEN excluded.md5
+				WHEN excluded.size = size AND excluded.last_modified = last_modified THEN md5
+				ELSE '' END
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %v", err)
@@ -137,7 +322,7 @@ func (c *cacheDB) Insert(objects ...fs.EntryInfo) error {
 		}
 
 		_, err := stmt.Exec(obj.Path, obj.Size,
-			obj.LastModified, obj.IsDir, now, obj.Processed)
+			obj.LastModified, obj.IsDir, now, obj.Processed, obj.Owner, obj.Checksum, obj.StorageClass, obj.MD5)
 		if err != nil {
 			return fmt.Errorf("failed to insert object %s: %v", obj.Path, err)
 		}
@@ -147,12 +332,12 @@ func (c *cacheDB) Insert(objects ...fs.EntryInfo) error {
 }
 
 func (c *cacheDB) scanEntry(scanner func(dest ...any) error) (fs.EntryInfo, error) {
-	var path string
-	var size, lastModified int64
+	var path, owner, checksum, storageClass, md5 string
+	var size, lastModified, updatedAt int64
 	var isDir, processed int
 
-	if err := scanner(&path, &size, &lastModified, &isDir, &processed); err != nil {
-		return fs.EntryInfo{}, fmt.Errorf("failed to scan row: %v", err)
+	if err := scanner(&path, &size, &lastModified, &isDir, &processed, &owner, &checksum, &storageClass, &md5, &updatedAt); err != nil {
+		return fs.EntryInfo{}, fmt.Errorf("failed to scan row: %w", err)
 	}
 
 	return fs.EntryInfo{
@@ -161,26 +346,35 @@ func (c *cacheDB) scanEntry(scanner func(dest ...any) error) (fs.EntryInfo, erro
 		LastModified: lastModified,
 		IsDir:        isDir == 1,
 		Processed:    processed == 1,
+		Owner:        owner,
+		Checksum:     checksum,
+		StorageClass: storageClass,
+		MD5:          md5,
+		UpdatedAt:    updatedAt,
 	}, nil
 }
 
+// entryColumns are the columns read by scanEntry
+const entryColumns = "path, size, last_modified, is_dir, processed, owner, checksum, storage_class, md5, updated_at"
+
 func (c *cacheDB) findObject(where string, args ...any) (fs.EntryInfo, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	row := c.db.QueryRow(`
-		SELECT path, size, last_modified, is_dir, processed
-		FROM entries WHERE `+where, args...)
+	row := c.db.QueryRow(`SELECT `+entryColumns+` FROM entries WHERE `+where, args...)
 	return c.scanEntry(row.Scan)
 }
 
 func (c *cacheDB) findObjects(where string, args ...any) ([]fs.EntryInfo, error) {
+	return c.queryObjects(`SELECT `+entryColumns+` FROM entries WHERE `+where, args...)
+}
+
+// queryObjects runs the query selecting entryColumns
+func (c *cacheDB) queryObjects(query string, args ...any) ([]fs.EntryInfo, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	rows, err := c.db.Query(`
-		SELECT path, size, last_modified, is_dir, processed
-		FROM entries WHERE `+where, args...)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query objects: %v", err)
 	}
@@ -200,45 +394,93 @@ func (c *cacheDB) findObjects(where string, args ...any) ([]fs.EntryInfo, error)
 }
 
 // List retrieves objects from a bucket with optional prefix and marker
+// The prefix either names a directory (ending with '/') or is matched as a plain string prefix
 // Returns objects up to the specified limit, ordered by path
 // Also returns whether results were truncated
 func (c *cacheDB) List(prefix, marker string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error) {
+	return c.list(prefix, marker, "", dirOnly, limit)
+}
+
+// ListOwnedBy works like List, but only returns files uploaded by the owner
+// Directories are not filtered, as they have no owner
+func (c *cacheDB) ListOwnedBy(owner, prefix, marker string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error) {
+	if owner == "" {
+		return nil, false, fmt.Errorf("owner cannot be empty")
+	}
+	return c.list(prefix, marker, owner, dirOnly, limit)
+}
+
+func (c *cacheDB) list(prefix, marker, owner string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error) {
 	if strings.HasPrefix(prefix, "/") {
 		return nil, false, fmt.Errorf("prefix cannot start with '/': %s", prefix)
 	}
-	if !strings.HasSuffix(prefix, "/") && prefix != "" {
-		return nil, false, fmt.Errorf("prefix must end with '/' if not empty: %s", prefix)
-	}
 	if strings.HasPrefix(marker, "/") {
 		return nil, false, fmt.Errorf("marker cannot start with '/': %s", marker)
 	}
 
-	// Base query
-	query := "1=1"
+	// Range of paths under the prefix, after the marker
+	where := "1=1"
 	args := []interface{}{}
 
 	if marker != "" {
-		query += " AND path > ?"
+		where += " AND path > ?"
 		args = append(args, marker)
 	}
 
-	if prefix != "" {
-		query += " AND path > ? AND path < ?"
+	if strings.HasSuffix(prefix, "/") {
+		// Directory prefix, excluding the directory itself, so only the paths under it
+		where += " AND path > ? AND path < ?"
+		args = append(args, prefix, prefix+"\xFF")
+	} else if prefix != "" {
+		// Arbitrary prefix, matching any path starting with it like S3 does,
+		// so the prefix a/file matches the key a/file, but also a/file2 and a/files/
+		where += " AND path >= ? AND path < ?"
 		args = append(args, prefix, prefix+"\xFF")
 	}
 
+	ownerFilter := ""
+	if owner != "" {
+		ownerFilter = " AND owner = ?"
+	}
+
+	var query string
+	var queryArgs []interface{}
+
 	if dirOnly {
-		query += " AND rtrim(path, '/') NOT LIKE ?"
-		args = append(args, prefix+"%/%")
+		// Direct children of the directory containing the prefix: files found with
+		// the dirname index, and directories with no further '/' but the trailing one
+		parent := prefix[:strings.LastIndex(prefix, "/")+1]
+
+		query = `
+			SELECT ` + entryColumns + ` FROM entries
+			WHERE is_dir = 0 AND rtrim(path, replace(path, '/', '')) = ? AND ` + where + ownerFilter + `
+			UNION ALL
+			SELECT ` + entryColumns + ` FROM entries
+			WHERE is_dir = 1 AND ` + where + `
+				AND instr(substr(path, length(?) + 1), '/') = length(path) - length(?)
+			ORDER BY path LIMIT ?`
+
+		queryArgs = append(queryArgs, parent)
+		queryArgs = append(queryArgs, args...)
+		if owner != "" {
+			queryArgs = append(queryArgs, owner)
+		}
+		queryArgs = append(queryArgs, args...)
+		queryArgs = append(queryArgs, parent, parent)
 	} else {
-		query += " AND is_dir = 0"
+		query = `SELECT ` + entryColumns + ` FROM entries
+			WHERE is_dir = 0 AND ` + where + ownerFilter + ` ORDER BY path LIMIT ?`
+
+		queryArgs = append(queryArgs, args...)
+		if owner != "" {
+			queryArgs = append(queryArgs, owner)
+		}
 	}
 
 	// Query for limit+1 to determine if results are truncated
-	query += " ORDER BY path LIMIT ?"
-	args = append(args, limit+1)
+	queryArgs = append(queryArgs, limit+1)
 
-	files, err := c.findObjects(query, args...)
+	files, err := c.queryObjects(query, queryArgs...)
 	if err != nil {
 		return nil, false, fmt.Errorf("failed to query objects: %v", err)
 	}
@@ -316,9 +558,52 @@ func (c *cacheDB) Delete(path string) error {
 		return fmt.Errorf("multiple entries deleted for path: %s", path)
 	}
 
+	if _, err := tx.Exec("DELETE FROM aliases WHERE path = ?", path); err != nil {
+		return fmt.Errorf("failed to delete alias: %v", err)
+	}
+
 	return tx.Commit()
 }
 
+// DeleteDir deletes the directory, all entries beneath it and their aliases
+// in a single transaction, unlike Delete which refuses to remove the children
+func (c *cacheDB) DeleteDir(prefix string) (int64, error) {
+	if strings.HasPrefix(prefix, "/") {
+		return 0, fmt.Errorf("prefix cannot start with '/': %s", prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		return 0, fmt.Errorf("prefix must end with '/': %s", prefix)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Range of the directory and the paths under it, as LIKE would match _ and % too
+	result, err := tx.Exec("DELETE FROM entries WHERE path >= ? AND path < ?", prefix, prefix+"\xFF")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete entries: %v", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM aliases WHERE path > ? AND path < ?", prefix, prefix+"\xFF"); err != nil {
+		return 0, fmt.Errorf("failed to delete aliases: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
 // GetStats returns the number of processed and pending entries
 func (c *cacheDB) GetStats(prefix string) (processed int, pending int, totalSize int64, err error) {
 	if strings.HasPrefix(prefix, "/") {
@@ -388,3 +673,140 @@ func (c *cacheDB) SetProcessed(prefix string, recursive, processed bool) (int64,
 	}
 	return c.execSql("UPDATE entries SET processed = ? WHERE processed <> ? AND path = ?", processed, processed, prefix)
 }
+
+// SetAlias makes path resolve to target, an empty target removes the alias
+func (c *cacheDB) SetAlias(path, target string) error {
+	if strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return fmt.Errorf("alias path must be a file path: %s", path)
+	}
+	if strings.HasPrefix(target, "/") || strings.HasSuffix(target, "/") {
+		return fmt.Errorf("alias target must be a file path: %s", target)
+	}
+	if path == target {
+		return fmt.Errorf("alias cannot point to itself: %s", path)
+	}
+
+	if target == "" {
+		_, err := c.execSql("DELETE FROM aliases WHERE path = ?", path)
+		return err
+	}
+
+	_, err := c.execSql(`INSERT INTO aliases (path, target) VALUES (?, ?)
+		ON CONFLICT DO UPDATE SET target = excluded.target`, path, target)
+	return err
+}
+
+// GetAlias returns the target of the alias, or an empty string if path is not an alias
+func (c *cacheDB) GetAlias(path string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var target string
+	err := c.db.QueryRow("SELECT target FROM aliases WHERE path = ?", path).Scan(&target)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to query alias: %v", err)
+	}
+	return target, nil
+}
+
+// IsAliasTarget reports whether an alias resolves to the path
+func (c *cacheDB) IsAliasTarget(path string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var exists bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM aliases WHERE target = ?)", path).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to query alias target: %v", err)
+	}
+	return exists, nil
+}
+
+// SetTags replaces the tags of the file, an empty set removes them
+func (c *cacheDB) SetTags(path string, tags map[string]string) error {
+	if strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return fmt.Errorf("tagged path must be a file path: %s", path)
+	}
+
+	encoded := ""
+	if len(tags) > 0 {
+		data, err := json.Marshal(tags)
+		if err != nil {
+			return fmt.Errorf("failed to encode tags: %v", err)
+		}
+		encoded = string(data)
+	}
+
+	rowsAffected, err := c.execSql("UPDATE entries SET tags = ? WHERE path = ? AND is_dir = 0", encoded, path)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no entry found for path: %s", path)
+	}
+	return nil
+}
+
+// GetTags returns the tags of the file, or an empty set if it has none
+func (c *cacheDB) GetTags(path string) (map[string]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var encoded string
+	err := c.db.QueryRow("SELECT tags FROM entries WHERE path = ? AND is_dir = 0", path).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no entry found for path: %s", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %v", err)
+	}
+
+	tags := map[string]string{}
+	if encoded == "" {
+		return tags, nil
+	}
+	if err := json.Unmarshal([]byte(encoded), &tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %v", err)
+	}
+	return tags, nil
+}
+
+// AddBucket records the bucket created through the S3 API, adding it again is a no-op
+func (c *cacheDB) AddBucket(name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid bucket name: %s", name)
+	}
+
+	_, err := c.execSql("INSERT INTO buckets (name, created_at) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		name, time.Now().Unix())
+	return err
+}
+
+// RemoveBucket forgets the bucket created through the S3 API
+func (c *cacheDB) RemoveBucket(name string) error {
+	_, err := c.execSql("DELETE FROM buckets WHERE name = ?", name)
+	return err
+}
+
+// ListBuckets returns the buckets created through the S3 API, ordered by name
+func (c *cacheDB) ListBuckets() ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rows, err := c.db.Query("SELECT name FROM buckets ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query buckets: %v", err)
+	}
+	defer rows.Close()
+
+	var buckets []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan bucket: %v", err)
+		}
+		buckets = append(buckets, name)
+	}
+	return buckets, rows.Err()
+}
@@ -1,8 +1,10 @@
 package cache
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -105,6 +107,64 @@ func TestCacheList(t *testing.T) {
 			//assert.Equal(t, "aa", results)
 			assert.Equal(t, 5, len(results))
 		})
+
+		t.Run("List partial file name prefix", func(t *testing.T) {
+			results, truncated, err := cache.List("bucket-a/root-", "", false, 100)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			require.Equal(t, 1, len(results))
+			assert.Equal(t, "bucket-a/root-file.txt", results[0].Path)
+		})
+
+		t.Run("List partial directory name prefix", func(t *testing.T) {
+			results, truncated, err := cache.List("bucket-a/folder-a/ab", "", false, 100)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			assert.Equal(t, 2, len(results))
+		})
+
+		t.Run("List partial prefix dir only", func(t *testing.T) {
+			results, truncated, err := cache.List("bucket-a/folder-", "", true, 100)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			require.Equal(t, 4, len(results))
+			for _, result := range results {
+				assert.True(t, result.IsDir, "Expected directory, got %s", result.Path)
+			}
+		})
+
+		t.Run("List prefix matching nothing", func(t *testing.T) {
+			results, truncated, err := cache.List("bucket-a/zzz", "", false, 100)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			assert.Empty(t, results)
+		})
+
+		t.Run("List root dir only", func(t *testing.T) {
+			results, truncated, err := cache.List("", "", true, 100)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			assert.Equal(t, []string{"bucket-a/", "bucket-b/"}, entryPaths(results))
+		})
+
+		t.Run("List dir only skips nested entries without parent", func(t *testing.T) {
+			results, truncated, err := cache.List("bucket-b/", "", true, 100)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			assert.Equal(t, []string{"bucket-b/folder-a/"}, entryPaths(results))
+		})
+
+		t.Run("List dir only mixed files and dirs", func(t *testing.T) {
+			results, truncated, err := cache.List("bucket-a/", "bucket-a/folder-b/", true, 2)
+			require.NoError(t, err)
+			assert.True(t, truncated)
+			assert.Equal(t, []string{"bucket-a/folder-c/", "bucket-a/folder-d/"}, entryPaths(results))
+
+			results, truncated, err = cache.List("bucket-a/", "bucket-a/folder-d/", true, 2)
+			require.NoError(t, err)
+			assert.False(t, truncated)
+			assert.Equal(t, []string{"bucket-a/root-file.txt"}, entryPaths(results))
+		})
 	})
 }
 
@@ -207,6 +267,56 @@ func TestCacheDelete(t *testing.T) {
 	})
 }
 
+func TestCacheDeleteDir(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		require.NoError(t, cache.Insert(createFileObjects(dirStructure...)...))
+		require.NoError(t, cache.Insert(createFileObjects(fileStructure...)...))
+		require.NoError(t, cache.Insert(createFileObjects("bucket-a/dir_1/", "bucket-a/dir_1/file.txt", "bucket-a/dirX1/", "bucket-a/dirX1/file.txt")...))
+		require.NoError(t, cache.SetAlias("bucket-a/folder-a/alias.txt", "bucket-a/root-file.txt"))
+
+		all, _, err := cache.List("bucket-a/folder-a/", "", false, 1000)
+		require.NoError(t, err)
+		require.NotEmpty(t, all)
+
+		deleted, err := cache.DeleteDir("bucket-a/folder-a/")
+		require.NoError(t, err)
+		assert.Greater(t, deleted, int64(len(all)), "Directories should be deleted too")
+
+		_, err = cache.Stat("bucket-a/folder-a/")
+		assert.True(t, IsNotFound(err))
+		files, _, err := cache.List("bucket-a/folder-a/", "", false, 1000)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+
+		target, err := cache.GetAlias("bucket-a/folder-a/alias.txt")
+		require.NoError(t, err)
+		assert.Empty(t, target)
+
+		_, err = cache.Stat("bucket-a/root-file.txt")
+		assert.NoError(t, err)
+
+		t.Run("Prefix with wildcard characters", func(t *testing.T) {
+			_, err := cache.DeleteDir("bucket-a/dir_1/")
+			require.NoError(t, err)
+			_, err = cache.Stat("bucket-a/dir_1/file.txt")
+			assert.True(t, IsNotFound(err))
+			_, err = cache.Stat("bucket-a/dirX1/file.txt")
+			assert.NoError(t, err)
+		})
+
+		t.Run("Prefix without slash should fail", func(t *testing.T) {
+			_, err := cache.DeleteDir("bucket-a/folder-b")
+			assert.Error(t, err)
+		})
+
+		t.Run("Nonexistent directory", func(t *testing.T) {
+			deleted, err := cache.DeleteDir("bucket-a/missing/")
+			require.NoError(t, err)
+			assert.Zero(t, deleted)
+		})
+	})
+}
+
 func TestCacheStat(t *testing.T) {
 	forEachTestBackend(t, func(t *testing.T, cache Cache) {
 		err := cache.Insert(createFileObjects(dirStructure...)...)
@@ -218,6 +328,7 @@ func TestCacheStat(t *testing.T) {
 		t.Run("Stat nonexistent file", func(t *testing.T) {
 			_, err = cache.Stat("nonexistent")
 			assert.Error(t, err)
+			assert.True(t, IsNotFound(err))
 		})
 
 		t.Run("Stat directory", func(t *testing.T) {
@@ -481,3 +592,445 @@ func TestCacheClose(t *testing.T) {
 		})
 	})
 }
+
+func TestCacheAlias(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		err := cache.Insert(createFileObjects("bucket-a/target.txt", "bucket-a/alias.txt")...)
+		require.NoError(t, err)
+
+		t.Run("Missing alias", func(t *testing.T) {
+			target, err := cache.GetAlias("bucket-a/target.txt")
+			require.NoError(t, err)
+			assert.Empty(t, target)
+		})
+
+		t.Run("Set and get alias", func(t *testing.T) {
+			require.NoError(t, cache.SetAlias("bucket-a/alias.txt", "bucket-a/target.txt"))
+
+			target, err := cache.GetAlias("bucket-a/alias.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "bucket-a/target.txt", target)
+		})
+
+		t.Run("Alias target", func(t *testing.T) {
+			isTarget, err := cache.IsAliasTarget("bucket-a/target.txt")
+			require.NoError(t, err)
+			assert.True(t, isTarget)
+
+			isTarget, err = cache.IsAliasTarget("bucket-a/alias.txt")
+			require.NoError(t, err)
+			assert.False(t, isTarget)
+		})
+
+		t.Run("Invalid aliases", func(t *testing.T) {
+			assert.Error(t, cache.SetAlias("bucket-a/alias.txt", "bucket-a/alias.txt"))
+			assert.Error(t, cache.SetAlias("bucket-a/", "bucket-a/target.txt"))
+			assert.Error(t, cache.SetAlias("bucket-a/alias.txt", "bucket-a/"))
+		})
+
+		t.Run("Delete removes alias", func(t *testing.T) {
+			require.NoError(t, cache.Delete("bucket-a/alias.txt"))
+
+			target, err := cache.GetAlias("bucket-a/alias.txt")
+			require.NoError(t, err)
+			assert.Empty(t, target)
+		})
+	})
+}
+
+func TestCacheTags(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		err := cache.Insert(createFileObjects("bucket-a/", "bucket-a/tagged.txt")...)
+		require.NoError(t, err)
+
+		tags, err := cache.GetTags("bucket-a/tagged.txt")
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+
+		require.NoError(t, cache.SetTags("bucket-a/tagged.txt", map[string]string{"env": "prod", "team": ""}))
+		tags, err = cache.GetTags("bucket-a/tagged.txt")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "team": ""}, tags)
+
+		// Re-inserting, as the sync does, keeps the tags
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/tagged.txt", Size: 10, Processed: true}))
+		tags, err = cache.GetTags("bucket-a/tagged.txt")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "team": ""}, tags)
+
+		require.NoError(t, cache.SetTags("bucket-a/tagged.txt", nil))
+		tags, err = cache.GetTags("bucket-a/tagged.txt")
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+
+		assert.Error(t, cache.SetTags("bucket-a/missing.txt", map[string]string{"env": "prod"}))
+		assert.Error(t, cache.SetTags("bucket-a/", map[string]string{"env": "prod"}))
+		_, err = cache.GetTags("bucket-a/missing.txt")
+		assert.Error(t, err)
+
+		// Deleting the object removes its tags
+		require.NoError(t, cache.SetTags("bucket-a/tagged.txt", map[string]string{"env": "prod"}))
+		require.NoError(t, cache.Delete("bucket-a/tagged.txt"))
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/tagged.txt", Processed: true}))
+		tags, err = cache.GetTags("bucket-a/tagged.txt")
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+	})
+}
+
+func TestCacheChecksum(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		uploaded := fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true, Checksum: "CRC32C:yZRlqg=="}
+		require.NoError(t, cache.Insert(uploaded))
+
+		entry, err := cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "CRC32C:yZRlqg==", entry.Checksum)
+
+		// Re-inserting the unchanged file without a checksum, as the sync does, keeps it
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "CRC32C:yZRlqg==", entry.Checksum)
+
+		// A changed file drops the checksum of the previous content
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 6, LastModified: 101, Processed: true}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Empty(t, entry.Checksum)
+	})
+}
+
+func TestCacheStorageClass(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true, StorageClass: "GLACIER"}))
+
+		entry, err := cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "GLACIER", entry.StorageClass)
+
+		// Re-inserting the unchanged file without a class, as the sync does, keeps it
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "GLACIER", entry.StorageClass)
+
+		// A file changed on the backend is no longer the uploaded one
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 6, LastModified: 101, Processed: true}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Empty(t, entry.StorageClass)
+	})
+}
+
+func TestCacheMD5(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true}))
+		entry, err := cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Empty(t, entry.MD5)
+
+		// The digest computed later is stored with the unchanged entry
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true, MD5: "5d41402abc4b2a76b9719d911017c592"}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", entry.MD5)
+
+		// Re-inserting the unchanged file without it, as the sync does, keeps it
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 5, LastModified: 100, Processed: true}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", entry.MD5)
+
+		// A changed file drops the digest of the previous content
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/file.txt", Size: 6, LastModified: 101, Processed: true}))
+		entry, err = cache.Stat("bucket-a/file.txt")
+		require.NoError(t, err)
+		assert.Empty(t, entry.MD5)
+	})
+}
+
+func TestCacheBuckets(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		buckets, err := cache.ListBuckets()
+		require.NoError(t, err)
+		assert.Empty(t, buckets)
+
+		require.NoError(t, cache.AddBucket("bucket-b"))
+		require.NoError(t, cache.AddBucket("bucket-a"))
+		require.NoError(t, cache.AddBucket("bucket-b"))
+
+		buckets, err = cache.ListBuckets()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bucket-a", "bucket-b"}, buckets)
+
+		require.NoError(t, cache.RemoveBucket("bucket-b"))
+		require.NoError(t, cache.RemoveBucket("missing-bucket"))
+
+		buckets, err = cache.ListBuckets()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bucket-a"}, buckets)
+
+		assert.Error(t, cache.AddBucket(""))
+		assert.Error(t, cache.AddBucket("bucket/nested"))
+	})
+}
+
+func TestCacheOwner(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		err := cache.Insert(
+			fs.EntryInfo{Path: "bucket-a/", IsDir: true, Processed: true},
+			fs.EntryInfo{Path: "bucket-a/dir/", IsDir: true, Processed: true},
+			fs.EntryInfo{Path: "bucket-a/alice.txt", Processed: true, Owner: "alice"},
+			fs.EntryInfo{Path: "bucket-a/bob.txt", Processed: true, Owner: "bob"},
+			fs.EntryInfo{Path: "bucket-a/dir/alice.txt", Processed: true, Owner: "alice"},
+			fs.EntryInfo{Path: "bucket-a/synced.txt", Processed: true},
+		)
+		require.NoError(t, err)
+
+		// Re-inserting without an owner, as the sync does, keeps the recorded owner
+		require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/alice.txt", Size: 10, Processed: true}))
+		entry, err := cache.Stat("bucket-a/alice.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", entry.Owner)
+		assert.Equal(t, int64(10), entry.Size)
+
+		entry, err = cache.Stat("bucket-a/synced.txt")
+		require.NoError(t, err)
+		assert.Empty(t, entry.Owner)
+
+		files, truncated, err := cache.ListOwnedBy("alice", "bucket-a/", "", false, 10)
+		require.NoError(t, err)
+		assert.False(t, truncated)
+		assert.Equal(t, []string{"bucket-a/alice.txt", "bucket-a/dir/alice.txt"}, entryPaths(files))
+
+		files, _, err = cache.ListOwnedBy("bob", "bucket-a/", "", true, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bucket-a/bob.txt", "bucket-a/dir/"}, entryPaths(files))
+
+		files, _, err = cache.ListOwnedBy("carol", "bucket-a/", "", false, 10)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+
+		_, _, err = cache.ListOwnedBy("", "bucket-a/", "", false, 10)
+		assert.Error(t, err)
+	})
+}
+
+func TestCacheMigration(t *testing.T) {
+	dbPath := fmt.Sprintf("%s/legacy.db", t.TempDir())
+
+	// Original schema, created before the version was recorded
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			path TEXT NOT NULL UNIQUE,
+			size INTEGER NOT NULL,
+			last_modified INTEGER NOT NULL,
+			is_dir INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			processed INTEGER NOT NULL
+		);
+		INSERT INTO entries (path, size, last_modified, is_dir, updated_at, processed)
+		VALUES ('bucket-a/old.txt', 1, 0, 0, 0, 1);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	schemaVersion := func() int {
+		db, err := sql.Open("sqlite", dbPath)
+		require.NoError(t, err)
+		defer db.Close()
+
+		var version int
+		require.NoError(t, db.QueryRow("PRAGMA user_version").Scan(&version))
+		return version
+	}
+
+	cache, err := NewCacheDB(dbPath)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), schemaVersion())
+
+	entry, err := cache.Stat("bucket-a/old.txt")
+	require.NoError(t, err)
+	assert.Empty(t, entry.Owner)
+	assert.Empty(t, entry.Checksum)
+	assert.Empty(t, entry.StorageClass)
+
+	require.NoError(t, cache.Insert(fs.EntryInfo{Path: "bucket-a/new.txt", Processed: true, Owner: "alice", Checksum: "crc32:AAAAAA==", StorageClass: "GLACIER"}))
+	entry, err = cache.Stat("bucket-a/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", entry.Owner)
+	assert.Equal(t, "crc32:AAAAAA==", entry.Checksum)
+	assert.Equal(t, "GLACIER", entry.StorageClass)
+
+	tags, err := cache.GetTags("bucket-a/old.txt")
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+	require.NoError(t, cache.SetTags("bucket-a/old.txt", map[string]string{"env": "prod"}))
+	require.NoError(t, cache.Close())
+
+	// Opening the migrated database again keeps the data
+	cache, err = NewCacheDB(dbPath)
+	require.NoError(t, err)
+	defer cache.Close()
+	assert.Equal(t, len(migrations), schemaVersion())
+
+	tags, err = cache.GetTags("bucket-a/old.txt")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, tags)
+}
+
+func TestCacheMigrationNewerVersion(t *testing.T) {
+	dbPath := fmt.Sprintf("%s/newer.db", t.TempDir())
+
+	cache, err := NewCacheDB(dbPath)
+	require.NoError(t, err)
+	require.NoError(t, cache.Close())
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations)+1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = NewCacheDB(dbPath)
+	assert.ErrorContains(t, err, "newer than supported")
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options Options
+	}{
+		{"single connection", Options{BusyTimeout: DefaultBusyTimeout, MaxOpenConns: 1}},
+		{"connection pool", Options{BusyTimeout: DefaultBusyTimeout, MaxOpenConns: DefaultMaxOpenConns}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbPath := t.TempDir() + "/concurrent.db"
+
+			// Two caches of the same file lock each other like separate processes
+			var caches []Cache
+			for range 2 {
+				cache, err := NewCacheDBWithOptions(dbPath, tc.options)
+				require.NoError(t, err)
+				t.Cleanup(func() { cache.Close() })
+				caches = append(caches, cache)
+			}
+
+			const writers, readers, iterations = 8, 8, 200
+			errs := make(chan error, (writers+readers)*iterations)
+
+			var wg sync.WaitGroup
+			for w := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cache := caches[w%len(caches)]
+					for i := range iterations {
+						errs <- cache.Insert(createFileObjects(
+							fmt.Sprintf("bucket-a/writer-%d/", w),
+							fmt.Sprintf("bucket-a/writer-%d/file-%d.txt", w, i),
+						)...)
+					}
+				}()
+			}
+			for r := range readers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cache := caches[r%len(caches)]
+					for range iterations {
+						_, _, err := cache.List("bucket-a/", "", false, 100)
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			for w := range writers {
+				entries, _, err := caches[0].List(fmt.Sprintf("bucket-a/writer-%d/", w), "", false, 1000)
+				require.NoError(t, err)
+				assert.Len(t, entries, iterations)
+			}
+		})
+	}
+}
+
+func entryPaths(entries []fs.EntryInfo) []string {
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	return paths
+}
+
+func TestCacheVacuum(t *testing.T) {
+	dbPath := t.TempDir() + "/vacuum.db"
+	cache, err := NewCacheDB(dbPath)
+	require.NoError(t, err)
+	defer cache.Close()
+
+	// dbSize includes the WAL, where the changes are written before being checkpointed
+	dbSize := func() int64 {
+		var size int64
+		for _, path := range []string{dbPath, dbPath + "-wal"} {
+			if info, err := os.Stat(path); err == nil {
+				size += info.Size()
+			}
+		}
+		return size
+	}
+
+	files := make([]string, 0, 5000)
+	for i := range 5000 {
+		files = append(files, fmt.Sprintf("bucket/dir/file-%05d", i))
+	}
+	require.NoError(t, cache.Insert(createFileObjects(files...)...))
+	for _, file := range files {
+		require.NoError(t, cache.Delete(file))
+	}
+
+	// Deleting only marks the pages as free
+	before := dbSize()
+	require.NoError(t, cache.Vacuum())
+	assert.Less(t, dbSize(), before)
+
+	results, _, err := cache.List("bucket/", "", false, 10)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestRunMaintenance(t *testing.T) {
+	forEachTestBackend(t, func(t *testing.T, cache Cache) {
+		require.NoError(t, cache.Insert(createFileObjects(fileStructure...)...))
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			RunMaintenance(cache, 10*time.Millisecond, 25*time.Millisecond, stop)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		close(stop)
+		<-done
+
+		results, _, err := cache.List("", "", false, 100)
+		require.NoError(t, err)
+		assert.Len(t, results, len(fileStructure))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		// Returns immediately without a stop channel
+		RunMaintenance(nil, 0, 0, nil)
+	})
+}
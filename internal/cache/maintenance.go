@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"log"
+	"time"
+)
+
+// RunMaintenance runs Optimise every optimiseInterval and Vacuum every vacuumInterval
+// until stop is closed, an interval of 0 disables that task
+func RunMaintenance(c Cache, optimiseInterval, vacuumInterval time.Duration, stop <-chan struct{}) {
+	if optimiseInterval <= 0 && vacuumInterval <= 0 {
+		return
+	}
+
+	var optimise, vacuum <-chan time.Time
+	if optimiseInterval > 0 {
+		ticker := time.NewTicker(optimiseInterval)
+		defer ticker.Stop()
+		optimise = ticker.C
+	}
+	if vacuumInterval > 0 {
+		ticker := time.NewTicker(vacuumInterval)
+		defer ticker.Stop()
+		vacuum = ticker.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-optimise:
+			if err := c.Optimise(); err != nil {
+				log.Printf("Cache: Failed to optimise: %v", err)
+			}
+		case <-vacuum:
+			start := time.Now()
+			if err := c.Vacuum(); err != nil {
+				log.Printf("Cache: Failed to vacuum: %v", err)
+			} else {
+				log.Printf("Cache: Vacuumed in %v", time.Since(start))
+			}
+		}
+	}
+}
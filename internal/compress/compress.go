@@ -0,0 +1,149 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	r               *http.Request
+	compressObjects bool
+
+	wroteHeader bool
+	gzip        *gzip.Writer
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+
+	if rw.shouldCompress(code) {
+		rw.Header().Set("Content-Encoding", "gzip")
+		rw.Header().Del("Content-Length")
+		rw.gzip = gzipWriters.Get().(*gzip.Writer)
+		rw.gzip.Reset(rw.ResponseWriter)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.gzip != nil {
+		return rw.gzip.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *responseWriter) Flush() {
+	if rw.gzip != nil {
+		rw.gzip.Flush()
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) close() {
+	if rw.gzip != nil {
+		rw.gzip.Close()
+		gzipWriters.Put(rw.gzip)
+		rw.gzip = nil
+	}
+}
+
+// shouldCompress checks if the response can be compressed, objects are recognized by
+// their ETag header and are compressed only if enabled, as they are often compressed already
+func (rw *responseWriter) shouldCompress(code int) bool {
+	header := rw.Header()
+
+	if rw.r.Method == "HEAD" || code < 200 || code == http.StatusNoContent ||
+		code == http.StatusNotModified || code == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	if header.Get("ETag") != "" {
+		if !rw.compressObjects {
+			return false
+		}
+	} else if !isCompressible(header.Get("Content-Type")) {
+		return false
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+	return acceptsGzip(rw.r.Header.Get("Accept-Encoding"))
+}
+
+// isCompressible checks if the content type is text, XML or JSON
+func isCompressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/xml" || mediaType == "application/json":
+		return true
+	case strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return false
+}
+
+// acceptsGzip checks if the Accept-Encoding header allows gzip, either by name or by *
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+
+		q := 1.0
+		if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// CompressMiddleware compresses text, XML and JSON responses with gzip for clients accepting it,
+// and object content as well with compressObjects
+func CompressMiddleware(next http.Handler, compressObjects bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapped := &responseWriter{
+			ResponseWriter:  w,
+			r:               r,
+			compressObjects: compressObjects,
+		}
+		defer wrapped.close()
+
+		next.ServeHTTP(wrapped, r)
+	})
+}
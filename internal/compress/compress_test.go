@@ -0,0 +1,191 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"s3-to-webdav/internal/access_log"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"br, deflate", false},
+		{"*", true},
+		{"gzip;q=0, *", false},
+		{"*;q=0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.expected, acceptsGzip(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat("<Contents><Key>file.txt</Key></Contents>", 100)
+
+	tests := []struct {
+		name            string
+		method          string
+		acceptEncoding  string
+		contentType     string
+		etag            string
+		status          int
+		compressObjects bool
+		expectGzip      bool
+		expectVary      bool
+	}{
+		{
+			name:           "xml listing",
+			acceptEncoding: "gzip",
+			contentType:    "application/xml",
+			expectGzip:     true,
+			expectVary:     true,
+		},
+		{
+			name:           "json with charset",
+			acceptEncoding: "gzip, deflate",
+			contentType:    "application/json; charset=utf-8",
+			expectGzip:     true,
+			expectVary:     true,
+		},
+		{
+			name:        "client not accepting gzip",
+			contentType: "application/xml",
+			expectVary:  true,
+		},
+		{
+			name:           "binary content",
+			acceptEncoding: "gzip",
+			contentType:    "application/octet-stream",
+		},
+		{
+			name:           "object",
+			acceptEncoding: "gzip",
+			contentType:    "text/plain",
+			etag:           `"abc"`,
+		},
+		{
+			name:            "object with compressed objects",
+			acceptEncoding:  "gzip",
+			contentType:     "application/octet-stream",
+			etag:            `"abc"`,
+			compressObjects: true,
+			expectGzip:      true,
+			expectVary:      true,
+		},
+		{
+			name:           "head request",
+			method:         "HEAD",
+			acceptEncoding: "gzip",
+			contentType:    "application/xml",
+		},
+		{
+			name:           "not modified",
+			acceptEncoding: "gzip",
+			contentType:    "application/xml",
+			status:         http.StatusNotModified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				if r.Method != "HEAD" && tt.status != http.StatusNotModified {
+					io.WriteString(w, body)
+				}
+			}), tt.compressObjects)
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, "/bucket", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if tt.expectVary {
+				assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			} else {
+				assert.Empty(t, w.Header().Get("Vary"))
+			}
+
+			if !tt.expectGzip {
+				assert.Empty(t, w.Header().Get("Content-Encoding"))
+				if method == "GET" && tt.status != http.StatusNotModified {
+					assert.Equal(t, body, w.Body.String())
+					assert.Equal(t, fmt.Sprint(len(body)), w.Header().Get("Content-Length"))
+				}
+				return
+			}
+
+			assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+			assert.Empty(t, w.Header().Get("Content-Length"))
+			assert.Less(t, w.Body.Len(), len(body))
+
+			reader, err := gzip.NewReader(w.Body)
+			require.NoError(t, err)
+			decompressed, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(decompressed))
+		})
+	}
+}
+
+func TestCompressMiddlewareAccessLog(t *testing.T) {
+	body := strings.Repeat("<Key>file.txt</Key>", 100)
+
+	handler := access_log.AccessLogMiddleware(CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, body)
+	}), false), nil)
+
+	oldStdout := os.Stdout
+	r, pipe, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = pipe
+
+	req := httptest.NewRequest("GET", "/bucket", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	pipe.Close()
+	os.Stdout = oldStdout
+	logLine, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Less(t, w.Body.Len(), len(body))
+	// Request and response sizes, the response being the compressed one
+	assert.Contains(t, string(logLine), fmt.Sprintf(" 0/%d ", w.Body.Len()))
+}
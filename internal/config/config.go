@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Load sets the options of the flag set from the JSON configuration file, an object
+// mapping the flag names to their values, e.g. {"http-port": "8443", "read-only": true}.
+// Options given on the command line take precedence over the file, which takes
+// precedence over the environment variables, as these only provide the flag defaults
+func Load(path string, flags *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return Apply(data, flags)
+}
+
+// Apply sets the options of the flag set from the JSON object, except the ones
+// given on the command line, failing on unknown options and invalid values
+func Apply(data []byte, flags *flag.FlagSet) error {
+	var options map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&options); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	// Flags given on the command line are not overridden
+	passed := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
+	// Sorted to report the errors deterministically
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		value, err := formatValue(options[name])
+		if err != nil {
+			return fmt.Errorf("option %q: %v", name, err)
+		}
+		if passed[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("option %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// formatValue returns the JSON value as given on the command line
+func formatValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	default:
+		return "", fmt.Errorf("expected a string, number or boolean")
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testOptions struct {
+	webdavURL string
+	buckets   string
+	httpPort  string
+	retries   int
+	readOnly  bool
+	timeout   time.Duration
+}
+
+// newTestFlags mirrors a few options of the server, the defaults standing in
+// for the values taken from the environment
+func newTestFlags(t *testing.T, args ...string) (*flag.FlagSet, *testOptions) {
+	options := &testOptions{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&options.webdavURL, "webdav-url", "", "")
+	flags.StringVar(&options.buckets, "buckets", "from-env", "")
+	flags.StringVar(&options.httpPort, "http-port", "8080", "")
+	flags.IntVar(&options.retries, "webdav-retries", 2, "")
+	flags.BoolVar(&options.readOnly, "read-only", false, "")
+	flags.DurationVar(&options.timeout, "webdav-timeout", 30*time.Second, "")
+	require.NoError(t, flags.Parse(args))
+	return flags, options
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"webdav-url": "https://dav.example.com",
+		"buckets": "photos,docs",
+		"http-port": "8443",
+		"webdav-retries": 5,
+		"read-only": true,
+		"webdav-timeout": "1m"
+	}`), 0600))
+
+	t.Run("file values", func(t *testing.T) {
+		flags, options := newTestFlags(t)
+		require.NoError(t, Load(path, flags))
+		assert.Equal(t, &testOptions{
+			webdavURL: "https://dav.example.com",
+			buckets:   "photos,docs",
+			httpPort:  "8443",
+			retries:   5,
+			readOnly:  true,
+			timeout:   time.Minute,
+		}, options)
+	})
+
+	t.Run("command line overrides file", func(t *testing.T) {
+		flags, options := newTestFlags(t, "-http-port", "9000", "-read-only=false")
+		require.NoError(t, Load(path, flags))
+		assert.Equal(t, "9000", options.httpPort)
+		assert.False(t, options.readOnly)
+		assert.Equal(t, "photos,docs", options.buckets)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		flags, _ := newTestFlags(t)
+		assert.Error(t, Load(filepath.Join(t.TempDir(), "missing.json"), flags))
+	})
+}
+
+func TestApplyDefaults(t *testing.T) {
+	// Options missing from the file keep their defaults
+	flags, options := newTestFlags(t)
+	require.NoError(t, Apply([]byte(`{"webdav-url": "https://dav.example.com"}`), flags))
+	assert.Equal(t, &testOptions{
+		webdavURL: "https://dav.example.com",
+		buckets:   "from-env",
+		httpPort:  "8080",
+		retries:   2,
+		timeout:   30 * time.Second,
+	}, options)
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{name: "invalid JSON", data: `{"buckets": `, error: "invalid JSON"},
+		{name: "not an object", data: `["buckets"]`, error: "invalid JSON"},
+		{name: "unknown option", data: `{"webdav-uri": "https://dav.example.com"}`, error: `unknown option "webdav-uri"`},
+		{name: "invalid value", data: `{"webdav-retries": "many"}`, error: `option "webdav-retries"`},
+		{name: "invalid duration", data: `{"webdav-timeout": 30}`, error: `option "webdav-timeout"`},
+		{name: "list value", data: `{"buckets": ["photos", "docs"]}`, error: `option "buckets": expected a string, number or boolean`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, _ := newTestFlags(t)
+			err := Apply([]byte(tt.data), flags)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
@@ -11,6 +11,15 @@ type EntryInfo struct {
 	LastModified int64
 	IsDir        bool
 	Processed    bool
+	Owner        string
+	// Checksum is the additional checksum given on upload, as algorithm:base64 value
+	Checksum string
+	// StorageClass is the class given on upload, empty for objects discovered by the sync
+	StorageClass string
+	// MD5 is the hex encoded MD5 of the content, served as the ETag once it is computed
+	MD5 string
+	// UpdatedAt is the Unix time the entry was last stored, as read from the cache
+	UpdatedAt int64
 }
 
 // BucketAndKeyFromPath extracts bucket and key from path
@@ -1,6 +1,9 @@
 package fs
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"io"
 	"os"
 
@@ -13,6 +16,114 @@ type Fs interface {
 	ReadStream(path string) (io.ReadCloser, error)
 	WriteStream(path string, stream io.Reader, contentLength int64, mode os.FileMode) (err error)
 	Remove(path string) error
+	Rename(oldPath, newPath string) error
+	Mkdir(path string) error
+	// Copy copies the file within the backend, overwriting the destination
+	Copy(srcPath, dstPath string) error
+
+	// ReadStreamContext is ReadStream aborting the transfer once the context is done
+	ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error)
+	// ReadStreamRange is ReadStreamContext returning length bytes of the file starting at offset
+	ReadStreamRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
+	// WriteStreamContext is WriteStream failing once the context is done, without storing the partial file
+	WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error
+}
+
+// Redirector is implemented by backends able to report a redirect of the read,
+// like to a signed CDN URL, instead of following it
+type Redirector interface {
+	// ReadStreamOrRedirect returns either the content, or the location the backend redirected to
+	ReadStreamOrRedirect(ctx context.Context, path string) (io.ReadCloser, string, error)
+}
+
+// contextReadCloser closes the reader once the context is done, which unblocks
+// the read waiting for the backend, and reports the cancellation as its error
+type contextReadCloser struct {
+	io.ReadCloser
+	ctx  context.Context
+	stop func() bool
+}
+
+func closeOnDone(ctx context.Context, reader io.ReadCloser) io.ReadCloser {
+	stop := context.AfterFunc(ctx, func() { reader.Close() })
+	return &contextReadCloser{ReadCloser: reader, ctx: ctx, stop: stop}
+}
+
+func (r *contextReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		return n, r.ctx.Err()
+	}
+	return n, err
+}
+
+func (r *contextReadCloser) Close() error {
+	// Already closed once the context was done
+	if !r.stop() {
+		return nil
+	}
+	return r.ReadCloser.Close()
+}
+
+// limitReadCloser reads up to the limit of the reader, closing the underlying one
+type limitReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// LimitReadCloser returns the reader of length bytes starting at offset, discarding
+// the bytes before it, for backends answering a range request with the whole file
+func LimitReadCloser(reader io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &limitReadCloser{Reader: io.LimitReader(reader, length), Closer: reader}, nil
+}
+
+// contextReader fails reading once the context is done, so the write of the stream is aborted
+type contextReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+// CopyStream copies the file by reading it and writing it back,
+// for backends without a native copy
+func CopyStream(client Fs, srcPath, dstPath string) error {
+	info, err := client.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+
+	reader, err := client.ReadStream(srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return client.WriteStream(dstPath, reader, info.Size(), info.Mode().Perm())
+}
+
+// ContentMD5 reads the file and returns the hex encoded MD5 of its content
+func ContentMD5(ctx context.Context, client Fs, path string) (string, error) {
+	reader, err := client.ReadStreamContext(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
 func IsNotFound(err error) bool {
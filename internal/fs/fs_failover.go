@@ -0,0 +1,150 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// FailoverFs reads from the fallback backends when the primary fails with a 5xx or
+// connection error, while writes go only to the primary, so the backends never take
+// different writes. Once the primary failed, reads start with the fallbacks until it
+// answers again, found either by a read or by the health check
+type FailoverFs struct {
+	// Fs is the primary backend, handling all writes
+	Fs
+	fallbacks []Fs
+
+	// primaryDown is set once the primary failed a read
+	primaryDown atomic.Bool
+}
+
+// NewFailoverFs wraps the primary backend to fail reads over to the fallbacks, in order
+func NewFailoverFs(primary Fs, fallbacks ...Fs) *FailoverFs {
+	return &FailoverFs{Fs: primary, fallbacks: fallbacks}
+}
+
+// backends returns the backends in the order reads try them, with the primary
+// as the last resort while it is down
+func (fs *FailoverFs) backends() []Fs {
+	if fs.primaryDown.Load() {
+		return append(append([]Fs(nil), fs.fallbacks...), fs.Fs)
+	}
+	return append([]Fs{fs.Fs}, fs.fallbacks...)
+}
+
+// setPrimaryDown records the state of the primary, logging when it changes
+func (fs *FailoverFs) setPrimaryDown(down bool, err error) {
+	if fs.primaryDown.Swap(down) == down {
+		return
+	}
+	if down {
+		log.Printf("Failover: Primary backend failed, reading from the fallbacks: %v", err)
+	} else {
+		log.Printf("Failover: Primary backend is back, reading from it again")
+	}
+}
+
+// failover runs the read on every backend in turn, until one succeeds or
+// fails with an error other than a 5xx or connection error. A read failing
+// once the context is done leaves the state of the primary as it was, as the
+// caller gave up rather than the backend
+func failover[T any](ctx context.Context, fs *FailoverFs, fn func(client Fs) (T, error)) (T, error) {
+	var result T
+	var err error
+	for _, client := range fs.backends() {
+		result, err = fn(client)
+		if err != nil && ctx.Err() != nil {
+			return result, err
+		}
+		primary := client == fs.Fs
+		if err == nil || !isRetryable(err) {
+			if primary {
+				fs.setPrimaryDown(false, nil)
+			}
+			return result, err
+		}
+		if primary {
+			fs.setPrimaryDown(true, err)
+		}
+	}
+	return result, err
+}
+
+func (fs *FailoverFs) ReadDir(path string) ([]os.FileInfo, error) {
+	return failover(context.Background(), fs, func(client Fs) ([]os.FileInfo, error) {
+		return client.ReadDir(path)
+	})
+}
+
+func (fs *FailoverFs) Stat(path string) (os.FileInfo, error) {
+	return failover(context.Background(), fs, func(client Fs) (os.FileInfo, error) {
+		return client.Stat(path)
+	})
+}
+
+func (fs *FailoverFs) ReadStream(path string) (io.ReadCloser, error) {
+	return failover(context.Background(), fs, func(client Fs) (io.ReadCloser, error) {
+		return client.ReadStream(path)
+	})
+}
+
+func (fs *FailoverFs) ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	return failover(ctx, fs, func(client Fs) (io.ReadCloser, error) {
+		return client.ReadStreamContext(ctx, path)
+	})
+}
+
+func (fs *FailoverFs) ReadStreamRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return failover(ctx, fs, func(client Fs) (io.ReadCloser, error) {
+		return client.ReadStreamRange(ctx, path, offset, length)
+	})
+}
+
+// ReadStreamOrRedirect reports the redirect of the backend serving the read,
+// backends unable to report redirects return the content
+func (fs *FailoverFs) ReadStreamOrRedirect(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	type readOrRedirect struct {
+		reader   io.ReadCloser
+		location string
+	}
+	result, err := failover(ctx, fs, func(client Fs) (readOrRedirect, error) {
+		if redirector, ok := client.(Redirector); ok {
+			reader, location, err := redirector.ReadStreamOrRedirect(ctx, path)
+			return readOrRedirect{reader, location}, err
+		}
+		reader, err := client.ReadStreamContext(ctx, path)
+		return readOrRedirect{reader: reader}, err
+	})
+	return result.reader, result.location, err
+}
+
+// CheckPrimary stats the path on the primary while it is down, reading from it
+// again once it answers with anything but a 5xx or connection error
+func (fs *FailoverFs) CheckPrimary(path string) {
+	if !fs.primaryDown.Load() {
+		return
+	}
+	if _, err := fs.Fs.Stat(path); err == nil || !isRetryable(err) {
+		fs.setPrimaryDown(false, nil)
+	}
+}
+
+// RunHealthCheck checks the primary every interval until stop is closed,
+// so reads fail back to it without waiting for the fallbacks to fail
+func (fs *FailoverFs) RunHealthCheck(path string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fs.CheckPrimary(path)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package fs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// LayoutFlat stores the files at the paths of their keys
+	LayoutFlat = "flat"
+	// LayoutHashed stores the files under directories named after the hash of their keys
+	LayoutHashed = "hashed"
+)
+
+// hashedFs stores every entry of a bucket under two levels of directories named after
+// the hash of its key, like bucket/ab/cd/key, so no backend directory holds more than
+// 256 hash directories or the entries sharing a hash
+type hashedFs struct {
+	Fs
+}
+
+// hashedRedirectFs is the hashedFs of a backend able to report redirects
+type hashedRedirectFs struct {
+	hashedFs
+}
+
+// hashedDirInfo reports the latest modification of the directory content,
+// which is kept in the hash directories
+type hashedDirInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (fi *hashedDirInfo) ModTime() time.Time { return fi.modTime }
+
+// NewHashedFs wraps the backend to store files in the hashed layout,
+// the paths given to and returned by it are the paths of the flat layout
+func NewHashedFs(client Fs) Fs {
+	hashed := hashedFs{Fs: client}
+	if _, ok := client.(Redirector); ok {
+		return &hashedRedirectFs{hashed}
+	}
+	return &hashed
+}
+
+// HashedPath returns the backend path of the path in the hashed layout, every name
+// below the bucket is prefixed with two directories taken from the md5 of the key up to it
+func HashedPath(path string) string {
+	leading := strings.HasPrefix(path, "/")
+	trailing := strings.HasSuffix(path, "/")
+
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return path
+	}
+
+	hashed := []string{names[0]}
+	for i := 1; i < len(names); i++ {
+		sum := md5.Sum([]byte(strings.Join(names[1:i+1], "/")))
+		hash := hex.EncodeToString(sum[:])
+		hashed = append(hashed, hash[0:2], hash[2:4], names[i])
+	}
+
+	result := strings.Join(hashed, "/")
+	if leading {
+		result = "/" + result
+	}
+	if trailing {
+		result += "/"
+	}
+	return result
+}
+
+func isHashName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isDirPath reports whether the path is a bucket or ends with a slash,
+// as objects never do
+func isDirPath(path string) bool {
+	return strings.HasSuffix(path, "/") || !strings.Contains(strings.Trim(path, "/"), "/")
+}
+
+// hashDirs returns the directories of the second hash level of the backend directory
+func (fs *hashedFs) hashDirs(dir string) ([]string, []os.FileInfo, error) {
+	dir = strings.TrimSuffix(dir, "/")
+
+	first, err := fs.Fs.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var paths []string
+	var infos []os.FileInfo
+	for _, info := range first {
+		if !info.IsDir() || !isHashName(info.Name()) {
+			continue
+		}
+
+		second, err := fs.Fs.ReadDir(dir + "/" + info.Name())
+		if IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		for _, hashInfo := range second {
+			if hashInfo.IsDir() && isHashName(hashInfo.Name()) {
+				paths = append(paths, dir+"/"+info.Name()+"/"+hashInfo.Name())
+				infos = append(infos, hashInfo)
+			}
+		}
+	}
+	return paths, infos, nil
+}
+
+func (fs *hashedFs) ReadDir(path string) ([]os.FileInfo, error) {
+	dirs, _, err := fs.hashDirs(HashedPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []os.FileInfo
+	for _, dir := range dirs {
+		infos, err := fs.Fs.ReadDir(dir)
+		if IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, infos...)
+	}
+	return result, nil
+}
+
+func (fs *hashedFs) Stat(path string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(HashedPath(path))
+	if err != nil || !info.IsDir() {
+		return info, err
+	}
+
+	// Adding to an existing hash directory does not modify the directory itself
+	_, hashInfos, err := fs.hashDirs(HashedPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	dirInfo := &hashedDirInfo{FileInfo: info, modTime: info.ModTime()}
+	for _, hashInfo := range hashInfos {
+		if hashInfo.ModTime().After(dirInfo.modTime) {
+			dirInfo.modTime = hashInfo.ModTime()
+		}
+	}
+	return dirInfo, nil
+}
+
+func (fs *hashedFs) ReadStream(path string) (io.ReadCloser, error) {
+	return fs.Fs.ReadStream(HashedPath(path))
+}
+
+func (fs *hashedFs) ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	return fs.Fs.ReadStreamContext(ctx, HashedPath(path))
+}
+
+func (fs *hashedFs) ReadStreamRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return fs.Fs.ReadStreamRange(ctx, HashedPath(path), offset, length)
+}
+
+func (fs *hashedFs) WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.Fs.WriteStreamContext(ctx, HashedPath(path), stream, contentLength, mode)
+}
+
+func (fs *hashedFs) WriteStream(path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.Fs.WriteStream(HashedPath(path), stream, contentLength, mode)
+}
+
+// Remove removes the file, or the empty directory together with its hash directories
+func (fs *hashedFs) Remove(path string) error {
+	if !isDirPath(path) {
+		return fs.Fs.Remove(HashedPath(path))
+	}
+
+	dir := strings.TrimSuffix(HashedPath(path), "/")
+	first, err := fs.Fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range first {
+		if !info.IsDir() || !isHashName(info.Name()) {
+			continue
+		}
+		firstDir := dir + "/" + info.Name()
+
+		second, err := fs.Fs.ReadDir(firstDir)
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+		for _, hashInfo := range second {
+			if !hashInfo.IsDir() || !isHashName(hashInfo.Name()) {
+				continue
+			}
+			if err := fs.Fs.Remove(firstDir + "/" + hashInfo.Name()); err != nil && !IsNotFound(err) {
+				return err
+			}
+		}
+
+		if err := fs.Fs.Remove(firstDir); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return fs.Fs.Remove(dir)
+}
+
+func (fs *hashedFs) Rename(oldPath, newPath string) error {
+	return fs.Fs.Rename(HashedPath(oldPath), HashedPath(newPath))
+}
+
+func (fs *hashedFs) Copy(srcPath, dstPath string) error {
+	return fs.Fs.Copy(HashedPath(srcPath), HashedPath(dstPath))
+}
+
+func (fs *hashedFs) Mkdir(path string) error {
+	return fs.Fs.Mkdir(HashedPath(path))
+}
+
+func (fs *hashedRedirectFs) ReadStreamOrRedirect(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	return fs.Fs.(Redirector).ReadStreamOrRedirect(ctx, HashedPath(path))
+}
@@ -0,0 +1,17 @@
+package fs
+
+// Every backend and wrapper implements the Fs interface, and the ones able to pass
+// redirects through implement Redirector, checked at compile time
+var (
+	_ Fs = (*localFs)(nil)
+	_ Fs = (*webdavFs)(nil)
+	_ Fs = (*s3Fs)(nil)
+	_ Fs = (*hashedFs)(nil)
+	_ Fs = (*mappedFs)(nil)
+	_ Fs = (*FailoverFs)(nil)
+
+	_ Redirector = (*webdavFs)(nil)
+	_ Redirector = (*hashedRedirectFs)(nil)
+	_ Redirector = (*mappedRedirectFs)(nil)
+	_ Redirector = (*FailoverFs)(nil)
+)
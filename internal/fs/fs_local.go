@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -8,25 +9,91 @@ import (
 	"strings"
 )
 
+const (
+	DefaultDirMode  os.FileMode = 0755
+	DefaultFileMode os.FileMode = 0644
+)
+
+// LocalOptions configures the local filesystem backend
+type LocalOptions struct {
+	// DirMode is applied to the directories created for objects, regardless of the umask
+	DirMode os.FileMode
+	// DurableWrites flushes written files and their directories to the disk before
+	// the write returns, so a power loss does not lose acknowledged objects
+	DurableWrites bool
+}
+
 type localFs struct {
 	rootPath string
+	dirMode  os.FileMode
+	durable  bool
+}
+
+// fsync flushes the file or directory to the disk, a variable to observe it in tests
+var fsync = func(file *os.File) error {
+	return file.Sync()
+}
+
+// syncDir flushes the directory, persisting the files created or renamed in it
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return fsync(dir)
 }
 
 func NewLocalFs(rootPath string) (Fs, error) {
+	return NewLocalFsWithOptions(rootPath, LocalOptions{
+		DirMode: DefaultDirMode,
+	})
+}
+
+// NewLocalFsWithOptions initializes the local filesystem backend
+func NewLocalFsWithOptions(rootPath string, options LocalOptions) (Fs, error) {
 	absPath, err := filepath.Abs(rootPath)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := os.MkdirAll(absPath, 0755); err != nil {
+	if err := os.MkdirAll(absPath, options.DirMode); err != nil {
 		return nil, err
 	}
 
 	return &localFs{
 		rootPath: absPath,
+		dirMode:  options.DirMode,
+		durable:  options.DurableWrites,
 	}, nil
 }
 
+// mkdirAll creates the missing parents of path with the configured mode,
+// chmoding them afterwards as MkdirAll is subject to the umask
+func (fs *localFs) mkdirAll(path string) error {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := fs.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(path, fs.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Chmod(path, fs.dirMode); err != nil {
+		return err
+	}
+	if fs.durable {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
 func (fs *localFs) getFullPath(path string) (string, error) {
 	fullPath := filepath.Join(fs.rootPath, filepath.Clean(path))
 
@@ -80,13 +147,42 @@ func (fs *localFs) ReadStream(path string) (io.ReadCloser, error) {
 	return os.Open(fullPath)
 }
 
+func (fs *localFs) ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := fs.ReadStream(path)
+	if err != nil {
+		return nil, err
+	}
+	return closeOnDone(ctx, reader), nil
+}
+
+func (fs *localFs) ReadStreamRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	fullPath, err := fs.getFullPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return closeOnDone(ctx, &limitReadCloser{Reader: io.LimitReader(file, length), Closer: file}), nil
+}
+
+func (fs *localFs) WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.WriteStream(path, &contextReader{Reader: stream, ctx: ctx}, contentLength, mode)
+}
+
 func (fs *localFs) WriteStream(path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
 	fullPath, err := fs.getFullPath(path)
 	if err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+	if err := fs.mkdirAll(filepath.Dir(fullPath)); err != nil {
 		return err
 	}
 
@@ -109,11 +205,23 @@ func (fs *localFs) WriteStream(path string, stream io.Reader, contentLength int6
 		return err
 	}
 
+	if fs.durable {
+		if err := fsync(tempFile); err != nil {
+			return err
+		}
+	}
+
 	if err := tempFile.Close(); err != nil {
 		return err
 	}
 
-	return os.Rename(tempPath, fullPath)
+	if err := os.Rename(tempPath, fullPath); err != nil {
+		return err
+	}
+	if fs.durable {
+		return syncDir(filepath.Dir(fullPath))
+	}
+	return nil
 }
 
 func (fs *localFs) Remove(path string) error {
@@ -123,3 +231,63 @@ func (fs *localFs) Remove(path string) error {
 	}
 	return os.Remove(fullPath)
 }
+
+func (fs *localFs) Rename(oldPath, newPath string) error {
+	fullOldPath, err := fs.getFullPath(oldPath)
+	if err != nil {
+		return err
+	}
+	fullNewPath, err := fs.getFullPath(newPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fullOldPath); err != nil {
+		return err
+	}
+
+	if err := fs.mkdirAll(filepath.Dir(fullNewPath)); err != nil {
+		return err
+	}
+	if err := os.Rename(fullOldPath, fullNewPath); err != nil {
+		return err
+	}
+	if fs.durable {
+		if err := syncDir(filepath.Dir(fullOldPath)); err != nil {
+			return err
+		}
+		return syncDir(filepath.Dir(fullNewPath))
+	}
+	return nil
+}
+
+func (fs *localFs) Mkdir(path string) error {
+	fullPath, err := fs.getFullPath(path)
+	if err != nil {
+		return err
+	}
+	return fs.mkdirAll(fullPath)
+}
+
+// Copy copies the file with its mode, replacing the destination atomically like writes
+func (fs *localFs) Copy(srcPath, dstPath string) error {
+	fullSrcPath, err := fs.getFullPath(srcPath)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(fullSrcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("cannot copy directory: %s", srcPath)
+	}
+	return fs.WriteStream(dstPath, src, info.Size(), info.Mode().Perm())
+}
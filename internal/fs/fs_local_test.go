@@ -0,0 +1,48 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFsDurableWrites(t *testing.T) {
+	var synced []string
+	defer func(original func(*os.File) error) { fsync = original }(fsync)
+	fsync = func(file *os.File) error {
+		synced = append(synced, file.Name())
+		return file.Sync()
+	}
+
+	root := t.TempDir()
+	write := func(options LocalOptions, path string) {
+		client, err := NewLocalFsWithOptions(root, options)
+		require.NoError(t, err)
+		require.NoError(t, client.WriteStream(path, strings.NewReader("content"), 7, DefaultFileMode))
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		synced = nil
+		write(LocalOptions{DirMode: DefaultDirMode}, "bucket/plain.txt")
+		assert.Empty(t, synced)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		synced = nil
+		write(LocalOptions{DirMode: DefaultDirMode, DurableWrites: true}, "bucket/new/durable.txt")
+
+		// The new directory, the temporary file and the directory it was renamed in
+		require.Len(t, synced, 3)
+		assert.Equal(t, filepath.Join(root, "bucket"), synced[0])
+		assert.True(t, strings.HasPrefix(synced[1], filepath.Join(root, "bucket", "new", "durable.txt.tmp")), synced[1])
+		assert.Equal(t, filepath.Join(root, "bucket", "new"), synced[2])
+
+		content, err := os.ReadFile(filepath.Join(root, "bucket", "new", "durable.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "content", string(content))
+	})
+}
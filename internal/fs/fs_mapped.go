@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// mappedFs stores the buckets of the mapping under their backend prefixes instead of
+// the top-level directories named after them, like photos/key stored at media/images/key
+type mappedFs struct {
+	Fs
+	prefixes map[string]string
+}
+
+// mappedRedirectFs is the mappedFs of a backend able to report redirects
+type mappedRedirectFs struct {
+	mappedFs
+}
+
+// NewMappedFs wraps the backend to store the buckets of the mapping under their prefixes,
+// the paths given to and returned by it start with the bucket names
+func NewMappedFs(client Fs, prefixes map[string]string) Fs {
+	mapped := mappedFs{Fs: client, prefixes: prefixes}
+	if _, ok := client.(Redirector); ok {
+		return &mappedRedirectFs{mapped}
+	}
+	return &mapped
+}
+
+// CleanBackendPrefix returns the backend prefix without leading and trailing slashes,
+// failing for an empty prefix or one leaving the backend root
+func CleanBackendPrefix(prefix string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "", fmt.Errorf("backend prefix is empty")
+	}
+	for _, name := range strings.Split(prefix, "/") {
+		if name == "" || name == "." || name == ".." {
+			return "", fmt.Errorf("invalid backend prefix: %s", prefix)
+		}
+	}
+	return prefix, nil
+}
+
+// MappedPath returns the backend path of the path, with the bucket replaced by its prefix
+func (fs *mappedFs) MappedPath(path string) string {
+	leading := strings.HasPrefix(path, "/")
+	bucket, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+
+	prefix, ok := fs.prefixes[bucket]
+	if !ok {
+		return path
+	}
+
+	result := prefix
+	if rest != "" || strings.HasSuffix(path, "/") {
+		result += "/" + rest
+	}
+	if leading {
+		result = "/" + result
+	}
+	return result
+}
+
+func (fs *mappedFs) ReadDir(path string) ([]os.FileInfo, error) {
+	return fs.Fs.ReadDir(fs.MappedPath(path))
+}
+
+func (fs *mappedFs) Stat(path string) (os.FileInfo, error) {
+	return fs.Fs.Stat(fs.MappedPath(path))
+}
+
+func (fs *mappedFs) ReadStream(path string) (io.ReadCloser, error) {
+	return fs.Fs.ReadStream(fs.MappedPath(path))
+}
+
+func (fs *mappedFs) ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	return fs.Fs.ReadStreamContext(ctx, fs.MappedPath(path))
+}
+
+func (fs *mappedFs) ReadStreamRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return fs.Fs.ReadStreamRange(ctx, fs.MappedPath(path), offset, length)
+}
+
+func (fs *mappedFs) WriteStream(path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.Fs.WriteStream(fs.MappedPath(path), stream, contentLength, mode)
+}
+
+func (fs *mappedFs) WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.Fs.WriteStreamContext(ctx, fs.MappedPath(path), stream, contentLength, mode)
+}
+
+func (fs *mappedFs) Remove(path string) error {
+	return fs.Fs.Remove(fs.MappedPath(path))
+}
+
+func (fs *mappedFs) Rename(oldPath, newPath string) error {
+	return fs.Fs.Rename(fs.MappedPath(oldPath), fs.MappedPath(newPath))
+}
+
+func (fs *mappedFs) Copy(srcPath, dstPath string) error {
+	return fs.Fs.Copy(fs.MappedPath(srcPath), fs.MappedPath(dstPath))
+}
+
+func (fs *mappedFs) Mkdir(path string) error {
+	return fs.Fs.Mkdir(fs.MappedPath(path))
+}
+
+func (fs *mappedRedirectFs) ReadStreamOrRedirect(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	return fs.Fs.(Redirector).ReadStreamOrRedirect(ctx, fs.MappedPath(path))
+}
@@ -0,0 +1,484 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Region is used for signing, S3-compatible servers like MinIO accept it by default
+const s3Region = "us-east-1"
+
+type s3Fs struct {
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	bucket    string
+	client    *http.Client
+}
+
+// s3FileInfo implements os.FileInfo for objects and common prefixes
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return fi.isDir }
+func (fi *s3FileInfo) Sys() interface{}   { return nil }
+func (fi *s3FileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		Size         int64  `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// NewS3Fs creates filesystem storing files as objects of the bucket on S3-compatible server,
+// directories are synthesized from common prefixes of the keys
+func NewS3Fs(endpoint, accessKey, secretKey, bucket string) (Fs, error) {
+	log.Printf("S3: Endpoint: %s", endpoint)
+	log.Printf("S3: Bucket: %s", bucket)
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint scheme: %s", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+
+	fs := &s3Fs{
+		endpoint:  endpointURL,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		bucket:    bucket,
+		client:    &http.Client{},
+	}
+
+	resp, err := fs.do(context.Background(), "HEAD", "", nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to access S3 bucket %s: %s", bucket, resp.Status)
+	}
+	log.Printf("S3: Successfully connected to S3 server")
+
+	return fs, nil
+}
+
+// objectKey converts the filesystem path into the object key
+func objectKey(p string) string {
+	return strings.TrimPrefix(p, "/")
+}
+
+// dirPrefix converts the filesystem path of the directory into the key prefix
+func dirPrefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+func (fs *s3Fs) statusError(op, p string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+	}
+	return &os.PathError{Op: op, Path: p, Err: fmt.Errorf("unexpected status: %s", resp.Status)}
+}
+
+func (fs *s3Fs) list(prefix, token string, limit int) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	resp, err := fs.do(context.Background(), "GET", "", query, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fs.statusError("ReadDir", prefix, resp)
+	}
+
+	var result s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode list response: %v", err)
+	}
+	return &result, nil
+}
+
+func (fs *s3Fs) ReadDir(p string) ([]os.FileInfo, error) {
+	prefix := dirPrefix(p)
+
+	var infos []os.FileInfo
+	found := prefix == ""
+
+	for token := ""; ; {
+		result, err := fs.list(prefix, token, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			found = true
+			if object.Key == prefix {
+				// Directory marker object
+				continue
+			}
+			modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+			infos = append(infos, &s3FileInfo{
+				name:    strings.TrimPrefix(object.Key, prefix),
+				size:    object.Size,
+				modTime: modTime,
+			})
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			found = true
+			infos = append(infos, &s3FileInfo{
+				name:  strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"),
+				isDir: true,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	if !found {
+		return nil, &os.PathError{Op: "ReadDir", Path: p, Err: os.ErrNotExist}
+	}
+	return infos, nil
+}
+
+func (fs *s3Fs) Stat(p string) (os.FileInfo, error) {
+	if !strings.HasSuffix(p, "/") {
+		resp, err := fs.do(context.Background(), "HEAD", objectKey(p), nil, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+			return &s3FileInfo{
+				name:    path.Base(p),
+				size:    resp.ContentLength,
+				modTime: modTime,
+			}, nil
+		} else if resp.StatusCode != http.StatusNotFound {
+			return nil, fs.statusError("Stat", p, resp)
+		}
+	}
+
+	// Directory exists if there is any key with its prefix
+	prefix := dirPrefix(p)
+	if prefix != "" {
+		result, err := fs.list(prefix, "", 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+			return nil, &os.PathError{Op: "Stat", Path: p, Err: os.ErrNotExist}
+		}
+	}
+
+	return &s3FileInfo{
+		name:  path.Base(strings.TrimSuffix(p, "/")),
+		isDir: true,
+	}, nil
+}
+
+func (fs *s3Fs) ReadStream(p string) (io.ReadCloser, error) {
+	return fs.ReadStreamContext(context.Background(), p)
+}
+
+func (fs *s3Fs) ReadStreamContext(ctx context.Context, p string) (io.ReadCloser, error) {
+	resp, err := fs.do(ctx, "GET", objectKey(p), nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fs.statusError("ReadStream", p, resp)
+	}
+	return resp.Body, nil
+}
+
+func (fs *s3Fs) ReadStreamRange(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}
+	resp, err := fs.do(ctx, "GET", objectKey(p), nil, nil, 0, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		return LimitReadCloser(resp.Body, offset, length)
+	default:
+		resp.Body.Close()
+		return nil, fs.statusError("ReadStream", p, resp)
+	}
+}
+
+func (fs *s3Fs) WriteStream(p string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.WriteStreamContext(context.Background(), p, stream, contentLength, mode)
+}
+
+func (fs *s3Fs) WriteStreamContext(ctx context.Context, p string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	// S3 requires the length of the object upfront, so a body of unknown length, like
+	// of a form or chunked upload, is spooled to a temporary file instead of memory
+	if contentLength < 0 {
+		spooled, err := spoolToTempFile(stream)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			spooled.Close()
+			os.Remove(spooled.Name())
+		}()
+
+		info, err := spooled.Stat()
+		if err != nil {
+			return err
+		}
+		stream, contentLength = spooled, info.Size()
+	}
+
+	resp, err := fs.do(ctx, "PUT", objectKey(p), nil, stream, contentLength, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fs.statusError("WriteStream", p, resp)
+	}
+	return nil
+}
+
+func (fs *s3Fs) Remove(p string) error {
+	key := objectKey(p)
+	if strings.HasSuffix(p, "/") {
+		key = dirPrefix(p)
+	}
+
+	resp, err := fs.do(context.Background(), "DELETE", key, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fs.statusError("Remove", p, resp)
+	}
+	return nil
+}
+
+func (fs *s3Fs) Rename(oldPath, newPath string) error {
+	// S3 has no rename, so the object is copied and the source removed
+	if err := fs.copyObject("Rename", oldPath, newPath); err != nil {
+		return err
+	}
+	return fs.Remove(oldPath)
+}
+
+func (fs *s3Fs) Copy(srcPath, dstPath string) error {
+	return fs.copyObject("Copy", srcPath, dstPath)
+}
+
+// copyObject copies the object on the server with CopyObject
+func (fs *s3Fs) copyObject(op, srcPath, dstPath string) error {
+	copySource := "/" + fs.bucket + "/" + objectKey(srcPath)
+	headers := map[string]string{"X-Amz-Copy-Source": s3URIEscape(copySource, false)}
+
+	resp, err := fs.do(context.Background(), "PUT", objectKey(dstPath), nil, nil, 0, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fs.statusError(op, srcPath, resp)
+	}
+	return nil
+}
+
+func (fs *s3Fs) Mkdir(p string) error {
+	// Empty directory is represented by the marker object
+	resp, err := fs.do(context.Background(), "PUT", dirPrefix(p), nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fs.statusError("Mkdir", p, resp)
+	}
+	return nil
+}
+
+// do sends the request signed with AWS Signature Version 4 for the key in the bucket
+func (fs *s3Fs) do(ctx context.Context, method, key string, query url.Values, body io.Reader, contentLength int64, headers map[string]string) (*http.Response, error) {
+	rawPath := path.Join(fs.endpoint.Path, fs.bucket)
+	if key != "" {
+		rawPath += "/" + key
+	}
+	if !strings.HasPrefix(rawPath, "/") {
+		rawPath = "/" + rawPath
+	}
+
+	reqURL := *fs.endpoint
+	reqURL.Path = rawPath
+	reqURL.RawPath = s3URIEscape(rawPath, false)
+	reqURL.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = contentLength
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	fs.sign(req, reqURL.RawPath, reqURL.RawQuery, time.Now().UTC())
+	return fs.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 authorization to the request
+func (fs *s3Fs) sign(req *http.Request, canonicalURI, canonicalQueryString string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQueryString,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + s3Region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSum([]byte("AWS4"+fs.secretKey), date)
+	key = hmacSum(key, s3Region)
+	key = hmacSum(key, "s3")
+	key = hmacSum(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		fs.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by key, as required for signing
+func canonicalQuery(query url.Values) string {
+	parts := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			parts = append(parts, s3URIEscape(key, true)+"="+s3URIEscape(value, true))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+// s3URIEscape encodes everything except unreserved characters, and '/' unless encodeSlash
+func s3URIEscape(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '.' || b == '_' || b == '~' || (b == '/' && !encodeSlash) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
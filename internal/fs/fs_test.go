@@ -0,0 +1,1101 @@
+package fs_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"s3-to-webdav/internal/cache"
+	"s3-to-webdav/internal/fs"
+	"s3-to-webdav/internal/s3"
+	"s3-to-webdav/internal/tests"
+)
+
+func forEachTestFs(t *testing.T, fn func(t *testing.T, client fs.Fs)) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	t.Run("local", func(t *testing.T) {
+		client, err := fs.NewLocalFs(t.TempDir())
+		require.NoError(t, err)
+		fn(t, client)
+	})
+
+	t.Run("webdav", func(t *testing.T) {
+		webdavServer := tests.NewFakeWebDAVServer()
+		defer webdavServer.Close()
+
+		client, err := webdavServer.CreateWebDAVFs()
+		require.NoError(t, err)
+		fn(t, client)
+	})
+}
+
+func readFile(t *testing.T, client fs.Fs, path string) string {
+	reader, err := client.ReadStream(path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestRename(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, client fs.Fs) {
+		write := func(path, content string) {
+			err := client.WriteStream(path, strings.NewReader(content), int64(len(content)), 0644)
+			require.NoError(t, err)
+		}
+
+		t.Run("rename file", func(t *testing.T) {
+			write("bucket/old.txt", "old content")
+
+			require.NoError(t, client.Rename("bucket/old.txt", "bucket/new.txt"))
+
+			_, err := client.Stat("bucket/old.txt")
+			assert.True(t, fs.IsNotFound(err))
+			assert.Equal(t, "old content", readFile(t, client, "bucket/new.txt"))
+		})
+
+		t.Run("rename into new directory", func(t *testing.T) {
+			write("bucket/file.txt", "moved content")
+
+			require.NoError(t, client.Rename("bucket/file.txt", "bucket/nested/dir/file.txt"))
+
+			_, err := client.Stat("bucket/file.txt")
+			assert.True(t, fs.IsNotFound(err))
+			assert.Equal(t, "moved content", readFile(t, client, "bucket/nested/dir/file.txt"))
+		})
+
+		t.Run("rename overwrites destination", func(t *testing.T) {
+			write("bucket/source.txt", "source")
+			write("bucket/target.txt", "target")
+
+			require.NoError(t, client.Rename("bucket/source.txt", "bucket/target.txt"))
+			assert.Equal(t, "source", readFile(t, client, "bucket/target.txt"))
+		})
+
+		t.Run("rename missing source", func(t *testing.T) {
+			err := client.Rename("bucket/missing.txt", "bucket/other.txt")
+			require.Error(t, err)
+			assert.True(t, fs.IsNotFound(err), "Expected not found error, got %v", err)
+		})
+	})
+}
+
+func TestCopy(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, client fs.Fs) {
+		write := func(path, content string) {
+			err := client.WriteStream(path, strings.NewReader(content), int64(len(content)), 0644)
+			require.NoError(t, err)
+		}
+
+		t.Run("copy file", func(t *testing.T) {
+			write("bucket/source.txt", "source content")
+
+			require.NoError(t, client.Copy("bucket/source.txt", "bucket/nested/copy.txt"))
+			assert.Equal(t, "source content", readFile(t, client, "bucket/source.txt"))
+			assert.Equal(t, "source content", readFile(t, client, "bucket/nested/copy.txt"))
+
+			stat, err := client.Stat("bucket/nested/copy.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len("source content")), stat.Size())
+		})
+
+		t.Run("copy overwrites destination", func(t *testing.T) {
+			write("bucket/target.txt", "previous target")
+
+			require.NoError(t, client.Copy("bucket/source.txt", "bucket/target.txt"))
+			assert.Equal(t, "source content", readFile(t, client, "bucket/target.txt"))
+		})
+
+		t.Run("copy missing source", func(t *testing.T) {
+			err := client.Copy("bucket/missing.txt", "bucket/other.txt")
+			require.Error(t, err)
+			assert.True(t, fs.IsNotFound(err), "Expected not found error, got %v", err)
+		})
+	})
+}
+
+func TestContextCancel(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, client fs.Fs) {
+		const content = "streamed content"
+		err := client.WriteStream("bucket/file.txt", strings.NewReader(content), int64(len(content)), 0644)
+		require.NoError(t, err)
+
+		t.Run("read is aborted", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			reader, err := client.ReadStreamContext(ctx, "bucket/file.txt")
+			require.NoError(t, err)
+			defer reader.Close()
+
+			cancel()
+			_, err = io.ReadAll(reader)
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+
+		t.Run("read completes", func(t *testing.T) {
+			reader, err := client.ReadStreamContext(context.Background(), "bucket/file.txt")
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+			assert.NoError(t, reader.Close())
+		})
+
+		t.Run("write is aborted", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := client.WriteStreamContext(ctx, "bucket/aborted.txt", strings.NewReader(content), -1, 0644)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = client.Stat("bucket/aborted.txt")
+			assert.True(t, fs.IsNotFound(err), "Expected not found error, got %v", err)
+		})
+	})
+}
+
+func TestReadStreamRange(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, client fs.Fs) {
+		const content = "0123456789"
+		err := client.WriteStream("bucket/file.txt", strings.NewReader(content), int64(len(content)), 0644)
+		require.NoError(t, err)
+
+		tests := []struct {
+			offset, length int64
+			expected       string
+		}{
+			{0, 10, "0123456789"},
+			{0, 1, "0"},
+			{3, 4, "3456"},
+			{9, 1, "9"},
+			{5, 0, ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%d+%d", tt.offset, tt.length), func(t *testing.T) {
+				reader, err := client.ReadStreamRange(context.Background(), "bucket/file.txt", tt.offset, tt.length)
+				require.NoError(t, err)
+				defer reader.Close()
+
+				data, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, string(data))
+			})
+		}
+
+		t.Run("missing file", func(t *testing.T) {
+			_, err := client.ReadStreamRange(context.Background(), "bucket/missing.txt", 0, 1)
+			assert.True(t, fs.IsNotFound(err), "Expected not found error, got %v", err)
+		})
+	})
+}
+
+func TestLimitReadCloser(t *testing.T) {
+	reader, err := fs.LimitReadCloser(io.NopCloser(strings.NewReader("0123456789")), 2, 3)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "234", string(data))
+	assert.NoError(t, reader.Close())
+
+	_, err = fs.LimitReadCloser(io.NopCloser(strings.NewReader("01")), 5, 1)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestWebDAVCopyUnsupported(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	webdavServer := tests.NewFakeWebDAVServer()
+	defer webdavServer.Close()
+	webdavServer.DisableCopy()
+	webdavServer.AddFile("/bucket/source.txt", []byte("source content"))
+
+	client, err := webdavServer.CreateWebDAVFs()
+	require.NoError(t, err)
+
+	for _, dst := range []string{"bucket/first.txt", "bucket/second.txt"} {
+		require.NoError(t, client.Copy("bucket/source.txt", dst))
+		assert.Equal(t, "source content", readFile(t, client, dst))
+
+		stat, err := client.Stat(dst)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("source content")), stat.Size())
+	}
+}
+
+func TestMkdir(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, client fs.Fs) {
+		require.NoError(t, client.Mkdir("bucket"))
+
+		stat, err := client.Stat("bucket")
+		require.NoError(t, err)
+		assert.True(t, stat.IsDir())
+
+		// Creating an existing directory is not an error
+		require.NoError(t, client.Mkdir("bucket"))
+
+		entries, err := client.ReadDir("bucket")
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestWebDAVRetry(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	policy := fs.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	testCases := []struct {
+		name        string
+		policy      fs.RetryPolicy
+		failures    int
+		status      int
+		op          func(client fs.Fs) error
+		expectError bool
+	}{
+		{
+			name:     "read dir after two unavailable responses",
+			policy:   policy,
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			op: func(client fs.Fs) error {
+				_, err := client.ReadDir("bucket")
+				return err
+			},
+		},
+		{
+			name:     "stat after two unavailable responses",
+			policy:   policy,
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			op: func(client fs.Fs) error {
+				_, err := client.Stat("bucket/file.txt")
+				return err
+			},
+		},
+		{
+			name:     "read stream after two bad gateway responses",
+			policy:   policy,
+			failures: 2,
+			status:   http.StatusBadGateway,
+			op: func(client fs.Fs) error {
+				reader, err := client.ReadStream("bucket/file.txt")
+				if err == nil {
+					reader.Close()
+				}
+				return err
+			},
+		},
+		{
+			name:     "write seekable stream after two unavailable responses",
+			policy:   policy,
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			op: func(client fs.Fs) error {
+				return client.WriteStream("bucket/new.txt", strings.NewReader("new content"), 11, 0644)
+			},
+		},
+		{
+			name:     "write buffered stream after two unavailable responses",
+			policy:   policy,
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			op: func(client fs.Fs) error {
+				stream := io.MultiReader(strings.NewReader("new "), strings.NewReader("content"))
+				return client.WriteStream("bucket/new.txt", stream, 11, 0644)
+			},
+		},
+		{
+			name:     "attempts exhausted",
+			policy:   fs.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			failures: 2,
+			status:   http.StatusServiceUnavailable,
+			op: func(client fs.Fs) error {
+				_, err := client.Stat("bucket/file.txt")
+				return err
+			},
+			expectError: true,
+		},
+		{
+			name:     "retries disabled",
+			failures: 1,
+			status:   http.StatusServiceUnavailable,
+			op: func(client fs.Fs) error {
+				_, err := client.Stat("bucket/file.txt")
+				return err
+			},
+			expectError: true,
+		},
+		{
+			name:     "client errors are not retried",
+			policy:   policy,
+			failures: 1,
+			status:   http.StatusForbidden,
+			op: func(client fs.Fs) error {
+				_, err := client.Stat("bucket/file.txt")
+				return err
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			webdavServer := tests.NewFakeWebDAVServer()
+			defer webdavServer.Close()
+			webdavServer.AddFile("/bucket/file.txt", []byte("content"))
+
+			client, err := fs.NewWebDAVFs(webdavServer.URL(), "", "", false, 0, tt.policy, "/")
+			require.NoError(t, err)
+
+			webdavServer.FailRequests(tt.failures, tt.status)
+			err = tt.op(client)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestWebDAVRetryCanceled(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	webdavServer := tests.NewFakeWebDAVServer()
+	defer webdavServer.Close()
+	webdavServer.AddFile("/bucket/file.txt", []byte("content"))
+
+	client, err := fs.NewWebDAVFs(webdavServer.URL(), "", "", false, 0, fs.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute}, "/")
+	require.NoError(t, err)
+
+	t.Run("backoff stops once the context is done", func(t *testing.T) {
+		webdavServer.FailRequests(1000, http.StatusServiceUnavailable)
+		defer webdavServer.FailRequests(0, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := client.ReadStreamContext(ctx, "bucket/file.txt")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("canceled request is not retried", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		requestsBefore := webdavServer.RequestCount()
+		_, _, err := client.(fs.Redirector).ReadStreamOrRedirect(ctx, "bucket/file.txt")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, requestsBefore, webdavServer.RequestCount())
+	})
+}
+
+func TestWebDAVRetryLargeStream(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	webdavServer := tests.NewFakeWebDAVServer()
+	defer webdavServer.Close()
+
+	client, err := fs.NewWebDAVFs(webdavServer.URL(), "", "", false, 0, fs.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, "/")
+	require.NoError(t, err)
+
+	// Not seekable and too large to buffer, so it cannot be sent again
+	content := strings.Repeat("x", 8<<20)
+	stream := io.MultiReader(strings.NewReader(content))
+
+	webdavServer.FailRequests(1, http.StatusServiceUnavailable)
+	requestsBefore := webdavServer.RequestCount()
+
+	err = client.WriteStream("large.bin", stream, int64(len(content)), 0644)
+	assert.Error(t, err)
+	// Single PUT, followed by the removal of the temporary file
+	assert.Equal(t, 2, webdavServer.RequestCount()-requestsBefore)
+}
+
+func TestWebDAVTimeout(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const timeout = 100 * time.Millisecond
+
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			w.WriteHeader(http.StatusOK)
+		case "PROPFIND":
+			// Hung server
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+		case "GET":
+			// Slow, but steady stream taking longer than the timeout
+			w.WriteHeader(http.StatusOK)
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				time.Sleep(timeout / 2)
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(stall)
+
+	client, err := fs.NewWebDAVFs(server.URL, "", "", false, timeout, fs.RetryPolicy{}, "")
+	require.NoError(t, err)
+
+	t.Run("metadata operations time out", func(t *testing.T) {
+		start := time.Now()
+		_, err := client.Stat("bucket/file.txt")
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 10*timeout)
+	})
+
+	t.Run("streams are not cut off", func(t *testing.T) {
+		start := time.Now()
+		assert.Equal(t, "xxxxx", readFile(t, client, "bucket/file.txt"))
+		assert.Greater(t, time.Since(start), timeout)
+	})
+}
+
+func TestWebDAVReadCancel(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			w.WriteHeader(http.StatusOK)
+		case "GET":
+			// Stalled stream, which only ends when the client goes away
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	client, err := fs.NewWebDAVFs(server.URL, "", "", false, time.Second, fs.RetryPolicy{}, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	reader, err := client.ReadStreamContext(ctx, "bucket/file.txt")
+	require.NoError(t, err)
+	defer reader.Close()
+
+	start := time.Now()
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestWebDAVPreflight(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	testCases := []struct {
+		name          string
+		status        int
+		preflightPath string
+		expectError   bool
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, preflightPath: "/", expectError: true},
+		{name: "forbidden", status: http.StatusForbidden, preflightPath: "/", expectError: true},
+		{name: "skipped", status: http.StatusUnauthorized, preflightPath: ""},
+		{name: "other errors are ignored", status: http.StatusNotFound, preflightPath: "/"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// Anonymous OPTIONS, like servers letting anyone connect
+			var propfinds int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "PROPFIND" {
+					propfinds++
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			_, err := fs.NewWebDAVFs(server.URL, "user", "wrong", false, 0, fs.RetryPolicy{}, tt.preflightPath)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "rejected the credentials")
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tt.preflightPath == "" {
+				assert.Zero(t, propfinds)
+			} else {
+				assert.NotZero(t, propfinds)
+			}
+		})
+	}
+}
+
+// interruptedReader returns the content and then fails, like a dropped client connection
+type interruptedReader struct {
+	content io.Reader
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestWebDAVAtomicWrite(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	testCases := []struct {
+		name            string
+		disableMove     bool
+		existing        string
+		content         string
+		interrupt       bool
+		expectedContent string
+	}{
+		{
+			name:            "complete upload",
+			content:         "new content",
+			expectedContent: "new content",
+		},
+		{
+			name:      "interrupted upload",
+			content:   "partial",
+			interrupt: true,
+		},
+		{
+			name:            "interrupted upload keeps existing object",
+			existing:        "old content",
+			content:         "partial",
+			interrupt:       true,
+			expectedContent: "old content",
+		},
+		{
+			name:            "complete upload without MOVE support",
+			disableMove:     true,
+			existing:        "old content",
+			content:         "new content",
+			expectedContent: "new content",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			webdavServer := tests.NewFakeWebDAVServer()
+			defer webdavServer.Close()
+			if tt.existing != "" {
+				webdavServer.AddFile("/bucket/file.txt", []byte(tt.existing))
+			}
+			if tt.disableMove {
+				webdavServer.DisableMove()
+			}
+
+			client, err := webdavServer.CreateWebDAVFs()
+			require.NoError(t, err)
+
+			var stream io.Reader = strings.NewReader(tt.content)
+			contentLength := int64(len(tt.content))
+			if tt.interrupt {
+				stream = &interruptedReader{content: stream}
+				contentLength = 100
+			}
+
+			err = client.WriteStream("bucket/file.txt", stream, contentLength, 0644)
+			if tt.interrupt {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tt.expectedContent == "" {
+				_, err := client.Stat("bucket/file.txt")
+				assert.True(t, fs.IsNotFound(err))
+			} else {
+				assert.Equal(t, tt.expectedContent, readFile(t, client, "bucket/file.txt"))
+			}
+
+			// No temporary files are left behind
+			infos, err := client.ReadDir("bucket")
+			require.NoError(t, err)
+			names := []string{}
+			for _, info := range infos {
+				names = append(names, info.Name())
+			}
+			if tt.expectedContent == "" {
+				assert.Empty(t, names)
+			} else {
+				assert.Equal(t, []string{"file.txt"}, names)
+			}
+		})
+	}
+
+	t.Run("direct writes after MOVE was rejected", func(t *testing.T) {
+		webdavServer := tests.NewFakeWebDAVServer()
+		defer webdavServer.Close()
+		webdavServer.DisableMove()
+
+		client, err := webdavServer.CreateWebDAVFs()
+		require.NoError(t, err)
+
+		require.NoError(t, client.WriteStream("bucket/first.txt", strings.NewReader("first"), 5, 0644))
+
+		requestsBefore := webdavServer.RequestCount()
+		require.NoError(t, client.WriteStream("bucket/second.txt", strings.NewReader("second"), 6, 0644))
+		// MKCOL of the parent and PUT, without a temporary file and MOVE
+		assert.Equal(t, 2, webdavServer.RequestCount()-requestsBefore)
+		assert.Equal(t, "second", readFile(t, client, "bucket/second.txt"))
+	})
+}
+
+func setupTestS3Fs(t *testing.T, secretKey string) (fs.Fs, error) {
+	local, err := fs.NewLocalFs(t.TempDir())
+	require.NoError(t, err)
+
+	db, err := cache.NewCacheDB(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	server := s3.NewServer(db, local)
+	server.SetBucketMap(map[string]interface{}{"backend": nil})
+
+	router := mux.NewRouter()
+	server.SetupReadRoutes(router)
+	server.SetupWriteRoutes(router)
+
+	httpServer := httptest.NewServer(s3.AuthMiddleware(s3.AuthMiddlewareConfig{
+		Credentials:  s3.AuthConfig{AccessKey: "access-key", SecretKey: "secret-key"},
+		MaxClockSkew: s3.DefaultMaxClockSkew,
+	}, router))
+	t.Cleanup(httpServer.Close)
+
+	return fs.NewS3Fs(httpServer.URL, "access-key", secretKey, "backend")
+}
+
+func TestS3Fs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	client, err := setupTestS3Fs(t, "secret-key")
+	require.NoError(t, err)
+
+	for path, content := range map[string]string{
+		"bucket/file.txt":            "file content",
+		"bucket/dir/nested file.txt": "nested content",
+	} {
+		err := client.WriteStream(path, strings.NewReader(content), int64(len(content)), 0644)
+		require.NoError(t, err)
+	}
+
+	t.Run("read root", func(t *testing.T) {
+		infos, err := client.ReadDir("")
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.Equal(t, "bucket", infos[0].Name())
+		assert.True(t, infos[0].IsDir())
+	})
+
+	t.Run("read dir", func(t *testing.T) {
+		infos, err := client.ReadDir("bucket/")
+		require.NoError(t, err)
+
+		names := map[string]bool{}
+		for _, info := range infos {
+			names[info.Name()] = info.IsDir()
+		}
+		assert.Equal(t, map[string]bool{"file.txt": false, "dir": true}, names)
+	})
+
+	t.Run("read missing dir", func(t *testing.T) {
+		_, err := client.ReadDir("bucket/missing/")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("stat file", func(t *testing.T) {
+		info, err := client.Stat("bucket/dir/nested file.txt")
+		require.NoError(t, err)
+		assert.False(t, info.IsDir())
+		assert.Equal(t, int64(len("nested content")), info.Size())
+		assert.False(t, info.ModTime().IsZero())
+	})
+
+	t.Run("stat dir", func(t *testing.T) {
+		info, err := client.Stat("bucket/dir")
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+	})
+
+	t.Run("stat missing", func(t *testing.T) {
+		_, err := client.Stat("bucket/missing.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("read stream", func(t *testing.T) {
+		assert.Equal(t, "nested content", readFile(t, client, "bucket/dir/nested file.txt"))
+
+		_, err := client.ReadStream("bucket/missing.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("read stream range", func(t *testing.T) {
+		reader, err := client.ReadStreamRange(context.Background(), "bucket/dir/nested file.txt", 7, 4)
+		require.NoError(t, err)
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "cont", string(data))
+	})
+
+	t.Run("write stream of unknown length", func(t *testing.T) {
+		require.NoError(t, client.WriteStream("bucket/unknown.txt", strings.NewReader("unknown length"), -1, 0644))
+		assert.Equal(t, "unknown length", readFile(t, client, "bucket/unknown.txt"))
+
+		info, err := client.Stat("bucket/unknown.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("unknown length")), info.Size())
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		require.NoError(t, client.Copy("bucket/dir/nested file.txt", "bucket/copy.txt"))
+		assert.Equal(t, "nested content", readFile(t, client, "bucket/copy.txt"))
+
+		info, err := client.Stat("bucket/copy.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("nested content")), info.Size())
+
+		err = client.Copy("bucket/missing.txt", "bucket/other.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, client.Remove("bucket/file.txt"))
+
+		_, err := client.Stat("bucket/file.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+}
+
+func TestS3FsInvalidCredentials(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	_, err := setupTestS3Fs(t, "wrong-secret")
+	assert.Error(t, err)
+}
+
+func TestHashedPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"bucket", "bucket"},
+		{"bucket/", "bucket/"},
+		{"bucket/key", "bucket/3c/6e/key"},
+		{"/bucket/key", "/bucket/3c/6e/key"},
+		{"bucket/dir/", "bucket/73/60/dir/"},
+		{"bucket/dir/key", "bucket/73/60/dir/50/3d/key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.expected, fs.HashedPath(tt.path))
+		})
+	}
+}
+
+func TestHashedFs(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, backend fs.Fs) {
+		client := fs.NewHashedFs(backend)
+
+		write := func(path, content string) {
+			err := client.WriteStream(path, strings.NewReader(content), int64(len(content)), 0644)
+			require.NoError(t, err)
+		}
+
+		names := func(path string) []string {
+			infos, err := client.ReadDir(path)
+			require.NoError(t, err)
+			var names []string
+			for _, info := range infos {
+				names = append(names, info.Name())
+			}
+			return names
+		}
+
+		require.NoError(t, client.Mkdir("bucket"))
+		write("bucket/a.txt", "a")
+		write("bucket/b.txt", "b")
+		write("bucket/dir/c.txt", "c")
+
+		t.Run("files are stored at hashed paths", func(t *testing.T) {
+			assert.Equal(t, "a", readFile(t, backend, "bucket/a5/e5/a.txt"))
+			assert.Equal(t, "c", readFile(t, backend, fs.HashedPath("bucket/dir/c.txt")))
+
+			_, err := backend.Stat("bucket/a.txt")
+			assert.True(t, fs.IsNotFound(err))
+		})
+
+		t.Run("read", func(t *testing.T) {
+			assert.Equal(t, "a", readFile(t, client, "bucket/a.txt"))
+			assert.Equal(t, "c", readFile(t, client, "bucket/dir/c.txt"))
+
+			stat, err := client.Stat("bucket/b.txt")
+			require.NoError(t, err)
+			assert.False(t, stat.IsDir())
+			assert.Equal(t, int64(1), stat.Size())
+		})
+
+		t.Run("read dir", func(t *testing.T) {
+			assert.ElementsMatch(t, []string{"a.txt", "b.txt", "dir"}, names("bucket"))
+			assert.ElementsMatch(t, []string{"c.txt"}, names("bucket/dir/"))
+
+			_, err := client.ReadDir("bucket/missing/")
+			assert.True(t, fs.IsNotFound(err))
+		})
+
+		t.Run("stat dir reports latest content change", func(t *testing.T) {
+			before, err := client.Stat("bucket/dir/")
+			require.NoError(t, err)
+			assert.True(t, before.IsDir())
+
+			time.Sleep(1100 * time.Millisecond)
+			write("bucket/dir/d.txt", "d")
+
+			after, err := client.Stat("bucket/dir/")
+			require.NoError(t, err)
+			assert.True(t, after.ModTime().After(before.ModTime()))
+		})
+
+		t.Run("remove", func(t *testing.T) {
+			require.NoError(t, client.Remove("bucket/a.txt"))
+			_, err := client.Stat("bucket/a.txt")
+			assert.True(t, fs.IsNotFound(err))
+			assert.ElementsMatch(t, []string{"b.txt", "dir"}, names("bucket"))
+		})
+
+		t.Run("remove empty dir", func(t *testing.T) {
+			require.NoError(t, client.Remove("bucket/dir/c.txt"))
+			require.NoError(t, client.Remove("bucket/dir/d.txt"))
+			assert.Empty(t, names("bucket/dir/"))
+
+			require.NoError(t, client.Remove("bucket/dir/"))
+			_, err := client.Stat("bucket/dir/")
+			assert.True(t, fs.IsNotFound(err))
+			assert.ElementsMatch(t, []string{"b.txt"}, names("bucket"))
+		})
+
+		t.Run("rename", func(t *testing.T) {
+			require.NoError(t, client.Rename("bucket/b.txt", "bucket/nested/e.txt"))
+			assert.Equal(t, "b", readFile(t, client, "bucket/nested/e.txt"))
+			assert.Equal(t, "b", readFile(t, backend, fs.HashedPath("bucket/nested/e.txt")))
+		})
+
+		t.Run("copy", func(t *testing.T) {
+			require.NoError(t, client.Copy("bucket/nested/e.txt", "bucket/f.txt"))
+			assert.Equal(t, "b", readFile(t, client, "bucket/f.txt"))
+			assert.Equal(t, "b", readFile(t, backend, fs.HashedPath("bucket/f.txt")))
+		})
+	})
+}
+
+func TestMappedFs(t *testing.T) {
+	forEachTestFs(t, func(t *testing.T, backend fs.Fs) {
+		client := fs.NewMappedFs(backend, map[string]string{"photos": "media/images"})
+
+		write := func(client fs.Fs, path, content string) {
+			err := client.WriteStream(path, strings.NewReader(content), int64(len(content)), 0644)
+			require.NoError(t, err)
+		}
+
+		write(client, "photos/a.jpg", "a")
+		write(client, "other/b.txt", "b")
+
+		t.Run("mapped bucket is stored under the prefix", func(t *testing.T) {
+			assert.Equal(t, "a", readFile(t, backend, "media/images/a.jpg"))
+			assert.Equal(t, "a", readFile(t, client, "photos/a.jpg"))
+
+			_, err := backend.Stat("photos/a.jpg")
+			assert.True(t, fs.IsNotFound(err))
+		})
+
+		t.Run("other buckets are not mapped", func(t *testing.T) {
+			assert.Equal(t, "b", readFile(t, backend, "other/b.txt"))
+		})
+
+		t.Run("read dir", func(t *testing.T) {
+			infos, err := client.ReadDir("photos/")
+			require.NoError(t, err)
+			require.Len(t, infos, 1)
+			assert.Equal(t, "a.jpg", infos[0].Name())
+		})
+
+		t.Run("rename and copy", func(t *testing.T) {
+			require.NoError(t, client.Rename("photos/a.jpg", "photos/dir/c.jpg"))
+			assert.Equal(t, "a", readFile(t, backend, "media/images/dir/c.jpg"))
+
+			require.NoError(t, client.Copy("photos/dir/c.jpg", "other/c.jpg"))
+			assert.Equal(t, "a", readFile(t, backend, "other/c.jpg"))
+		})
+
+		t.Run("remove", func(t *testing.T) {
+			require.NoError(t, client.Remove("photos/dir/c.jpg"))
+			_, err := backend.Stat("media/images/dir/c.jpg")
+			assert.True(t, fs.IsNotFound(err))
+		})
+	})
+}
+
+func TestFailoverFs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	primaryServer := tests.NewFakeWebDAVServer()
+	defer primaryServer.Close()
+	secondaryServer := tests.NewFakeWebDAVServer()
+	defer secondaryServer.Close()
+
+	primary, err := primaryServer.CreateWebDAVFs()
+	require.NoError(t, err)
+	secondary, err := secondaryServer.CreateWebDAVFs()
+	require.NoError(t, err)
+	client := fs.NewFailoverFs(primary, secondary)
+
+	primaryServer.AddFile("/bucket/file.txt", []byte("primary"))
+	secondaryServer.AddFile("/bucket/file.txt", []byte("secondary"))
+	secondaryServer.AddFile("/bucket/replica-only.txt", []byte("replica"))
+
+	t.Run("reads from the primary", func(t *testing.T) {
+		assert.Equal(t, "primary", readFile(t, client, "bucket/file.txt"))
+
+		// A missing file is not looked up on the fallbacks
+		_, err := client.Stat("bucket/replica-only.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("reads fail over when the primary fails", func(t *testing.T) {
+		primaryServer.FailRequests(1000, http.StatusServiceUnavailable)
+		defer primaryServer.FailRequests(0, 0)
+
+		assert.Equal(t, "secondary", readFile(t, client, "bucket/file.txt"))
+
+		info, err := client.Stat("bucket/replica-only.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), info.Size())
+
+		infos, err := client.ReadDir("bucket/")
+		require.NoError(t, err)
+		assert.Len(t, infos, 2)
+
+		reader, location, err := client.ReadStreamOrRedirect(context.Background(), "bucket/file.txt")
+		require.NoError(t, err)
+		assert.Empty(t, location)
+		reader.Close()
+
+		// Writes go only to the primary
+		err = client.WriteStream("bucket/new.txt", strings.NewReader("new"), 3, 0644)
+		assert.Error(t, err)
+		_, err = secondary.Stat("bucket/new.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("reads start with the fallbacks until the primary is back", func(t *testing.T) {
+		requests := primaryServer.RequestCount()
+		assert.Equal(t, "secondary", readFile(t, client, "bucket/file.txt"))
+		assert.Equal(t, requests, primaryServer.RequestCount())
+
+		client.CheckPrimary("/")
+		assert.Equal(t, "primary", readFile(t, client, "bucket/file.txt"))
+	})
+
+	t.Run("writes go to the primary", func(t *testing.T) {
+		require.NoError(t, client.WriteStream("bucket/new.txt", strings.NewReader("new"), 3, 0644))
+		assert.Equal(t, "new", readFile(t, primary, "bucket/new.txt"))
+		_, err := secondary.Stat("bucket/new.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("canceled reads do not fail over", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		requests := secondaryServer.RequestCount()
+		_, _, err := client.ReadStreamOrRedirect(ctx, "bucket/file.txt")
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.Equal(t, "primary", readFile(t, client, "bucket/file.txt"))
+		assert.Equal(t, requests, secondaryServer.RequestCount())
+	})
+
+	t.Run("fails when every backend fails", func(t *testing.T) {
+		primaryServer.FailRequests(1000, http.StatusServiceUnavailable)
+		defer primaryServer.FailRequests(0, 0)
+		secondaryServer.FailRequests(1000, http.StatusBadGateway)
+		defer secondaryServer.FailRequests(0, 0)
+
+		_, err := client.Stat("bucket/file.txt")
+		assert.Error(t, err)
+	})
+}
+
+func TestCleanBackendPrefix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		expected string
+		valid    bool
+	}{
+		{"media/images", "media/images", true},
+		{"/media/images/", "media/images", true},
+		{"", "", false},
+		{"/", "", false},
+		{"media//images", "", false},
+		{"media/../images", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			prefix, err := fs.CleanBackendPrefix(tt.prefix)
+			if !tt.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, prefix)
+		})
+	}
+}
@@ -1,32 +1,93 @@
 package fs
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/studio-b12/gowebdav"
 )
 
+// retryBufferSize is the largest non-seekable upload buffered in memory to allow retries
+const retryBufferSize = 4 << 20
+
+// RetryPolicy controls retries of WebDAV operations failing with 5xx or connection errors
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of each operation, values below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled for every next one
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries, zero means no cap
+	MaxBackoff time.Duration
+}
+
 type webdavFs struct {
 	client *gowebdav.Client
+	retry  RetryPolicy
+
+	// streamClient reads and writes files, without the timeout of the whole request
+	streamClient *gowebdav.Client
+
+	// moveUnsupported is set once the server rejected MOVE, uploads then go directly to the path
+	moveUnsupported atomic.Bool
+	// copyUnsupported is set once the server rejected COPY, copies then go through the bridge
+	copyUnsupported atomic.Bool
+
+	// redirectClient reads files without following redirects
+	redirectClient *http.Client
+	url            string
+	user           string
+	password       string
 }
 
-func NewWebDAVFs(webdavURL, webdavUser, webdavPassword string, webdavInsecure bool) (Fs, error) {
+// NewWebDAVFs creates filesystem on the WebDAV server, timeout limits the whole request
+// of metadata operations, but only the wait for the response of reads and writes.
+// The credentials are checked by reading preflightPath, unless it is empty
+func NewWebDAVFs(webdavURL, webdavUser, webdavPassword string, webdavInsecure bool, timeout time.Duration, retry RetryPolicy, preflightPath string) (Fs, error) {
 	// Create WebDAV client
 	log.Printf("WebDAV: URL: %s", webdavURL)
 	log.Printf("WebDAV: User: %s", webdavUser)
 
-	client := gowebdav.NewClient(webdavURL, webdavUser, webdavPassword)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
 
 	// Configure TLS settings if needed
 	if webdavInsecure {
 		log.Printf("WebDAV: Allowing self-signed certificates")
-		client.SetTransport(&http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		})
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if timeout > 0 {
+		log.Printf("WebDAV: Timeout: %v", timeout)
+	}
+
+	// The clients share the authentication negotiated on connect
+	auth := gowebdav.NewAutoAuth(webdavUser, webdavPassword)
+
+	client := gowebdav.NewAuthClient(webdavURL, auth)
+	client.SetTransport(transport)
+	client.SetTimeout(timeout)
+
+	streamClient := gowebdav.NewAuthClient(webdavURL, auth)
+	streamClient.SetTransport(transport)
+
+	redirectClient := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
 
 	if err := client.Connect(); err != nil {
@@ -34,25 +95,349 @@ func NewWebDAVFs(webdavURL, webdavUser, webdavPassword string, webdavInsecure bo
 	}
 	log.Printf("WebDAV: Successfully connected to WebDAV server")
 
-	return &webdavFs{client: client}, nil
+	if preflightPath != "" {
+		if err := preflight(client, preflightPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if retry.MaxAttempts > 1 {
+		log.Printf("WebDAV: Retrying failed operations up to %d times", retry.MaxAttempts-1)
+	}
+
+	return &webdavFs{
+		client:         client,
+		retry:          retry,
+		streamClient:   streamClient,
+		redirectClient: redirectClient,
+		url:            webdavURL,
+		user:           webdavUser,
+		password:       webdavPassword,
+	}, nil
 }
 
-func (fs *webdavFs) ReadDir(path string) ([]os.FileInfo, error) {
-	return fs.client.ReadDir(path)
+// preflight reads the path to check the credentials, as servers allowing anonymous
+// access to the root accept any credentials on connect. Only rejected credentials
+// fail, other errors are left for the operations to report
+func preflight(client *gowebdav.Client, path string) error {
+	_, err := client.Stat(path)
+
+	var statusErr gowebdav.StatusError
+	if errors.As(err, &statusErr) && (statusErr.Status == http.StatusUnauthorized || statusErr.Status == http.StatusForbidden) {
+		return fmt.Errorf("WebDAV server rejected the credentials with %d %s on PROPFIND of %s",
+			statusErr.Status, http.StatusText(statusErr.Status), path)
+	} else if err != nil {
+		log.Printf("WebDAV: Pre-flight PROPFIND of %s failed: %v", path, err)
+	} else {
+		log.Printf("WebDAV: Credentials accepted on PROPFIND of %s", path)
+	}
+	return nil
 }
 
-func (fs *webdavFs) Stat(path string) (os.FileInfo, error) {
-	return fs.client.Stat(path)
+// isRetryable checks if the error is a server error or a connection error. A request
+// canceled by the caller, like for a client that went away, is not a failure of the server
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr gowebdav.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// withRetry runs the operation until it succeeds, fails with a non-retryable error,
+// runs out of attempts, or the context is done, with before called ahead of every retry.
+// The context is checked as the deadline of the caller fails the operation like a timeout
+// of the server, which is retried
+func (fs *webdavFs) withRetry(ctx context.Context, op string, before func() error, fn func() error) error {
+	backoff := fs.retry.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= fs.retry.MaxAttempts || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("WebDAV: %s failed (attempt %d/%d), retrying in %v: %v",
+			op, attempt, fs.retry.MaxAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if fs.retry.MaxBackoff > 0 && backoff > fs.retry.MaxBackoff {
+			backoff = fs.retry.MaxBackoff
+		}
+
+		if before != nil {
+			if err := before(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (fs *webdavFs) ReadDir(path string) (infos []os.FileInfo, err error) {
+	err = fs.withRetry(context.Background(), "ReadDir", nil, func() (err error) {
+		infos, err = fs.client.ReadDir(path)
+		return err
+	})
+	return infos, err
+}
+
+func (fs *webdavFs) Stat(path string) (info os.FileInfo, err error) {
+	err = fs.withRetry(context.Background(), "Stat", nil, func() (err error) {
+		info, err = fs.client.Stat(path)
+		return err
+	})
+	return info, err
 }
 
 func (fs *webdavFs) ReadStream(path string) (io.ReadCloser, error) {
-	return fs.client.ReadStream(path)
+	return fs.readStream(context.Background(), path)
+}
+
+func (fs *webdavFs) readStream(ctx context.Context, path string) (reader io.ReadCloser, err error) {
+	err = fs.withRetry(ctx, "ReadStream", nil, func() (err error) {
+		reader, err = fs.streamClient.ReadStream(path)
+		return err
+	})
+	return reader, err
+}
+
+// ReadStreamContext reads the file, closing the response once the context is done,
+// as the WebDAV client does not take a context
+func (fs *webdavFs) ReadStreamContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := fs.readStream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return closeOnDone(ctx, reader), nil
+}
+
+// ReadStreamRange reads the range of the file, the WebDAV client skips to the offset
+// itself when the server answers with the whole file
+func (fs *webdavFs) ReadStreamRange(ctx context.Context, path string, offset, length int64) (reader io.ReadCloser, err error) {
+	if length <= 0 {
+		// The client asks for the rest of the file for a zero length
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	err = fs.withRetry(ctx, "ReadStream", nil, func() (err error) {
+		reader, err = fs.streamClient.ReadStreamRange(path, offset, length)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return closeOnDone(ctx, reader), nil
+}
+
+func (fs *webdavFs) ReadStreamOrRedirect(ctx context.Context, path string) (reader io.ReadCloser, location string, err error) {
+	err = fs.withRetry(ctx, "ReadStream", nil, func() (err error) {
+		reader, location, err = fs.readStreamOrRedirect(ctx, path)
+		return err
+	})
+	return reader, location, err
+}
+
+func (fs *webdavFs) readStreamOrRedirect(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", gowebdav.PathEscape(gowebdav.Join(fs.url, path)), nil)
+	if err != nil {
+		return nil, "", gowebdav.NewPathErrorErr("ReadStream", path, err)
+	}
+	if fs.user != "" {
+		req.SetBasicAuth(fs.user, fs.password)
+	}
+
+	resp, err := fs.redirectClient.Do(req)
+	if err != nil {
+		return nil, "", gowebdav.NewPathErrorErr("ReadStream", path, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, "", nil
+
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			return nil, "", gowebdav.NewPathErrorErr("ReadStream", path, err)
+		}
+		return nil, location.String(), nil
+	}
+
+	resp.Body.Close()
+	return nil, "", gowebdav.NewPathError("ReadStream", path, resp.StatusCode)
 }
 
+// WriteStream uploads the stream to a temporary sibling and moves it into place,
+// so interrupted uploads never leave a truncated object at the path
 func (fs *webdavFs) WriteStream(path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
-	return fs.client.WriteStreamWithLength(path, stream, contentLength, mode)
+	// WebDAV servers often reject chunked uploads, so a body of unknown length,
+	// like of a chunked S3 upload, is spooled to a temporary file to send its length
+	if contentLength < 0 {
+		spooled, err := spoolToTempFile(stream)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			spooled.Close()
+			os.Remove(spooled.Name())
+		}()
+
+		info, err := spooled.Stat()
+		if err != nil {
+			return err
+		}
+		stream, contentLength = spooled, info.Size()
+	}
+
+	if fs.moveUnsupported.Load() {
+		return fs.writeStream(path, stream, contentLength, mode)
+	}
+
+	tempPath := tempSiblingPath(path)
+	if err := fs.writeStream(tempPath, stream, contentLength, mode); err != nil {
+		fs.client.Remove(tempPath)
+		return err
+	}
+
+	err := fs.withRetry(context.Background(), "Rename", nil, func() error {
+		return fs.client.Rename(tempPath, path, true)
+	})
+	if isMethodUnsupported(err) {
+		log.Printf("WebDAV: MOVE is not supported, writing directly to the destination: %v", err)
+		fs.moveUnsupported.Store(true)
+		err = fs.copyStream(tempPath, path, contentLength, mode)
+	} else if err == nil {
+		return nil
+	}
+
+	fs.client.Remove(tempPath)
+	return err
+}
+
+func (fs *webdavFs) WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	return fs.WriteStream(path, &contextReader{Reader: stream, ctx: ctx}, contentLength, mode)
+}
+
+// spoolToTempFile copies the stream to a temporary file, rewound to its start
+func spoolToTempFile(stream io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "s3-to-webdav-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(file, stream)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// copyStream uploads the content of srcPath to dstPath
+func (fs *webdavFs) copyStream(srcPath, dstPath string, contentLength int64, mode os.FileMode) error {
+	reader, err := fs.ReadStream(srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return fs.writeStream(dstPath, reader, contentLength, mode)
+}
+
+// tempSiblingPath returns a unique temporary path in the directory of the path
+func tempSiblingPath(path string) string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	return path + ".tmp" + hex.EncodeToString(suffix)
+}
+
+// isMethodUnsupported checks if the server rejected MOVE or COPY as not allowed or not implemented
+func isMethodUnsupported(err error) bool {
+	var statusErr gowebdav.StatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.Status == http.StatusMethodNotAllowed || statusErr.Status == http.StatusNotImplemented)
+}
+
+// writeStream uploads the stream to the path, retrying when the stream can be rewound
+func (fs *webdavFs) writeStream(path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	if fs.retry.MaxAttempts <= 1 {
+		return fs.streamClient.WriteStreamWithLength(path, stream, contentLength, mode)
+	}
+
+	// The body can be sent again only if it can be rewound
+	var rewind func() error
+	if seeker, ok := stream.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			rewind = func() error {
+				_, err := seeker.Seek(start, io.SeekStart)
+				return err
+			}
+		}
+	}
+	if rewind == nil && contentLength >= 0 && contentLength <= retryBufferSize {
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			return err
+		}
+		buffered := bytes.NewReader(data)
+		stream = buffered
+		rewind = func() error {
+			_, err := buffered.Seek(0, io.SeekStart)
+			return err
+		}
+	}
+	if rewind == nil {
+		return fs.streamClient.WriteStreamWithLength(path, stream, contentLength, mode)
+	}
+
+	return fs.withRetry(context.Background(), "WriteStream", rewind, func() error {
+		return fs.streamClient.WriteStreamWithLength(path, stream, contentLength, mode)
+	})
 }
 
 func (fs *webdavFs) Remove(path string) error {
 	return fs.client.Remove(path)
 }
+
+func (fs *webdavFs) Rename(oldPath, newPath string) error {
+	return fs.client.Rename(oldPath, newPath, true)
+}
+
+func (fs *webdavFs) Mkdir(path string) error {
+	return fs.client.MkdirAll(path, 0755)
+}
+
+// Copy copies the file on the server with COPY, without the timeout of the whole request
+// as the server copies all bytes, or reads and writes it back if COPY is not supported
+func (fs *webdavFs) Copy(srcPath, dstPath string) error {
+	if fs.copyUnsupported.Load() {
+		return CopyStream(fs, srcPath, dstPath)
+	}
+
+	err := fs.streamClient.Copy(srcPath, dstPath, true)
+	if isMethodUnsupported(err) {
+		log.Printf("WebDAV: COPY is not supported, copying through the bridge: %v", err)
+		fs.copyUnsupported.Store(true)
+		return CopyStream(fs, srcPath, dstPath)
+	}
+	return err
+}
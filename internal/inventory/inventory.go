@@ -0,0 +1,242 @@
+package inventory
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"s3-to-webdav/internal/cache"
+	"s3-to-webdav/internal/fs"
+)
+
+const (
+	fileSchema   = "Bucket, Key, Size, LastModifiedDate, ETag, StorageClass"
+	storageClass = "STANDARD"
+	listBatch    = 1000
+)
+
+// Options controls the layout of the exported inventory
+type Options struct {
+	// Gzip compresses the data files, as S3 Inventory does by default
+	Gzip bool
+	// RowsPerFile splits the data into multiple files, zero means a single file
+	RowsPerFile int
+	// ETag returns the ETag reported for the entry
+	ETag func(entry fs.EntryInfo) string
+}
+
+// Manifest is the manifest.json of S3 Inventory report
+type Manifest struct {
+	SourceBucket      string         `json:"sourceBucket"`
+	DestinationBucket string         `json:"destinationBucket"`
+	Version           string         `json:"version"`
+	CreationTimestamp string         `json:"creationTimestamp"`
+	FileFormat        string         `json:"fileFormat"`
+	FileSchema        string         `json:"fileSchema"`
+	Files             []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5checksum string `json:"MD5checksum"`
+}
+
+// Export writes the S3 Inventory report of the bucket into destDir, using the layout
+// <destDir>/<bucket>/data/<file>.csv[.gz] and <destDir>/<bucket>/<timestamp>/manifest.json
+func Export(db cache.Cache, bucket, destDir string, opts Options) (*Manifest, error) {
+	now := time.Now().UTC()
+	bucketDir := filepath.Join(destDir, bucket)
+	if err := os.MkdirAll(filepath.Join(bucketDir, "data"), 0755); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		SourceBucket:      bucket,
+		DestinationBucket: filepath.Base(destDir),
+		Version:           "2016-11-30",
+		CreationTimestamp: strconv.FormatInt(now.UnixMilli(), 10),
+		FileFormat:        "CSV",
+		FileSchema:        fileSchema,
+	}
+
+	var writer *dataWriter
+	defer func() {
+		if writer != nil {
+			writer.abort()
+		}
+	}()
+
+	flush := func() error {
+		file, err := writer.close(destDir)
+		writer = nil
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	}
+
+	marker := ""
+	rows := 0
+
+	for {
+		entries, truncated, err := db.List(bucket+"/", marker, false, listBatch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", bucket, err)
+		}
+
+		for _, entry := range entries {
+			if writer == nil {
+				name := fmt.Sprintf("%s-%d.csv", now.Format("20060102T150405Z"), len(manifest.Files))
+				if opts.Gzip {
+					name += ".gz"
+				}
+				writer, err = newDataWriter(filepath.Join(bucketDir, "data", name), opts.Gzip)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if err := writer.write(bucket, entry, opts.ETag); err != nil {
+				return nil, err
+			}
+			rows++
+
+			if opts.RowsPerFile > 0 && rows%opts.RowsPerFile == 0 {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if !truncated || len(entries) == 0 {
+			break
+		}
+		marker = entries[len(entries)-1].Path
+	}
+
+	if writer != nil {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	manifestDir := filepath.Join(bucketDir, now.Format("2006-01-02T15-04Z"))
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(manifestDir, "manifest.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// dataWriter writes a single inventory data file and tracks its checksum
+type dataWriter struct {
+	path   string
+	file   *os.File
+	hasher hash.Hash
+	gzip   *gzip.Writer
+	csv    *csv.Writer
+}
+
+func newDataWriter(path string, compress bool) (*dataWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &dataWriter{
+		path:   path,
+		file:   file,
+		hasher: md5.New(),
+	}
+	out := io.MultiWriter(file, w.hasher)
+
+	if compress {
+		w.gzip = gzip.NewWriter(out)
+		w.csv = csv.NewWriter(w.gzip)
+	} else {
+		w.csv = csv.NewWriter(out)
+	}
+	return w, nil
+}
+
+func (w *dataWriter) write(bucket string, entry fs.EntryInfo, etag func(fs.EntryInfo) string) error {
+	_, key, _ := fs.BucketAndKeyFromPath(entry.Path)
+
+	class := entry.StorageClass
+	if class == "" {
+		class = storageClass
+	}
+
+	tag := ""
+	if etag != nil {
+		tag = strings.Trim(etag(entry), "\"")
+	}
+
+	return w.csv.Write([]string{
+		bucket,
+		strings.ReplaceAll(url.QueryEscape(key), "%2F", "/"),
+		strconv.FormatInt(entry.Size, 10),
+		time.Unix(entry.LastModified, 0).UTC().Format("2006-01-02T15:04:05.000Z"),
+		tag,
+		class,
+	})
+}
+
+func (w *dataWriter) close(destDir string) (ManifestFile, error) {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		w.abort()
+		return ManifestFile{}, err
+	}
+	if w.gzip != nil {
+		if err := w.gzip.Close(); err != nil {
+			w.abort()
+			return ManifestFile{}, err
+		}
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.path)
+		return ManifestFile{}, err
+	}
+
+	stat, err := os.Stat(w.path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	key, err := filepath.Rel(destDir, w.path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	return ManifestFile{
+		Key:         filepath.ToSlash(key),
+		Size:        stat.Size(),
+		MD5checksum: hex.EncodeToString(w.hasher.Sum(nil)),
+	}, nil
+}
+
+func (w *dataWriter) abort() {
+	w.file.Close()
+	os.Remove(w.path)
+}
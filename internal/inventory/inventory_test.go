@@ -0,0 +1,120 @@
+package inventory
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"s3-to-webdav/internal/cache"
+	"s3-to-webdav/internal/fs"
+)
+
+func setupInventoryTest(t *testing.T) cache.Cache {
+	db, err := cache.NewCacheDB(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix()
+	err = db.Insert(
+		fs.EntryInfo{Path: "test-bucket/", IsDir: true, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/a.txt", Size: 10, LastModified: modTime, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/dir/", IsDir: true, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/dir/b c.txt", Size: 20, LastModified: modTime, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/dir/d.txt", Size: 30, LastModified: modTime, Processed: true},
+		fs.EntryInfo{Path: "other-bucket/e.txt", Size: 40, LastModified: modTime, Processed: true},
+	)
+	require.NoError(t, err)
+	return db
+}
+
+func readDataFile(t *testing.T, path string, compressed bool) [][]string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var reader io.Reader = file
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		defer gz.Close()
+		reader = gz
+	}
+
+	records, err := csv.NewReader(reader).ReadAll()
+	require.NoError(t, err)
+	return records
+}
+
+func TestExport(t *testing.T) {
+	db := setupInventoryTest(t)
+	destDir := t.TempDir()
+
+	manifest, err := Export(db, "test-bucket", destDir, Options{
+		Gzip: true,
+		ETag: func(entry fs.EntryInfo) string { return "\"etag-" + filepath.Base(entry.Path) + "\"" },
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "test-bucket", manifest.SourceBucket)
+	assert.Equal(t, "CSV", manifest.FileFormat)
+	assert.Equal(t, "Bucket, Key, Size, LastModifiedDate, ETag, StorageClass", manifest.FileSchema)
+	require.Len(t, manifest.Files, 1)
+
+	dataPath := filepath.Join(destDir, manifest.Files[0].Key)
+	data, err := os.ReadFile(dataPath)
+	require.NoError(t, err)
+	sum := md5.Sum(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), manifest.Files[0].MD5checksum)
+	assert.Equal(t, int64(len(data)), manifest.Files[0].Size)
+
+	records := readDataFile(t, dataPath, true)
+	assert.Equal(t, [][]string{
+		{"test-bucket", "a.txt", "10", "2024-01-02T03:04:05.000Z", "etag-a.txt", "STANDARD"},
+		{"test-bucket", "dir/b+c.txt", "20", "2024-01-02T03:04:05.000Z", "etag-b c.txt", "STANDARD"},
+		{"test-bucket", "dir/d.txt", "30", "2024-01-02T03:04:05.000Z", "etag-d.txt", "STANDARD"},
+	}, records)
+
+	manifests, err := filepath.Glob(filepath.Join(destDir, "test-bucket", "*", "manifest.json"))
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+
+	var stored Manifest
+	content, err := os.ReadFile(manifests[0])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(content, &stored))
+	assert.Equal(t, *manifest, stored)
+}
+
+func TestExportChunked(t *testing.T) {
+	db := setupInventoryTest(t)
+	destDir := t.TempDir()
+
+	manifest, err := Export(db, "test-bucket", destDir, Options{RowsPerFile: 2})
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 2)
+
+	rows := 0
+	for _, file := range manifest.Files {
+		assert.Equal(t, ".csv", filepath.Ext(file.Key))
+		rows += len(readDataFile(t, filepath.Join(destDir, file.Key), false))
+	}
+	assert.Equal(t, 3, rows)
+}
+
+func TestExportEmptyBucket(t *testing.T) {
+	db := setupInventoryTest(t)
+
+	manifest, err := Export(db, "empty-bucket", t.TempDir(), Options{Gzip: true})
+	require.NoError(t, err)
+	assert.Empty(t, manifest.Files)
+}
@@ -0,0 +1,120 @@
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Address is an address the server listens on
+type Address struct {
+	Network string
+	Address string
+	TLS     bool
+}
+
+// String returns the address in the URL form it was given in
+func (a Address) String() string {
+	switch {
+	case a.Network == "unix":
+		return "unix://" + a.Address
+	case a.TLS:
+		return "https://" + a.Address
+	default:
+		return "http://" + a.Address
+	}
+}
+
+// Parse parses the comma-separated addresses, given as tcp://host:port, unix:///path,
+// or http:// and https:// to serve TCP without or with TLS. tcp:// uses TLS if defaultTLS
+// is set, like the default port, and unix:// never does, as it is meant for local proxies
+func Parse(value string, defaultTLS bool) ([]Address, error) {
+	var addresses []Address
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %v", part, err)
+		}
+
+		switch parsed.Scheme {
+		case "unix":
+			if parsed.Path == "" {
+				return nil, fmt.Errorf("invalid listen address %q: missing socket path", part)
+			}
+			addresses = append(addresses, Address{Network: "unix", Address: parsed.Path})
+		case "tcp", "http", "https":
+			if parsed.Host == "" || parsed.Path != "" {
+				return nil, fmt.Errorf("invalid listen address %q: expected host:port", part)
+			}
+			tls := parsed.Scheme == "https" || (parsed.Scheme == "tcp" && defaultTLS)
+			addresses = append(addresses, Address{Network: "tcp", Address: parsed.Host, TLS: tls})
+		default:
+			return nil, fmt.Errorf("invalid listen address %q: unsupported scheme", part)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no listen address given")
+	}
+	return addresses, nil
+}
+
+// Listen creates the listener of the address, replacing the socket file left over
+// by a previous run, the socket file is removed when the listener is closed
+func (a Address) Listen() (net.Listener, error) {
+	if a.Network == "unix" {
+		if info, err := os.Stat(a.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(a.Address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(a.Network, a.Address)
+}
+
+// Serve serves the server on all addresses until it is shut down, which closes the
+// listeners, addresses with TLS are served with the certificate and key files
+func Serve(server *http.Server, addresses []Address, certFile, keyFile string) error {
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		listener, err := address.Listen()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %v", address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	errs := make(chan error, len(addresses))
+	for i, address := range addresses {
+		go func(listener net.Listener, tls bool) {
+			if tls {
+				errs <- server.ServeTLS(listener, certFile, keyFile)
+			} else {
+				errs <- server.Serve(listener)
+			}
+		}(listeners[i], address.TLS)
+	}
+
+	// The first listener failing stops the others, as after a shutdown
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		server.Close()
+	}
+	for range addresses[1:] {
+		<-errs
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package listen
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"s3-to-webdav/internal/cache"
+	"s3-to-webdav/internal/s3"
+	"s3-to-webdav/internal/tests"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		defaultTLS bool
+		expected   []Address
+		wantErr    bool
+	}{
+		{
+			name:     "tcp without TLS",
+			value:    "tcp://:8080",
+			expected: []Address{{Network: "tcp", Address: ":8080"}},
+		},
+		{
+			name:       "tcp with default TLS",
+			value:      "tcp://127.0.0.1:8443",
+			defaultTLS: true,
+			expected:   []Address{{Network: "tcp", Address: "127.0.0.1:8443", TLS: true}},
+		},
+		{
+			name:       "explicit schemes",
+			value:      "http://localhost:8080, https://:8443,unix:///var/run/s3.sock",
+			defaultTLS: true,
+			expected: []Address{
+				{Network: "tcp", Address: "localhost:8080"},
+				{Network: "tcp", Address: ":8443", TLS: true},
+				{Network: "unix", Address: "/var/run/s3.sock"},
+			},
+		},
+		{name: "empty", value: " , ", wantErr: true},
+		{name: "unknown scheme", value: "udp://:8080", wantErr: true},
+		{name: "missing scheme", value: ":8080", wantErr: true},
+		{name: "missing socket path", value: "unix://", wantErr: true},
+		{name: "tcp with path", value: "tcp://:8080/path", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses, err := Parse(tt.value, tt.defaultTLS)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, addresses)
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	webdav := tests.NewFakeWebDAVServer()
+	defer webdav.Close()
+	client, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+
+	db, err := cache.NewCacheDB(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	s3Server := s3.NewServer(db, client)
+	s3Server.SetBucketMap(map[string]interface{}{"test-bucket": struct{}{}})
+	router := mux.NewRouter()
+	s3Server.SetupReadRoutes(router)
+
+	// Reserve a free port, as the address must be known to dial it
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpAddress := reserved.Addr().String()
+	reserved.Close()
+
+	socketPath := filepath.Join(t.TempDir(), "s3.sock")
+
+	// A socket file left over by a previous run is replaced
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	addresses, err := Parse("tcp://"+tcpAddress+",unix://"+socketPath, false)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: router}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(server, addresses, "", "")
+	}()
+
+	clients := map[string]*http.Client{
+		"tcp": {},
+		"unix": {Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		}},
+	}
+
+	for network, client := range clients {
+		t.Run(network, func(t *testing.T) {
+			var resp *http.Response
+			require.Eventually(t, func() bool {
+				resp, err = client.Get("http://" + tcpAddress + "/")
+				return err == nil
+			}, 5*time.Second, 10*time.Millisecond)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var result s3.ListBucketsResult
+			require.NoError(t, xml.NewDecoder(resp.Body).Decode(&result))
+			require.Len(t, result.Buckets.Bucket, 1)
+			assert.Equal(t, "test-bucket", result.Buckets.Bucket[0].Name)
+		})
+	}
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-done, http.ErrServerClosed)
+
+	// Shutdown removes the socket file
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,21 @@
+package s3
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// isValidBucketName checks the bucket name against the S3 naming rules
+func isValidBucketName(name string) bool {
+	if !bucketNameRegexp.MatchString(name) {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, ".-") || strings.Contains(name, "-.") {
+		return false
+	}
+	// Names formatted as IP addresses are not allowed
+	return net.ParseIP(name) == nil
+}
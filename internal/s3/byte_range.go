@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange is the part of the object requested with the Range header
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// contentRange returns the Content-Range header of the range of the object
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.offset, r.offset+r.length-1, size)
+}
+
+// parseRange parses the Range header of a single byte range against the object size.
+// Like S3 a malformed header or multiple ranges are ignored and the whole object is
+// served, so it returns nil, while satisfiable is false for a range past the end
+func parseRange(header string, size int64) (rng *byteRange, satisfiable bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, true
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, true
+	}
+
+	// Suffix range of the last bytes
+	if first == "" {
+		length, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || length < 0 {
+			return nil, true
+		}
+		if length == 0 || size == 0 {
+			return nil, false
+		}
+		length = min(length, size)
+		return &byteRange{offset: size - length, length: length}, true
+	}
+
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 {
+		return nil, true
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < offset {
+			return nil, true
+		}
+		end = min(end, size-1)
+	}
+	if offset >= size {
+		return nil, false
+	}
+	return &byteRange{offset: offset, length: end - offset + 1}, true
+}
+
+// ifRangeMatches checks the If-Range validator, an ETag or an HTTP date, against the
+// object. Like the strong comparison required for ranges, a weak ETag never matches
+// and a date matches only the exact modification time
+func ifRangeMatches(validator, etag string, lastModified int64) bool {
+	validator = strings.TrimSpace(validator)
+	if strings.HasPrefix(validator, "W/") {
+		return false
+	}
+	if strings.HasPrefix(validator, "\"") {
+		return validator == etag
+	}
+	date, err := http.ParseTime(validator)
+	if err != nil {
+		return false
+	}
+	return date.Equal(time.Unix(lastModified, 0))
+}
@@ -0,0 +1,52 @@
+package s3
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// immutableCacheControl is the Cache-Control of the immutable objects
+// when no default is configured, caching them for a year
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// cacheControlOf returns the Cache-Control of the object, empty if none is configured
+func (s *server) cacheControlOf(key string) string {
+	if s.immutableKeys == nil || !s.immutableKeys.MatchString(key) {
+		return s.cacheControl
+	}
+	if s.cacheControl == "" {
+		return immutableCacheControl
+	}
+	return s.cacheControl + ", immutable"
+}
+
+// maxAgeOf returns the max-age directive of the Cache-Control value
+func maxAgeOf(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.Trim(value, "\""), 10, 64)
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+// setCacheHeaders sets the configured Cache-Control and Expires of the object, unless
+// the request replaces Cache-Control with the response-cache-control parameter
+func (s *server) setCacheHeaders(w http.ResponseWriter, r *http.Request, key string) {
+	cacheControl := s.cacheControlOf(key)
+	if cacheControl == "" || r.URL.Query().Get("response-cache-control") != "" {
+		return
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	if maxAge, ok := maxAgeOf(cacheControl); ok {
+		w.Header().Set("Expires", formatHTTPTime(time.Now().Add(maxAge).Unix()))
+	}
+}
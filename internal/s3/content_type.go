@@ -0,0 +1,26 @@
+package s3
+
+import (
+	"mime"
+	"strings"
+)
+
+// isContentTypeAllowed checks the content type against a list of
+// media types, where "type/*" matches any subtype
+func isContentTypeAllowed(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mediaType {
+			return true
+		}
+		if base, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, base+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidContinuationToken = errors.New("InvalidArgument")
+
+// continuationToken is the state carried between ListObjectsV2 pages
+type continuationToken struct {
+	Marker string `json:"m"`
+}
+
+// encodeContinuationToken wraps the marker into an opaque token, signed when a key is set
+func (s *server) encodeContinuationToken(marker string) string {
+	if marker == "" {
+		return ""
+	}
+
+	payload, _ := json.Marshal(continuationToken{Marker: marker})
+	if s.tokenKey != nil {
+		payload = append(s.signContinuationToken(payload), payload...)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// decodeContinuationToken validates the token and returns the marker it carries
+func (s *server) decodeContinuationToken(token string) (string, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidContinuationToken
+	}
+
+	if s.tokenKey != nil {
+		if len(payload) < sha256.Size {
+			return "", ErrInvalidContinuationToken
+		}
+		signature, data := payload[:sha256.Size], payload[sha256.Size:]
+		if !hmac.Equal(signature, s.signContinuationToken(data)) {
+			return "", ErrInvalidContinuationToken
+		}
+		payload = data
+	}
+
+	var state continuationToken
+	if err := json.Unmarshal(payload, &state); err != nil || state.Marker == "" {
+		return "", ErrInvalidContinuationToken
+	}
+	return state.Marker, nil
+}
+
+func (s *server) signContinuationToken(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.tokenKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
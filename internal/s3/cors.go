@@ -0,0 +1,135 @@
+package s3
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"s3-to-webdav/internal/access_log"
+)
+
+// corsMethods are the methods allowed in cross-origin requests
+var corsMethods = []string{"GET", "HEAD", "PUT", "POST", "DELETE"}
+
+// corsHeaders are the request headers allowed in cross-origin requests,
+// where "prefix*" matches any header starting with the prefix
+var corsHeaders = []string{
+	"authorization",
+	"cache-control",
+	"content-disposition",
+	"content-encoding",
+	"content-md5",
+	"content-type",
+	"expires",
+	"if-*",
+	"range",
+	"x-amz-*",
+}
+
+// corsExposeHeaders are the response headers readable by cross-origin scripts
+var corsExposeHeaders = []string{
+	"ETag",
+	"Content-Range",
+	"Last-Modified",
+	"X-Amz-Request-Id",
+	"X-Amz-Version-Id",
+	"X-Amz-Checksum-Crc32",
+	"X-Amz-Checksum-Crc32c",
+	"X-Amz-Checksum-Sha1",
+	"X-Amz-Checksum-Sha256",
+}
+
+// CORSConfig holds the origins allowed to make cross-origin requests
+type CORSConfig struct {
+	// AllowedOrigins lists the allowed origins, "*" allows any origin
+	AllowedOrigins []string
+	// MaxAge is how long browsers may cache the preflight response (zero omits it)
+	MaxAge time.Duration
+}
+
+func (c CORSConfig) allowOrigin(origin string) (string, bool) {
+	if slices.Contains(c.AllowedOrigins, "*") {
+		return "*", true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+func isCORSHeaderAllowed(header string) bool {
+	header = strings.ToLower(strings.TrimSpace(header))
+	for _, pattern := range corsHeaders {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(header, prefix) {
+				return true
+			}
+		} else if header == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware adds the CORS headers to responses for allowed origins, and answers
+// preflight requests before they reach the authentication
+func CORSMiddleware(config CORSConfig, next http.Handler) http.Handler {
+	// Skip CORS if no origins are allowed
+	if len(config.AllowedOrigins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowOrigin, originAllowed := config.allowOrigin(origin)
+		w.Header().Add("Vary", "Origin")
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestMethod == "" {
+			if originAllowed {
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposeHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Preflight request, the browser sends no credentials with it
+		var requestHeaders []string
+		for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+			for _, header := range strings.Split(value, ",") {
+				if header = strings.TrimSpace(header); header != "" {
+					requestHeaders = append(requestHeaders, header)
+				}
+			}
+		}
+		headersAllowed := !slices.ContainsFunc(requestHeaders, func(header string) bool {
+			return !isCORSHeaderAllowed(header)
+		})
+		if !originAllowed || !slices.Contains(corsMethods, requestMethod) || !headersAllowed {
+			access_log.AddLogContext(r, "cors-denied")
+			writeS3Error(w, r, "AccessForbidden", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+		if len(requestHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+		}
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposeHeaders, ", "))
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialProviders looks up the access key in each of the providers in order
+type CredentialProviders []CredentialProvider
+
+func (p CredentialProviders) Lookup(accessKey string) (string, bool) {
+	for _, provider := range p {
+		if secretKey, ok := provider.Lookup(accessKey); ok {
+			return secretKey, true
+		}
+	}
+	return "", false
+}
+
+// FileCredentials are the access keys of a file with an access_key:secret_key pair per line,
+// with empty lines and lines starting with # ignored, re-read while the server is running
+type FileCredentials struct {
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+// NewFileCredentials reads the access keys of the file
+func NewFileCredentials(path string) (*FileCredentials, error) {
+	c := &FileCredentials{path: path}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *FileCredentials) Lookup(accessKey string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	secretKey, ok := c.keys[accessKey]
+	return secretKey, ok
+}
+
+// Reload re-reads the file, reporting whether the keys changed. The previous keys
+// are kept if the file cannot be read, so a broken edit does not lock everyone out
+func (c *FileCredentials) Reload() (bool, error) {
+	keys, err := readCredentialsFile(c.path)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if maps.Equal(c.keys, keys) {
+		return false, nil
+	}
+	c.keys = keys
+	return true, nil
+}
+
+// Watch reloads the file every interval until stop is closed
+func (c *FileCredentials) Watch(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if changed, err := c.Reload(); err != nil {
+				log.Printf("S3: Failed to reload credentials from %s: %v", c.path, err)
+			} else if changed {
+				log.Printf("S3: Reloaded %d access keys from %s", c.count(), c.path)
+			}
+		}
+	}
+}
+
+func (c *FileCredentials) count() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.keys)
+}
+
+func readCredentialsFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		accessKey, secretKey, ok := strings.Cut(text, ":")
+		accessKey = strings.TrimSpace(accessKey)
+		secretKey = strings.TrimSpace(secretKey)
+		if !ok || accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("%s:%d: expected access_key:secret_key", path, line)
+		}
+		if _, exists := keys[accessKey]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate access key %s", path, line, accessKey)
+		}
+		keys[accessKey] = secretKey
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -1,40 +1,149 @@
 package s3
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
+	"net/http"
+	"strings"
 )
 
 var ErrBadDigest = errors.New("BadDigest")
 
+// ErrBadContentMD5 is returned when the body does not match the Content-MD5 header
+var ErrBadContentMD5 = fmt.Errorf("%w: Content-MD5", ErrBadDigest)
+
+// ErrBadChecksum is returned when the body does not match the x-amz-checksum-* header
+var ErrBadChecksum = fmt.Errorf("%w: checksum", ErrBadDigest)
+
+// checksumAlgorithms are the additional checksums clients can request on upload
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":  func() hash.Hash { return crc32.NewIEEE() },
+	"CRC32C": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+}
+
+// checksumHeader returns the header carrying the base64 encoded checksum of the algorithm
+func checksumHeader(algorithm string) string {
+	return http.CanonicalHeaderKey("X-Amz-Checksum-" + algorithm)
+}
+
+// parseChecksumHeaders returns the algorithm of the checksum requested on upload,
+// and the expected value if the client sent it
+func parseChecksumHeaders(header http.Header) (algorithm, expected string, err error) {
+	algorithm = header.Get("X-Amz-Checksum-Algorithm")
+	if algorithm == "" {
+		algorithm = header.Get("X-Amz-Sdk-Checksum-Algorithm")
+	}
+	algorithm = strings.ToUpper(algorithm)
+
+	for name := range checksumAlgorithms {
+		if value := header.Get(checksumHeader(name)); value != "" {
+			if algorithm != "" && algorithm != name {
+				return "", "", errors.New("Expecting a single x-amz-checksum- header")
+			}
+			algorithm, expected = name, value
+		}
+	}
+
+	if algorithm == "" {
+		return "", "", nil
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return "", "", errors.New("Value for x-amz-checksum-algorithm header is invalid.")
+	}
+	if expected != "" {
+		digest, err := base64.StdEncoding.DecodeString(expected)
+		if err != nil || len(digest) != newHash().Size() {
+			return "", "", fmt.Errorf("Value for x-amz-checksum-%s header is invalid.", strings.ToLower(algorithm))
+		}
+	}
+	return algorithm, expected, nil
+}
+
 type hashVerifier struct {
-	reader      io.Reader
-	expectedHex string
-	hasher      hash.Hash
+	reader   io.Reader
+	expected string
+	hasher   hash.Hash
+	encode   func([]byte) string
+	err      error
 }
 
-func newHashVerifier(reader io.Reader, hash hash.Hash, expectedHex string) io.Reader {
-	hasher := sha256.New()
+// newHashVerifier verifies the body against the hex encoded digest
+func newHashVerifier(reader io.Reader, hasher hash.Hash, expectedHex string) io.Reader {
 	return &hashVerifier{
-		reader:      io.TeeReader(reader, hasher),
-		expectedHex: expectedHex,
-		hasher:      hasher,
+		reader:   io.TeeReader(reader, hasher),
+		expected: expectedHex,
+		hasher:   hasher,
+		encode:   hex.EncodeToString,
+		err:      ErrBadDigest,
 	}
 }
 
+// newMD5Verifier verifies the body against the base64 encoded Content-MD5 digest
+func newMD5Verifier(reader io.Reader, expectedBase64 string) io.Reader {
+	hasher := md5.New()
+	return &hashVerifier{
+		reader:   io.TeeReader(reader, hasher),
+		expected: expectedBase64,
+		hasher:   hasher,
+		encode:   base64.StdEncoding.EncodeToString,
+		err:      ErrBadContentMD5,
+	}
+}
+
+// newChecksumVerifier computes the checksum of the body with the algorithm,
+// verifying it against the base64 encoded value if it is given
+func newChecksumVerifier(reader io.Reader, algorithm, expectedBase64 string) *hashVerifier {
+	hasher := checksumAlgorithms[algorithm]()
+	return &hashVerifier{
+		reader:   io.TeeReader(reader, hasher),
+		expected: expectedBase64,
+		hasher:   hasher,
+		encode:   base64.StdEncoding.EncodeToString,
+		err:      ErrBadChecksum,
+	}
+}
+
+// newMD5Hasher computes the hex encoded MD5 of the body, without verifying it
+func newMD5Hasher(reader io.Reader) *hashVerifier {
+	hasher := md5.New()
+	return &hashVerifier{
+		reader: io.TeeReader(reader, hasher),
+		hasher: hasher,
+		encode: hex.EncodeToString,
+	}
+}
+
+// isValidContentMD5 checks that the header is a base64 encoded MD5 digest
+func isValidContentMD5(value string) bool {
+	digest, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(digest) == md5.Size
+}
+
 func (s *hashVerifier) Read(p []byte) (int, error) {
 	n, err := s.reader.Read(p)
 
 	// If we hit EOF, verify the hash before returning
 	if err == io.EOF {
-		actualHash := hex.EncodeToString(s.hasher.Sum(nil))
-		if actualHash != s.expectedHex {
-			return n, ErrBadDigest
+		if s.expected != "" && s.Sum() != s.expected {
+			return n, s.err
 		}
 	}
 
 	return n, err
 }
+
+// Sum returns the encoded digest of the body read so far
+func (s *hashVerifier) Sum() string {
+	return s.encode(s.hasher.Sum(nil))
+}
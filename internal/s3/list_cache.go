@@ -0,0 +1,119 @@
+package s3
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxListCacheEntries is the number of list responses kept by the list cache
+const maxListCacheEntries = 1000
+
+// listCache keeps recent list responses for a short time, least recently used
+// responses are evicted first, and writes to a bucket drop all of its responses
+type listCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+
+	// generations are bumped on every write, so responses read before
+	// the write but stored after it are not cached
+	generations map[string]uint64
+}
+
+type listCacheEntry struct {
+	key     string
+	bucket  string
+	body    []byte
+	expires time.Time
+}
+
+func newListCache(ttl time.Duration) *listCache {
+	return &listCache{
+		ttl:         ttl,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		generations: make(map[string]uint64),
+	}
+}
+
+// generation returns the current generation of the bucket, to be passed to put
+func (c *listCache) generation(bucket string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[bucket]
+}
+
+// get returns the cached response, unless it expired
+func (c *listCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*listCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.body, true
+}
+
+// put stores the response, unless the bucket was written to since the generation was taken
+func (c *listCache) put(key, bucket string, generation uint64, body []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[bucket] != generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[key] = c.order.PushFront(&listCacheEntry{
+		key:     key,
+		bucket:  bucket,
+		body:    body,
+		expires: time.Now().Add(c.ttl),
+	})
+	for c.order.Len() > maxListCacheEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops all responses of the bucket
+func (c *listCache) invalidate(bucket string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[bucket]++
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*listCacheEntry).bucket == bucket {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+func (c *listCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*listCacheEntry).key)
+}
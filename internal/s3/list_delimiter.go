@@ -0,0 +1,65 @@
+package s3
+
+import (
+	"strings"
+
+	"s3-to-webdav/internal/fs"
+)
+
+// listPage lists up to limit files under the prefix after the marker, recursively
+type listPage func(marker string, limit int) ([]fs.EntryInfo, bool, error)
+
+// commonPrefixOf returns the path of the common prefix the path is rolled up into,
+// up to the first delimiter after the listed prefix
+func commonPrefixOf(path, prefix, delimiter string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return "", false
+	}
+	index := strings.Index(rest, delimiter)
+	if index < 0 {
+		return "", false
+	}
+	return prefix + rest[:index+len(delimiter)], true
+}
+
+// listDelimited lists the files under the prefix grouped on any delimiter other than /,
+// which the stored directories cannot answer, so the files are scanned in pages and
+// the common prefixes are returned as directory entries with paths ending with the
+// delimiter. Keys of a common prefix are consecutive, so each is skipped at once
+func listDelimited(list listPage, prefix, marker, delimiter string, limit int) ([]fs.EntryInfo, bool, error) {
+	// Continuing after a common prefix skips all of its keys
+	if group, ok := commonPrefixOf(marker, prefix, delimiter); ok && group == marker {
+		marker += "\xFF"
+	}
+
+	var results []fs.EntryInfo
+	for {
+		files, more, err := list(marker, maxListKeys)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, file := range files {
+			entry := file
+			if group, ok := commonPrefixOf(file.Path, prefix, delimiter); ok {
+				if len(results) > 0 && results[len(results)-1].Path == group {
+					continue
+				}
+				entry = fs.EntryInfo{Path: group, IsDir: true}
+			}
+			if len(results) == limit {
+				return results, true, nil
+			}
+			results = append(results, entry)
+		}
+
+		if !more || len(files) == 0 {
+			return results, false, nil
+		}
+		marker = files[len(files)-1].Path
+		if last := results[len(results)-1]; last.IsDir && strings.HasPrefix(marker, last.Path) {
+			marker = last.Path + "\xFF"
+		}
+	}
+}
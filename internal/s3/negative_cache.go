@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"container/list"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"s3-to-webdav/internal/fs"
+)
+
+// negativeCache remembers the objects recently found missing for a short time, so
+// repeated requests for them are answered without querying the database. Least
+// recently missed objects are evicted first, and writes drop the written object
+// and its parent directories
+type negativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+
+	// generations are bumped on every write, so misses read before
+	// the write but stored after it are not cached
+	generations map[string]uint64
+}
+
+type negativeCacheEntry struct {
+	path    string
+	expires time.Time
+}
+
+func newNegativeCache(size int, ttl time.Duration) *negativeCache {
+	return &negativeCache{
+		ttl:         ttl,
+		size:        size,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		generations: make(map[string]uint64),
+	}
+}
+
+// generation returns the current generation of the bucket of the path, to be passed to put
+func (c *negativeCache) generation(path string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[bucketOfPath(path)]
+}
+
+// missing reports whether the object was recently found missing
+func (c *negativeCache) missing(path string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[path]
+	if !ok {
+		return false
+	}
+	if time.Now().After(element.Value.(*negativeCacheEntry).expires) {
+		c.remove(element)
+		return false
+	}
+	c.order.MoveToFront(element)
+	return true
+}
+
+// put records the object as missing, unless its bucket was written to since the generation was taken
+func (c *negativeCache) put(path string, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[bucketOfPath(path)] != generation {
+		return
+	}
+	if element, ok := c.entries[path]; ok {
+		c.remove(element)
+	}
+
+	c.entries[path] = c.order.PushFront(&negativeCacheEntry{
+		path:    path,
+		expires: time.Now().Add(c.ttl),
+	})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops the written objects and their parent directories, with and without
+// the trailing slash of directory markers
+func (c *negativeCache) invalidate(entries ...fs.EntryInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range entries {
+		c.generations[bucketOfPath(entry.Path)]++
+
+		c.drop(entry.Path)
+		for dir := filepath.Dir(entry.Path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+			c.drop(dir)
+			c.drop(dir + "/")
+		}
+	}
+}
+
+func (c *negativeCache) drop(path string) {
+	if element, ok := c.entries[path]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *negativeCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*negativeCacheEntry).path)
+}
+
+func bucketOfPath(path string) string {
+	bucket, _, _ := fs.BucketAndKeyFromPath(path)
+	return bucket
+}
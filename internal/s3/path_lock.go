@@ -0,0 +1,62 @@
+package s3
+
+import "sync"
+
+// pathLocks serializes operations on the same path, keeping a mutex
+// only while the path is locked or waited for
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+// lock waits until the path is free and locks it, the returned function unlocks it
+func (l *pathLocks) lock(path string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+	lock, ok := l.locks[path]
+	if !ok {
+		lock = &pathLock{}
+		l.locks[path] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() { l.unlock(path, lock) }
+}
+
+// tryLock locks the path unless it is already locked
+func (l *pathLocks) tryLock(path string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.locks[path]; ok {
+		return nil, false
+	}
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+
+	lock := &pathLock{waiters: 1}
+	lock.mu.Lock()
+	l.locks[path] = lock
+	return func() { l.unlock(path, lock) }, true
+}
+
+func (l *pathLocks) unlock(path string, lock *pathLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock.waiters--
+	if lock.waiters == 0 {
+		delete(l.locks, path)
+	}
+	lock.mu.Unlock()
+}
@@ -0,0 +1,130 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+)
+
+// cannedACLs are the canned ACLs accepted, and ignored, by PUT ?acl
+var cannedACLs = []string{
+	"private",
+	"public-read",
+	"public-read-write",
+	"authenticated-read",
+	"aws-exec-read",
+	"bucket-owner-read",
+	"bucket-owner-full-control",
+}
+
+type AccessControlPolicy struct {
+	XMLName           xml.Name `xml:"AccessControlPolicy"`
+	Owner             *Owner   `xml:"Owner"`
+	AccessControlList []Grant  `xml:"AccessControlList>Grant"`
+}
+
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+type Grantee struct {
+	XMLNSXSI    string `xml:"xmlns:xsi,attr"`
+	Type        string `xml:"xsi:type,attr"`
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// requestOwner returns the owner identified by the access key of the request
+func requestOwner(r *http.Request) *Owner {
+	owner := AccessKey(r)
+	if owner == "" {
+		owner = anonymousOwner
+	}
+	return newOwner(owner)
+}
+
+// writeACL writes the policy granting the owner full control, as permissions are not enforced
+func writeACL(w http.ResponseWriter, owner *Owner) {
+	policy := AccessControlPolicy{
+		Owner: owner,
+		AccessControlList: []Grant{{
+			Grantee: Grantee{
+				XMLNSXSI:    "http://www.w3.org/2001/XMLSchema-instance",
+				Type:        "CanonicalUser",
+				ID:          owner.ID,
+				DisplayName: owner.DisplayName,
+			},
+			Permission: "FULL_CONTROL",
+		}},
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(policy)
+}
+
+// validCannedACL checks the x-amz-acl header, which is optional
+func validCannedACL(w http.ResponseWriter, r *http.Request) bool {
+	acl := r.Header.Get("X-Amz-Acl")
+	if acl != "" && !slices.Contains(cannedACLs, acl) {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "The canned ACL you provided is not supported", http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-acl:%s", acl)
+		return false
+	}
+	return true
+}
+
+func (s *server) handleGetBucketACL(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	access_log.AddLogContext(r, "get-bucket-acl:%s", bucket)
+
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	writeACL(w, requestOwner(r))
+}
+
+func (s *server) handlePutBucketACL(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	access_log.AddLogContext(r, "put-bucket-acl:%s", bucket)
+
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	if !validCannedACL(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleGetObjectACL(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.subresourceObject(w, r, "get-acl")
+	if !ok {
+		return
+	}
+
+	// Objects discovered by the sync have no recorded owner
+	owner := requestOwner(r)
+	if entryInfo, err := s.db.Stat(path); err == nil && entryInfo.Owner != "" {
+		owner = newOwner(entryInfo.Owner)
+	}
+	writeACL(w, owner)
+}
+
+func (s *server) handlePutObjectACL(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.subresourceObject(w, r, "put-acl"); !ok {
+		return
+	}
+	if !validCannedACL(w, r) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+type APIListResult struct {
+	Bucket                string         `json:"bucket"`
+	Prefix                string         `json:"prefix"`
+	Delimiter             string         `json:"delimiter,omitempty"`
+	Directories           []APIDirectory `json:"directories"`
+	Files                 []APIFile      `json:"files"`
+	IsTruncated           bool           `json:"isTruncated"`
+	NextContinuationToken string         `json:"nextContinuationToken,omitempty"`
+}
+
+type APIDirectory struct {
+	Prefix       string    `json:"prefix"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type APIFile struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	ETag         string    `json:"etag"`
+}
+
+// handleAPIList lists the bucket as JSON for the browser, reading the cache directly.
+// Pages are continued with the same tokens as ListObjectsV2
+func (s *server) handleAPIList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	bucket := query.Get("bucket")
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+
+	access_log.AddLogContext(r, "api-list:%s", bucket)
+
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	if delimiter != "" && delimiter != "/" {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "Only the / delimiter is supported", http.StatusBadRequest)
+		return
+	}
+
+	limit := maxListKeys
+	if maxKeysStr := query.Get("max-keys"); maxKeysStr != "" {
+		maxKeys, err := strconv.Atoi(maxKeysStr)
+		if err != nil || maxKeys < 0 {
+			writeS3ErrorMessage(w, r, "InvalidArgument", "Provided max-keys not an integer or within integer range", http.StatusBadRequest)
+			return
+		}
+		limit = min(maxKeys, maxListKeys)
+	}
+
+	var marker string
+	if token := query.Get("continuation-token"); token != "" {
+		var err error
+		marker, err = s.decodeContinuationToken(token)
+		if err != nil || !strings.HasPrefix(marker, bucket+"/") {
+			writeS3ErrorMessage(w, r, "InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, truncated, err := s.db.List(bucket+"/"+prefix, marker, delimiter == "/", limit)
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		return
+	}
+
+	result := APIListResult{
+		Bucket:      bucket,
+		Prefix:      prefix,
+		Delimiter:   delimiter,
+		Directories: make([]APIDirectory, 0),
+		Files:       make([]APIFile, 0, len(entries)),
+		IsTruncated: truncated,
+	}
+
+	for _, entry := range entries {
+		_, key, ok := fs.BucketAndKeyFromPath(entry.Path)
+		if !ok {
+			continue
+		}
+		if entry.IsDir {
+			result.Directories = append(result.Directories, APIDirectory{
+				Prefix:       key + "/",
+				LastModified: time.Unix(entry.LastModified, 0).UTC(),
+			})
+			continue
+		}
+
+		target, err := s.resolveAlias(entry)
+		if err != nil {
+			target = entry
+		}
+		result.Files = append(result.Files, APIFile{
+			Key:          key,
+			Size:         target.Size,
+			LastModified: time.Unix(target.LastModified, 0).UTC(),
+			ETag:         ETag(target),
+		})
+	}
+
+	// Next page starts past the last entry, be it a file or a directory
+	if truncated && len(entries) > 0 {
+		result.NextContinuationToken = s.encodeContinuationToken(entries[len(entries)-1].Path)
+	}
+
+	writeJSON(w, result)
+}
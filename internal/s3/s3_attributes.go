@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+// objectAttributes are the values accepted in the x-amz-object-attributes header
+var objectAttributes = map[string]bool{
+	"ETag":         true,
+	"Checksum":     true,
+	"ObjectParts":  true,
+	"StorageClass": true,
+	"ObjectSize":   true,
+}
+
+// GetObjectAttributesResponse holds the requested attributes, the others are omitted
+type GetObjectAttributesResponse struct {
+	XMLName      xml.Name        `xml:"GetObjectAttributesResponse"`
+	ETag         string          `xml:"ETag,omitempty"`
+	Checksum     *ObjectChecksum `xml:"Checksum,omitempty"`
+	StorageClass string          `xml:"StorageClass,omitempty"`
+	ObjectSize   *int64          `xml:"ObjectSize,omitempty"`
+}
+
+// ObjectChecksum holds the checksum given on upload, under the element of its algorithm
+type ObjectChecksum struct {
+	ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+// newObjectChecksum returns the stored checksum of the object, nil if there is none
+func newObjectChecksum(entryInfo fs.EntryInfo) *ObjectChecksum {
+	algorithm, value, ok := strings.Cut(entryInfo.Checksum, ":")
+	if !ok {
+		return nil
+	}
+	switch algorithm {
+	case "CRC32":
+		return &ObjectChecksum{ChecksumCRC32: value}
+	case "CRC32C":
+		return &ObjectChecksum{ChecksumCRC32C: value}
+	case "SHA1":
+		return &ObjectChecksum{ChecksumSHA1: value}
+	case "SHA256":
+		return &ObjectChecksum{ChecksumSHA256: value}
+	}
+	return nil
+}
+
+// parseObjectAttributes returns the attributes of the comma-separated header,
+// which must name at least one
+func parseObjectAttributes(header string) (map[string]bool, bool) {
+	requested := make(map[string]bool)
+	for _, attribute := range strings.Split(header, ",") {
+		attribute = strings.TrimSpace(attribute)
+		if attribute == "" {
+			continue
+		}
+		if !objectAttributes[attribute] {
+			return nil, false
+		}
+		requested[attribute] = true
+	}
+	return requested, len(requested) > 0
+}
+
+// handleGetObjectAttributes returns the requested attributes of the object from
+// the cache, without reading its content. Objects are never uploaded in parts,
+// so ObjectParts is accepted but never returned
+func (s *server) handleGetObjectAttributes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	access_log.AddLogContext(r, "get-attributes:%s/%s", bucket, key)
+
+	// Validate bucket is allowed
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	requested, ok := parseObjectAttributes(strings.Join(r.Header.Values("X-Amz-Object-Attributes"), ","))
+	if !ok {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "Invalid attribute name specified.", http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-attributes")
+		return
+	}
+
+	path := fs.PathFromBucketAndKey(bucket, key)
+	entryInfo, err := s.statObject(r, path)
+	if err != nil || entryInfo.IsDir {
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	entryInfo = s.lazyMD5(r, entryInfo)
+
+	etag := ETag(entryInfo)
+	w.Header().Set("Last-Modified", formatHTTPTime(entryInfo.LastModified))
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
+
+	// Like S3, the ETag is returned without the quotes of the header
+	var result GetObjectAttributesResponse
+	if requested["ETag"] {
+		result.ETag = strings.Trim(etag, "\"")
+	}
+	if requested["Checksum"] {
+		result.Checksum = newObjectChecksum(entryInfo)
+	}
+	if requested["StorageClass"] {
+		result.StorageClass = storageClassOf(entryInfo)
+	}
+	if requested["ObjectSize"] {
+		result.ObjectSize = &entryInfo.Size
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -17,36 +18,117 @@ import (
 	"s3-to-webdav/internal/access_log"
 )
 
-// AuthConfig holds the configuration for S3 authentication
+// CredentialProvider looks up the secret key of the access key a request is signed with,
+// so the keys can be loaded from elsewhere and change while the server is running
+type CredentialProvider interface {
+	Lookup(accessKey string) (secretKey string, ok bool)
+}
+
+// AuthConfig holds the single access key given with the flags or the environment
 type AuthConfig struct {
 	AccessKey string
 	SecretKey string
 }
 
-// AuthMiddleware provides AWS-style authentication including presigned URLs
-func AuthMiddleware(config AuthConfig, next http.Handler) http.Handler {
-	// Skip authentication if no access key is configured
-	if config.AccessKey == "" {
+// Lookup returns the secret key of the configured access key
+func (c AuthConfig) Lookup(accessKey string) (string, bool) {
+	if c.AccessKey == "" || accessKey != c.AccessKey {
+		return "", false
+	}
+	return c.SecretKey, true
+}
+
+// DefaultMaxClockSkew is the largest difference between the time a request was signed
+// at and the server time, the one allowed by AWS
+const DefaultMaxClockSkew = 15 * time.Minute
+
+// amzDateFormat is the format of the X-Amz-Date of v4 signatures
+const amzDateFormat = "20060102T150405Z"
+
+// AuthMiddlewareConfig holds the settings of the authentication of requests
+type AuthMiddlewareConfig struct {
+	// Credentials looks up the secret keys, nil disables authentication
+	Credentials CredentialProvider
+	// MaxClockSkew is how far the signing time of requests signed in the Authorization
+	// header can be from the server time, so captured requests cannot be replayed later
+	// (zero disables the check)
+	MaxClockSkew time.Duration
+}
+
+// requestTimeV4 returns the time the request signed with the v4 Authorization header
+// was signed at, from X-Amz-Date or, as the v4 signature allows, the Date header
+func requestTimeV4(r *http.Request) (time.Time, bool) {
+	if requestTime, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date")); err == nil {
+		return requestTime, true
+	}
+	requestTime, err := http.ParseTime(r.Header.Get("Date"))
+	return requestTime, err == nil
+}
+
+type accessKeyKey struct{}
+
+// AccessKey returns the access key the request was authenticated with,
+// or an empty string when authentication is disabled
+func AccessKey(r *http.Request) string {
+	accessKey, _ := r.Context().Value(accessKeyKey{}).(string)
+	return accessKey
+}
+
+// authValidators are the ways a request can be signed, each returning the access key it was signed with
+var authValidators = []struct {
+	name     string
+	validate func(r *http.Request, credentials CredentialProvider) (string, bool)
+}{
+	{"presigned-v2", validatePresignedURLV2},
+	{"presigned-v4", validatePresignedURLV4},
+	{"auth-v2", validateAuthorizationV2},
+	{"auth-v4", validateAuthorizationV4},
+}
+
+// AuthMiddleware provides AWS-style authentication including presigned URLs,
+// the secret keys are looked up for every request, so they can change at runtime
+func AuthMiddleware(config AuthMiddlewareConfig, next http.Handler) http.Handler {
+	// Skip authentication if no credentials are configured
+	credentials := config.Credentials
+	if credentials == nil {
 		return next
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if validatePresignedURLV2(r, config) {
-			access_log.AddLogContext(r, "presigned-v2")
-		} else if validatePresignedURLV4(r, config) {
-			access_log.AddLogContext(r, "presigned-v4")
-		} else if validateAuthorizationV2(r, config) {
-			access_log.AddLogContext(r, "auth-v2")
-		} else if validateAuthorizationV4(r, config) {
-			access_log.AddLogContext(r, "auth-v4")
-		} else {
-			access_log.AddLogContext(r, "auth-fail")
-			w.Header().Set("WWW-Authenticate", "AWS")
-			http.Error(w, "Authorization failed", http.StatusUnauthorized)
+		// The signing time is checked first, failing closed when it is not given
+		if config.MaxClockSkew > 0 && strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			requestTime, ok := requestTimeV4(r)
+			if !ok {
+				access_log.AddLogContext(r, "auth-no-date")
+				writeS3ErrorMessage(w, r, "AccessDenied", "AWS authentication requires a valid Date or x-amz-date header", http.StatusForbidden)
+				return
+			}
+			if skew := time.Since(requestTime); skew > config.MaxClockSkew || skew < -config.MaxClockSkew {
+				access_log.AddLogContext(r, "auth-skewed")
+				writeS3Error(w, r, "RequestTimeTooSkewed", http.StatusForbidden)
+				return
+			}
+		}
+
+		for _, validator := range authValidators {
+			if accessKey, ok := validator.validate(r, credentials); ok {
+				access_log.AddLogContext(r, "%s", validator.name)
+				r = r.WithContext(context.WithValue(r.Context(), accessKeyKey{}, accessKey))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if isPostObject(r) {
+			// Form uploads are signed by the policy in the form, checked once it is read
+			access_log.AddLogContext(r, "auth-post-policy")
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), postPolicyKey{}, credentials)))
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		access_log.AddLogContext(r, "auth-fail")
+		w.Header().Set("WWW-Authenticate", "AWS")
+		writeS3ErrorMessage(w, r, "AccessDenied", "Authorization failed", http.StatusUnauthorized)
 	})
 }
 
@@ -74,31 +156,35 @@ func calculateSignature(r *http.Request, date, secretKey string) string {
 }
 
 // validateAuthorizationV2 validates AWS-style Authorization header including parsing and signature validation
-func validateAuthorizationV2(r *http.Request, config AuthConfig) bool {
+func validateAuthorizationV2(r *http.Request, credentials CredentialProvider) (string, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return false
+		return "", false
 	}
 
 	// Check AWS format: "AWS AccessKeyId:Signature"
 	if !strings.HasPrefix(authHeader, "AWS ") {
-		return false
+		return "", false
 	}
 
 	// Extract access key and signature
 	authParts := strings.SplitN(authHeader[4:], ":", 2)
-	if len(authParts) != 2 || authParts[0] != config.AccessKey {
-		return false
+	if len(authParts) != 2 {
+		return "", false
+	}
+	secretKey, ok := credentials.Lookup(authParts[0])
+	if !ok {
+		return "", false
 	}
 
 	// Validate the signature
 	date := r.Header.Get("Date")
-	expectedSignature := calculateSignature(r, date, config.SecretKey)
-	return expectedSignature == authParts[1]
+	expectedSignature := calculateSignature(r, date, secretKey)
+	return authParts[0], expectedSignature == authParts[1]
 }
 
 // validatePresignedURLV2 validates AWS-style presigned URL signatures
-func validatePresignedURLV2(r *http.Request, config AuthConfig) bool {
+func validatePresignedURLV2(r *http.Request, credentials CredentialProvider) (string, bool) {
 	query := r.URL.Query()
 
 	// Check for required presigned URL parameters
@@ -107,34 +193,35 @@ func validatePresignedURLV2(r *http.Request, config AuthConfig) bool {
 	expires := query.Get("Expires")
 
 	if accessKey == "" || signature == "" || expires == "" {
-		return false
+		return "", false
 	}
 
 	// Validate access key
-	if accessKey != config.AccessKey {
-		return false
+	secretKey, ok := credentials.Lookup(accessKey)
+	if !ok {
+		return "", false
 	}
 
 	// Check expiration
 	expiresTime, err := strconv.ParseInt(expires, 10, 64)
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	if time.Now().Unix() > expiresTime {
-		return false
+		return "", false
 	}
 
 	// Calculate expected signature using shared function
-	expectedSignature := calculateSignature(r, expires, config.SecretKey)
+	expectedSignature := calculateSignature(r, expires, secretKey)
 
 	// URL decode the provided signature
 	decodedSignature, err := url.QueryUnescape(signature)
 	if err != nil {
-		return false
+		return "", false
 	}
 
-	return expectedSignature == decodedSignature
+	return accessKey, expectedSignature == decodedSignature
 }
 
 // AWS Signature Version 4 implementation
@@ -158,22 +245,26 @@ func calculateSignatureV4(r *http.Request, region, service, secretKey, date, sig
 	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%s", algorithm, date, credentialScope, hashedCanonicalRequest)
 
 	// Step 3: Calculate signature
-	kDate := hmacSHA256([]byte("AWS4"+secretKey), date[:8])
-	kRegion := hmacSHA256(kDate, region)
-	kService := hmacSHA256(kRegion, service)
-	kSigning := hmacSHA256(kService, "aws4_request")
-	signature := hmacSHA256(kSigning, stringToSign)
+	signature := hmacSHA256(signingKeyV4(secretKey, date[:8], region, service), stringToSign)
 
 	return hex.EncodeToString(signature), nil
 }
 
+// signingKeyV4 derives the AWS v4 signing key of the day, region and service
+func signingKeyV4(secretKey, day, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), day)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, service)
+	return hmacSHA256(kService, "aws4_request")
+}
+
 // createCanonicalRequest creates the canonical request for AWS v4 signature
 func createCanonicalRequest(r *http.Request, signedHeaders string) (string, error) {
 	// HTTP Method
 	method := r.Method
 
 	// Canonical URI - must be URL-encoded per AWS v4 spec
-	canonicalURI := r.URL.Path
+	canonicalURI := signedPath(r)
 	if canonicalURI == "" {
 		canonicalURI = "/"
 	} else {
@@ -307,10 +398,10 @@ func hmacSHA256(key []byte, data string) []byte {
 }
 
 // validateAuthorizationV4 validates AWS v4 Authorization header
-func validateAuthorizationV4(r *http.Request, config AuthConfig) bool {
+func validateAuthorizationV4(r *http.Request, credentials CredentialProvider) (string, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256 ") {
-		return false
+		return "", false
 	}
 
 	// Parse the authorization header
@@ -329,13 +420,13 @@ func validateAuthorizationV4(r *http.Request, config AuthConfig) bool {
 	signedHeaders := authData["SignedHeaders"]
 
 	if credential == "" || signature == "" || signedHeaders == "" {
-		return false
+		return "", false
 	}
 
 	// Parse credential
 	credentialParts := strings.Split(credential, "/")
 	if len(credentialParts) < 5 {
-		return false
+		return "", false
 	}
 
 	accessKey := credentialParts[0]
@@ -343,27 +434,29 @@ func validateAuthorizationV4(r *http.Request, config AuthConfig) bool {
 	service := credentialParts[3]
 
 	// Validate access key
-	if accessKey != config.AccessKey {
-		return false
+	secretKey, ok := credentials.Lookup(accessKey)
+	if !ok {
+		return "", false
 	}
 
-	// Get the date from X-Amz-Date header
-	amzDate := r.Header.Get("X-Amz-Date")
-	if amzDate == "" {
-		return false
+	// The signing time is given in X-Amz-Date, or in the Date header
+	requestTime, ok := requestTimeV4(r)
+	if !ok {
+		return "", false
 	}
+	amzDate := requestTime.UTC().Format(amzDateFormat)
 
 	// Calculate expected signature
-	expectedSignature, err := calculateSignatureV4(r, region, service, config.SecretKey, amzDate, signedHeaders)
+	expectedSignature, err := calculateSignatureV4(r, region, service, secretKey, amzDate, signedHeaders)
 	if err != nil {
-		return false
+		return "", false
 	}
 
-	return expectedSignature == signature
+	return accessKey, expectedSignature == signature
 }
 
 // validatePresignedURLV4 validates AWS v4 presigned URLs
-func validatePresignedURLV4(r *http.Request, config AuthConfig) bool {
+func validatePresignedURLV4(r *http.Request, credentials CredentialProvider) (string, bool) {
 	query := r.URL.Query()
 
 	// Check for v4 presigned URL parameters
@@ -374,13 +467,13 @@ func validatePresignedURLV4(r *http.Request, config AuthConfig) bool {
 	date := query.Get("X-Amz-Date")
 
 	if credential == "" || signature == "" || signedHeaders == "" || expires == "" || date == "" {
-		return false
+		return "", false
 	}
 
 	// Parse credential
 	credentialParts := strings.Split(credential, "/")
 	if len(credentialParts) < 5 {
-		return false
+		return "", false
 	}
 
 	accessKey := credentialParts[0]
@@ -388,24 +481,25 @@ func validatePresignedURLV4(r *http.Request, config AuthConfig) bool {
 	service := credentialParts[3]
 
 	// Validate access key
-	if accessKey != config.AccessKey {
-		return false
+	secretKey, ok := credentials.Lookup(accessKey)
+	if !ok {
+		return "", false
 	}
 
 	// Check expiration
 	expiresSeconds, err := strconv.ParseInt(expires, 10, 64)
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	// Parse date and check if expired
-	requestTime, err := time.Parse("20060102T150405Z", date)
+	requestTime, err := time.Parse(amzDateFormat, date)
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	if time.Now().After(requestTime.Add(time.Duration(expiresSeconds) * time.Second)) {
-		return false
+		return "", false
 	}
 
 	// For presigned URLs, we need to create a modified request without the signature parameter
@@ -419,10 +513,10 @@ func validatePresignedURLV4(r *http.Request, config AuthConfig) bool {
 	modifiedRequest.URL = &modifiedURL
 
 	// Calculate expected signature
-	expectedSignature, err := calculateSignatureV4(&modifiedRequest, region, service, config.SecretKey, date, signedHeaders)
+	expectedSignature, err := calculateSignatureV4(&modifiedRequest, region, service, secretKey, date, signedHeaders)
 	if err != nil {
-		return false
+		return "", false
 	}
 
-	return expectedSignature == signature
+	return accessKey, expectedSignature == signature
 }
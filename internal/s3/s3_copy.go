@@ -0,0 +1,278 @@
+package s3
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+// Directives of the metadata and tags of the copy
+const (
+	directiveCopy    = "COPY"
+	directiveReplace = "REPLACE"
+)
+
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// parseCopySource returns the bucket and key of the x-amz-copy-source header,
+// given as the URL-encoded bucket/key with an optional leading slash and versionId,
+// which is ignored as only the current version exists
+func parseCopySource(header string) (string, string, bool) {
+	source, _, _ := strings.Cut(header, "?")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return "", "", false
+	}
+
+	bucket, key, ok := strings.Cut(source, "/")
+	if !ok || bucket == "" || key == "" || strings.HasSuffix(key, "/") {
+		return "", "", false
+	}
+	return bucket, key, true
+}
+
+// parseDirective returns the COPY or REPLACE directive of the header, COPY if not given
+func parseDirective(header string) (string, bool) {
+	switch directive := strings.ToUpper(header); directive {
+	case "":
+		return directiveCopy, true
+	case directiveCopy, directiveReplace:
+		return directive, true
+	default:
+		return "", false
+	}
+}
+
+// checkCopyPreconditions checks the x-amz-copy-source-if-* headers against the source object.
+// Like S3, a matching If-Match overrides a failing If-Unmodified-Since, and a failing
+// If-None-Match overrides a matching If-Modified-Since
+func checkCopyPreconditions(r *http.Request, etag string, lastModified time.Time) bool {
+	modifiedSince := func(header string) (bool, bool) {
+		since, err := http.ParseTime(r.Header.Get(header))
+		if err != nil {
+			return false, false
+		}
+		return lastModified.After(since), true
+	}
+
+	if ifMatch := r.Header.Get("X-Amz-Copy-Source-If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag) {
+			return false
+		}
+	} else if modified, ok := modifiedSince("X-Amz-Copy-Source-If-Unmodified-Since"); ok && modified {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+	} else if modified, ok := modifiedSince("X-Amz-Copy-Source-If-Modified-Since"); ok && !modified {
+		return false
+	}
+	return true
+}
+
+func (s *server) handleCopyObject(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	path := fs.PathFromBucketAndKey(bucket, key)
+
+	access_log.AddLogContext(r, "copy:%s/%s", bucket, key)
+
+	// Validate bucket is allowed
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		writeS3ErrorMessage(w, r, "InvalidRequest", "Directory marker cannot be the destination of a copy", http.StatusBadRequest)
+		return
+	}
+
+	sourceBucket, sourceKey, ok := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if !ok {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-copy-source")
+		return
+	}
+	sourcePath := fs.PathFromBucketAndKey(sourceBucket, sourceKey)
+	access_log.AddLogContext(r, "source:%s/%s", sourceBucket, sourceKey)
+
+	if !s.isBucketAllowed(sourceBucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	metadataDirective, ok := parseDirective(r.Header.Get("X-Amz-Metadata-Directive"))
+	if !ok {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "Unknown metadata directive.", http.StatusBadRequest)
+		return
+	}
+	taggingDirective, ok := parseDirective(r.Header.Get("X-Amz-Tagging-Directive"))
+	if !ok {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "Unknown tagging directive.", http.StatusBadRequest)
+		return
+	}
+	storageClass, ok := parseStorageClass(r.Header.Get("X-Amz-Storage-Class"))
+	if !ok {
+		writeS3Error(w, r, "InvalidStorageClass", http.StatusBadRequest)
+		return
+	}
+	// Changing only the storage class of the object is a copy to itself like in S3
+	if sourcePath == path && metadataDirective != directiveReplace && r.Header.Get("X-Amz-Storage-Class") == "" {
+		writeS3ErrorMessage(w, r, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.", http.StatusBadRequest)
+		return
+	}
+
+	source, err := s.db.Stat(sourcePath)
+	if err == nil {
+		source, err = s.resolveAlias(source)
+	}
+	if err != nil || source.IsDir {
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		access_log.AddLogContext(r, "local-fail")
+		return
+	}
+
+	// Preconditions of the source are checked before any data is moved
+	sourceETag := ETag(source)
+	if !checkCopyPreconditions(r, sourceETag, time.Unix(source.LastModified, 0)) {
+		writeS3Error(w, r, "PreconditionFailed", http.StatusPreconditionFailed)
+		access_log.AddLogContext(r, "precondition-failed")
+		return
+	}
+
+	if s.maxObjectSize > 0 && source.Size > s.maxObjectSize {
+		writeS3Error(w, r, "EntityTooLarge", http.StatusBadRequest)
+		access_log.AddLogContext(r, "too-large")
+		return
+	}
+
+	// Tags are carried over, unless replaced by the ones given inline
+	var tags map[string]string
+	if taggingDirective == directiveReplace {
+		tags, err = parseTaggingHeader(r.Header.Get("X-Amz-Tagging"))
+		if err != nil {
+			writeS3ErrorMessage(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+			access_log.AddLogContext(r, "invalid-tags")
+			return
+		}
+	} else if tags, err = s.db.GetTags(sourcePath); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	// Copy over an alias follows the alias writes policy like an upload
+	if target, err := s.db.GetAlias(path); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	} else if target != "" {
+		if s.aliasWrites != AliasWritesRedirect {
+			writeS3ErrorMessage(w, r, "OperationAborted", "Object is an alias", http.StatusConflict)
+			access_log.AddLogContext(r, "alias-rejected")
+			return
+		}
+		access_log.AddLogContext(r, "alias-redirect:%s", target)
+		path = target
+	}
+
+	// Backend write and cache update of the same key must not interleave
+	if s.writeConflicts == WriteConflictsReject {
+		unlock, ok := s.writeLocks.tryLock(path)
+		if !ok {
+			writeS3ErrorMessage(w, r, "OperationAborted", "A conflicting write to this object is in progress", http.StatusConflict)
+			access_log.AddLogContext(r, "write-conflict")
+			return
+		}
+		defer unlock()
+	} else {
+		defer s.writeLocks.lock(path)()
+	}
+
+	if !s.checkWritePreconditions(r, path) {
+		writeS3Error(w, r, "PreconditionFailed", http.StatusPreconditionFailed)
+		access_log.AddLogContext(r, "precondition-failed")
+		return
+	}
+
+	// The backend copies natively where it can, without the bytes passing through.
+	// A copy onto itself only replaces the metadata kept in the cache, and is not sent
+	// as backends reject it, WebDAV servers with 403 and S3 without the REPLACE directive
+	if source.Path == path {
+		access_log.AddLogContext(r, "metadata-only")
+	} else if err := s.client.Copy(source.Path, path); err != nil {
+		if fs.IsNotFound(err) {
+			s.evictMissing(r, source)
+			writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		} else {
+			writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+			s.logBackendError(w, r, "copy", path, err)
+		}
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	}
+
+	stat, err := s.client.Stat(path)
+	if source.Path == path && fs.IsNotFound(err) {
+		s.evictMissing(r, source)
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	} else if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "stat", path, err)
+		access_log.AddLogContext(r, "stat-fail")
+		return
+	}
+
+	entryInfo := fs.EntryInfo{
+		Path:         path,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime().Unix(),
+		Processed:    true,
+		Owner:        AccessKey(r),
+		Checksum:     source.Checksum,
+		StorageClass: storageClass,
+		MD5:          source.MD5,
+	}
+
+	if err := s.db.Insert(append(fs.BaseDirEntries(path), entryInfo)...); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to insert object metadata: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	s.negativeCache.invalidate(entryInfo)
+	defer s.listCache.invalidate(bucket)
+
+	if err := s.db.SetTags(path, tags); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to update tags: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	etag := ETag(entryInfo)
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(CopyObjectResult{
+		LastModified: formatXMLTime(entryInfo.LastModified),
+		ETag:         etag,
+	})
+}
@@ -0,0 +1,133 @@
+package s3
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+// DeletePrefixResult reports the files removed by the delete-prefix request
+type DeletePrefixResult struct {
+	XMLName xml.Name `xml:"DeletePrefixResult"`
+	Prefix  string   `xml:"Prefix"`
+	Deleted int      `xml:"Deleted"`
+}
+
+// isDirectoryPrefix checks that the prefix names a directory of the bucket, so the
+// recursive removal cannot reach the bucket itself or outside of it
+func isDirectoryPrefix(prefix string) bool {
+	if prefix == "" || strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+		return false
+	}
+	segments := strings.Split(strings.TrimSuffix(prefix, "/"), "/")
+	return !slices.ContainsFunc(segments, func(segment string) bool {
+		return segment == "" || segment == "." || segment == ".."
+	})
+}
+
+// handleDeletePrefix handles the non-standard POST /{bucket}?delete-prefix&prefix=dir/,
+// removing the directory with everything beneath it from the backend and the cache.
+// The backend is walked instead of the cache, so files not scanned yet are removed too
+func (s *server) handleDeletePrefix(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+	prefix := r.URL.Query().Get("prefix")
+
+	access_log.AddLogContext(r, "delete-prefix:%s/%s", bucket, prefix)
+
+	if !s.prefixDelete {
+		writeS3Error(w, r, "MethodNotAllowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate bucket is allowed
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	if !isDirectoryPrefix(prefix) {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "The prefix must name a directory, ending with a slash", http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-prefix")
+		return
+	}
+
+	path := fs.PathFromBucketAndKey(bucket, prefix)
+	defer s.listCache.invalidate(bucket)
+
+	deleted, failedPath, err := s.removeTree(path)
+	access_log.AddLogContext(r, "deleted:%d", deleted)
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "remove", failedPath, err)
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	}
+
+	if _, err := s.db.DeleteDir(path); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to delete directory from database: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(DeletePrefixResult{
+		Prefix:  prefix,
+		Deleted: deleted,
+	})
+}
+
+// removeTree removes the files of the backend directory and its subdirectories, then
+// the directories themselves, returning the number of files removed. Each removed file
+// is dropped from the cache at once, so a failure leaves the cache matching the backend
+func (s *server) removeTree(dir string) (int, string, error) {
+	infos, err := s.client.ReadDir(dir)
+	if fs.IsNotFound(err) {
+		return 0, "", nil
+	} else if err != nil {
+		return 0, dir, err
+	}
+
+	deleted := 0
+	for _, info := range infos {
+		child := dir + info.Name()
+		if info.IsDir() {
+			removed, failedPath, err := s.removeTree(child + "/")
+			deleted += removed
+			if err != nil {
+				return deleted, failedPath, err
+			}
+			continue
+		}
+
+		if err := s.removeFile(child); err != nil {
+			return deleted, child, err
+		}
+		deleted++
+	}
+
+	if err := s.client.Remove(dir); err != nil && !fs.IsNotFound(err) {
+		return deleted, dir, err
+	}
+	return deleted, "", nil
+}
+
+// removeFile removes the file from the backend and the cache, under the write lock
+func (s *server) removeFile(path string) error {
+	defer s.writeLocks.lock(path)()
+
+	if err := s.client.Remove(path); err != nil && !fs.IsNotFound(err) {
+		return err
+	}
+	if err := s.db.Delete(path); err != nil {
+		log.Printf("Failed to delete object %s from database: %v", path, err)
+	}
+	return nil
+}
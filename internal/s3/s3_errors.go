@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+// ErrorResponse is the S3 XML error document
+type ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestId string   `xml:"RequestId"`
+}
+
+var errorMessages = map[string]string{
+	"AccessDenied":            "Access Denied",
+	"AccessForbidden":         "CORSResponse: This CORS request is not allowed.",
+	"BucketAlreadyOwnedByYou": "The bucket you tried to create already exists, and you own it.",
+	"BucketNotEmpty":          "The bucket you tried to delete is not empty.",
+	"BadDigest":               "The Content-SHA256 you specified did not match what we received.",
+	"EntityTooLarge":          "Your proposed upload exceeds the maximum allowed object size.",
+	"EntityTooSmall":          "Your proposed upload is smaller than the minimum allowed object size.",
+	"IncompleteBody":          "You did not provide the number of bytes specified by the Content-Length HTTP header.",
+	"InternalError":           "We encountered an internal error. Please try again.",
+	"InvalidArgument":         "Invalid Argument",
+	"InvalidBucketName":       "The specified bucket is not valid.",
+	"InvalidDigest":           "The Content-MD5 you specified was invalid.",
+	"InvalidRange":            "The requested range is not satisfiable",
+	"InvalidRequest":          "Invalid Request",
+	"InvalidStorageClass":     "The storage class you specified is not valid",
+	"MalformedXML":            "The XML you provided was not well-formed or did not validate against our published schema.",
+	"MethodNotAllowed":        "The specified method is not allowed against this resource.",
+	"NoSuchBucket":            "The specified bucket does not exist.",
+	"NoSuchKey":               "The specified key does not exist.",
+	"OperationAborted":        "A conflicting conditional operation is currently in progress against this resource.",
+	"PreconditionFailed":      "At least one of the pre-conditions you specified did not hold",
+	"RequestTimeTooSkewed":    "The difference between the request time and the current time is too large.",
+}
+
+// writeS3Error writes the S3 XML error document with the default message for the code
+func writeS3Error(w http.ResponseWriter, r *http.Request, code string, httpStatus int) {
+	message, ok := errorMessages[code]
+	if !ok {
+		message = code
+	}
+	writeS3ErrorMessage(w, r, code, message, httpStatus)
+}
+
+// writeS3ErrorMessage writes the S3 XML error document with a custom message
+func writeS3ErrorMessage(w http.ResponseWriter, r *http.Request, code, message string, httpStatus int) {
+	requestId := w.Header().Get("X-Amz-Request-Id")
+	if requestId == "" {
+		requestId = access_log.GenerateRequestId()
+		w.Header().Set("X-Amz-Request-Id", requestId)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(httpStatus)
+
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestId: requestId,
+	})
+}
+
+// logBackendError logs the cause of the backend failure of the request, with the request
+// id of the error sent to the client to correlate them. It is called after the error is
+// written, as the request id is generated then if the request has none yet
+func (s *server) logBackendError(w http.ResponseWriter, r *http.Request, operation, path string, err error) {
+	bucket, key, _ := fs.BucketAndKeyFromPath(path)
+	s.backendLog.Printf("S3: Backend %s failed request-id=%s method=%s bucket=%s key=%q: %v",
+		operation, w.Header().Get("X-Amz-Request-Id"), r.Method, bucket, key, err)
+}
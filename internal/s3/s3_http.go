@@ -1,8 +1,12 @@
 package s3
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/xml"
 	"errors"
@@ -10,9 +14,16 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,15 +31,9 @@ import (
 	"s3-to-webdav/internal/access_log"
 	"s3-to-webdav/internal/cache"
 	"s3-to-webdav/internal/fs"
+	syncer "s3-to-webdav/internal/sync"
 )
 
-func parseInt(s string) int {
-	if val, err := strconv.Atoi(s); err == nil {
-		return val
-	}
-	return 0
-}
-
 // generateETag generates an ETag from file metadata
 func generateETag(path string, size int64, lastModified int64) string {
 	h := md5.New()
@@ -36,14 +41,162 @@ func generateETag(path string, size int64, lastModified int64) string {
 	return fmt.Sprintf("\"%s\"", hex.EncodeToString(h.Sum(nil)))
 }
 
+// xmlTimeFormat is the format of the times in the XML documents of S3, always UTC with milliseconds
+const xmlTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// formatXMLTime formats the Unix time for the XML documents
+func formatXMLTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(xmlTimeFormat)
+}
+
+// formatHTTPTime formats the Unix time for the headers, RFC1123 in GMT as HTTP requires
+func formatHTTPTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(http.TimeFormat)
+}
+
+// checksumSHA256Header carries the base64 encoded SHA256 of the object
+const checksumSHA256Header = "X-Amz-Checksum-Sha256"
+
+// versionIdHeader carries the version of the object. Objects are not versioned,
+// so it is a synthetic value changing only when the object is replaced.
+const versionIdHeader = "X-Amz-Version-Id"
+
+// generateVersionId derives the synthetic version ID from the ETag of the object
+func generateVersionId(etag string) string {
+	return strings.Trim(etag, "\"")
+}
+
+// responseOverrides maps the GetObject query parameters to the headers they replace,
+// as used by presigned URLs to force a download filename or content type
+var responseOverrides = map[string]string{
+	"response-content-type":        "Content-Type",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-cache-control":       "Cache-Control",
+	"response-expires":             "Expires",
+}
+
+// setContentDisposition suggests the base name of the key as the filename, so browsers
+// keep it when saving the object. Quotes are escaped, and non-ASCII names are sent
+// encoded as in RFC 6266, with a fallback for clients not supporting it
+func setContentDisposition(w http.ResponseWriter, key string) {
+	name := path.Base(key)
+	if name == "." || name == "/" {
+		return
+	}
+
+	var quoted strings.Builder
+	ascii := true
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			quoted.WriteByte('_')
+		case r > 0x7f:
+			ascii = false
+			quoted.WriteByte('_')
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+
+	value := `inline; filename="` + quoted.String() + `"`
+	if !ascii {
+		value += "; filename*=UTF-8''" + strings.ReplaceAll(url.QueryEscape(name), "+", "%20")
+	}
+	w.Header().Set("Content-Disposition", value)
+}
+
+// setResponseOverrides replaces the response headers with the ones requested in the query
+func setResponseOverrides(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	for param, header := range responseOverrides {
+		if value := query.Get(param); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+}
+
+const (
+	// AliasWritesReject rejects writes to an existing alias
+	AliasWritesReject = "reject"
+	// AliasWritesRedirect writes to the alias target instead
+	AliasWritesRedirect = "redirect"
+)
+
+const (
+	// WriteConflictsLastWriteWins serializes concurrent writes to the same key, the last one wins
+	WriteConflictsLastWriteWins = "last-write-wins"
+	// WriteConflictsReject rejects a write while another write to the same key is in progress
+	WriteConflictsReject = "reject"
+)
+
+const (
+	// ETagScanModeSynthetic serves the ETag derived from the path, size and modification time
+	ETagScanModeSynthetic = "synthetic"
+	// ETagScanModeMD5Lazy computes the MD5 of the content on the first GET or HEAD of the object
+	ETagScanModeMD5Lazy = "md5-lazy"
+	// ETagScanModeMD5Eager computes the MD5 of the content while scanning the backend
+	ETagScanModeMD5Eager = "md5-eager"
+)
+
+// ETag returns the ETag served for the entry, the MD5 of the content if it is known
+func ETag(entryInfo fs.EntryInfo) string {
+	if entryInfo.MD5 != "" {
+		return fmt.Sprintf("\"%s\"", entryInfo.MD5)
+	}
+	return generateETag(entryInfo.Path, entryInfo.Size, entryInfo.LastModified)
+}
+
 type server struct {
-	db        cache.Cache
-	client    fs.Fs
-	bucketMap map[string]interface{}
+	db          cache.Cache
+	client      fs.Fs
+	bucketMap   map[string]interface{}
+	bucketMu    sync.RWMutex
+	aliasWrites string
+	tokenKey    []byte
+
+	allowedContentTypes map[string][]string
+
+	deleteRetries int
+	deleteTimeout time.Duration
+
+	listBackendFallback bool
+	readThrough         bool
+	autoCreateBuckets   bool
+	prefixDelete        bool
+	headValidate        bool
+	headValidateAfter   time.Duration
+	maxObjectSize       int64
+	maxBandwidth        int64
+	etagScanMode        string
+	cacheControl        string
+	immutableKeys       *regexp.Regexp
+	fileMode            os.FileMode
+	region              string
+	bucketStatsHeaders  bool
+
+	passthroughRedirects bool
+	redirectBaseURL      *url.URL
+
+	writeConflicts string
+	writeLocks     pathLocks
+
+	sync *syncer.Sync
+
+	// backendLog logs the backend failures hidden behind the generic errors sent to clients
+	backendLog *log.Logger
+
+	listCache     *listCache
+	negativeCache *negativeCache
 }
 
 type ListBucketsResult struct {
 	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Owner   *Owner   `xml:"Owner"`
 	Buckets Buckets  `xml:"Buckets"`
 }
 
@@ -89,6 +242,25 @@ type Object struct {
 	ETag         string `xml:"ETag"`
 	Size         int64  `xml:"Size"`
 	StorageClass string `xml:"StorageClass"`
+	Owner        *Owner `xml:"Owner,omitempty"`
+}
+
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// anonymousOwner is the owner reported when authentication is disabled
+const anonymousOwner = "anonymous"
+
+// newOwner returns the owner identified by the access key, with a stable
+// hash of the key as the ID, like the canonical user ID of S3
+func newOwner(accessKey string) *Owner {
+	sum := sha256.Sum256([]byte(accessKey))
+	return &Owner{
+		ID:          hex.EncodeToString(sum[:]),
+		DisplayName: accessKey,
+	}
 }
 
 type CommonPrefix struct {
@@ -98,9 +270,16 @@ type CommonPrefix struct {
 // Bulk delete structures
 type DeleteRequest struct {
 	XMLName xml.Name         `xml:"Delete"`
+	Quiet   bool             `xml:"Quiet"`
 	Objects []ObjectToDelete `xml:"Object"`
 }
 
+// maxDeleteObjects is the maximum number of keys in a single bulk delete
+const maxDeleteObjects = 1000
+
+// maxListKeys is the maximum number of keys in a single listing
+const maxListKeys = 1000
+
 type ObjectToDelete struct {
 	Key string `xml:"Key"`
 }
@@ -121,10 +300,20 @@ type DeleteError struct {
 	Message string `xml:"Message"`
 }
 
+// DefaultRegion is the region reported for the buckets, the one SDKs assume when not configured
+const DefaultRegion = "us-east-1"
+
 func NewServer(db cache.Cache, client fs.Fs) *server {
 	return &server{
-		db:     db,
-		client: client,
+		db:             db,
+		client:         client,
+		aliasWrites:    AliasWritesReject,
+		deleteRetries:  2,
+		writeConflicts: WriteConflictsLastWriteWins,
+		fileMode:       fs.DefaultFileMode,
+		region:         DefaultRegion,
+		etagScanMode:   ETagScanModeSynthetic,
+		backendLog:     log.Default(),
 	}
 }
 
@@ -133,25 +322,339 @@ func (s *server) SetBucketMap(buckets map[string]interface{}) {
 	s.bucketMap = buckets
 }
 
+// SetAliasWrites sets how writes to an existing alias are handled
+func (s *server) SetAliasWrites(policy string) {
+	s.aliasWrites = policy
+}
+
+// SetWriteConflicts sets how concurrent writes to the same key are handled
+func (s *server) SetWriteConflicts(policy string) {
+	s.writeConflicts = policy
+}
+
+// SetETagScanMode sets how the ETag of the objects is computed, the MD5 modes
+// also store the MD5 of uploads
+func (s *server) SetETagScanMode(mode string) {
+	s.etagScanMode = mode
+}
+
+// SetContinuationTokenKey sets the key used to sign continuation tokens
+func (s *server) SetContinuationTokenKey(key string) {
+	if key == "" {
+		s.tokenKey = nil
+	} else {
+		s.tokenKey = []byte(key)
+	}
+}
+
+// SetAllowedContentTypes limits uploads to the bucket to the given media types,
+// an empty list allows any content type
+func (s *server) SetAllowedContentTypes(bucket string, contentTypes []string) {
+	if s.allowedContentTypes == nil {
+		s.allowedContentTypes = make(map[string][]string)
+	}
+	if len(contentTypes) == 0 {
+		delete(s.allowedContentTypes, bucket)
+	} else {
+		s.allowedContentTypes[bucket] = contentTypes
+	}
+}
+
+// SetBulkDeleteBudget sets the number of retries for each key of the bulk delete,
+// and the deadline for the whole batch (zero means no deadline)
+func (s *server) SetBulkDeleteBudget(retries int, timeout time.Duration) {
+	s.deleteRetries = retries
+	s.deleteTimeout = timeout
+}
+
+// SetListBackendFallback enables reading directories from the backend during listing
+// when they were not scanned into the cache yet
+func (s *server) SetListBackendFallback(enabled bool) {
+	s.listBackendFallback = enabled
+}
+
+// SetReadThrough enables looking up objects missing from the cache on the backend,
+// serving and caching the ones found there
+func (s *server) SetReadThrough(enabled bool) {
+	s.readThrough = enabled
+}
+
+// SetAutoCreateBuckets allows creating new buckets with the CreateBucket request,
+// and deleting the created ones with the DeleteBucket request
+func (s *server) SetAutoCreateBuckets(enabled bool) {
+	s.autoCreateBuckets = enabled
+}
+
+// SetPrefixDelete enables the non-standard POST /{bucket}?delete-prefix request,
+// removing a directory with everything beneath it
+func (s *server) SetPrefixDelete(enabled bool) {
+	s.prefixDelete = enabled
+}
+
+// SetHeadValidate enables checking the object on the backend on HEAD requests
+// when its cache entry was stored longer than the given time ago
+func (s *server) SetHeadValidate(enabled bool, after time.Duration) {
+	s.headValidate = enabled
+	s.headValidateAfter = after
+}
+
+// SetCacheControl sets the Cache-Control header sent with the objects, with Expires
+// following its max-age. Objects whose keys match immutableKeys are marked immutable,
+// an empty value and nil pattern send no cache headers
+func (s *server) SetCacheControl(cacheControl string, immutableKeys *regexp.Regexp) {
+	s.cacheControl = cacheControl
+	s.immutableKeys = immutableKeys
+}
+
+// SetListCacheTTL enables caching list responses for the given time,
+// writes to a bucket drop its cached responses (zero disables the cache)
+func (s *server) SetListCacheTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.listCache = newListCache(ttl)
+	} else {
+		s.listCache = nil
+	}
+}
+
+// SetNegativeCache enables remembering up to size objects found missing for the given
+// time, writes and the sync drop the written objects (zero disables the cache)
+func (s *server) SetNegativeCache(size int, ttl time.Duration) {
+	if size > 0 && ttl > 0 {
+		s.negativeCache = newNegativeCache(size, ttl)
+	} else {
+		s.negativeCache = nil
+	}
+}
+
+// SetMaxObjectSize sets the largest accepted upload in bytes, zero means unlimited
+func (s *server) SetMaxObjectSize(size int64) {
+	s.maxObjectSize = size
+}
+
+// SetMaxBandwidthPerRequest limits the bytes per second of each object download
+// and upload, zero means unlimited
+func (s *server) SetMaxBandwidthPerRequest(bytesPerSecond int64) {
+	s.maxBandwidth = bytesPerSecond
+}
+
+// throttle limits the reader to the per-request bandwidth, if one is configured
+func (s *server) throttle(r *http.Request, reader io.Reader) io.Reader {
+	if s.maxBandwidth <= 0 {
+		return reader
+	}
+	return newThrottledReader(r.Context(), reader, s.maxBandwidth)
+}
+
+// SetRegion sets the region reported for the buckets, which SDKs use to route requests
+func (s *server) SetRegion(region string) {
+	s.region = region
+}
+
+// SetBucketStatsHeaders adds the non-standard object count and size headers to HEAD of a bucket
+func (s *server) SetBucketStatsHeaders(enabled bool) {
+	s.bucketStatsHeaders = enabled
+}
+
+// SetFileMode sets the permissions of the objects written to the backend
+func (s *server) SetFileMode(mode os.FileMode) {
+	s.fileMode = mode
+}
+
+// SetSync sets the synchronizer reporting the last sync time in the stats
+func (s *server) SetSync(sync *syncer.Sync) {
+	s.sync = sync
+	if sync != nil {
+		sync.SetOnInsert(func(entries ...fs.EntryInfo) {
+			s.negativeCache.invalidate(entries...)
+		})
+	}
+}
+
+// SetPassthroughRedirects makes GET answer with 307 to the location the backend redirected to,
+// instead of proxying the content, with the scheme and host replaced by baseURL if not nil
+func (s *server) SetPassthroughRedirects(enabled bool, baseURL *url.URL) {
+	s.passthroughRedirects = enabled
+	s.redirectBaseURL = baseURL
+}
+
+// SetBackendLogger sets the logger of the backend failures, the default logger if not set
+func (s *server) SetBackendLogger(logger *log.Logger) {
+	s.backendLog = logger
+}
+
+// readStream opens the object, or its range if not nil, or returns the location the backend
+// redirected to when redirects are passed through to the client. The transfer is aborted
+// once the request is done, so a client going away does not keep the backend busy
+func (s *server) readStream(ctx context.Context, path string, rng *byteRange) (io.ReadCloser, string, error) {
+	if s.passthroughRedirects {
+		if redirector, ok := s.client.(fs.Redirector); ok {
+			reader, location, err := redirector.ReadStreamOrRedirect(ctx, path)
+			if err != nil || location != "" || rng == nil {
+				return reader, location, err
+			}
+			// Not redirected, so the whole file is read
+			reader, err = fs.LimitReadCloser(reader, rng.offset, rng.length)
+			return reader, "", err
+		}
+	}
+
+	if rng != nil {
+		reader, err := s.client.ReadStreamRange(ctx, path, rng.offset, rng.length)
+		return reader, "", err
+	}
+	reader, err := s.client.ReadStreamContext(ctx, path)
+	return reader, "", err
+}
+
+// requestedRange returns the range of the file requested with the Range header, nil for
+// the whole file, and writes the InvalidRange error if the range starts past its end.
+// Directory markers, and files changed since the If-Range validator, are served whole
+func requestedRange(w http.ResponseWriter, r *http.Request, entryInfo fs.EntryInfo, etag string) (*byteRange, bool) {
+	header := r.Header.Get("Range")
+	if header == "" || entryInfo.IsDir {
+		return nil, true
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, etag, entryInfo.LastModified) {
+		access_log.AddLogContext(r, "if-range-changed")
+		return nil, true
+	}
+
+	rng, satisfiable := parseRange(header, entryInfo.Size)
+	if !satisfiable {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", entryInfo.Size))
+		writeS3Error(w, r, "InvalidRange", http.StatusRequestedRangeNotSatisfiable)
+		access_log.AddLogContext(r, "invalid-range:%s", header)
+		return nil, false
+	}
+	if rng != nil {
+		access_log.AddLogContext(r, "range:%d-%d", rng.offset, rng.offset+rng.length-1)
+	}
+	return rng, true
+}
+
+// rewriteRedirect replaces the scheme and host of the backend redirect location
+func (s *server) rewriteRedirect(location string) string {
+	if s.redirectBaseURL == nil {
+		return location
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	parsed.Scheme = s.redirectBaseURL.Scheme
+	parsed.Host = s.redirectBaseURL.Host
+	return parsed.String()
+}
+
 // isBucketAllowed checks if a bucket is allowed based on the bucket map
 func (s *server) isBucketAllowed(bucket string) bool {
+	s.bucketMu.RLock()
+	defer s.bucketMu.RUnlock()
+
 	// Check if bucket is in the allowed map (O(1) lookup)
 	_, exists := s.bucketMap[bucket]
 	return exists
 }
 
-func (s *server) handleListBuckets(w http.ResponseWriter, r *http.Request) {
-	access_log.AddLogContext(r, "list-buckets")
-
-	// Use specified bucket map (buckets are required)
+// Buckets returns the names of the buckets exposed via S3 API, sorted,
+// including the ones created through the API
+func (s *server) Buckets() []string {
+	s.bucketMu.RLock()
 	buckets := make([]string, 0, len(s.bucketMap))
 	for bucket := range s.bucketMap {
 		buckets = append(buckets, bucket)
 	}
+	s.bucketMu.RUnlock()
 
 	sort.Strings(buckets)
+	return buckets
+}
+
+// removeBucket removes the bucket from the bucket map
+func (s *server) removeBucket(bucket string) {
+	s.bucketMu.Lock()
+	defer s.bucketMu.Unlock()
+
+	delete(s.bucketMap, bucket)
+	s.listCache.invalidate(bucket)
+}
+
+// addBucket adds the bucket to the bucket map
+func (s *server) addBucket(bucket string) {
+	s.bucketMu.Lock()
+	defer s.bucketMu.Unlock()
+
+	if s.bucketMap == nil {
+		s.bucketMap = make(map[string]interface{})
+	}
+	s.bucketMap[bucket] = struct{}{}
+}
+
+// resolveAlias returns the entry the alias points to, or the entry itself if it is not an alias.
+// Aliases are always zero-byte objects, so other entries do not need a lookup.
+func (s *server) resolveAlias(entryInfo fs.EntryInfo) (fs.EntryInfo, error) {
+	if entryInfo.IsDir || entryInfo.Size != 0 {
+		return entryInfo, nil
+	}
+
+	target, err := s.db.GetAlias(entryInfo.Path)
+	if err != nil || target == "" {
+		return entryInfo, err
+	}
+	return s.db.Stat(target)
+}
+
+// etagMatches checks if the ETag is in the comma separated list of the conditional header,
+// * matches any existing object
+func etagMatches(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, "\"") == strings.Trim(etag, "\"") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWritePreconditions checks the If-Match and If-None-Match headers
+// against the ETag of the object currently stored at the path
+func (s *server) checkWritePreconditions(r *http.Request, path string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+
+	etag := ""
+	entryInfo, err := s.db.Stat(path)
+	if err == nil {
+		entryInfo, err = s.resolveAlias(entryInfo)
+	}
+	if err == nil && !entryInfo.IsDir {
+		etag = ETag(entryInfo)
+	}
+
+	if ifMatch != "" && !etagMatches(ifMatch, etag) {
+		return false
+	}
+	if ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		return false
+	}
+	return true
+}
+
+func (s *server) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	access_log.AddLogContext(r, "list-buckets")
+
+	// Use specified bucket map (buckets are required)
+	buckets := s.Buckets()
 
 	result := ListBucketsResult{
+		Owner: requestOwner(r),
 		Buckets: Buckets{
 			Bucket: make([]Bucket, len(buckets)),
 		},
@@ -160,7 +663,7 @@ func (s *server) handleListBuckets(w http.ResponseWriter, r *http.Request) {
 	for i, bucket := range buckets {
 		result.Buckets.Bucket[i] = Bucket{
 			Name:         bucket,
-			CreationDate: time.Now().Format(time.RFC3339),
+			CreationDate: formatXMLTime(time.Now().Unix()),
 		}
 	}
 
@@ -174,7 +677,7 @@ func (s *server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 
 	// Validate bucket is allowed
 	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
 		access_log.AddLogContext(r, "no-such-bucket:%s", bucket)
 		return
 	}
@@ -185,17 +688,18 @@ func (s *server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 	var prefix, marker, delimiter string
 	delimiter = r.URL.Query().Get("delimiter")
 
-	if delimiter != "" && delimiter != "/" {
-		http.Error(w, "InvalidDelimiter", http.StatusBadRequest)
-		access_log.AddLogContext(r, "invalid-delimiter:%s", delimiter)
-		return
-	}
-
 	if isV2 {
 		// ListObjectsV2 parameters
 		prefix = r.URL.Query().Get("prefix")
-		marker = r.URL.Query().Get("continuation-token")
-		if marker == "" {
+		if token := r.URL.Query().Get("continuation-token"); token != "" {
+			var err error
+			marker, err = s.decodeContinuationToken(token)
+			if err != nil || !strings.HasPrefix(marker, bucket+"/") {
+				writeS3ErrorMessage(w, r, "InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest)
+				access_log.AddLogContext(r, "invalid-continuation-token")
+				return
+			}
+		} else {
 			marker = r.URL.Query().Get("start-after")
 			if marker != "" {
 				marker = filepath.Join(bucket, marker)
@@ -209,17 +713,60 @@ func (s *server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 		access_log.AddLogContext(r, "list-objects:%s", bucket)
 	}
 
-	// Default limit to 1000, but allow customization via max-keys parameter
-	limit := 1000
+	// Like S3, larger max-keys are capped at 1000 instead of rejected
+	limit := maxListKeys
 	if maxKeysStr := r.URL.Query().Get("max-keys"); maxKeysStr != "" {
-		if maxKeysInt := parseInt(maxKeysStr); maxKeysInt > 0 && maxKeysInt <= 1000 {
-			limit = maxKeysInt
+		maxKeys, err := strconv.Atoi(maxKeysStr)
+		if err != nil || maxKeys < 0 {
+			writeS3ErrorMessage(w, r, "InvalidArgument", "Provided max-keys not an integer or within integer range", http.StatusBadRequest)
+			access_log.AddLogContext(r, "invalid-max-keys:%s", maxKeysStr)
+			return
+		}
+		limit = min(maxKeys, maxListKeys)
+	}
+
+	// V1 always includes the owner, V2 only when requested
+	fetchOwner := !isV2 || r.URL.Query().Get("fetch-owner") == "true"
+
+	// Every query parameter affects the response, so all of them are the cache key
+	cacheKey := bucket + "?" + r.URL.Query().Encode()
+	if body, ok := s.listCache.get(cacheKey); ok {
+		access_log.AddLogContext(r, "list-cache-hit")
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(body)
+		return
+	}
+	generation := s.listCache.generation(bucket)
+
+	if s.listBackendFallback {
+		s.scanFromBackend(r, bucket, prefix, delimiter != "/")
+	}
+
+	list := func(marker string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error) {
+		return s.db.List(bucket+"/"+prefix, marker, dirOnly, limit)
+	}
+	if owner := r.URL.Query().Get("owner"); owner != "" {
+		// Extension: only list objects uploaded with the given access key
+		access_log.AddLogContext(r, "owner:%s", owner)
+		list = func(marker string, dirOnly bool, limit int) ([]fs.EntryInfo, bool, error) {
+			return s.db.ListOwnedBy(owner, bucket+"/"+prefix, marker, dirOnly, limit)
 		}
 	}
 
-	files, truncated, err := s.db.List(filepath.Join(bucket, prefix)+"/", marker, delimiter == "/", limit)
+	var files []fs.EntryInfo
+	var truncated bool
+	var err error
+	if delimiter == "" || delimiter == "/" {
+		// The stored directories are the common prefixes of the / delimiter
+		files, truncated, err = list(marker, delimiter == "/", limit)
+	} else {
+		access_log.AddLogContext(r, "delimiter:%s", delimiter)
+		files, truncated, err = listDelimited(func(marker string, limit int) ([]fs.EntryInfo, bool, error) {
+			return list(marker, false, limit)
+		}, bucket+"/"+prefix, marker, delimiter, limit)
+	}
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
 		return
 	}
 
@@ -228,33 +775,46 @@ func (s *server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 	nextMarker := ""
 
 	for _, file := range files {
+		// Next page starts past the last emitted item, be it a key or a prefix
+		if truncated {
+			nextMarker = file.Path
+		}
+
 		fileBucket, fileKey, ok := fs.BucketAndKeyFromPath(file.Path)
 		if !ok || fileBucket != bucket {
 			log.Printf("ListObjects: Failed to parse path %s", file.Path)
 			continue
 		}
 		if file.IsDir {
+			// Directories end with the / delimiter, and the grouped paths with theirs
 			commonPrefixes = append(commonPrefixes, CommonPrefix{
-				Prefix: fileKey + "/",
+				Prefix: strings.TrimPrefix(file.Path, bucket+"/"),
 			})
 			continue
 		}
 
-		etag := generateETag(file.Path, file.Size, file.LastModified)
-		objects = append(objects, Object{
+		target, err := s.resolveAlias(file)
+		if err != nil {
+			log.Printf("ListObjects: Failed to resolve alias %s: %v", file.Path, err)
+			target = file
+		}
+
+		etag := ETag(target)
+		object := Object{
 			Key:          fileKey,
-			LastModified: time.Unix(file.LastModified, 0).Format(time.RFC3339),
+			LastModified: formatXMLTime(target.LastModified),
 			ETag:         etag,
-			Size:         file.Size,
-			StorageClass: "STANDARD",
-		})
-		if truncated {
-			nextMarker = file.Path
+			Size:         target.Size,
+			StorageClass: storageClassOf(target),
 		}
+		// Objects discovered by the sync have no recorded owner
+		if fetchOwner && file.Owner != "" {
+			object.Owner = newOwner(file.Owner)
+		}
+		objects = append(objects, object)
 	}
 
-	w.Header().Set("Content-Type", "application/xml")
-
+	var body bytes.Buffer
 	if isV2 {
 		// ListObjectsV2 response
 		resultV2 := ListBucketResultV2{
@@ -263,14 +823,14 @@ func (s *server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 			MaxKeys:               limit,
 			IsTruncated:           truncated,
 			Delimiter:             delimiter,
-			KeyCount:              len(objects),
+			KeyCount:              len(objects) + len(commonPrefixes),
 			ContinuationToken:     r.URL.Query().Get("continuation-token"),
-			NextContinuationToken: nextMarker,
+			NextContinuationToken: s.encodeContinuationToken(nextMarker),
 			StartAfter:            r.URL.Query().Get("start-after"),
 			Contents:              objects,
 			CommonPrefixes:        commonPrefixes,
 		}
-		xml.NewEncoder(w).Encode(resultV2)
+		xml.NewEncoder(&body).Encode(resultV2)
 	} else {
 		// ListObjects (V1) response
 		result := ListBucketResult{
@@ -283,108 +843,411 @@ func (s *server) handleListObjects(w http.ResponseWriter, r *http.Request) {
 			Delimiter:      delimiter,
 			CommonPrefixes: commonPrefixes,
 		}
-		xml.NewEncoder(w).Encode(result)
+		xml.NewEncoder(&body).Encode(result)
 	}
-}
+	s.listCache.put(cacheKey, bucket, generation, body.Bytes())
 
-func (s *server) handleHeadBucket(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	bucket := vars["bucket"]
-
-	access_log.AddLogContext(r, "head-bucket:%s", bucket)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(body.Bytes())
+}
 
-	// Validate bucket is allowed (buckets are required)
-	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+// scanFromBackend populates the cache with the directory containing the prefix,
+// if it was not scanned yet, so the listing does not need to wait for the sync
+func (s *server) scanFromBackend(r *http.Request, bucket, prefix string, recursive bool) {
+	dir := bucket + "/" + prefix[:strings.LastIndex(prefix, "/")+1]
+	if entry, err := s.db.Stat(dir); err == nil && entry.IsDir && entry.Processed {
 		return
 	}
 
-	// Return 200 OK with no body for HEAD bucket request
-	w.WriteHeader(http.StatusOK)
+	access_log.AddLogContext(r, "backend-fallback:%s", dir)
+	if err := syncer.New(s.client, s.db).ScanDir(dir, recursive); err != nil {
+		log.Printf("ListObjects: Failed to read %s from backend: %v", dir, err)
+	}
 }
 
-func (s *server) handleHeadObject(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
-	key := vars["key"]
 
-	access_log.AddLogContext(r, "head:%s/%s", bucket, key)
+	access_log.AddLogContext(r, "create-bucket:%s", bucket)
 
-	// Validate bucket is allowed
-	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+	// Creating an existing bucket is reported like S3 does when buckets can be created,
+	// otherwise it is a no-op for clients ensuring that the configured bucket exists
+	if s.isBucketAllowed(bucket) {
+		if s.autoCreateBuckets {
+			writeS3Error(w, r, "BucketAlreadyOwnedByYou", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Location", "/"+bucket)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	path := fs.PathFromBucketAndKey(bucket, key)
-	entryInfo, err := s.db.Stat(path)
-	if err != nil || entryInfo.IsDir {
-		http.Error(w, "Object not found", http.StatusNotFound)
+	if !s.autoCreateBuckets {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
 		return
 	}
 
-	etag := generateETag(entryInfo.Path, entryInfo.Size, entryInfo.LastModified)
+	if !isValidBucketName(bucket) {
+		writeS3Error(w, r, "InvalidBucketName", http.StatusBadRequest)
+		return
+	}
 
-	// Check If-None-Match header for conditional requests
-	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
-		if ifNoneMatch == "*" || ifNoneMatch == etag {
-			w.WriteHeader(http.StatusNotModified)
-			return
-		}
+	// Directory existing on the backend is left for the sync to scan
+	_, err := s.client.Stat(bucket)
+	existing := err == nil
+	if fs.IsNotFound(err) {
+		err = s.client.Mkdir(bucket)
+	}
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "mkdir", bucket, err)
+		access_log.AddLogContext(r, "remote-fail")
+		return
 	}
 
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", entryInfo.Size))
-	w.Header().Set("Last-Modified", time.Unix(entryInfo.LastModified, 0).Format(http.TimeFormat))
-	w.Header().Set("ETag", etag)
+	err = s.db.Insert(fs.EntryInfo{
+		Path:         bucket + "/",
+		LastModified: time.Now().Unix(),
+		IsDir:        true,
+		Processed:    !existing,
+	})
+	if err == nil {
+		err = s.db.AddBucket(bucket)
+	}
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to store bucket %s: %v", bucket, err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	s.addBucket(bucket)
+	log.Printf("CreateBucket: Created bucket %s", bucket)
+
+	w.Header().Set("Location", "/"+bucket)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *server) handleGetObject(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleDeleteBucket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
-	key := vars["key"]
 
-	access_log.AddLogContext(r, "get:%s/%s", bucket, key)
+	access_log.AddLogContext(r, "delete-bucket:%s", bucket)
 
-	// Validate bucket is allowed
 	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
 		return
 	}
 
-	path := fs.PathFromBucketAndKey(bucket, key)
-	entryInfo, err := s.db.Stat(path)
-	if err != nil || entryInfo.IsDir {
-		http.Error(w, "Object not found", http.StatusNotFound)
-		access_log.AddLogContext(r, "local-fail")
+	if !s.autoCreateBuckets {
+		writeS3Error(w, r, "MethodNotAllowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	etag := generateETag(entryInfo.Path, entryInfo.Size, entryInfo.LastModified)
-
-	// Check If-None-Match header for conditional requests
-	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
-		if ifNoneMatch == "*" || ifNoneMatch == etag {
-			w.Header().Set("ETag", etag)
-			w.WriteHeader(http.StatusNotModified)
-			return
-		}
-	}
-
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", entryInfo.Size))
-	w.Header().Set("Last-Modified", time.Unix(entryInfo.LastModified, 0).Format(http.TimeFormat))
-	w.Header().Set("ETag", etag)
-
-	reader, err := s.client.ReadStream(entryInfo.Path)
+	// Only buckets created with the CreateBucket request can be deleted
+	created, err := s.db.ListBuckets()
 	if err != nil {
-		http.Error(w, "Object not found", http.StatusNotFound)
-		access_log.AddLogContext(r, "remote-fail")
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	if !slices.Contains(created, bucket) {
+		writeS3ErrorMessage(w, r, "AccessDenied", "Configured bucket cannot be deleted", http.StatusForbidden)
+		access_log.AddLogContext(r, "configured-bucket")
+		return
+	}
+
+	// Any entry besides the bucket directory, including pending ones, keeps the bucket
+	processed, pending, _, err := s.db.GetStats(bucket + "/")
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	if processed+pending > 1 {
+		writeS3Error(w, r, "BucketNotEmpty", http.StatusConflict)
+		return
+	}
+
+	// The cache might not know about files written directly to the backend
+	infos, err := s.client.ReadDir(bucket)
+	if err != nil && !fs.IsNotFound(err) {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "list", bucket, err)
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	} else if len(infos) > 0 {
+		writeS3Error(w, r, "BucketNotEmpty", http.StatusConflict)
+		access_log.AddLogContext(r, "remote-not-empty")
+		return
+	}
+
+	if err := s.client.Remove(bucket); err != nil && !fs.IsNotFound(err) {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "remove", bucket, err)
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	}
+
+	if err := s.db.Delete(bucket + "/"); err == nil {
+		err = s.db.RemoveBucket(bucket)
+	}
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to forget bucket %s: %v", bucket, err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	s.removeBucket(bucket)
+
+	log.Printf("DeleteBucket: Deleted bucket %s", bucket)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleHeadBucket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	access_log.AddLogContext(r, "head-bucket:%s", bucket)
+
+	// Validate bucket is allowed (buckets are required)
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Amz-Bucket-Region", s.region)
+
+	// Entries of the cache, so directories are counted and files not synced yet are not
+	if s.bucketStatsHeaders {
+		processed, pending, totalSize, err := s.db.GetStats(bucket + "/")
+		if err != nil {
+			log.Printf("Failed to get stats of bucket %s: %v", bucket, err)
+		} else {
+			w.Header().Set("X-Amz-Object-Count", strconv.Itoa(processed+pending))
+			w.Header().Set("X-Amz-Bucket-Size", strconv.FormatInt(totalSize, 10))
+		}
+	}
+
+	// Return 200 OK with no body for HEAD bucket request
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleHeadObject(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	access_log.AddLogContext(r, "head:%s/%s", bucket, key)
+
+	// Only the current version exists, so the requested version is served as current
+	if versionId := r.URL.Query().Get("versionId"); versionId != "" {
+		access_log.AddLogContext(r, "version-id:%s", versionId)
+	}
+
+	// Validate bucket is allowed
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	path := fs.PathFromBucketAndKey(bucket, key)
+	entryInfo, err := s.statObject(r, path)
+	if err != nil {
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	if !entryInfo.IsDir && s.headValidate {
+		entryInfo, err = s.validateEntry(r, entryInfo)
+		if err != nil {
+			writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+	}
+	if entryInfo.IsDir {
+		// Directories are served as the zero-byte directory marker of their key
+		entryInfo.Size = 0
+		access_log.AddLogContext(r, "dir-marker")
+	} else {
+		entryInfo = s.lazyMD5(r, entryInfo)
+	}
+
+	etag := ETag(entryInfo)
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
+
+	// Check If-None-Match header for conditional requests
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if ifNoneMatch == "*" || ifNoneMatch == etag {
+			w.Header().Set("ETag", etag)
+			s.setCacheHeaders(w, r, key)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	rng, ok := requestedRange(w, r, entryInfo, etag)
+	if !ok {
+		return
+	}
+
+	if !entryInfo.IsDir {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	w.Header().Set("Last-Modified", formatHTTPTime(entryInfo.LastModified))
+	w.Header().Set("ETag", etag)
+	setStorageClassHeader(w, entryInfo)
+	s.setCacheHeaders(w, r, key)
+	setResponseOverrides(w, r)
+
+	if rng != nil {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", rng.length))
+		w.Header().Set("Content-Range", rng.contentRange(entryInfo.Size))
+		w.WriteHeader(http.StatusPartialContent)
+		return
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", entryInfo.Size))
+	setChecksumHeader(w, entryInfo)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleGetObject(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	access_log.AddLogContext(r, "get:%s/%s", bucket, key)
+
+	// Only the current version exists, so the requested version is served as current
+	if versionId := r.URL.Query().Get("versionId"); versionId != "" {
+		access_log.AddLogContext(r, "version-id:%s", versionId)
+	}
+
+	// Validate bucket is allowed
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	path := fs.PathFromBucketAndKey(bucket, key)
+	entryInfo, err := s.statObject(r, path)
+	if err != nil {
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		access_log.AddLogContext(r, "local-fail")
+		return
+	}
+	if entryInfo.IsDir {
+		// Directories are served as the zero-byte directory marker of their key
+		entryInfo.Size = 0
+		access_log.AddLogContext(r, "dir-marker")
+	} else {
+		entryInfo = s.lazyMD5(r, entryInfo)
+	}
+
+	etag := ETag(entryInfo)
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
+
+	// Check If-None-Match header for conditional requests
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if ifNoneMatch == "*" || ifNoneMatch == etag {
+			w.Header().Set("ETag", etag)
+			s.setCacheHeaders(w, r, key)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if entryInfo.IsDir {
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Last-Modified", formatHTTPTime(entryInfo.LastModified))
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		s.setCacheHeaders(w, r, key)
+		setResponseOverrides(w, r)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rng, ok := requestedRange(w, r, entryInfo, etag)
+	if !ok {
+		return
+	}
+
+	reader, location, err := s.readStream(r.Context(), entryInfo.Path, rng)
+	if err != nil {
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		if fs.IsNotFound(err) {
+			s.evictMissing(r, entryInfo)
+		} else {
+			s.logBackendError(w, r, "read", entryInfo.Path, err)
+		}
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	}
+
+	// Let the client fetch the content from where the backend redirected
+	if location != "" {
+		w.Header().Set("Location", s.rewriteRedirect(location))
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		access_log.AddLogContext(r, "redirect")
 		return
 	}
 	defer reader.Close()
+	body := s.throttle(r, reader)
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	setStorageClassHeader(w, entryInfo)
+
+	// The checksums are of the whole object, so a range is sent without them
+	if rng != nil {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", rng.length))
+		w.Header().Set("Content-Range", rng.contentRange(entryInfo.Size))
+		w.Header().Set("Last-Modified", formatHTTPTime(entryInfo.LastModified))
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		setContentDisposition(w, key)
+		s.setCacheHeaders(w, r, key)
+		setResponseOverrides(w, r)
+		w.WriteHeader(http.StatusPartialContent)
+		io.Copy(w, body)
+		return
+	}
+
+	// Checksum given on upload is returned as is, otherwise it is computed while streaming
+	// and sent as a trailer, which requires chunked encoding instead of Content-Length
+	checksumStored := setChecksumHeader(w, entryInfo)
+	checksumTrailer := !checksumStored && strings.EqualFold(r.Header.Get("X-Amz-Checksum-Mode"), "ENABLED") && r.ProtoAtLeast(1, 1)
+
+	if checksumTrailer {
+		w.Header().Set("Trailer", checksumSHA256Header)
+	} else {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", entryInfo.Size))
+	}
+	w.Header().Set("Last-Modified", formatHTTPTime(entryInfo.LastModified))
+	w.Header().Set("ETag", etag)
 
 	w.Header().Set("Content-Type", "application/octet-stream")
-	io.Copy(w, reader)
+	setContentDisposition(w, key)
+	s.setCacheHeaders(w, r, key)
+	setResponseOverrides(w, r)
+
+	if !checksumTrailer {
+		io.Copy(w, body)
+		return
+	}
+
+	hasher := sha256.New()
+	written, err := io.Copy(w, io.TeeReader(body, hasher))
+	if err != nil || written != entryInfo.Size {
+		// Do not send the checksum of the partial content
+		access_log.AddLogContext(r, "checksum-incomplete")
+		return
+	}
+	w.Header().Set(checksumSHA256Header, base64.StdEncoding.EncodeToString(hasher.Sum(nil)))
+	access_log.AddLogContext(r, "checksum-trailer")
 }
 
 func (s *server) handlePutObject(w http.ResponseWriter, r *http.Request) {
@@ -398,44 +1261,222 @@ func (s *server) handlePutObject(w http.ResponseWriter, r *http.Request) {
 
 	// Validate bucket is allowed
 	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
 		return
 	}
 
-	if r.ContentLength < 0 {
-		http.Error(w, "Invalid content length", http.StatusBadRequest)
+	// Uploads over the limit are rejected before reading the body,
+	// the body of unknown length is limited while it is streamed
+	if s.maxObjectSize > 0 && r.ContentLength > s.maxObjectSize {
+		writeS3Error(w, r, "EntityTooLarge", http.StatusBadRequest)
+		access_log.AddLogContext(r, "too-large")
 		return
 	}
 
-	// Check for SHA256 content verification
-	var bodyReader io.Reader = r.Body
+	// Keys ending with a slash can only be stored as directories
+	if strings.HasSuffix(key, "/") {
+		if r.ContentLength != 0 {
+			writeS3ErrorMessage(w, r, "InvalidRequest", "Directory marker object must be empty", http.StatusBadRequest)
+			return
+		}
+		s.putDirectoryMarker(w, r, path)
+		return
+	}
+
+	// Zero-byte object carrying an alias target creates or updates an alias
+	aliasTarget := r.Header.Get("X-Amz-Meta-Alias-Target")
+	if aliasTarget != "" {
+		if r.ContentLength != 0 {
+			writeS3ErrorMessage(w, r, "InvalidRequest", "Alias object must be empty", http.StatusBadRequest)
+			return
+		}
+
+		aliasTarget = fs.PathFromBucketAndKey(bucket, aliasTarget)
+		if aliasTarget == path {
+			writeS3ErrorMessage(w, r, "InvalidRequest", "Alias cannot point to itself", http.StatusBadRequest)
+			return
+		}
+		if entryInfo, err := s.db.Stat(aliasTarget); err != nil || entryInfo.IsDir {
+			writeS3ErrorMessage(w, r, "InvalidRequest", "Alias target not found", http.StatusBadRequest)
+			return
+		}
+		if target, err := s.db.GetAlias(aliasTarget); err != nil || target != "" {
+			writeS3ErrorMessage(w, r, "InvalidRequest", "Alias target cannot be an alias", http.StatusBadRequest)
+			return
+		}
+		// Aliases resolve a single level, so the target of an alias cannot become one
+		if isTarget, err := s.db.IsAliasTarget(path); err != nil {
+			writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+			access_log.AddLogContext(r, "db-fail")
+			return
+		} else if isTarget {
+			writeS3ErrorMessage(w, r, "InvalidRequest", "Object is the target of an alias", http.StatusBadRequest)
+			return
+		}
+		access_log.AddLogContext(r, "alias:%s", aliasTarget)
+	} else if target, err := s.db.GetAlias(path); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	} else if target != "" {
+		if s.aliasWrites != AliasWritesRedirect {
+			writeS3ErrorMessage(w, r, "OperationAborted", "Object is an alias", http.StatusConflict)
+			access_log.AddLogContext(r, "alias-rejected")
+			return
+		}
+		access_log.AddLogContext(r, "alias-redirect:%s", target)
+		path = target
+	}
+
+	// Tags given inline are validated before the body is streamed
+	tags, err := parseTaggingHeader(r.Header.Get("X-Amz-Tagging"))
+	if err != nil {
+		writeS3ErrorMessage(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-tags")
+		return
+	}
 
+	storageClass, ok := parseStorageClass(r.Header.Get("X-Amz-Storage-Class"))
+	if !ok {
+		writeS3Error(w, r, "InvalidStorageClass", http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-storage-class")
+		return
+	}
+
+	// Checksum algorithm and value are validated before the body is streamed
+	checksumAlgorithm, expectedChecksum, err := parseChecksumHeaders(r.Header)
+	if err != nil {
+		writeS3ErrorMessage(w, r, "InvalidRequest", err.Error(), http.StatusBadRequest)
+		access_log.AddLogContext(r, "checksum-invalid")
+		return
+	}
+
+	// The body is not read until all checks not needing it passed, as the first read
+	// answers Expect: 100-continue, and rejected clients then do not send the body
+	bodyReader := s.throttle(r, r.Body)
+	if s.maxObjectSize > 0 && r.ContentLength < 0 {
+		bodyReader = newSizeLimiter(bodyReader, s.maxObjectSize)
+	}
+
+	// Check for SHA256 content verification
 	if expectedSHA256 := r.Header.Get("X-Amz-Content-Sha256"); expectedSHA256 != "" && expectedSHA256 != "UNSIGNED-PAYLOAD" {
-		bodyReader = newHashVerifier(r.Body, sha256.New(), expectedSHA256)
+		bodyReader = newHashVerifier(bodyReader, sha256.New(), expectedSHA256)
+	}
+
+	// Check for MD5 content verification, both verifiers can be applied at once
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		if !isValidContentMD5(contentMD5) {
+			writeS3Error(w, r, "InvalidDigest", http.StatusBadRequest)
+			access_log.AddLogContext(r, "md5-invalid")
+			return
+		}
+		bodyReader = newMD5Verifier(bodyReader, contentMD5)
+	}
+
+	// Compute the additional checksum requested by the client, verifying it if given
+	var checksumVerifier *hashVerifier
+	if checksumAlgorithm != "" {
+		checksumVerifier = newChecksumVerifier(bodyReader, checksumAlgorithm, expectedChecksum)
+		bodyReader = checksumVerifier
+		access_log.AddLogContext(r, "checksum:%s", strings.ToLower(checksumAlgorithm))
+	}
+
+	// The MD5 of the content is stored to be served as the ETag
+	var md5Hasher *hashVerifier
+	if s.etagScanMode != ETagScanModeSynthetic {
+		md5Hasher = newMD5Hasher(bodyReader)
+		bodyReader = md5Hasher
 	}
 
-	err := s.client.WriteStream(path, bodyReader, r.ContentLength, 0644)
-	if errors.Is(err, ErrBadDigest) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
-<Error>
-	<Code>BadDigest</Code>
-	<Message>The Content-SHA256 you specified did not match what we received.</Message>
-</Error>`))
+	// Backend write and cache update of the same key must not interleave
+	if s.writeConflicts == WriteConflictsReject {
+		unlock, ok := s.writeLocks.tryLock(path)
+		if !ok {
+			writeS3ErrorMessage(w, r, "OperationAborted", "A conflicting write to this object is in progress", http.StatusConflict)
+			access_log.AddLogContext(r, "write-conflict")
+			return
+		}
+		defer unlock()
+	} else {
+		defer s.writeLocks.lock(path)()
+	}
+
+	// Preconditions are checked under the lock and before the body is streamed,
+	// so concurrent writers can compare-and-swap the object
+	if !s.checkWritePreconditions(r, path) {
+		writeS3Error(w, r, "PreconditionFailed", http.StatusPreconditionFailed)
+		access_log.AddLogContext(r, "precondition-failed")
+		return
+	}
+
+	// Check content type against the bucket allow-list, sniffing it if not provided,
+	// which is done last as it reads the body
+	if allowed := s.allowedContentTypes[bucket]; len(allowed) > 0 {
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			buffered := bufio.NewReader(bodyReader)
+			head, _ := buffered.Peek(512)
+			contentType = http.DetectContentType(head)
+			bodyReader = buffered
+		}
+		if !isContentTypeAllowed(contentType, allowed) {
+			writeS3ErrorMessage(w, r, "AccessDenied", "Content type is not allowed in this bucket", http.StatusForbidden)
+			access_log.AddLogContext(r, "content-type-denied:%s", contentType)
+			return
+		}
+	}
+
+	// Content already stored, as told by the checksum sent up front, is not written again
+	previous, previousErr := s.db.Stat(path)
+	stat, unchanged := s.unchangedObject(path, previous, previousErr == nil, checksumAlgorithm, expectedChecksum, r.ContentLength)
+
+	// The object replaced by the upload, as stored on the backend, to tell whether
+	// an interrupted upload changed it
+	var before os.FileInfo
+	var beforeErr error
+	if unchanged {
+		access_log.AddLogContext(r, "unchanged")
+	} else {
+		before, beforeErr = s.client.Stat(path)
+		err = s.client.WriteStreamContext(r.Context(), path, bodyReader, r.ContentLength, s.fileMode)
+	}
+	if err != nil && isClientAbort(r, err) {
+		s.discardPartialWrite(r, path, before, beforeErr)
+		writeS3Error(w, r, "IncompleteBody", http.StatusBadRequest)
+		access_log.AddLogContext(r, "client-abort")
+		return
+	} else if errors.Is(err, ErrEntityTooLarge) {
+		writeS3Error(w, r, "EntityTooLarge", http.StatusBadRequest)
+		access_log.AddLogContext(r, "too-large")
+		return
+	} else if errors.Is(err, ErrBadContentMD5) {
+		writeS3ErrorMessage(w, r, "BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest)
+		access_log.AddLogContext(r, "md5-fail")
+		return
+	} else if errors.Is(err, ErrBadChecksum) {
+		writeS3ErrorMessage(w, r, "BadDigest", fmt.Sprintf("The %s you specified did not match the calculated checksum.", checksumAlgorithm), http.StatusBadRequest)
+		access_log.AddLogContext(r, "checksum-fail")
+		return
+	} else if errors.Is(err, ErrBadDigest) {
+		writeS3Error(w, r, "BadDigest", http.StatusBadRequest)
 		access_log.AddLogContext(r, "sha256-fail")
 		return
 	} else if err != nil {
-		http.Error(w, "Failed to upload object", http.StatusInternalServerError)
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "write", path, err)
 		access_log.AddLogContext(r, "remote-fail")
 		return
 	}
 
 	// Get file info from WebDAV to update database
-	stat, err := s.client.Stat(path)
-	if err != nil {
-		http.Error(w, "Failed to stat uploaded object", http.StatusInternalServerError)
-		access_log.AddLogContext(r, "stat-fail")
-		return
+	if !unchanged {
+		stat, err = s.client.Stat(path)
+		if err != nil {
+			writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+			s.logBackendError(w, r, "stat", path, err)
+			access_log.AddLogContext(r, "stat-fail")
+			return
+		}
 	}
 
 	entryInfo := fs.EntryInfo{
@@ -444,20 +1485,350 @@ func (s *server) handlePutObject(w http.ResponseWriter, r *http.Request) {
 		LastModified: stat.ModTime().Unix(),
 		IsDir:        stat.IsDir(),
 		Processed:    true,
+		Owner:        AccessKey(r),
+		StorageClass: storageClass,
+	}
+	if unchanged {
+		entryInfo.Checksum = previous.Checksum
+		entryInfo.MD5 = previous.MD5
+	} else {
+		if checksumVerifier != nil {
+			entryInfo.Checksum = checksumAlgorithm + ":" + checksumVerifier.Sum()
+		}
+		if md5Hasher != nil {
+			entryInfo.MD5 = md5Hasher.Sum()
+		}
 	}
 
 	entryInfos := append(fs.BaseDirEntries(path), entryInfo)
 
 	// Insert into DB
 	if err := s.db.Insert(entryInfos...); err != nil {
-		http.Error(w, "Failed to insert object metadata", http.StatusInternalServerError)
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
 		log.Printf("Failed to insert object metadata: %v", err)
 		access_log.AddLogContext(r, "db-fail")
 		return
 	}
+	s.negativeCache.invalidate(entryInfo)
+	// Dropped once the alias is updated too, as it changes the listed size
+	defer s.listCache.invalidate(bucket)
+
+	// The new object replaces the tags of the previous one
+	if err := s.db.SetTags(path, tags); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to update tags: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	if aliasTarget != "" {
+		if err := s.db.SetAlias(path, aliasTarget); err != nil {
+			writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+			log.Printf("Failed to update alias: %v", err)
+			access_log.AddLogContext(r, "db-fail")
+			return
+		}
+	}
+
+	etag := ETag(entryInfo)
+	w.Header().Set("ETag", etag)
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
+	setChecksumHeader(w, entryInfo)
+	w.WriteHeader(http.StatusOK)
+}
+
+// statObject returns the entry of the object with its alias resolved. In read-through
+// mode an object missing from the cache is looked up on the backend. Missing objects
+// are remembered by the negative cache, if enabled
+func (s *server) statObject(r *http.Request, path string) (fs.EntryInfo, error) {
+	if s.negativeCache.missing(path) {
+		access_log.AddLogContext(r, "negative-cache")
+		return fs.EntryInfo{}, os.ErrNotExist
+	}
+	generation := s.negativeCache.generation(path)
+
+	entryInfo, err := s.db.Stat(path)
+	if err == nil {
+		return s.resolveAlias(entryInfo)
+	}
+	if s.readThrough {
+		entryInfo, err = s.statFromBackend(r, path)
+		if fs.IsNotFound(err) {
+			s.negativeCache.put(path, generation)
+		}
+	} else if cache.IsNotFound(err) {
+		s.negativeCache.put(path, generation)
+	}
+	return entryInfo, err
+}
+
+// lazyMD5 computes the MD5 of the object without one in the md5-lazy mode, reading
+// it once and storing the digest, which is served as the ETag from then on.
+// The digest is not stored if the object is being written or changed meanwhile
+func (s *server) lazyMD5(r *http.Request, entryInfo fs.EntryInfo) fs.EntryInfo {
+	if s.etagScanMode != ETagScanModeMD5Lazy || entryInfo.MD5 != "" {
+		return entryInfo
+	}
+
+	digest, err := fs.ContentMD5(r.Context(), s.client, entryInfo.Path)
+	if err != nil {
+		access_log.AddLogContext(r, "md5-fail")
+		return entryInfo
+	}
+	entryInfo.MD5 = digest
+	access_log.AddLogContext(r, "md5-computed")
+
+	unlock, ok := s.writeLocks.tryLock(entryInfo.Path)
+	if !ok {
+		return entryInfo
+	}
+	defer unlock()
+
+	cached, err := s.db.Stat(entryInfo.Path)
+	if err != nil || cached.Size != entryInfo.Size || cached.LastModified != entryInfo.LastModified {
+		return entryInfo
+	}
+	cached.MD5 = digest
+	if err := s.db.Insert(cached); err != nil {
+		log.Printf("Failed to store MD5 of object %s: %v", entryInfo.Path, err)
+		return entryInfo
+	}
+	if bucket, _, ok := fs.BucketAndKeyFromPath(entryInfo.Path); ok {
+		s.listCache.invalidate(bucket)
+	}
+	return entryInfo
+}
+
+// statFromBackend returns the entry of the file found on the backend, caching it
+// unless the object is being written. Its new parent directories are left for the sync
+// to scan, as their other files are not known yet
+func (s *server) statFromBackend(r *http.Request, path string) (fs.EntryInfo, error) {
+	stat, err := s.client.Stat(path)
+	if err != nil {
+		return fs.EntryInfo{}, err
+	}
+	if stat.IsDir() {
+		return fs.EntryInfo{}, fmt.Errorf("not a file: %s", path)
+	}
+
+	entryInfo := fs.EntryInfo{
+		Path:         path,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime().Unix(),
+		Processed:    true,
+	}
+	access_log.AddLogContext(r, "read-through")
+
+	unlock, ok := s.writeLocks.tryLock(path)
+	if !ok {
+		return entryInfo, nil
+	}
+	defer unlock()
+
+	parents := fs.BaseDirEntries(path)
+	for i := range parents {
+		parents[i].Processed = false
+	}
+	if err := s.db.Insert(append(parents, entryInfo)...); err != nil {
+		log.Printf("Failed to cache object %s read through: %v", path, err)
+		return entryInfo, nil
+	}
+	s.negativeCache.invalidate(entryInfo)
+	if bucket, _, ok := fs.BucketAndKeyFromPath(path); ok {
+		s.listCache.invalidate(bucket)
+	}
+	return entryInfo, nil
+}
+
+// validateEntry returns the entry refreshed from the backend when its cache entry is
+// older than the validate threshold, storing the new size and modification time.
+// The cached entry is served when the backend cannot be reached, and an error is
+// returned when the file was removed out-of-band
+func (s *server) validateEntry(r *http.Request, entryInfo fs.EntryInfo) (fs.EntryInfo, error) {
+	if time.Since(time.Unix(entryInfo.UpdatedAt, 0)) < s.headValidateAfter {
+		return entryInfo, nil
+	}
+
+	stat, err := s.client.Stat(entryInfo.Path)
+	if fs.IsNotFound(err) {
+		s.evictMissing(r, entryInfo)
+		return fs.EntryInfo{}, err
+	} else if err != nil {
+		access_log.AddLogContext(r, "validate-fail")
+		return entryInfo, nil
+	} else if stat.IsDir() {
+		s.evictMissing(r, entryInfo)
+		return fs.EntryInfo{}, fmt.Errorf("not a file: %s", entryInfo.Path)
+	}
+
+	cached := entryInfo
+	entryInfo.Size = stat.Size()
+	entryInfo.LastModified = stat.ModTime().Unix()
+	changed := entryInfo.Size != cached.Size || entryInfo.LastModified != cached.LastModified
+	if changed {
+		// The checksums were of the previous content
+		entryInfo.Checksum = ""
+		entryInfo.MD5 = ""
+		access_log.AddLogContext(r, "validated")
+	}
+
+	unlock, ok := s.writeLocks.tryLock(entryInfo.Path)
+	if !ok {
+		return entryInfo, nil
+	}
+	defer unlock()
+
+	current, err := s.db.Stat(entryInfo.Path)
+	if err != nil || current.Size != cached.Size || current.LastModified != cached.LastModified {
+		return entryInfo, nil
+	}
+	if err := s.db.Insert(entryInfo); err != nil {
+		log.Printf("Failed to store validated object %s: %v", entryInfo.Path, err)
+		return entryInfo, nil
+	}
+	entryInfo.UpdatedAt = time.Now().Unix()
+	if changed {
+		if bucket, _, ok := fs.BucketAndKeyFromPath(entryInfo.Path); ok {
+			s.listCache.invalidate(bucket)
+		}
+	}
+	return entryInfo, nil
+}
+
+// evictMissing removes the entry of the object removed from the backend out-of-band,
+// so the next requests do not wait for the backend to miss it again. The entry is kept
+// if it is being written, or was replaced since it was read
+func (s *server) evictMissing(r *http.Request, entryInfo fs.EntryInfo) {
+	unlock, ok := s.writeLocks.tryLock(entryInfo.Path)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	current, err := s.db.Stat(entryInfo.Path)
+	if err != nil || current.Size != entryInfo.Size || current.LastModified != entryInfo.LastModified {
+		return
+	}
+	if err := s.db.Delete(entryInfo.Path); err != nil {
+		log.Printf("Failed to evict missing object %s: %v", entryInfo.Path, err)
+		return
+	}
+	if bucket, _, ok := fs.BucketAndKeyFromPath(entryInfo.Path); ok {
+		s.listCache.invalidate(bucket)
+	}
+	access_log.AddLogContext(r, "evicted")
+}
+
+// unchangedObject returns the backend file of the object if the upload is of the same
+// content, known only when the client sent the checksum of the body up front and it is
+// the one stored on the previous upload. The ETag is not derived from the content, so
+// it cannot tell. The file must also still be the one cached, not changed out-of-band
+func (s *server) unchangedObject(path string, previous fs.EntryInfo, existed bool, algorithm, expected string, contentLength int64) (os.FileInfo, bool) {
+	if !existed || expected == "" || previous.IsDir || contentLength != previous.Size {
+		return nil, false
+	}
+	if previous.Checksum != algorithm+":"+expected {
+		return nil, false
+	}
+
+	stat, err := s.client.Stat(path)
+	if err != nil || stat.IsDir() || stat.Size() != previous.Size || stat.ModTime().Unix() != previous.LastModified {
+		return nil, false
+	}
+	return stat, true
+}
+
+// isClientAbort reports whether the write failed as the client went away mid-upload
+func isClientAbort(r *http.Request, err error) bool {
+	return r.Context().Err() != nil || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// discardPartialWrite removes what an interrupted upload left of the object. Backends
+// writing in place leave a partial object, which is removed with its entry, so the sync
+// does not pick it up, while the others keep the previous object untouched. The object
+// is compared with the backend before the write, as the cache may not know it yet
+func (s *server) discardPartialWrite(r *http.Request, path string, before os.FileInfo, beforeErr error) {
+	if beforeErr != nil && !fs.IsNotFound(beforeErr) {
+		log.Printf("Keeping possibly partial upload %s, failed to check it before the write: %v", path, beforeErr)
+		return
+	}
+
+	stat, err := s.client.Stat(path)
+	if err != nil && !fs.IsNotFound(err) {
+		log.Printf("Failed to check partial upload %s: %v", path, err)
+		return
+	}
+	if err == nil && beforeErr == nil && stat.Size() == before.Size() && stat.ModTime().Equal(before.ModTime()) {
+		return
+	}
+
+	if err == nil {
+		if err := s.client.Remove(path); err != nil && !fs.IsNotFound(err) {
+			log.Printf("Failed to remove partial upload %s: %v", path, err)
+			return
+		}
+	}
+	if _, err := s.db.Stat(path); err == nil {
+		if err := s.db.Delete(path); err != nil {
+			log.Printf("Failed to delete entry of partial upload %s: %v", path, err)
+			return
+		}
+		if bucket, _, ok := fs.BucketAndKeyFromPath(path); ok {
+			s.listCache.invalidate(bucket)
+		}
+	}
+	access_log.AddLogContext(r, "partial-removed")
+}
+
+// setChecksumHeader sets the header of the checksum given on upload of the object, if any
+func setChecksumHeader(w http.ResponseWriter, entryInfo fs.EntryInfo) bool {
+	algorithm, value, ok := strings.Cut(entryInfo.Checksum, ":")
+	if ok {
+		w.Header().Set(checksumHeader(algorithm), value)
+	}
+	return ok
+}
+
+// putDirectoryMarker creates the directory of a zero-byte key ending with a slash,
+// as created by tools to represent folders
+func (s *server) putDirectoryMarker(w http.ResponseWriter, r *http.Request, path string) {
+	access_log.AddLogContext(r, "dir-marker")
+
+	defer s.writeLocks.lock(path)()
+
+	_, err := s.client.Stat(path)
+	existing := err == nil
+	if fs.IsNotFound(err) {
+		err = s.client.Mkdir(path)
+	}
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "mkdir", path, err)
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	}
+
+	entryInfo := fs.EntryInfo{
+		Path:         path,
+		LastModified: time.Now().Unix(),
+		IsDir:        true,
+		Processed:    !existing,
+	}
+
+	entryInfos := append(fs.BaseDirEntries(strings.TrimSuffix(path, "/")), entryInfo)
+
+	if err := s.db.Insert(entryInfos...); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to insert directory metadata: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	s.listCache.invalidate(mux.Vars(r)["bucket"])
+	s.negativeCache.invalidate(entryInfo)
 
-	etag := generateETag(entryInfo.Path, entryInfo.Size, entryInfo.LastModified)
+	etag := ETag(entryInfo)
 	w.Header().Set("ETag", etag)
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -471,21 +1842,38 @@ func (s *server) handleDeleteObject(w http.ResponseWriter, r *http.Request) {
 
 	// Validate bucket is allowed
 	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	// Writes to the path are waited for, so the removed file is not one written meanwhile
+	defer s.writeLocks.lock(path)()
+
+	if strings.HasSuffix(key, "/") {
+		if err := s.removeDirectoryMarker(path); err != nil {
+			writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+			s.logBackendError(w, r, "remove", path, err)
+			access_log.AddLogContext(r, "remote-fail")
+			return
+		}
+		s.listCache.invalidate(bucket)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	// Remove from database immediately
 	if err := s.db.Delete(path); err != nil {
 		log.Printf("Failed to delete object from database: %v", err)
-		http.Error(w, "Failed to delete object metadata", http.StatusInternalServerError)
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
 		access_log.AddLogContext(r, "db-fail")
 		return
 	}
+	s.listCache.invalidate(bucket)
 
 	// Remove from the FS
 	if err := s.client.Remove(path); err != nil {
-		http.Error(w, "Failed to delete object", http.StatusInternalServerError)
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "remove", path, err)
 		access_log.AddLogContext(r, "remote-fail")
 		return
 	}
@@ -501,48 +1889,87 @@ func (s *server) handleBulkDelete(w http.ResponseWriter, r *http.Request) {
 
 	// Validate bucket is allowed
 	if !s.isBucketAllowed(bucket) {
-		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
 		return
 	}
 
 	// Read the delete request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeS3Error(w, r, "IncompleteBody", http.StatusBadRequest)
 		return
 	}
 
 	// Parse the delete request
 	var deleteRequest DeleteRequest
 	if err := xml.Unmarshal(body, &deleteRequest); err != nil {
-		http.Error(w, "Invalid delete request", http.StatusBadRequest)
+		writeS3Error(w, r, "MalformedXML", http.StatusBadRequest)
 		return
 	}
+	if len(deleteRequest.Objects) > maxDeleteObjects {
+		writeS3Error(w, r, "MalformedXML", http.StatusBadRequest)
+		access_log.AddLogContext(r, "too-many-keys:%d", len(deleteRequest.Objects))
+		return
+	}
+	if deleteRequest.Quiet {
+		access_log.AddLogContext(r, "quiet")
+	}
 
 	// Process each object to delete
 	var deletedObjects []DeletedObject
 	var errors []DeleteError
 
+	var deadline time.Time
+	if s.deleteTimeout > 0 {
+		deadline = time.Now().Add(s.deleteTimeout)
+	}
+	defer s.listCache.invalidate(bucket)
+
 	for _, obj := range deleteRequest.Objects {
 		key := obj.Key
 		path := fs.PathFromBucketAndKey(bucket, key)
 
-		// Remove from database
-		if err := s.db.Delete(path); err != nil {
-			log.Printf("Failed to delete object from database: %v", err)
-			http.Error(w, "Failed to delete object metadata", http.StatusInternalServerError)
-			access_log.AddLogContext(r, "db-fail")
-			return
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			errors = append(errors, DeleteError{
+				Key:     key,
+				Code:    "RequestTimeout",
+				Message: "Delete did not complete within the request deadline",
+			})
+			continue
+		}
+
+		// Remove from WebDAV while writes to the path wait, and from the database
+		// only once the file is gone, so a failed key stays listed
+		unlock := s.writeLocks.lock(path)
+		var err error
+		if strings.HasSuffix(key, "/") {
+			err = s.removeDirectoryMarker(path)
+			unlock()
+		} else if err = s.removeWithRetry(path, deadline, unlock); err == nil {
+			err = s.db.Delete(path)
+			unlock()
+			if err != nil {
+				log.Printf("Failed to delete object from database: %v", err)
+				writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+				access_log.AddLogContext(r, "db-fail")
+				return
+			}
 		}
 
-		// Remove from WebDAV
-		if err := s.client.Remove(path); err != nil {
+		if err == errDeleteTimeout {
+			errors = append(errors, DeleteError{
+				Key:     key,
+				Code:    "RequestTimeout",
+				Message: "Delete did not complete within the request deadline",
+			})
+			access_log.AddLogContext(r, "timeout:%s", key)
+		} else if err != nil {
 			errors = append(errors, DeleteError{
 				Key:     key,
 				Code:    "InternalError",
 				Message: "Failed to delete object",
 			})
-		} else {
+		} else if !deleteRequest.Quiet {
 			deletedObjects = append(deletedObjects, DeletedObject{
 				Key: key,
 			})
@@ -559,12 +1986,96 @@ func (s *server) handleBulkDelete(w http.ResponseWriter, r *http.Request) {
 	xml.NewEncoder(w).Encode(response)
 }
 
+// removeDirectoryMarker deletes the directory marker of the key ending with a slash.
+// Like in S3, deleting the marker leaves the objects under it, so the directory is only
+// removed from the backend and the cache while empty, and kept otherwise
+func (s *server) removeDirectoryMarker(path string) error {
+	infos, err := s.client.ReadDir(path)
+	if err != nil && !fs.IsNotFound(err) {
+		return err
+	}
+	if len(infos) > 0 {
+		return nil
+	}
+
+	if err == nil {
+		if err := s.client.Remove(path); err != nil && !fs.IsNotFound(err) {
+			return err
+		}
+	}
+	_, err = s.db.DeleteDir(path)
+	return err
+}
+
+var errDeleteTimeout = errors.New("delete timeout")
+
+// removeWithRetry removes the path retrying on failures, giving up once the deadline passes.
+// A missing path is treated as removed. The backend remove cannot be cancelled, so the attempt
+// running at the deadline is abandoned, only one per bulk delete as the keys after the deadline
+// are skipped. It may still remove the file after the key was reported as failed, leaving the
+// cache entry for the next GET to evict. unlock releases the write lock of the path held by the
+// caller: on failure it is called once no remove of the path is running, on success it is left
+// to the caller
+func (s *server) removeWithRetry(path string, deadline time.Time, unlock func()) error {
+	var err error
+
+	for attempt := 0; attempt <= s.deleteRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(attempt) * 100 * time.Millisecond
+			if !deadline.IsZero() {
+				backoff = min(backoff, time.Until(deadline))
+			}
+			time.Sleep(backoff)
+		}
+
+		if deadline.IsZero() {
+			err = s.client.Remove(path)
+		} else {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				unlock()
+				return errDeleteTimeout
+			}
+
+			// Stop waiting for the backend once the deadline passes,
+			// the path stays locked until the abandoned remove returns
+			result := make(chan error, 1)
+			go func() { result <- s.client.Remove(path) }()
+
+			select {
+			case err = <-result:
+			case <-time.After(remaining):
+				go func() {
+					<-result
+					unlock()
+				}()
+				return errDeleteTimeout
+			}
+		}
+
+		if err == nil || fs.IsNotFound(err) {
+			return nil
+		}
+	}
+
+	unlock()
+	return err
+}
+
 func (s *server) SetupReadRoutes(r *mux.Router) {
+	r.HandleFunc("/-/stats", s.handleStats).Methods("GET")
+	r.HandleFunc("/-/stats/{bucket}", s.handleStats).Methods("GET")
+	r.HandleFunc("/-/api/list", s.handleAPIList).Methods("GET")
 	r.HandleFunc("/", s.handleListBuckets).Methods("GET")
+	r.HandleFunc("/{bucket}", s.handleGetBucketACL).Methods("GET").Queries("acl", "")
+	r.HandleFunc("/{bucket}/", s.handleGetBucketACL).Methods("GET").Queries("acl", "")
 	r.HandleFunc("/{bucket}", s.handleListObjects).Methods("GET")
 	r.HandleFunc("/{bucket}/", s.handleListObjects).Methods("GET")
 	r.HandleFunc("/{bucket}", s.handleHeadBucket).Methods("HEAD")
 	r.HandleFunc("/{bucket}/", s.handleHeadBucket).Methods("HEAD")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handleGetObjectTagging).Methods("GET").Queries("tagging", "")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handleGetObjectACL).Methods("GET").Queries("acl", "")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handleGetObjectAttributes).Methods("GET").Queries("attributes", "")
 	r.HandleFunc("/{bucket}/{key:.*}", s.handleGetObject).Methods("GET")
 	r.HandleFunc("/{bucket}/{key:.*}", s.handleHeadObject).Methods("HEAD")
 }
@@ -572,6 +2083,20 @@ func (s *server) SetupReadRoutes(r *mux.Router) {
 func (s *server) SetupWriteRoutes(r *mux.Router) {
 	r.HandleFunc("/{bucket}/", s.handleBulkDelete).Methods("POST").Queries("delete", "")
 	r.HandleFunc("/{bucket}", s.handleBulkDelete).Methods("POST").Queries("delete", "")
+	r.HandleFunc("/{bucket}/", s.handleDeletePrefix).Methods("POST").Queries("delete-prefix", "")
+	r.HandleFunc("/{bucket}", s.handleDeletePrefix).Methods("POST").Queries("delete-prefix", "")
+	r.HandleFunc("/{bucket}", s.handlePostObject).Methods("POST")
+	r.HandleFunc("/{bucket}/", s.handlePostObject).Methods("POST")
+	r.HandleFunc("/{bucket}", s.handlePutBucketACL).Methods("PUT").Queries("acl", "")
+	r.HandleFunc("/{bucket}/", s.handlePutBucketACL).Methods("PUT").Queries("acl", "")
+	r.HandleFunc("/{bucket}", s.handleCreateBucket).Methods("PUT")
+	r.HandleFunc("/{bucket}/", s.handleCreateBucket).Methods("PUT")
+	r.HandleFunc("/{bucket}", s.handleDeleteBucket).Methods("DELETE")
+	r.HandleFunc("/{bucket}/", s.handleDeleteBucket).Methods("DELETE")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handlePutObjectTagging).Methods("PUT").Queries("tagging", "")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handlePutObjectACL).Methods("PUT").Queries("acl", "")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handleDeleteObjectTagging).Methods("DELETE").Queries("tagging", "")
+	r.HandleFunc("/{bucket}/{key:.*}", s.handleCopyObject).Methods("PUT").Headers("X-Amz-Copy-Source", "")
 	r.HandleFunc("/{bucket}/{key:.*}", s.handlePutObject).Methods("PUT")
 	r.HandleFunc("/{bucket}/{key:.*}", s.handleDeleteObject).Methods("DELETE")
 }
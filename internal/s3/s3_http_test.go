@@ -1,18 +1,29 @@
 package s3
 
 import (
+	"bufio"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -22,6 +33,7 @@ import (
 
 	"s3-to-webdav/internal/cache"
 	"s3-to-webdav/internal/fs"
+	syncer "s3-to-webdav/internal/sync"
 	"s3-to-webdav/internal/tests"
 )
 
@@ -119,6 +131,41 @@ func TestHandleListBuckets(t *testing.T) {
 	assert.Contains(t, bucketNames, "test-bucket")
 }
 
+func TestHandleListBucketsOwner(t *testing.T) {
+	s, _, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name        string
+		accessKey   string
+		displayName string
+	}{
+		{"authenticated", "alice", "alice"},
+		{"authentication disabled", "", "anonymous"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accessKey != "" {
+				req = req.WithContext(context.WithValue(req.Context(), accessKeyKey{}, tt.accessKey))
+			}
+			w := httptest.NewRecorder()
+
+			s.handleListBuckets(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketsResult
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			require.NotNil(t, result.Owner)
+			assert.Equal(t, tt.displayName, result.Owner.DisplayName)
+
+			sum := sha256.Sum256([]byte(tt.displayName))
+			assert.Equal(t, hex.EncodeToString(sum[:]), result.Owner.ID)
+		})
+	}
+}
+
 func TestHandleHeadBucket(t *testing.T) {
 	s, _, _, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -145,6 +192,52 @@ func TestHandleHeadBucket(t *testing.T) {
 	}
 }
 
+func TestHandleHeadBucketHeaders(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/dir/", IsDir: true, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/dir/a.txt", Size: 10, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/b.txt", Size: 5, Processed: true},
+	))
+
+	head := func(bucket string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/"+bucket, nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": bucket})
+		w := httptest.NewRecorder()
+		s.handleHeadBucket(w, req)
+		return w
+	}
+
+	t.Run("default region", func(t *testing.T) {
+		w := head("test-bucket")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "us-east-1", w.Header().Get("X-Amz-Bucket-Region"))
+		assert.Empty(t, w.Header().Get("X-Amz-Object-Count"))
+		assert.Empty(t, w.Header().Get("X-Amz-Bucket-Size"))
+	})
+
+	t.Run("configured region and stats", func(t *testing.T) {
+		s.SetRegion("eu-central-1")
+		s.SetBucketStatsHeaders(true)
+		defer s.SetRegion(DefaultRegion)
+		defer s.SetBucketStatsHeaders(false)
+
+		w := head("test-bucket")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "eu-central-1", w.Header().Get("X-Amz-Bucket-Region"))
+		assert.Equal(t, "3", w.Header().Get("X-Amz-Object-Count"))
+		assert.Equal(t, "15", w.Header().Get("X-Amz-Bucket-Size"))
+	})
+
+	t.Run("unknown bucket", func(t *testing.T) {
+		w := head("forbidden")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get("X-Amz-Bucket-Region"))
+	})
+}
+
 func TestHandleHeadObject(t *testing.T) {
 	s, db, _, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -308,6 +401,237 @@ func TestHandleGetObject(t *testing.T) {
 	}
 }
 
+func TestTimeFormats(t *testing.T) {
+	// Times must not depend on the time zone of the server
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	defer func() { time.Local = local }()
+
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	modTime := time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)
+	webdav.AddFile("/test-bucket/file.txt", []byte("content"))
+	webdav.AddFile("/test-bucket/source.txt", []byte("content"))
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/file.txt", Size: 7, LastModified: modTime.Unix(), Processed: true},
+		fs.EntryInfo{Path: "test-bucket/source.txt", Size: 7, LastModified: modTime.Unix(), Processed: true},
+	))
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	xmlTime := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z$`)
+
+	t.Run("Headers", func(t *testing.T) {
+		for _, method := range []string{"GET", "HEAD"} {
+			w := serve(method, "/test-bucket/file.txt", nil)
+			require.Equal(t, http.StatusOK, w.Code, method)
+			assert.Equal(t, "Fri, 01 Mar 2024 12:30:45 GMT", w.Header().Get("Last-Modified"), method)
+		}
+	})
+
+	t.Run("ListObjects", func(t *testing.T) {
+		for _, query := range []string{"", "?list-type=2"} {
+			w := serve("GET", "/test-bucket"+query, nil)
+			require.Equal(t, http.StatusOK, w.Code)
+			var result ListBucketResultV2
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			require.NotEmpty(t, result.Contents)
+			assert.Equal(t, "2024-03-01T12:30:45.000Z", result.Contents[0].LastModified, query)
+		}
+	})
+
+	t.Run("ListBuckets", func(t *testing.T) {
+		w := serve("GET", "/", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var result ListBucketsResult
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		require.NotEmpty(t, result.Buckets.Bucket)
+		assert.Regexp(t, xmlTime, result.Buckets.Bucket[0].CreationDate)
+	})
+
+	t.Run("CopyObject", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/copy.txt", map[string]string{"X-Amz-Copy-Source": "test-bucket/source.txt"})
+		require.Equal(t, http.StatusOK, w.Code)
+		var result CopyObjectResult
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		assert.Regexp(t, xmlTime, result.LastModified)
+	})
+}
+
+func TestHandleGetObjectEvictsMissing(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	get := func(key string) int {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		s.handleGetObject(w, req)
+		return w.Code
+	}
+
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/removed.txt", Size: 7, LastModified: time.Now().Unix(), Processed: true},
+		fs.EntryInfo{Path: "test-bucket/failing.txt", Size: 7, LastModified: time.Now().Unix(), Processed: true},
+	))
+	webdav.AddFile("/test-bucket/failing.txt", []byte("content"))
+
+	t.Run("removed on backend", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("removed.txt"))
+
+		_, err := db.Stat("test-bucket/removed.txt")
+		assert.Error(t, err, "Phantom entry should be evicted")
+
+		// The next miss is answered from the cache
+		requests := webdav.RequestCount()
+		assert.Equal(t, http.StatusNotFound, get("removed.txt"))
+		assert.Equal(t, requests, webdav.RequestCount())
+	})
+
+	t.Run("backend failure", func(t *testing.T) {
+		webdav.FailRequests(1, http.StatusInternalServerError)
+		assert.Equal(t, http.StatusNotFound, get("failing.txt"))
+
+		_, err := db.Stat("test-bucket/failing.txt")
+		assert.NoError(t, err, "Entry should be kept when the backend fails")
+	})
+}
+
+func TestHandleGetObjectReadThrough(t *testing.T) {
+	for _, readThrough := range []bool{false, true} {
+		t.Run(fmt.Sprintf("read-through=%v", readThrough), func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+			s.SetReadThrough(readThrough)
+
+			serve := func(method, key string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, "/test-bucket/"+key, nil)
+				req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+				w := httptest.NewRecorder()
+				if method == "HEAD" {
+					s.handleHeadObject(w, req)
+				} else {
+					s.handleGetObject(w, req)
+				}
+				return w
+			}
+
+			webdav.AddFile("/test-bucket/dir/backend-only.txt", []byte("backend only"))
+			webdav.AddFile("/test-bucket/head-only.txt", []byte("head"))
+
+			w := serve("GET", "dir/backend-only.txt")
+			_, err := db.Stat("test-bucket/dir/backend-only.txt")
+			if !readThrough {
+				assert.Equal(t, http.StatusNotFound, w.Code)
+				assert.Error(t, err, "Object should not be cached")
+				assert.Equal(t, http.StatusNotFound, serve("HEAD", "head-only.txt").Code)
+				return
+			}
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "backend only", w.Body.String())
+			require.NoError(t, err, "Object should be cached")
+
+			// The directory holding it is left for the sync to scan
+			dir, err := db.Stat("test-bucket/dir/")
+			require.NoError(t, err)
+			assert.False(t, dir.Processed)
+
+			w = serve("HEAD", "head-only.txt")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "4", w.Header().Get("Content-Length"))
+			entry, err := db.Stat("test-bucket/head-only.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(4), entry.Size)
+
+			// The cached object is served without asking the backend for its metadata
+			requests := webdav.RequestCount()
+			assert.Equal(t, http.StatusOK, serve("HEAD", "dir/backend-only.txt").Code)
+			assert.Equal(t, requests, webdav.RequestCount())
+
+			// Missing on both, and directories, are still not found
+			assert.Equal(t, http.StatusNotFound, serve("GET", "missing.txt").Code)
+			assert.Equal(t, http.StatusNotFound, serve("GET", "dir").Code)
+		})
+	}
+}
+
+func TestHandleHeadObjectValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		validate     bool
+		after        time.Duration
+		expectedSize string
+	}{
+		{"disabled serves the cached size", false, 0, "3"},
+		{"fresh entry serves the cached size", true, time.Hour, "3"},
+		{"stale entry is validated", true, 0, "11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+			s.SetHeadValidate(tt.validate, tt.after)
+
+			head := func(key string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("HEAD", "/test-bucket/"+key, nil)
+				req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+				w := httptest.NewRecorder()
+				s.handleHeadObject(w, req)
+				return w
+			}
+
+			// The file was rewritten on the backend out-of-band
+			require.NoError(t, db.Insert(
+				fs.EntryInfo{Path: "test-bucket/changed.txt", Size: 3, LastModified: 1000, Checksum: "SHA256:old", Processed: true},
+				fs.EntryInfo{Path: "test-bucket/removed.txt", Size: 3, LastModified: 1000, Processed: true},
+			))
+			webdav.AddFile("/test-bucket/changed.txt", []byte("new content"))
+
+			w := head("changed.txt")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectedSize, w.Header().Get("Content-Length"))
+
+			entry, err := db.Stat("test-bucket/changed.txt")
+			require.NoError(t, err)
+			assert.Equal(t, w.Header().Get("Content-Length"), fmt.Sprint(entry.Size), "Cache should match the response")
+
+			w = head("removed.txt")
+			_, err = db.Stat("test-bucket/removed.txt")
+			if tt.expectedSize == "3" {
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Equal(t, "", entry.Checksum, "Checksum of the previous content should be dropped")
+			assert.NotEqual(t, int64(1000), entry.LastModified)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Error(t, err, "Object removed on the backend should be evicted")
+
+			// The backend failing serves the cached entry
+			webdav.FailRequests(1, http.StatusInternalServerError)
+			w = head("changed.txt")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "11", w.Header().Get("Content-Length"))
+		})
+	}
+}
+
 func TestHandlePutObject(t *testing.T) {
 	s, db, webdav, cleanup := setupTestServer(t)
 	defer cleanup()
@@ -319,6 +643,7 @@ func TestHandlePutObject(t *testing.T) {
 		content              string
 		contentLength        string
 		sha256Header         string
+		md5Header            string
 		expectedStatus       int
 		expectedResponseBody string
 		checkStat            bool
@@ -357,6 +682,90 @@ func TestHandlePutObject(t *testing.T) {
 			expectedStatus:       http.StatusBadRequest,
 			expectedResponseBody: "BadDigest",
 		},
+		{
+			name:          "put with valid MD5 verification",
+			bucket:        "test-bucket",
+			key:           "put-md5.txt",
+			content:       "test content",
+			contentLength: "12",
+			md5Header: func() string {
+				h := md5.Sum([]byte("test content"))
+				return base64.StdEncoding.EncodeToString(h[:])
+			}(),
+			expectedStatus: http.StatusOK,
+			checkStat:      true,
+		},
+		{
+			name:          "put with invalid MD5 hash",
+			bucket:        "test-bucket",
+			key:           "put-invalid-md5.txt",
+			content:       "test content",
+			contentLength: "12",
+			md5Header: func() string {
+				h := md5.Sum([]byte("other content"))
+				return base64.StdEncoding.EncodeToString(h[:])
+			}(),
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: "BadDigest",
+		},
+		{
+			name:                 "put with malformed MD5 header",
+			bucket:               "test-bucket",
+			key:                  "put-malformed-md5.txt",
+			content:              "test content",
+			contentLength:        "12",
+			md5Header:            "not-base64-md5",
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: "InvalidDigest",
+		},
+		{
+			name:          "put with valid SHA256 and MD5 verification",
+			bucket:        "test-bucket",
+			key:           "put-sha256-md5.txt",
+			content:       "test content",
+			contentLength: "12",
+			sha256Header: func() string {
+				h := sha256.Sum256([]byte("test content"))
+				return hex.EncodeToString(h[:])
+			}(),
+			md5Header: func() string {
+				h := md5.Sum([]byte("test content"))
+				return base64.StdEncoding.EncodeToString(h[:])
+			}(),
+			expectedStatus: http.StatusOK,
+			checkStat:      true,
+		},
+		{
+			name:          "put with valid SHA256 and invalid MD5",
+			bucket:        "test-bucket",
+			key:           "put-sha256-bad-md5.txt",
+			content:       "test content",
+			contentLength: "12",
+			sha256Header: func() string {
+				h := sha256.Sum256([]byte("test content"))
+				return hex.EncodeToString(h[:])
+			}(),
+			md5Header: func() string {
+				h := md5.Sum([]byte("other content"))
+				return base64.StdEncoding.EncodeToString(h[:])
+			}(),
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: "Content-MD5",
+		},
+		{
+			name:          "put with invalid SHA256 and valid MD5",
+			bucket:        "test-bucket",
+			key:           "put-bad-sha256-md5.txt",
+			content:       "test content",
+			contentLength: "12",
+			sha256Header:  "invalid-sha256-hash",
+			md5Header: func() string {
+				h := md5.Sum([]byte("test content"))
+				return base64.StdEncoding.EncodeToString(h[:])
+			}(),
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: "Content-SHA256",
+		},
 		{
 			name:           "put with truncated content",
 			bucket:         "test-bucket",
@@ -366,12 +775,14 @@ func TestHandlePutObject(t *testing.T) {
 			expectedStatus: http.StatusOK,
 		},
 		{
+			// Sent with unknown length, like a chunked upload, and stored as received
 			name:           "put with content too long",
 			bucket:         "test-bucket",
 			key:            "put-toolong.txt",
 			content:        "this content is much longer than expected",
 			contentLength:  "5",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusOK,
+			checkStat:      true,
 		},
 		{
 			name:           "forbidden bucket",
@@ -423,6 +834,9 @@ func TestHandlePutObject(t *testing.T) {
 			if tt.sha256Header != "" {
 				req.Header.Set("X-Amz-Content-Sha256", tt.sha256Header)
 			}
+			if tt.md5Header != "" {
+				req.Header.Set("Content-MD5", tt.md5Header)
+			}
 			req = mux.SetURLVars(req, map[string]string{
 				"bucket": tt.bucket,
 				"key":    tt.key,
@@ -730,6 +1144,34 @@ func TestHandleListObjects(t *testing.T) {
 			expectedMaxKeys:     1,
 			expectedIsTruncated: true,
 		},
+		{
+			name:                "list with max-keys=0",
+			bucket:              "test-bucket",
+			params:              map[string]string{"max-keys": "0"},
+			expectedStatus:      http.StatusOK,
+			expectedCount:       0,
+			expectedIsTruncated: true,
+		},
+		{
+			name:            "list with max-keys above the cap",
+			bucket:          "test-bucket",
+			params:          map[string]string{"max-keys": "5000"},
+			expectedStatus:  http.StatusOK,
+			expectedCount:   3,
+			expectedMaxKeys: 1000,
+		},
+		{
+			name:           "list with non-numeric max-keys",
+			bucket:         "test-bucket",
+			params:         map[string]string{"max-keys": "abc"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "list with negative max-keys",
+			bucket:         "test-bucket",
+			params:         map[string]string{"max-keys": "-1"},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:              "list with delimiter",
 			bucket:            "test-bucket",
@@ -746,6 +1188,22 @@ func TestHandleListObjects(t *testing.T) {
 			expectedCount:  2,
 			expectedMarker: "file1.txt",
 		},
+		{
+			name:           "list with partial file name prefix",
+			bucket:         "test-bucket",
+			params:         map[string]string{"prefix": "prefix/fi"},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+			checkPrefix:    "prefix/fi",
+		},
+		{
+			name:           "list with partial directory name prefix",
+			bucket:         "test-bucket",
+			params:         map[string]string{"prefix": "pre"},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			checkPrefix:    "pre",
+		},
 		{
 			name:              "list with delimiter and prefix",
 			bucket:            "test-bucket",
@@ -765,7 +1223,7 @@ func TestHandleListObjects(t *testing.T) {
 		{
 			name:           "list objects v2 with continuation-token",
 			bucket:         "test-bucket",
-			params:         map[string]string{"list-type": "2", "continuation-token": "test-bucket/file1.txt"},
+			params:         map[string]string{"list-type": "2", "continuation-token": s.encodeContinuationToken("test-bucket/file1.txt")},
 			expectedStatus: http.StatusOK,
 			expectedCount:  2,
 			expectedMarker: "file1.txt",
@@ -778,6 +1236,18 @@ func TestHandleListObjects(t *testing.T) {
 			expectedCount:  2,
 			expectedMarker: "file1.txt",
 		},
+		{
+			name:           "list objects v2 with raw key as continuation-token",
+			bucket:         "test-bucket",
+			params:         map[string]string{"list-type": "2", "continuation-token": "test-bucket/file1.txt"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "list objects v2 with continuation-token of other bucket",
+			bucket:         "test-bucket",
+			params:         map[string]string{"list-type": "2", "continuation-token": s.encodeContinuationToken("bucket2/file1.txt")},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "forbidden bucket",
 			bucket:         "forbidden",
@@ -893,6 +1363,9 @@ func TestListAll(t *testing.T) {
 					assert.Equal(t, "test-bucket", result.Name)
 					marker = result.NextContinuationToken
 					count += len(result.Contents)
+					for _, file := range testFiles {
+						assert.NotContains(t, marker, strings.TrimPrefix(file.Path, "test-bucket/"), "Token should be opaque")
+					}
 					if !result.IsTruncated {
 						require.Equal(t, len(testFiles), count, "Should have listed all files")
 						return
@@ -915,3 +1388,4562 @@ func TestListAll(t *testing.T) {
 		})
 	}
 }
+
+func TestListAllDelimited(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	now := time.Now().Unix()
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a.txt", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b/c.txt", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b/d/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b/d/e.txt", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b/f/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b/f/g.txt", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/h/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/h/i.txt", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/j.txt", Size: 1, LastModified: now},
+	))
+
+	expectedKeys := []string{"a.txt", "b/c.txt", "b/d/e.txt", "b/f/g.txt", "h/i.txt", "j.txt"}
+	expectedPrefixes := []string{"b/", "b/d/", "b/f/", "h/"}
+
+	// page lists a single page of the prefix, returning the keys, prefixes and the next marker
+	pages := map[int]func(t *testing.T, prefix, marker string) ([]string, []string, string){
+		1: func(t *testing.T, prefix, marker string) ([]string, []string, string) {
+			query := url.Values{"prefix": {prefix}, "delimiter": {"/"}, "max-keys": {"1"}, "marker": {marker}}
+			w := httptest.NewRecorder()
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/test-bucket?"+query.Encode(), nil), map[string]string{"bucket": "test-bucket"})
+			s.handleListObjects(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketResult
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			keys, prefixes := listedNames(result.Contents, result.CommonPrefixes)
+			assert.LessOrEqual(t, len(keys)+len(prefixes), 1)
+			if result.IsTruncated {
+				require.NotEmpty(t, result.NextMarker)
+				return keys, prefixes, result.NextMarker
+			}
+			return keys, prefixes, ""
+		},
+		2: func(t *testing.T, prefix, marker string) ([]string, []string, string) {
+			query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}, "max-keys": {"1"}}
+			if marker != "" {
+				query.Set("continuation-token", marker)
+			}
+			w := httptest.NewRecorder()
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/test-bucket?"+query.Encode(), nil), map[string]string{"bucket": "test-bucket"})
+			s.handleListObjects(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketResultV2
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			keys, prefixes := listedNames(result.Contents, result.CommonPrefixes)
+			assert.Equal(t, len(keys)+len(prefixes), result.KeyCount)
+			assert.LessOrEqual(t, result.KeyCount, 1)
+			if result.IsTruncated {
+				require.NotEmpty(t, result.NextContinuationToken)
+				return keys, prefixes, result.NextContinuationToken
+			}
+			return keys, prefixes, ""
+		},
+	}
+
+	for listType, page := range pages {
+		t.Run(fmt.Sprintf("Type%d", listType), func(t *testing.T) {
+			var keys, prefixes []string
+			queue := []string{""}
+
+			for requests := 0; len(queue) > 0; {
+				prefix := queue[0]
+				queue = queue[1:]
+
+				marker := ""
+				for {
+					requests++
+					require.Less(t, requests, 100, "Listing did not terminate")
+
+					pageKeys, pagePrefixes, next := page(t, prefix, marker)
+					keys = append(keys, pageKeys...)
+					prefixes = append(prefixes, pagePrefixes...)
+					queue = append(queue, pagePrefixes...)
+					if next == "" {
+						break
+					}
+					marker = next
+				}
+			}
+
+			assert.ElementsMatch(t, expectedKeys, keys, "Every key should be listed exactly once")
+			assert.ElementsMatch(t, expectedPrefixes, prefixes, "Every prefix should be listed exactly once")
+		})
+	}
+}
+
+// TestListPrefixMatchingKey checks a prefix is matched as a plain string like S3: a prefix
+// equal to a key also returns the keys starting with it, while a prefix ending in the
+// delimiter only returns the keys under that directory
+func TestListPrefixMatchingKey(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	now := time.Now().Unix()
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a/file", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a/file2", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a/files/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a/files/one", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/a/files/two", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/b", Size: 1, LastModified: now},
+	))
+
+	tests := []struct {
+		prefix           string
+		delimiter        string
+		expectedKeys     []string
+		expectedPrefixes []string
+	}{
+		{prefix: "a/file", expectedKeys: []string{"a/file", "a/file2", "a/files/one", "a/files/two"}},
+		{prefix: "a/file", delimiter: "/", expectedKeys: []string{"a/file", "a/file2"}, expectedPrefixes: []string{"a/files/"}},
+		{prefix: "a/file2", expectedKeys: []string{"a/file2"}},
+		{prefix: "a/file2", delimiter: "/", expectedKeys: []string{"a/file2"}},
+		{prefix: "a/file/"},
+		{prefix: "a/file/", delimiter: "/"},
+		{prefix: "a/files", delimiter: "/", expectedPrefixes: []string{"a/files/"}},
+		{prefix: "a/files/", expectedKeys: []string{"a/files/one", "a/files/two"}},
+		{prefix: "a/files/", delimiter: "/", expectedKeys: []string{"a/files/one", "a/files/two"}},
+		{prefix: "b", expectedKeys: []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"+"+tt.delimiter, func(t *testing.T) {
+			query := url.Values{"list-type": {"2"}, "prefix": {tt.prefix}, "delimiter": {tt.delimiter}}
+			w := httptest.NewRecorder()
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/test-bucket?"+query.Encode(), nil), map[string]string{"bucket": "test-bucket"})
+			s.handleListObjects(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketResultV2
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			keys, prefixes := listedNames(result.Contents, result.CommonPrefixes)
+			assert.Equal(t, tt.expectedKeys, keys)
+			assert.Equal(t, tt.expectedPrefixes, prefixes)
+			assert.False(t, result.IsTruncated)
+		})
+	}
+}
+
+func listedNames(contents []Object, commonPrefixes []CommonPrefix) ([]string, []string) {
+	var keys, prefixes []string
+	for _, object := range contents {
+		keys = append(keys, object.Key)
+	}
+	for _, prefix := range commonPrefixes {
+		prefixes = append(prefixes, prefix.Prefix)
+	}
+	return keys, prefixes
+}
+
+// TestListDelimiter checks delimiters other than / group the keys on their first
+// occurrence after the prefix, regardless of the directories
+func TestListDelimiter(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	now := time.Now().Unix()
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/2024-01-01_report", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/2024-01-01_summary", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/2024-01-02_report", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/dir/", IsDir: true, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/dir/a_b", Size: 1, LastModified: now},
+		fs.EntryInfo{Path: "test-bucket/readme", Size: 1, LastModified: now},
+	))
+
+	list := func(t *testing.T, query url.Values) ListBucketResultV2 {
+		query.Set("list-type", "2")
+		w := httptest.NewRecorder()
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/test-bucket?"+query.Encode(), nil), map[string]string{"bucket": "test-bucket"})
+		s.handleListObjects(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result ListBucketResultV2
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	tests := []struct {
+		prefix           string
+		delimiter        string
+		expectedKeys     []string
+		expectedPrefixes []string
+	}{
+		{delimiter: "_", expectedKeys: []string{"readme"}, expectedPrefixes: []string{"2024-01-01_", "2024-01-02_", "dir/a_"}},
+		{prefix: "2024-01-01", delimiter: "_", expectedPrefixes: []string{"2024-01-01_"}},
+		{prefix: "2024-01-01_", delimiter: "_", expectedKeys: []string{"2024-01-01_report", "2024-01-01_summary"}},
+		{delimiter: "-", expectedKeys: []string{"dir/a_b", "readme"}, expectedPrefixes: []string{"2024-"}},
+		{prefix: "2024-", delimiter: "-", expectedPrefixes: []string{"2024-01-"}},
+		{delimiter: "01", expectedKeys: []string{"dir/a_b", "readme"}, expectedPrefixes: []string{"2024-01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"+"+tt.delimiter, func(t *testing.T) {
+			result := list(t, url.Values{"prefix": {tt.prefix}, "delimiter": {tt.delimiter}})
+			keys, prefixes := listedNames(result.Contents, result.CommonPrefixes)
+			assert.Equal(t, tt.expectedKeys, keys)
+			assert.Equal(t, tt.expectedPrefixes, prefixes)
+			assert.Equal(t, tt.delimiter, result.Delimiter)
+			assert.False(t, result.IsTruncated)
+		})
+	}
+
+	t.Run("Pagination", func(t *testing.T) {
+		var visited []string
+		token := ""
+		for page := 0; page < 10; page++ {
+			query := url.Values{"delimiter": {"_"}, "max-keys": {"1"}}
+			if token != "" {
+				query.Set("continuation-token", token)
+			}
+			result := list(t, query)
+			keys, prefixes := listedNames(result.Contents, result.CommonPrefixes)
+			visited = append(append(visited, prefixes...), keys...)
+			assert.Equal(t, 1, result.KeyCount)
+			if !result.IsTruncated {
+				break
+			}
+			token = result.NextContinuationToken
+			require.NotEmpty(t, token)
+		}
+		assert.Equal(t, []string{"2024-01-01_", "2024-01-02_", "dir/a_", "readme"}, visited)
+	})
+
+	t.Run("Small pages", func(t *testing.T) {
+		paths := []string{"b/a_1", "b/a_2", "b/a_3", "b/b", "b/c_1", "b/c_2", "b/d"}
+		var markers []string
+		// Returns two paths at a time, however many are asked for
+		page := func(marker string, limit int) ([]fs.EntryInfo, bool, error) {
+			markers = append(markers, marker)
+			var files []fs.EntryInfo
+			for _, path := range paths {
+				if path > marker {
+					files = append(files, fs.EntryInfo{Path: path})
+				}
+			}
+			if len(files) > 2 {
+				return files[:2], true, nil
+			}
+			return files, false, nil
+		}
+
+		files, truncated, err := listDelimited(page, "b/", "", "_", 10)
+		require.NoError(t, err)
+		assert.False(t, truncated)
+		assert.Equal(t, []fs.EntryInfo{{Path: "b/a_", IsDir: true}, {Path: "b/b"}, {Path: "b/c_", IsDir: true}, {Path: "b/d"}}, files)
+		// The rest of a common prefix is skipped instead of read
+		assert.Equal(t, []string{"", "b/a_\xFF", "b/c_\xFF"}, markers)
+
+		markers = nil
+		files, truncated, err = listDelimited(page, "b/", "b/a_", "_", 2)
+		require.NoError(t, err)
+		assert.True(t, truncated)
+		assert.Equal(t, []fs.EntryInfo{{Path: "b/b"}, {Path: "b/c_", IsDir: true}}, files)
+		assert.Equal(t, "b/a_\xFF", markers[0])
+	})
+}
+
+func TestAlias(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	put := func(key, content string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(content))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		s.handlePutObject(w, req)
+		return w
+	}
+
+	get := func(method, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test-bucket/"+key, nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		if method == "HEAD" {
+			s.handleHeadObject(w, req)
+		} else {
+			s.handleGetObject(w, req)
+		}
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, put("2024-01-01.json", "old content", nil).Code)
+	require.Equal(t, http.StatusOK, put("2024-01-02.json", "newest content", nil).Code)
+
+	t.Run("create alias", func(t *testing.T) {
+		w := put("latest.json", "", map[string]string{"X-Amz-Meta-Alias-Target": "2024-01-01.json"})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		target, err := db.GetAlias("test-bucket/latest.json")
+		require.NoError(t, err)
+		assert.Equal(t, "test-bucket/2024-01-01.json", target)
+	})
+
+	t.Run("update alias", func(t *testing.T) {
+		w := put("latest.json", "", map[string]string{"X-Amz-Meta-Alias-Target": "2024-01-02.json"})
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("head resolves alias", func(t *testing.T) {
+		alias := get("HEAD", "latest.json")
+		target := get("HEAD", "2024-01-02.json")
+		require.Equal(t, http.StatusOK, alias.Code)
+		assert.Equal(t, target.Header().Get("ETag"), alias.Header().Get("ETag"))
+		assert.Equal(t, "14", alias.Header().Get("Content-Length"))
+	})
+
+	t.Run("get resolves alias", func(t *testing.T) {
+		w := get("GET", "latest.json")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "newest content", w.Body.String())
+	})
+
+	t.Run("listing shows target size and etag", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket", nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+		w := httptest.NewRecorder()
+		s.handleListObjects(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result ListBucketResult
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+
+		var found *Object
+		for i := range result.Contents {
+			if result.Contents[i].Key == "latest.json" {
+				found = &result.Contents[i]
+			}
+		}
+		require.NotNil(t, found)
+		assert.Equal(t, int64(14), found.Size)
+		assert.Equal(t, get("HEAD", "2024-01-02.json").Header().Get("ETag"), found.ETag)
+	})
+
+	t.Run("alias to missing target", func(t *testing.T) {
+		w := put("broken.json", "", map[string]string{"X-Amz-Meta-Alias-Target": "missing.json"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("alias with content", func(t *testing.T) {
+		w := put("broken.json", "data", map[string]string{"X-Amz-Meta-Alias-Target": "2024-01-01.json"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("alias to itself", func(t *testing.T) {
+		w := put("2024-01-01.json", "", map[string]string{"X-Amz-Meta-Alias-Target": "2024-01-01.json"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "old content", get("GET", "2024-01-01.json").Body.String(), "Object should keep its content")
+	})
+
+	t.Run("alias chain", func(t *testing.T) {
+		w := put("2024-01-02.json", "", map[string]string{"X-Amz-Meta-Alias-Target": "2024-01-01.json"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Object is the target of an alias")
+		assert.Equal(t, "newest content", get("GET", "latest.json").Body.String())
+	})
+
+	t.Run("write to alias is rejected", func(t *testing.T) {
+		w := put("latest.json", "overwrite", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("write to alias is redirected", func(t *testing.T) {
+		s.SetAliasWrites(AliasWritesRedirect)
+		defer s.SetAliasWrites(AliasWritesReject)
+
+		w := put("latest.json", "redirected", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "redirected", get("GET", "2024-01-02.json").Body.String())
+		assert.Equal(t, "redirected", get("GET", "latest.json").Body.String())
+	})
+}
+
+func TestContinuationToken(t *testing.T) {
+	s, _, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	t.Run("unsigned round-trip", func(t *testing.T) {
+		token := s.encodeContinuationToken("test-bucket/some key+with/chars?&")
+		marker, err := s.decodeContinuationToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, "test-bucket/some key+with/chars?&", marker)
+	})
+
+	t.Run("empty marker has no token", func(t *testing.T) {
+		assert.Empty(t, s.encodeContinuationToken(""))
+	})
+
+	t.Run("signed round-trip", func(t *testing.T) {
+		s.SetContinuationTokenKey("secret")
+		defer s.SetContinuationTokenKey("")
+
+		token := s.encodeContinuationToken("test-bucket/file1.txt")
+		marker, err := s.decodeContinuationToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, "test-bucket/file1.txt", marker)
+
+		s.SetContinuationTokenKey("other-secret")
+		_, err = s.decodeContinuationToken(token)
+		assert.ErrorIs(t, err, ErrInvalidContinuationToken)
+	})
+
+	t.Run("unsigned token rejected when signing", func(t *testing.T) {
+		token := s.encodeContinuationToken("test-bucket/file1.txt")
+
+		s.SetContinuationTokenKey("secret")
+		defer s.SetContinuationTokenKey("")
+
+		_, err := s.decodeContinuationToken(token)
+		assert.ErrorIs(t, err, ErrInvalidContinuationToken)
+	})
+
+	t.Run("garbage token", func(t *testing.T) {
+		_, err := s.decodeContinuationToken("!!not-base64!!")
+		assert.ErrorIs(t, err, ErrInvalidContinuationToken)
+	})
+}
+
+func TestHandlePutObjectContentTypes(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s.SetAllowedContentTypes("test-bucket", []string{"image/*", "video/mp4"})
+
+	pngHeader := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+
+	tests := []struct {
+		name           string
+		bucket         string
+		contentType    string
+		content        string
+		expectedStatus int
+	}{
+		{"allowed wildcard type", "test-bucket", "image/jpeg", "jpeg", http.StatusOK},
+		{"allowed exact type with params", "test-bucket", "video/mp4; codecs=avc1", "mp4", http.StatusOK},
+		{"allowed sniffed type", "test-bucket", "", pngHeader, http.StatusOK},
+		{"rejected type", "test-bucket", "application/x-sh", "#!/bin/sh", http.StatusForbidden},
+		{"rejected sniffed type", "test-bucket", "", "plain text", http.StatusForbidden},
+		{"rejected invalid type", "test-bucket", "image", "data", http.StatusForbidden},
+		{"unrestricted bucket", "bucket2", "application/x-sh", "#!/bin/sh", http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := fmt.Sprintf("file-%d", i)
+			req := httptest.NewRequest("PUT", "/"+tt.bucket+"/"+key, strings.NewReader(tt.content))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			req = mux.SetURLVars(req, map[string]string{"bucket": tt.bucket, "key": key})
+			w := httptest.NewRecorder()
+
+			s.handlePutObject(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			entry, err := db.Stat(tt.bucket + "/" + key)
+			if tt.expectedStatus == http.StatusOK {
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(tt.content)), entry.Size, "Sniffing should not consume the body")
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestS3ErrorResponses(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	err := db.Insert(fs.EntryInfo{
+		Path:         "test-bucket/cache-only.txt",
+		Size:         10,
+		LastModified: time.Now().Unix(),
+		Processed:    true,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		vars           map[string]string
+		body           string
+		handler        func(w http.ResponseWriter, r *http.Request)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"list unknown bucket", "GET", "/forbidden", map[string]string{"bucket": "forbidden"}, "", s.handleListObjects, http.StatusNotFound, "NoSuchBucket"},
+		{"head unknown bucket", "HEAD", "/forbidden", map[string]string{"bucket": "forbidden"}, "", s.handleHeadBucket, http.StatusNotFound, "NoSuchBucket"},
+		{"get missing key", "GET", "/test-bucket/missing.txt", map[string]string{"bucket": "test-bucket", "key": "missing.txt"}, "", s.handleGetObject, http.StatusNotFound, "NoSuchKey"},
+		{"get key missing on backend", "GET", "/test-bucket/cache-only.txt", map[string]string{"bucket": "test-bucket", "key": "cache-only.txt"}, "", s.handleGetObject, http.StatusNotFound, "NoSuchKey"},
+		{"put unknown bucket", "PUT", "/forbidden/file.txt", map[string]string{"bucket": "forbidden", "key": "file.txt"}, "data", s.handlePutObject, http.StatusNotFound, "NoSuchBucket"},
+		{"delete unknown bucket", "DELETE", "/forbidden/file.txt", map[string]string{"bucket": "forbidden", "key": "file.txt"}, "", s.handleDeleteObject, http.StatusNotFound, "NoSuchBucket"},
+		{"bulk delete malformed xml", "POST", "/test-bucket?delete", map[string]string{"bucket": "test-bucket"}, "<Delete>", s.handleBulkDelete, http.StatusBadRequest, "MalformedXML"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, tt.vars)
+			w := httptest.NewRecorder()
+
+			tt.handler(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "application/xml", w.Header().Get("Content-Type"))
+
+			var result ErrorResponse
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, tt.expectedCode, result.Code)
+			assert.NotEmpty(t, result.Message)
+			assert.Equal(t, req.URL.Path, result.Resource)
+			assert.NotEmpty(t, result.RequestId)
+		})
+	}
+}
+
+// slowRemoveFs delays or fails Remove for selected paths
+type slowRemoveFs struct {
+	fs.Fs
+	mu       sync.Mutex
+	delays   map[string]time.Duration
+	failures map[string]int
+	attempts map[string]int
+}
+
+func (f *slowRemoveFs) Remove(path string) error {
+	f.mu.Lock()
+	f.attempts[path]++
+	delay := f.delays[path]
+	fail := f.failures[path] > 0
+	if fail {
+		f.failures[path]--
+	}
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+	if fail {
+		return fmt.Errorf("transient failure")
+	}
+	return f.Fs.Remove(path)
+}
+
+func TestHandleBulkDeleteBudget(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, key := range []string{"fast1.txt", "flaky.txt", "slow.txt", "fast2.txt"} {
+		webdav.AddFile("/test-bucket/"+key, []byte("content"))
+		require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/" + key, Size: 7, LastModified: time.Now().Unix(), Processed: true}))
+	}
+
+	backend := &slowRemoveFs{
+		Fs:       s.client,
+		delays:   map[string]time.Duration{"test-bucket/slow.txt": 2 * time.Second},
+		failures: map[string]int{"test-bucket/flaky.txt": 1},
+		attempts: map[string]int{},
+	}
+	s.client = backend
+	s.SetBulkDeleteBudget(2, 500*time.Millisecond)
+
+	deleteXML := "<Delete>" +
+		"<Object><Key>fast1.txt</Key></Object>" +
+		"<Object><Key>flaky.txt</Key></Object>" +
+		"<Object><Key>slow.txt</Key></Object>" +
+		"<Object><Key>fast2.txt</Key></Object>" +
+		"</Delete>"
+
+	req := httptest.NewRequest("POST", "/test-bucket/?delete", strings.NewReader(deleteXML))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	s.handleBulkDelete(w, req)
+	assert.Less(t, time.Since(start), 2*time.Second, "Slow key should not hang the request")
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result DeleteResult
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+
+	deleted := make([]string, 0, len(result.Deleted))
+	for _, obj := range result.Deleted {
+		deleted = append(deleted, obj.Key)
+	}
+	assert.ElementsMatch(t, []string{"fast1.txt", "flaky.txt"}, deleted)
+	backend.mu.Lock()
+	assert.Equal(t, 2, backend.attempts["test-bucket/flaky.txt"], "Flaky key should be retried")
+	backend.mu.Unlock()
+
+	require.Len(t, result.Errors, 2)
+	for _, deleteError := range result.Errors {
+		assert.Contains(t, []string{"slow.txt", "fast2.txt"}, deleteError.Key)
+		assert.Equal(t, "RequestTimeout", deleteError.Code)
+	}
+
+	// Only the removed keys are dropped from the cache
+	for _, key := range []string{"fast1.txt", "flaky.txt"} {
+		_, err := db.Stat("test-bucket/" + key)
+		assert.Error(t, err, key)
+	}
+	for _, key := range []string{"slow.txt", "fast2.txt"} {
+		_, err := db.Stat("test-bucket/" + key)
+		assert.NoError(t, err, key)
+	}
+
+	// The abandoned remove keeps the path locked until it returns
+	_, locked := s.writeLocks.tryLock("test-bucket/slow.txt")
+	assert.False(t, locked, "Path should stay locked while the abandoned remove runs")
+	assert.Eventually(t, func() bool {
+		unlock, ok := s.writeLocks.tryLock("test-bucket/slow.txt")
+		if ok {
+			unlock()
+		}
+		return ok
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestHandleBulkDeleteBackendFailure(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/failing.txt", []byte("content"))
+	require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/failing.txt", Size: 7, LastModified: time.Now().Unix(), Processed: true}))
+	s.SetBulkDeleteBudget(0, 0)
+	webdav.FailRequests(1, http.StatusInternalServerError)
+
+	req := httptest.NewRequest("POST", "/test-bucket/?delete", strings.NewReader("<Delete><Object><Key>failing.txt</Key></Object></Delete>"))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+	w := httptest.NewRecorder()
+	s.handleBulkDelete(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result DeleteResult
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, "InternalError", result.Errors[0].Code)
+
+	// The file is still on the backend, so it stays listed
+	_, err := db.Stat("test-bucket/failing.txt")
+	assert.NoError(t, err)
+}
+
+func TestRemoveWithRetryDeadline(t *testing.T) {
+	s, _, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/failing.txt", []byte("content"))
+	webdav.FailRequests(100, http.StatusInternalServerError)
+	s.SetBulkDeleteBudget(10, 0)
+
+	// The backoff before the retries does not outlast the deadline
+	unlocked := make(chan struct{})
+	start := time.Now()
+	err := s.removeWithRetry("test-bucket/failing.txt", time.Now().Add(150*time.Millisecond), func() { close(unlocked) })
+	assert.Equal(t, errDeleteTimeout, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	select {
+	case <-unlocked:
+	case <-time.After(time.Second):
+		t.Fatal("path was not unlocked")
+	}
+}
+
+func TestHandleBulkDeleteQuiet(t *testing.T) {
+	tests := []struct {
+		name            string
+		quiet           string
+		keys            int
+		expectedStatus  int
+		expectedDeleted int
+	}{
+		{"quiet mode", "<Quiet>true</Quiet>", 3, http.StatusOK, 0},
+		{"explicit non-quiet mode", "<Quiet>false</Quiet>", 3, http.StatusOK, 3},
+		{"default mode", "", 3, http.StatusOK, 3},
+		{"maximum number of keys", "<Quiet>true</Quiet>", 1000, http.StatusOK, 0},
+		{"too many keys", "", 1001, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			deleteXML := "<Delete>" + tt.quiet
+			for i := 0; i < tt.keys; i++ {
+				key := fmt.Sprintf("file%d.txt", i)
+				if i < 3 {
+					webdav.AddFile("/test-bucket/"+key, []byte("content"))
+					require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/" + key, Size: 7, Processed: true}))
+				}
+				deleteXML += "<Object><Key>" + key + "</Key></Object>"
+			}
+			deleteXML += "</Delete>"
+
+			req := httptest.NewRequest("POST", "/test-bucket/?delete", strings.NewReader(deleteXML))
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+			w := httptest.NewRecorder()
+
+			s.handleBulkDelete(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedStatus != http.StatusOK {
+				var result ErrorResponse
+				require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+				assert.Equal(t, "MalformedXML", result.Code)
+
+				_, err := db.Stat("test-bucket/file0.txt")
+				assert.NoError(t, err, "Nothing should be deleted")
+				return
+			}
+
+			var result DeleteResult
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+			assert.Len(t, result.Deleted, tt.expectedDeleted)
+			assert.Empty(t, result.Errors)
+
+			_, err := db.Stat("test-bucket/file0.txt")
+			assert.Error(t, err, "Objects should be deleted")
+		})
+	}
+}
+
+func TestHandleListObjectsPartialPrefix(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	err := db.Insert(createEntries(
+		"test-bucket/photos/2024-01.jpg",
+		"test-bucket/photos/2024-02.jpg",
+		"test-bucket/photos/2025-01.jpg",
+		"test-bucket/photos/2024-03/a.jpg",
+	)...)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		query            string
+		expectedKeys     []string
+		expectedPrefixes []string
+	}{
+		{
+			name:         "partial file name",
+			query:        "prefix=photos/2024-0",
+			expectedKeys: []string{"photos/2024-01.jpg", "photos/2024-02.jpg", "photos/2024-03/a.jpg"},
+		},
+		{
+			name:             "partial file name with delimiter",
+			query:            "prefix=photos/2024-0&delimiter=/",
+			expectedKeys:     []string{"photos/2024-01.jpg", "photos/2024-02.jpg"},
+			expectedPrefixes: []string{"photos/2024-03/"},
+		},
+		{
+			name:         "exact key",
+			query:        "prefix=photos/2025-01.jpg",
+			expectedKeys: []string{"photos/2025-01.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket?"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+			w := httptest.NewRecorder()
+
+			s.handleListObjects(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketResult
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+
+			keys := []string{}
+			for _, obj := range result.Contents {
+				keys = append(keys, obj.Key)
+			}
+			prefixes := []string{}
+			for _, prefix := range result.CommonPrefixes {
+				prefixes = append(prefixes, prefix.Prefix)
+			}
+
+			assert.Equal(t, tt.expectedKeys, keys)
+			if tt.expectedPrefixes == nil {
+				tt.expectedPrefixes = []string{}
+			}
+			assert.Equal(t, tt.expectedPrefixes, prefixes)
+		})
+	}
+}
+
+// createEntries creates processed file entries with their parent directories
+func createEntries(paths ...string) []fs.EntryInfo {
+	var entries []fs.EntryInfo
+	for _, path := range paths {
+		entries = append(entries, fs.BaseDirEntries(path)...)
+		entries = append(entries, fs.EntryInfo{
+			Path:         path,
+			Size:         int64(len(path)),
+			LastModified: time.Now().Unix(),
+			Processed:    true,
+		})
+	}
+	return entries
+}
+
+func TestHandleListObjectsBackendFallback(t *testing.T) {
+	tests := []struct {
+		name             string
+		fallback         bool
+		query            string
+		expectedKeys     []string
+		expectedPrefixes []string
+	}{
+		{
+			name:             "fallback disabled",
+			query:            "",
+			expectedKeys:     []string{},
+			expectedPrefixes: []string{},
+		},
+		{
+			name:             "fallback lists whole bucket",
+			fallback:         true,
+			query:            "",
+			expectedKeys:     []string{"dir/nested/file3.txt", "dir/file2.txt", "file1.txt"},
+			expectedPrefixes: []string{},
+		},
+		{
+			name:             "fallback lists directory with delimiter",
+			fallback:         true,
+			query:            "prefix=dir/&delimiter=/",
+			expectedKeys:     []string{"dir/file2.txt"},
+			expectedPrefixes: []string{"dir/nested/"},
+		},
+		{
+			name:             "fallback lists partial prefix",
+			fallback:         true,
+			query:            "prefix=dir/fi",
+			expectedKeys:     []string{"dir/file2.txt"},
+			expectedPrefixes: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			webdav.AddFile("/test-bucket/file1.txt", []byte("content1"))
+			webdav.AddFile("/test-bucket/dir/file2.txt", []byte("content2"))
+			webdav.AddFile("/test-bucket/dir/nested/file3.txt", []byte("content3"))
+
+			s.SetListBackendFallback(tt.fallback)
+
+			req := httptest.NewRequest("GET", "/test-bucket?"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+			w := httptest.NewRecorder()
+
+			s.handleListObjects(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketResult
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+
+			keys := []string{}
+			for _, obj := range result.Contents {
+				keys = append(keys, obj.Key)
+			}
+			prefixes := []string{}
+			for _, prefix := range result.CommonPrefixes {
+				prefixes = append(prefixes, prefix.Prefix)
+			}
+			assert.ElementsMatch(t, tt.expectedKeys, keys)
+			assert.ElementsMatch(t, tt.expectedPrefixes, prefixes)
+
+			// Listed entries are stored in the cache
+			for _, key := range keys {
+				_, err := db.Stat("test-bucket/" + key)
+				assert.NoError(t, err, "Entry %s should be cached", key)
+			}
+		})
+	}
+}
+
+func TestHandleListObjectsCache(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s.SetListCacheTTL(time.Minute)
+
+	list := func(query string) []string {
+		req := httptest.NewRequest("GET", "/test-bucket?"+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+		w := httptest.NewRecorder()
+		s.handleListObjects(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result ListBucketResultV2
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		keys := []string{}
+		for _, obj := range result.Contents {
+			keys = append(keys, obj.Key)
+		}
+		return keys
+	}
+
+	insert := func(key string) {
+		require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/" + key, Size: 5, LastModified: time.Now().Unix()}))
+	}
+
+	insert("file1.txt")
+	assert.Equal(t, []string{"file1.txt"}, list("list-type=2"))
+
+	// Changes bypassing the S3 API are not seen until the cache is dropped
+	insert("file2.txt")
+	assert.Equal(t, []string{"file1.txt"}, list("list-type=2"), "Repeated listing should be served from cache")
+	assert.Equal(t, []string{"file1.txt", "file2.txt"}, list("list-type=2&max-keys=10"), "Other parameters should not share the cache")
+
+	// PUT drops the cached listings of the bucket
+	req := httptest.NewRequest("PUT", "/test-bucket/file3.txt", strings.NewReader("hello"))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "file3.txt"})
+	w := httptest.NewRecorder()
+	s.handlePutObject(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"file1.txt", "file2.txt", "file3.txt"}, list("list-type=2"))
+
+	// DELETE too
+	req = httptest.NewRequest("DELETE", "/test-bucket/file1.txt", nil)
+	req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "file1.txt"})
+	w = httptest.NewRecorder()
+	s.handleDeleteObject(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"file2.txt", "file3.txt"}, list("list-type=2"))
+
+	// Writes to other buckets keep the cached listings
+	insert("file4.txt")
+	req = httptest.NewRequest("PUT", "/bucket2/other.txt", strings.NewReader("hello"))
+	req = mux.SetURLVars(req, map[string]string{"bucket": "bucket2", "key": "other.txt"})
+	w = httptest.NewRecorder()
+	s.handlePutObject(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"file2.txt", "file3.txt"}, list("list-type=2"))
+}
+
+func TestListCacheExpiry(t *testing.T) {
+	c := newListCache(time.Millisecond)
+
+	c.put("key", "bucket", c.generation("bucket"), []byte("body"))
+	body, ok := c.get("key")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("body"), body)
+
+	time.Sleep(5 * time.Millisecond)
+	_, ok = c.get("key")
+	assert.False(t, ok, "Expired listing should not be served")
+
+	// Listings read before a write are not stored after it
+	generation := c.generation("bucket")
+	c.invalidate("bucket")
+	c.put("key", "bucket", generation, []byte("stale"))
+	_, ok = c.get("key")
+	assert.False(t, ok, "Listing read before a write should not be cached")
+}
+
+func TestNegativeCache(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s.SetNegativeCache(100, time.Minute)
+	sync := syncer.New(s.client, db)
+	s.SetSync(sync)
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		s.handleGetObject(w, req)
+		return w
+	}
+	put := func(key, content string) {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(content))
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		s.handlePutObject(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	t.Run("miss is remembered", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, get("config.json").Code)
+
+		// Changes bypassing the S3 API and the sync are not seen until the miss expires
+		require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/config.json", Size: 2, LastModified: time.Now().Unix()}))
+		assert.Equal(t, http.StatusNotFound, get("config.json").Code)
+	})
+
+	t.Run("put drops the miss", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, get("optional.yml").Code)
+		put("optional.yml", "found")
+
+		w := get("optional.yml")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "found", w.Body.String())
+	})
+
+	t.Run("put drops the miss of the parent directories", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, get("nested/dir/").Code)
+		put("nested/dir/file.txt", "file")
+
+		assert.Equal(t, http.StatusOK, get("nested/dir/").Code)
+	})
+
+	t.Run("sync drops the miss", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, get("synced.txt").Code)
+
+		webdav.AddFile("/test-bucket/synced.txt", []byte("synced"))
+		_, err := db.SetProcessed("test-bucket/", false, false)
+		require.NoError(t, err)
+		require.NoError(t, sync.ScanDir("test-bucket/", false))
+
+		w := get("synced.txt")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "synced", w.Body.String())
+	})
+}
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	c := newNegativeCache(2, time.Millisecond)
+
+	c.put("bucket/key", c.generation("bucket/key"))
+	assert.True(t, c.missing("bucket/key"))
+
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, c.missing("bucket/key"), "Expired miss should not be served")
+
+	// Misses read before a write are not stored after it
+	generation := c.generation("bucket/key")
+	c.invalidate(fs.EntryInfo{Path: "bucket/other"})
+	c.put("bucket/key", generation)
+	assert.False(t, c.missing("bucket/key"), "Miss read before a write should not be cached")
+
+	// The least recently missed objects are evicted first
+	c = newNegativeCache(2, time.Minute)
+	c.put("bucket/a", c.generation("bucket/a"))
+	c.put("bucket/b", c.generation("bucket/b"))
+	assert.True(t, c.missing("bucket/a"))
+	c.put("bucket/c", c.generation("bucket/c"))
+	assert.True(t, c.missing("bucket/a"))
+	assert.False(t, c.missing("bucket/b"))
+	assert.True(t, c.missing("bucket/c"))
+}
+
+func TestHandleGetObjectChecksumTrailer(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	content := []byte("content verified with trailer checksum")
+	webdav.AddFile("/test-bucket/checksum.txt", content)
+	err := db.Insert(fs.EntryInfo{
+		Path:         "test-bucket/checksum.txt",
+		Size:         int64(len(content)),
+		LastModified: time.Now().Unix(),
+		Processed:    true,
+	})
+	require.NoError(t, err)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = mux.SetURLVars(r, map[string]string{"bucket": "test-bucket", "key": "checksum.txt"})
+		s.handleGetObject(w, r)
+	}))
+	defer httpServer.Close()
+
+	sum := sha256.Sum256(content)
+	expectedChecksum := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name          string
+		checksumMode  string
+		expectTrailer bool
+	}{
+		{name: "checksum mode enabled", checksumMode: "ENABLED", expectTrailer: true},
+		{name: "checksum mode not requested", checksumMode: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", httpServer.URL+"/test-bucket/checksum.txt", nil)
+			require.NoError(t, err)
+			if tt.checksumMode != "" {
+				req.Header.Set("X-Amz-Checksum-Mode", tt.checksumMode)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			if !tt.expectTrailer {
+				assert.Equal(t, int64(len(content)), resp.ContentLength)
+				assert.Empty(t, resp.Trailer)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, content, body)
+				return
+			}
+
+			// Trailer is announced, but its value is known only after the body
+			_, announced := resp.Trailer["X-Amz-Checksum-Sha256"]
+			assert.True(t, announced)
+			assert.Empty(t, resp.Trailer.Get("X-Amz-Checksum-Sha256"))
+			assert.Empty(t, resp.Header.Get("X-Amz-Checksum-Sha256"))
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, content, body)
+			assert.Equal(t, expectedChecksum, resp.Trailer.Get("X-Amz-Checksum-Sha256"))
+		})
+	}
+}
+
+func TestHandleCreateBucket(t *testing.T) {
+	tests := []struct {
+		name           string
+		autoCreate     bool
+		bucket         string
+		expectedStatus int
+		expectedCode   string
+		expectCreated  bool
+	}{
+		{
+			name:           "create bucket",
+			autoCreate:     true,
+			bucket:         "new-bucket",
+			expectedStatus: http.StatusOK,
+			expectCreated:  true,
+		},
+		{
+			name:           "create existing bucket",
+			autoCreate:     true,
+			bucket:         "test-bucket",
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "BucketAlreadyOwnedByYou",
+		},
+		{
+			name:           "create disabled",
+			bucket:         "new-bucket",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "NoSuchBucket",
+		},
+		{
+			name:           "create existing bucket with create disabled",
+			bucket:         "test-bucket",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid bucket name",
+			autoCreate:     true,
+			bucket:         "Invalid_Bucket",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "InvalidBucketName",
+		},
+		{
+			name:           "bucket name formatted as IP address",
+			autoCreate:     true,
+			bucket:         "192.168.1.1",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "InvalidBucketName",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			s.SetAutoCreateBuckets(tt.autoCreate)
+
+			router := mux.NewRouter()
+			s.SetupReadRoutes(router)
+			s.SetupWriteRoutes(router)
+
+			req := httptest.NewRequest("PUT", "/"+tt.bucket, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedCode != "" {
+				var errResp ErrorResponse
+				require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &errResp))
+				assert.Equal(t, tt.expectedCode, errResp.Code)
+			}
+
+			created, err := db.ListBuckets()
+			require.NoError(t, err)
+
+			if !tt.expectCreated {
+				assert.Empty(t, created)
+				if tt.expectedStatus != http.StatusOK && tt.expectedStatus != http.StatusConflict {
+					assert.False(t, s.isBucketAllowed(tt.bucket))
+				}
+				return
+			}
+
+			assert.Equal(t, []string{tt.bucket}, created)
+			assert.True(t, s.isBucketAllowed(tt.bucket))
+
+			webdavFs, err := webdav.CreateWebDAVFs()
+			require.NoError(t, err)
+			stat, err := webdavFs.Stat(tt.bucket)
+			require.NoError(t, err)
+			assert.True(t, stat.IsDir())
+
+			// Re-creating the bucket fails, and keeps it
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("PUT", "/"+tt.bucket+"/", nil))
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Contains(t, w.Body.String(), "<Code>BucketAlreadyOwnedByYou</Code>")
+
+			created, err = db.ListBuckets()
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.bucket}, created)
+
+			// New bucket can be used right away
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest("PUT", "/"+tt.bucket+"/file.txt", strings.NewReader("content"))
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			assert.Contains(t, w.Body.String(), "<Name>"+tt.bucket+"</Name>")
+		})
+	}
+}
+
+func TestHandleDeleteBucket(t *testing.T) {
+	tests := []struct {
+		name           string
+		autoCreate     bool
+		bucket         string
+		objects        []string
+		backendFiles   []string
+		expectedStatus int
+		expectedCode   string
+		expectDeleted  bool
+	}{
+		{
+			name:           "delete empty bucket",
+			autoCreate:     true,
+			bucket:         "new-bucket",
+			expectedStatus: http.StatusNoContent,
+			expectDeleted:  true,
+		},
+		{
+			name:           "delete non-empty bucket",
+			autoCreate:     true,
+			bucket:         "new-bucket",
+			objects:        []string{"file.txt"},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "BucketNotEmpty",
+		},
+		{
+			name:           "delete bucket with files not in cache",
+			autoCreate:     true,
+			bucket:         "new-bucket",
+			backendFiles:   []string{"/new-bucket/out-of-band.txt"},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "BucketNotEmpty",
+		},
+		{
+			name:           "delete configured bucket",
+			autoCreate:     true,
+			bucket:         "test-bucket",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "delete unknown bucket",
+			autoCreate:     true,
+			bucket:         "unknown-bucket",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "NoSuchBucket",
+		},
+		{
+			name:           "delete disabled",
+			bucket:         "test-bucket",
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedCode:   "MethodNotAllowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			router := mux.NewRouter()
+			s.SetupReadRoutes(router)
+			s.SetupWriteRoutes(router)
+
+			// Buckets not configured on the command line are created through the API
+			if tt.bucket != "test-bucket" && tt.bucket != "unknown-bucket" {
+				s.SetAutoCreateBuckets(true)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("PUT", "/"+tt.bucket, nil))
+				require.Equal(t, http.StatusOK, w.Code)
+			}
+			s.SetAutoCreateBuckets(tt.autoCreate)
+
+			for _, key := range tt.objects {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("PUT", "/"+tt.bucket+"/"+key, strings.NewReader("content")))
+				require.Equal(t, http.StatusOK, w.Code)
+			}
+			for _, path := range tt.backendFiles {
+				webdav.AddFile(path, []byte("content"))
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("DELETE", "/"+tt.bucket, nil))
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedCode != "" {
+				var errResp ErrorResponse
+				require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &errResp))
+				assert.Equal(t, tt.expectedCode, errResp.Code)
+			}
+
+			created, err := db.ListBuckets()
+			require.NoError(t, err)
+
+			webdavFs, err := webdav.CreateWebDAVFs()
+			require.NoError(t, err)
+			_, statErr := webdavFs.Stat(tt.bucket)
+
+			if tt.expectDeleted {
+				assert.False(t, s.isBucketAllowed(tt.bucket))
+				assert.NotContains(t, created, tt.bucket)
+				assert.True(t, fs.IsNotFound(statErr))
+				_, err := db.Stat(tt.bucket + "/")
+				assert.Error(t, err)
+			} else if tt.bucket != "unknown-bucket" {
+				assert.True(t, s.isBucketAllowed(tt.bucket))
+			}
+		})
+	}
+}
+
+func TestHandleListObjectsOwner(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, upload := range []struct{ key, owner string }{
+		{"alice.txt", "alice"},
+		{"dir/alice.txt", "alice"},
+		{"bob.txt", "bob"},
+	} {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+upload.key, strings.NewReader("content"))
+		req = req.WithContext(context.WithValue(req.Context(), accessKeyKey{}, upload.owner))
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": upload.key})
+		w := httptest.NewRecorder()
+		s.handlePutObject(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Discovered by the sync, without a recorded owner
+	require.NoError(t, db.Insert(createEntries("test-bucket/synced.txt")...))
+
+	entry, err := db.Stat("test-bucket/alice.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", entry.Owner)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedOwners map[string]string
+	}{
+		{
+			name:  "V2 without fetch-owner",
+			query: "list-type=2",
+			expectedOwners: map[string]string{
+				"alice.txt": "", "bob.txt": "", "dir/alice.txt": "", "synced.txt": "",
+			},
+		},
+		{
+			name:  "V2 with fetch-owner",
+			query: "list-type=2&fetch-owner=true",
+			expectedOwners: map[string]string{
+				"alice.txt": "alice", "bob.txt": "bob", "dir/alice.txt": "alice", "synced.txt": "",
+			},
+		},
+		{
+			name:  "V1 includes owner",
+			query: "",
+			expectedOwners: map[string]string{
+				"alice.txt": "alice", "bob.txt": "bob", "dir/alice.txt": "alice", "synced.txt": "",
+			},
+		},
+		{
+			name:           "filtered by owner",
+			query:          "list-type=2&owner=alice&fetch-owner=true",
+			expectedOwners: map[string]string{"alice.txt": "alice", "dir/alice.txt": "alice"},
+		},
+		{
+			name:           "filtered by unknown owner",
+			query:          "owner=carol",
+			expectedOwners: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket?"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+			w := httptest.NewRecorder()
+
+			s.handleListObjects(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result ListBucketResultV2
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+
+			owners := map[string]string{}
+			for _, obj := range result.Contents {
+				owners[obj.Key] = ""
+				if obj.Owner != nil {
+					assert.Equal(t, newOwner(obj.Owner.DisplayName).ID, obj.Owner.ID)
+					assert.Len(t, obj.Owner.ID, 64)
+					owners[obj.Key] = obj.Owner.DisplayName
+				}
+			}
+			assert.Equal(t, tt.expectedOwners, owners)
+		})
+	}
+}
+
+func TestAuthMiddlewareAccessKey(t *testing.T) {
+	var accessKey string
+	handler := AuthMiddleware(AuthMiddlewareConfig{Credentials: AuthConfig{AccessKey: "alice", SecretKey: "secret"}},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accessKey = AccessKey(r)
+		}))
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req := httptest.NewRequest("GET", "/test-bucket", nil)
+	req.Header.Set("Date", date)
+	req.Header.Set("Authorization", "AWS alice:"+calculateSignature(req, date, "secret"))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", accessKey)
+}
+
+// rotatingCredentials is a provider whose secret keys change between requests
+type rotatingCredentials struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func (c *rotatingCredentials) Lookup(accessKey string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	secretKey, ok := c.keys[accessKey]
+	return secretKey, ok
+}
+
+func (c *rotatingCredentials) set(accessKey, secretKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[accessKey] = secretKey
+}
+
+func TestAuthMiddlewareCredentialProvider(t *testing.T) {
+	credentials := &rotatingCredentials{keys: map[string]string{"alice": "old-secret"}}
+	handler := AuthMiddleware(AuthMiddlewareConfig{Credentials: credentials}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(accessKey, secretKey string) int {
+		date := time.Now().UTC().Format(http.TimeFormat)
+		req := httptest.NewRequest("GET", "/test-bucket", nil)
+		req.Header.Set("Date", date)
+		req.Header.Set("Authorization", "AWS "+accessKey+":"+calculateSignature(req, date, secretKey))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("alice", "old-secret"))
+	assert.Equal(t, http.StatusUnauthorized, request("bob", "old-secret"))
+
+	credentials.set("alice", "new-secret")
+	assert.Equal(t, http.StatusUnauthorized, request("alice", "old-secret"))
+	assert.Equal(t, http.StatusOK, request("alice", "new-secret"))
+
+	credentials.set("bob", "bob-secret")
+	assert.Equal(t, http.StatusOK, request("bob", "bob-secret"))
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	called := false
+	handler := AuthMiddleware(AuthMiddlewareConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test-bucket", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, called)
+}
+
+func TestAuthMiddlewareClockSkew(t *testing.T) {
+	credentials := &rotatingCredentials{keys: map[string]string{"alice": "secret"}}
+	handler := AuthMiddleware(AuthMiddlewareConfig{Credentials: credentials, MaxClockSkew: DefaultMaxClockSkew},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// request signs the request at the time, given in the header, or in none
+	request := func(handler http.Handler, signedAt time.Time, header string) *httptest.ResponseRecorder {
+		date := signedAt.UTC().Format(amzDateFormat)
+		req := httptest.NewRequest("GET", "/test-bucket", nil)
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		signedHeaders := "host;x-amz-content-sha256"
+		switch header {
+		case "X-Amz-Date":
+			req.Header.Set("X-Amz-Date", date)
+			signedHeaders += ";x-amz-date"
+		case "Date":
+			req.Header.Set("Date", signedAt.UTC().Format(http.TimeFormat))
+			signedHeaders = "date;" + signedHeaders
+		}
+		signature, err := calculateSignatureV4(req, "us-east-1", "s3", "secret", date, signedHeaders)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=alice/%s/us-east-1/s3/aws4_request, SignedHeaders=%s, Signature=%s",
+			date[:8], signedHeaders, signature))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		header string
+		status int
+		code   string
+	}{
+		{"current", 0, "X-Amz-Date", http.StatusOK, ""},
+		{"5 minutes in the past", -5 * time.Minute, "X-Amz-Date", http.StatusOK, ""},
+		{"5 minutes in the future", 5 * time.Minute, "X-Amz-Date", http.StatusOK, ""},
+		{"20 minutes in the past", -20 * time.Minute, "X-Amz-Date", http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"20 minutes in the future", 20 * time.Minute, "X-Amz-Date", http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"current in Date", 0, "Date", http.StatusOK, ""},
+		{"20 minutes in the past in Date", -20 * time.Minute, "Date", http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"no date", 0, "", http.StatusForbidden, "AccessDenied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(handler, time.Now().Add(tt.offset), tt.header)
+			require.Equal(t, tt.status, w.Code)
+			if tt.code != "" {
+				assert.Contains(t, w.Body.String(), "<Code>"+tt.code+"</Code>")
+			}
+		})
+	}
+
+	t.Run("check disabled", func(t *testing.T) {
+		unchecked := AuthMiddleware(AuthMiddlewareConfig{Credentials: credentials},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		assert.Equal(t, http.StatusOK, request(unchecked, time.Now().Add(-20*time.Minute), "X-Amz-Date").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(unchecked, time.Now(), "").Code, "Request without a date cannot be signed")
+	})
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	credentials := &rotatingCredentials{keys: map[string]string{"alice": "secret"}}
+	handler := CORSMiddleware(CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		MaxAge:         time.Hour,
+	}, AuthMiddleware(AuthMiddlewareConfig{Credentials: credentials}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"etag"`)
+	})))
+
+	request := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test-bucket/file.txt", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		w := request("OPTIONS", "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "authorization, content-type, x-amz-date, x-amz-content-sha256",
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, HEAD, PUT, POST, DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "authorization, content-type, x-amz-date, x-amz-content-sha256", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag")
+		assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	})
+
+	denied := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+	}{
+		{"origin", "https://evil.example.com", "GET", ""},
+		{"method", "https://app.example.com", "PATCH", ""},
+		{"header", "https://app.example.com", "GET", "authorization, x-custom"},
+	}
+	for _, tc := range denied {
+		t.Run("preflight denied "+tc.name, func(t *testing.T) {
+			w := request("OPTIONS", tc.origin, map[string]string{
+				"Access-Control-Request-Method":  tc.method,
+				"Access-Control-Request-Headers": tc.headers,
+			})
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Contains(t, w.Body.String(), "<Code>AccessForbidden</Code>")
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+
+	t.Run("actual request", func(t *testing.T) {
+		date := time.Now().UTC().Format(http.TimeFormat)
+		req := httptest.NewRequest("GET", "/test-bucket/file.txt", nil)
+		req.Header.Set("Date", date)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Authorization", "AWS alice:"+calculateSignature(req, date, "secret"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag")
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("unauthenticated request still fails", func(t *testing.T) {
+		w := request("GET", "https://app.example.com", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("other origin gets no headers", func(t *testing.T) {
+		w := request("GET", "https://evil.example.com", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		handler := CORSMiddleware(CORSConfig{AllowedOrigins: []string{"*"}}, http.NotFoundHandler())
+		req := httptest.NewRequest("OPTIONS", "/test-bucket", nil)
+		req.Header.Set("Origin", "https://other.example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Max-Age"))
+	})
+}
+
+func TestVirtualHostMiddleware(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/dir/file.txt", []byte("content"))
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/dir/", IsDir: true, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/dir/file.txt", Size: 7, LastModified: time.Now().Unix(), Processed: true},
+	))
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	credentials := &rotatingCredentials{keys: map[string]string{"alice": "secret"}}
+	handler := VirtualHostMiddleware("s3.example.com", AuthMiddleware(AuthMiddlewareConfig{Credentials: credentials, MaxClockSkew: DefaultMaxClockSkew}, router))
+
+	// request signs the request with v4 as sent, before the path is rewritten
+	request := func(method, target string) *httptest.ResponseRecorder {
+		date := time.Now().UTC().Format(amzDateFormat)
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Amz-Date", date)
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+		signature, err := calculateSignatureV4(req, "us-east-1", "s3", "secret", date, signedHeaders)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=alice/%s/us-east-1/s3/aws4_request, SignedHeaders=%s, Signature=%s",
+			date[:8], signedHeaders, signature))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		status   int
+		contains string
+	}{
+		{"virtual-hosted object", "GET", "http://test-bucket.s3.example.com/dir/file.txt", http.StatusOK, "content"},
+		{"virtual-hosted object with port", "HEAD", "http://test-bucket.s3.example.com:8080/dir/file.txt", http.StatusOK, ""},
+		{"virtual-hosted listing", "GET", "http://test-bucket.s3.example.com/?prefix=dir/", http.StatusOK, "<Name>test-bucket</Name>"},
+		{"virtual-hosted other bucket", "GET", "http://bucket2.s3.example.com/", http.StatusOK, "<Name>bucket2</Name>"},
+		{"virtual-hosted missing key", "GET", "http://bucket2.s3.example.com/dir/file.txt", http.StatusNotFound, "NoSuchKey"},
+		{"domain is path-style", "GET", "http://s3.example.com/test-bucket/dir/file.txt", http.StatusOK, "content"},
+		{"domain lists buckets", "GET", "http://s3.example.com/", http.StatusOK, "<Name>bucket2</Name>"},
+		{"other host is path-style", "GET", "http://localhost/test-bucket/dir/file.txt", http.StatusOK, "content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(tt.method, tt.target)
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.contains)
+		})
+	}
+}
+
+func TestCredentialProviders(t *testing.T) {
+	providers := CredentialProviders{
+		AuthConfig{AccessKey: "alice", SecretKey: "static"},
+		&rotatingCredentials{keys: map[string]string{"alice": "shadowed", "bob": "bob-secret"}},
+	}
+
+	tests := []struct {
+		accessKey      string
+		expectedSecret string
+		expectedOK     bool
+	}{
+		{"alice", "static", true},
+		{"bob", "bob-secret", true},
+		{"carol", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accessKey, func(t *testing.T) {
+			secretKey, ok := providers.Lookup(tt.accessKey)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedSecret, secretKey)
+		})
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	path := t.TempDir() + "/credentials"
+	require.NoError(t, os.WriteFile(path, []byte("# keys\nalice:secret\n\n  bob : bob-secret  \n"), 0600))
+
+	credentials, err := NewFileCredentials(path)
+	require.NoError(t, err)
+
+	secretKey, ok := credentials.Lookup("alice")
+	assert.True(t, ok)
+	assert.Equal(t, "secret", secretKey)
+	secretKey, ok = credentials.Lookup("bob")
+	assert.True(t, ok)
+	assert.Equal(t, "bob-secret", secretKey)
+
+	changed, err := credentials.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Rotating alice and revoking bob
+	require.NoError(t, os.WriteFile(path, []byte("alice:rotated\n"), 0600))
+	changed, err = credentials.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	secretKey, ok = credentials.Lookup("alice")
+	assert.True(t, ok)
+	assert.Equal(t, "rotated", secretKey)
+	_, ok = credentials.Lookup("bob")
+	assert.False(t, ok)
+
+	// A broken file keeps the previous keys
+	for _, content := range []string{"alice\n", "alice:\n", ":secret\n", "alice:a\nalice:b\n"} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		_, err = credentials.Reload()
+		assert.Error(t, err, content)
+		secretKey, ok = credentials.Lookup("alice")
+		assert.True(t, ok)
+		assert.Equal(t, "rotated", secretKey)
+	}
+
+	_, err = NewFileCredentials(path + ".missing")
+	assert.Error(t, err)
+}
+
+func TestHandlePutObjectExpectContinue(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	s.SetAllowedContentTypes("bucket2", []string{"image/*"})
+	webdav.AddFile("/test-bucket/existing.txt", []byte("existing"))
+	require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/existing.txt", Size: 8, Processed: true}))
+	webdav.AddFile("/bucket2/existing.png", []byte("existing"))
+	require.NoError(t, db.Insert(fs.EntryInfo{Path: "bucket2/existing.png", Size: 8, Processed: true}))
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{"unknown bucket", "/missing-bucket/file.txt", nil, http.StatusNotFound},
+		{"failed precondition", "/test-bucket/existing.txt", map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed},
+		{"denied content type", "/bucket2/file.txt", map[string]string{"Content-Type": "text/plain"}, http.StatusForbidden},
+		{"failed precondition before sniffing content type", "/bucket2/existing.png", map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed},
+		{"accepted", "/test-bucket/new.txt", nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			body := "uploaded content"
+			request := fmt.Sprintf("PUT %s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nExpect: 100-continue\r\n",
+				tt.path, server.Listener.Addr(), len(body))
+			for name, value := range tt.headers {
+				request += name + ": " + value + "\r\n"
+			}
+			_, err = conn.Write([]byte(request + "\r\n"))
+			require.NoError(t, err)
+
+			// Only an accepted upload is asked for the body
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			if tt.expectedStatus != http.StatusOK {
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+				return
+			}
+			require.Equal(t, http.StatusContinue, resp.StatusCode)
+
+			_, err = conn.Write([]byte(body))
+			require.NoError(t, err)
+			resp, err = http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			entry, err := db.Stat("test-bucket/new.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(body)), entry.Size)
+		})
+	}
+}
+
+func TestHandlePostObject(t *testing.T) {
+	config := AuthConfig{AccessKey: "alice", SecretKey: "secret"}
+	day := time.Now().UTC().Format("20060102")
+
+	// policy returns the fields signing the policy with the conditions,
+	// which like the SDKs also cover the signing fields
+	policy := func(expiration time.Time, conditions ...any) map[string]string {
+		fields := map[string]string{
+			"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+			"x-amz-credential": config.AccessKey + "/" + day + "/us-east-1/s3/aws4_request",
+			"x-amz-date":       day + "T000000Z",
+		}
+		for _, field := range []string{"x-amz-algorithm", "x-amz-credential", "x-amz-date"} {
+			conditions = append(conditions, map[string]string{field: fields[field]})
+		}
+		document, err := json.Marshal(map[string]any{
+			"expiration": expiration.UTC().Format(time.RFC3339),
+			"conditions": conditions,
+		})
+		require.NoError(t, err)
+		encoded := base64.StdEncoding.EncodeToString(document)
+
+		signingKey := signingKeyV4(config.SecretKey, day, "us-east-1", "s3")
+		fields["policy"] = encoded
+		fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, encoded))
+		return fields
+	}
+	validUntil := time.Now().Add(time.Hour)
+	uploadsPolicy := policy(validUntil, map[string]string{"bucket": "test-bucket"}, []any{"starts-with", "$key", "uploads/"}, []any{"content-length-range", 1, 100})
+
+	tests := []struct {
+		name             string
+		auth             bool
+		fields           map[string]string
+		fileName         string
+		content          string
+		expectedStatus   int
+		expectedCode     string
+		expectedKey      string
+		expectedLocation string
+	}{
+		{
+			name:             "upload with filename placeholder",
+			fields:           map[string]string{"key": "uploads/${filename}"},
+			fileName:         "hello.txt",
+			content:          "hello",
+			expectedStatus:   http.StatusNoContent,
+			expectedKey:      "uploads/hello.txt",
+			expectedLocation: "http://example.com/test-bucket/uploads/hello.txt",
+		},
+		{
+			name:           "upload with created status",
+			fields:         map[string]string{"key": "created.txt", "success_action_status": "201"},
+			fileName:       "local.txt",
+			content:        "created",
+			expectedStatus: http.StatusCreated,
+			expectedKey:    "created.txt",
+		},
+		{
+			name:           "missing key",
+			fields:         map[string]string{},
+			fileName:       "hello.txt",
+			content:        "hello",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "InvalidArgument",
+		},
+		{
+			name:           "signed policy",
+			auth:           true,
+			fields:         mergeFields(uploadsPolicy, map[string]string{"key": "uploads/signed.txt"}),
+			fileName:       "signed.txt",
+			content:        "signed",
+			expectedStatus: http.StatusNoContent,
+			expectedKey:    "uploads/signed.txt",
+		},
+		{
+			name:           "missing policy",
+			auth:           true,
+			fields:         map[string]string{"key": "uploads/unsigned.txt"},
+			fileName:       "unsigned.txt",
+			content:        "unsigned",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "invalid signature",
+			auth:           true,
+			fields:         mergeFields(uploadsPolicy, map[string]string{"key": "uploads/forged.txt", "x-amz-signature": "forged"}),
+			fileName:       "forged.txt",
+			content:        "forged",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "expired policy",
+			auth:           true,
+			fields:         mergeFields(policy(time.Now().Add(-time.Hour)), map[string]string{"key": "uploads/expired.txt"}),
+			fileName:       "expired.txt",
+			content:        "expired",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "key outside of policy",
+			auth:           true,
+			fields:         mergeFields(uploadsPolicy, map[string]string{"key": "other/file.txt"}),
+			fileName:       "file.txt",
+			content:        "other",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "field not covered by policy",
+			auth:           true,
+			fields:         mergeFields(uploadsPolicy, map[string]string{"key": "uploads/extra.txt", "content-type": "text/html"}),
+			fileName:       "extra.txt",
+			content:        "extra",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name: "field covered by policy",
+			auth: true,
+			fields: mergeFields(
+				policy(validUntil, []any{"starts-with", "$key", "uploads/"}, []any{"starts-with", "$Content-Type", "text/"}),
+				map[string]string{"key": "uploads/typed.txt", "content-type": "text/plain", "x-ignore-tracking": "1"},
+			),
+			fileName:       "typed.txt",
+			content:        "typed",
+			expectedStatus: http.StatusNoContent,
+			expectedKey:    "uploads/typed.txt",
+		},
+		{
+			name:           "file larger than policy",
+			auth:           true,
+			fields:         mergeFields(uploadsPolicy, map[string]string{"key": "uploads/large.txt"}),
+			fileName:       "large.txt",
+			content:        strings.Repeat("x", 101),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "EntityTooLarge",
+		},
+		{
+			name:           "file smaller than policy",
+			auth:           true,
+			fields:         mergeFields(uploadsPolicy, map[string]string{"key": "uploads/empty.txt"}),
+			fileName:       "empty.txt",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "EntityTooSmall",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			router := mux.NewRouter()
+			s.SetupReadRoutes(router)
+			s.SetupWriteRoutes(router)
+			var handler http.Handler = router
+			if tt.auth {
+				handler = AuthMiddleware(AuthMiddlewareConfig{Credentials: config}, router)
+			}
+
+			var body strings.Builder
+			form := multipart.NewWriter(&body)
+			for name, value := range tt.fields {
+				require.NoError(t, form.WriteField(name, value))
+			}
+			file, err := form.CreateFormFile("file", tt.fileName)
+			require.NoError(t, err)
+			file.Write([]byte(tt.content))
+			require.NoError(t, form.Close())
+
+			req := httptest.NewRequest("POST", "/test-bucket", strings.NewReader(body.String()))
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedCode != "" {
+				var errResp ErrorResponse
+				require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &errResp))
+				assert.Equal(t, tt.expectedCode, errResp.Code)
+				return
+			}
+
+			if tt.expectedLocation != "" {
+				assert.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
+			}
+			if tt.expectedStatus == http.StatusCreated {
+				var result PostResponse
+				require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+				assert.Equal(t, "test-bucket", result.Bucket)
+				assert.Equal(t, tt.expectedKey, result.Key)
+				assert.Equal(t, w.Header().Get("ETag"), result.ETag)
+			}
+
+			entry, err := db.Stat("test-bucket/" + tt.expectedKey)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.content)), entry.Size)
+			if tt.auth {
+				assert.Equal(t, config.AccessKey, entry.Owner)
+			}
+
+			webdavFs, err := webdav.CreateWebDAVFs()
+			require.NoError(t, err)
+			reader, err := webdavFs.ReadStream("test-bucket/" + tt.expectedKey)
+			require.NoError(t, err)
+			defer reader.Close()
+			content, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tt.content, string(content))
+		})
+	}
+}
+
+func mergeFields(fields ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, f := range fields {
+		for name, value := range f {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+func TestHandleGetObjectPassthroughRedirect(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	content := []byte("content served by the CDN")
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer cdn.Close()
+
+	webdav.AddFile("/test-bucket/redirected.txt", []byte("placeholder content"))
+	webdav.RedirectFile("/test-bucket/redirected.txt", cdn.URL+"/signed/redirected.txt?sig=abc")
+	webdav.AddFile("/test-bucket/direct.txt", content)
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/redirected.txt", Size: int64(len(content)), Processed: true},
+		fs.EntryInfo{Path: "test-bucket/direct.txt", Size: int64(len(content)), Processed: true},
+	))
+
+	baseURL, err := url.Parse("https://cdn.example.com")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		enabled          bool
+		baseURL          *url.URL
+		key              string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:           "redirect followed when disabled",
+			key:            "redirected.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "redirect passed through",
+			enabled:          true,
+			key:              "redirected.txt",
+			expectedStatus:   http.StatusTemporaryRedirect,
+			expectedLocation: cdn.URL + "/signed/redirected.txt?sig=abc",
+		},
+		{
+			name:             "redirect passed through with rewritten location",
+			enabled:          true,
+			baseURL:          baseURL,
+			key:              "redirected.txt",
+			expectedStatus:   http.StatusTemporaryRedirect,
+			expectedLocation: "https://cdn.example.com/signed/redirected.txt?sig=abc",
+		},
+		{
+			name:           "content without redirect proxied",
+			enabled:        true,
+			key:            "direct.txt",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.SetPassthroughRedirects(tt.enabled, tt.baseURL)
+
+			req := httptest.NewRequest("GET", "/test-bucket/"+tt.key, nil)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": tt.key})
+			w := httptest.NewRecorder()
+
+			s.handleGetObject(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedLocation != "" {
+				assert.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
+				assert.Empty(t, w.Header().Get("Content-Length"))
+				assert.Empty(t, w.Body.Bytes())
+			} else {
+				assert.Empty(t, w.Header().Get("Location"))
+				assert.Equal(t, content, w.Body.Bytes())
+			}
+		})
+	}
+
+	t.Run("range of content without redirect", func(t *testing.T) {
+		s.SetPassthroughRedirects(true, nil)
+
+		req := httptest.NewRequest("GET", "/test-bucket/direct.txt", nil)
+		req.Header.Set("Range", "bytes=8-13")
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "direct.txt"})
+		w := httptest.NewRecorder()
+
+		s.handleGetObject(w, req)
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "served", w.Body.String())
+	})
+}
+
+func TestHandlePutObjectConcurrent(t *testing.T) {
+	readBackend := func(s *server, path string) []byte {
+		reader, err := s.client.ReadStream(path)
+		require.NoError(t, err)
+		defer reader.Close()
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return content
+	}
+	putObject := func(s *server, body io.Reader, size int64) int {
+		req := httptest.NewRequest("PUT", "/test-bucket/concurrent.txt", body)
+		req.ContentLength = size
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "concurrent.txt"})
+		w := httptest.NewRecorder()
+		s.handlePutObject(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name           string
+		policy         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "last write wins",
+			policy:         WriteConflictsLastWriteWins,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "second write",
+		},
+		{
+			name:           "reject",
+			policy:         WriteConflictsReject,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "first write, longer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, _, cleanup := setupTestServer(t)
+			defer cleanup()
+			s.SetWriteConflicts(tt.policy)
+
+			// First write is held in progress until the rest of its body is sent
+			first := "first write, longer"
+			pr, pw := io.Pipe()
+			firstDone := make(chan int)
+			go func() { firstDone <- putObject(s, pr, int64(len(first))) }()
+			_, err := pw.Write([]byte(first[:5]))
+			require.NoError(t, err)
+
+			secondDone := make(chan int)
+			go func() {
+				secondDone <- putObject(s, strings.NewReader("second write"), int64(len("second write")))
+			}()
+
+			if tt.policy == WriteConflictsReject {
+				assert.Equal(t, tt.expectedStatus, <-secondDone)
+			} else {
+				select {
+				case <-secondDone:
+					t.Fatal("second write finished while the first one was in progress")
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+
+			_, err = pw.Write([]byte(first[5:]))
+			require.NoError(t, err)
+			pw.Close()
+			assert.Equal(t, http.StatusOK, <-firstDone)
+
+			if tt.policy != WriteConflictsReject {
+				assert.Equal(t, tt.expectedStatus, <-secondDone)
+			}
+
+			assert.Equal(t, tt.expectedBody, string(readBackend(s, "test-bucket/concurrent.txt")))
+
+			entry, err := db.Stat("test-bucket/concurrent.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.expectedBody)), entry.Size)
+		})
+	}
+
+	t.Run("simultaneous writes keep cache consistent", func(t *testing.T) {
+		s, db, _, cleanup := setupTestServer(t)
+		defer cleanup()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := strings.Repeat("x", i+1)
+				assert.Equal(t, http.StatusOK, putObject(s, strings.NewReader(body), int64(len(body))))
+			}(i)
+		}
+		wg.Wait()
+
+		content := readBackend(s, "test-bucket/concurrent.txt")
+		entry, err := db.Stat("test-bucket/concurrent.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), entry.Size)
+	})
+}
+
+func TestDeleteObjectConcurrentPut(t *testing.T) {
+	putObject := func(s *server, body io.Reader, size int64) int {
+		req := httptest.NewRequest("PUT", "/test-bucket/concurrent.txt", body)
+		req.ContentLength = size
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "concurrent.txt"})
+		w := httptest.NewRecorder()
+		s.handlePutObject(w, req)
+		return w.Code
+	}
+	deleteObject := func(s *server) int {
+		req := httptest.NewRequest("DELETE", "/test-bucket/concurrent.txt", nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "concurrent.txt"})
+		w := httptest.NewRecorder()
+		s.handleDeleteObject(w, req)
+		return w.Code
+	}
+	bulkDelete := func(s *server) int {
+		body := `<Delete><Object><Key>concurrent.txt</Key></Object></Delete>`
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket"})
+		w := httptest.NewRecorder()
+		s.handleBulkDelete(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		delete   func(s *server) int
+		expected int
+	}{
+		{"delete", deleteObject, http.StatusNoContent},
+		{"bulk delete", bulkDelete, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, _, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			// The write is held in progress until the rest of its body is sent
+			content := "written content"
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			putDone := make(chan int)
+			go func() { putDone <- putObject(s, pr, int64(len(content))) }()
+			_, err := pw.Write([]byte(content[:5]))
+			require.NoError(t, err)
+
+			deleteDone := make(chan int)
+			go func() { deleteDone <- tt.delete(s) }()
+
+			select {
+			case <-deleteDone:
+				t.Fatal("delete finished while the write was in progress")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			_, err = pw.Write([]byte(content[5:]))
+			require.NoError(t, err)
+			pw.Close()
+			assert.Equal(t, http.StatusOK, <-putDone)
+			assert.Equal(t, tt.expected, <-deleteDone)
+
+			// The delete ran after the write, on both the cache and the backend
+			_, err = db.Stat("test-bucket/concurrent.txt")
+			assert.Error(t, err)
+			_, err = s.client.Stat("test-bucket/concurrent.txt")
+			assert.True(t, fs.IsNotFound(err))
+		})
+	}
+}
+
+func TestVersionId(t *testing.T) {
+	s, _, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	serve := func(method, target string, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "versioned.txt"})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := serve("PUT", "/test-bucket/versioned.txt", "first content", s.handlePutObject)
+	require.Equal(t, http.StatusOK, w.Code)
+	versionId := w.Header().Get("X-Amz-Version-Id")
+	require.NotEmpty(t, versionId)
+
+	for _, target := range []string{
+		"/test-bucket/versioned.txt",
+		"/test-bucket/versioned.txt?versionId=" + versionId,
+		"/test-bucket/versioned.txt?versionId=unknown-version",
+	} {
+		w = serve("GET", target, "", s.handleGetObject)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, versionId, w.Header().Get("X-Amz-Version-Id"), target)
+		assert.Equal(t, "first content", w.Body.String())
+
+		w = serve("HEAD", target, "", s.handleHeadObject)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, versionId, w.Header().Get("X-Amz-Version-Id"), target)
+	}
+
+	// Replacing the object changes its version
+	w = serve("PUT", "/test-bucket/versioned.txt", "second, longer content", s.handlePutObject)
+	require.Equal(t, http.StatusOK, w.Code)
+	newVersionId := w.Header().Get("X-Amz-Version-Id")
+	assert.NotEqual(t, versionId, newVersionId)
+
+	w = serve("HEAD", "/test-bucket/versioned.txt", "", s.handleHeadObject)
+	assert.Equal(t, newVersionId, w.Header().Get("X-Amz-Version-Id"))
+}
+
+func TestHandleStats(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+
+	entries := createEntries("test-bucket/a.txt", "test-bucket/dir/b.txt", "bucket2/c.txt")
+	entries = append(entries, fs.EntryInfo{Path: "test-bucket/pending/", IsDir: true})
+	for _, entry := range entries {
+		require.NoError(t, db.Insert(entry))
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	assertStats := func(t *testing.T, stats BucketStats) {
+		processed, pending, totalSize, err := db.GetStats(stats.Name + "/")
+		require.NoError(t, err)
+		assert.Equal(t, processed, stats.Processed)
+		assert.Equal(t, pending, stats.Pending)
+		assert.Equal(t, totalSize, stats.TotalSize)
+	}
+
+	t.Run("all buckets", func(t *testing.T) {
+		w := get("/-/stats")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var result StatsResult
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result.Buckets, 2)
+		assert.Equal(t, "bucket2", result.Buckets[0].Name)
+		assert.Equal(t, "test-bucket", result.Buckets[1].Name)
+		for _, stats := range result.Buckets {
+			assertStats(t, stats)
+			assert.Nil(t, stats.LastSync)
+		}
+		assert.Equal(t, 1, result.Buckets[1].Pending)
+		assert.Equal(t, int64(len("test-bucket/a.txt")+len("test-bucket/dir/b.txt")), result.Buckets[1].TotalSize)
+	})
+
+	t.Run("single bucket", func(t *testing.T) {
+		w := get("/-/stats/bucket2")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var stats BucketStats
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		assert.Equal(t, "bucket2", stats.Name)
+		assertStats(t, stats)
+	})
+
+	t.Run("unknown bucket", func(t *testing.T) {
+		w := get("/-/stats/unknown-bucket")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "NoSuchBucket")
+	})
+
+	t.Run("last sync", func(t *testing.T) {
+		sync := syncer.New(s.client, db)
+		s.SetSync(sync)
+		require.NoError(t, db.Delete("test-bucket/pending/"))
+
+		before := time.Now()
+		require.NoError(t, sync.Sync("test-bucket"))
+
+		var result StatsResult
+		require.NoError(t, json.NewDecoder(get("/-/stats").Body).Decode(&result))
+		require.Len(t, result.Buckets, 2)
+		assert.Nil(t, result.Buckets[0].LastSync)
+		require.NotNil(t, result.Buckets[1].LastSync)
+		assert.False(t, result.Buckets[1].LastSync.Before(before.Truncate(time.Second)))
+	})
+}
+
+func TestHandleAPIList(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix()
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/", IsDir: true, LastModified: modTime},
+		fs.EntryInfo{Path: "test-bucket/a.txt", Size: 5, LastModified: modTime},
+		fs.EntryInfo{Path: "test-bucket/dir/", IsDir: true, LastModified: modTime},
+		fs.EntryInfo{Path: "test-bucket/dir/nested.txt", Size: 7, LastModified: modTime},
+		fs.EntryInfo{Path: "test-bucket/z.txt", Size: 9, LastModified: modTime},
+	))
+
+	list := func(t *testing.T, query string) APIListResult {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/-/api/list?"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var result APIListResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	t.Run("Directory listing", func(t *testing.T) {
+		result := list(t, "bucket=test-bucket&delimiter=/")
+
+		assert.Equal(t, "test-bucket", result.Bucket)
+		assert.Equal(t, []APIDirectory{{Prefix: "dir/", LastModified: time.Unix(modTime, 0).UTC()}}, result.Directories)
+		require.Len(t, result.Files, 2)
+		assert.Equal(t, "a.txt", result.Files[0].Key)
+		assert.Equal(t, int64(5), result.Files[0].Size)
+		assert.True(t, result.Files[0].LastModified.Equal(time.Unix(modTime, 0)))
+		assert.NotEmpty(t, result.Files[0].ETag)
+		assert.Equal(t, "z.txt", result.Files[1].Key)
+		assert.False(t, result.IsTruncated)
+		assert.Empty(t, result.NextContinuationToken)
+	})
+
+	t.Run("Nested prefix", func(t *testing.T) {
+		result := list(t, "bucket=test-bucket&prefix=dir/&delimiter=/")
+
+		assert.Empty(t, result.Directories)
+		require.Len(t, result.Files, 1)
+		assert.Equal(t, "dir/nested.txt", result.Files[0].Key)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		var visited []string
+		token := ""
+		for page := 0; page < 10; page++ {
+			query := "bucket=test-bucket&delimiter=/&max-keys=1"
+			if token != "" {
+				query += "&continuation-token=" + url.QueryEscape(token)
+			}
+			result := list(t, query)
+			for _, dir := range result.Directories {
+				visited = append(visited, dir.Prefix)
+			}
+			for _, file := range result.Files {
+				visited = append(visited, file.Key)
+			}
+			if !result.IsTruncated {
+				break
+			}
+			token = result.NextContinuationToken
+			require.NotEmpty(t, token)
+		}
+		assert.Equal(t, []string{"a.txt", "dir/", "z.txt"}, visited)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, query := range []string{"bucket=missing", "bucket=test-bucket&continuation-token=invalid", "bucket=test-bucket&delimiter=-"} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/-/api/list?"+query, nil))
+			assert.NotEqual(t, http.StatusOK, w.Code, query)
+		}
+	})
+}
+
+func TestHashedLayout(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	webdavFs, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+	s.client = fs.NewHashedFs(webdavFs)
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	for _, key := range []string{"flat.txt", "nested/dir/file.txt"} {
+		t.Run(key, func(t *testing.T) {
+			content := "content of " + key
+
+			w := serve("PUT", "/test-bucket/"+key, content)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			// Stored under the hashed path only
+			reader, err := webdavFs.ReadStream(fs.HashedPath("test-bucket/" + key))
+			require.NoError(t, err)
+			stored, err := io.ReadAll(reader)
+			reader.Close()
+			require.NoError(t, err)
+			assert.Equal(t, content, string(stored))
+
+			_, err = webdavFs.Stat("test-bucket/" + key)
+			assert.True(t, fs.IsNotFound(err))
+
+			// The cache keeps the S3 key
+			entry, err := db.Stat("test-bucket/" + key)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(content)), entry.Size)
+
+			w = serve("GET", "/test-bucket/"+key, "")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, content, w.Body.String())
+
+			w = serve("HEAD", "/test-bucket/"+key, "")
+			require.Equal(t, http.StatusOK, w.Code)
+
+			w = serve("DELETE", "/test-bucket/"+key, "")
+			require.Equal(t, http.StatusNoContent, w.Code)
+
+			_, err = webdavFs.Stat(fs.HashedPath("test-bucket/" + key))
+			assert.True(t, fs.IsNotFound(err))
+
+			w = serve("GET", "/test-bucket/"+key, "")
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		})
+	}
+}
+
+func TestBucketMapping(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	webdavFs, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+	s.client = fs.NewMappedFs(webdavFs, map[string]string{"test-bucket": "media/images"})
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	readBackend := func(path string) string {
+		reader, err := webdavFs.ReadStream(path)
+		require.NoError(t, err)
+		defer reader.Close()
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	// Files already on the backend prefix are found by the sync
+	webdav.AddFile("/media/images/existing.txt", []byte("existing"))
+	webdav.AddFile("/test-bucket/unmapped.txt", []byte("unmapped"))
+	require.NoError(t, syncer.New(s.client, db).Sync("test-bucket"))
+
+	t.Run("sync stores keys under the bucket", func(t *testing.T) {
+		entry, err := db.Stat("test-bucket/existing.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("existing")), entry.Size)
+
+		_, err = db.Stat("test-bucket/unmapped.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("put stores under the prefix", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/dir/new.txt", "new content")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, "new content", readBackend("media/images/dir/new.txt"))
+		_, err := webdavFs.Stat("test-bucket/dir/new.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+
+	t.Run("get reads from the prefix", func(t *testing.T) {
+		w := serve("GET", "/test-bucket/existing.txt", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "existing", w.Body.String())
+
+		w = serve("GET", "/test-bucket/dir/new.txt", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "new content", w.Body.String())
+	})
+
+	t.Run("list returns the keys of the bucket", func(t *testing.T) {
+		w := serve("GET", "/test-bucket?list-type=2", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result ListBucketResultV2
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, "test-bucket", result.Name)
+
+		var keys []string
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		assert.ElementsMatch(t, []string{"existing.txt", "dir/new.txt"}, keys)
+	})
+
+	t.Run("delete removes from the prefix", func(t *testing.T) {
+		w := serve("DELETE", "/test-bucket/existing.txt", "")
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err := webdavFs.Stat("media/images/existing.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+}
+
+func TestHandleGetObjectResponseOverrides(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	content := []byte("downloaded content")
+	webdav.AddFile("/test-bucket/download.bin", content)
+	require.NoError(t, db.Insert(fs.EntryInfo{
+		Path:         "test-bucket/download.bin",
+		Size:         int64(len(content)),
+		LastModified: time.Now().Unix(),
+		Processed:    true,
+	}))
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected map[string]string
+	}{
+		{
+			name: "no overrides",
+			expected: map[string]string{
+				"Content-Type":        "application/octet-stream",
+				"Content-Disposition": `inline; filename="download.bin"`,
+			},
+		},
+		{
+			name:  "content disposition",
+			query: url.Values{"response-content-disposition": {"attachment; filename=foo.txt"}},
+			expected: map[string]string{
+				"Content-Type":        "application/octet-stream",
+				"Content-Disposition": "attachment; filename=foo.txt",
+			},
+		},
+		{
+			name: "all overrides",
+			query: url.Values{
+				"response-content-type":        {"text/plain"},
+				"response-content-disposition": {"inline"},
+				"response-content-encoding":    {"identity"},
+				"response-content-language":    {"en"},
+				"response-cache-control":       {"no-cache"},
+				"response-expires":             {"Thu, 01 Dec 1994 16:00:00 GMT"},
+			},
+			expected: map[string]string{
+				"Content-Type":        "text/plain",
+				"Content-Disposition": "inline",
+				"Content-Encoding":    "identity",
+				"Content-Language":    "en",
+				"Cache-Control":       "no-cache",
+				"Expires":             "Thu, 01 Dec 1994 16:00:00 GMT",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket/download.bin?"+tt.query.Encode(), nil)
+			req = mux.SetURLVars(req, map[string]string{
+				"bucket": "test-bucket",
+				"key":    "download.bin",
+			})
+			w := httptest.NewRecorder()
+
+			s.handleGetObject(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, string(content), w.Body.String())
+			for header, value := range tt.expected {
+				assert.Equal(t, value, w.Header().Get(header), header)
+			}
+		})
+	}
+}
+
+func TestHandleGetObjectCacheControl(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, key := range []string{"index.html", "app.0123abcd.js"} {
+		webdav.AddFile("/test-bucket/"+key, []byte("content"))
+		require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/" + key, Size: 7, LastModified: time.Now().Unix(), Processed: true}))
+	}
+
+	serve := func(method, key string, query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test-bucket/"+key+"?"+query.Encode(), nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		if method == "HEAD" {
+			s.handleHeadObject(w, req)
+		} else {
+			s.handleGetObject(w, req)
+		}
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	t.Run("not sent by default", func(t *testing.T) {
+		w := serve("GET", "index.html", nil)
+		assert.Empty(t, w.Header().Get("Cache-Control"))
+		assert.Empty(t, w.Header().Get("Expires"))
+	})
+
+	s.SetCacheControl("public, max-age=3600", regexp.MustCompile(`\.[0-9a-f]{8}\.js$`))
+
+	tests := []struct {
+		name         string
+		method       string
+		key          string
+		query        url.Values
+		cacheControl string
+		expires      string
+	}{
+		{"configured", "GET", "index.html", nil, "public, max-age=3600", formatHTTPTime(time.Now().Add(time.Hour).Unix())},
+		{"configured on head", "HEAD", "index.html", nil, "public, max-age=3600", formatHTTPTime(time.Now().Add(time.Hour).Unix())},
+		{"immutable key", "GET", "app.0123abcd.js", nil, "public, max-age=3600, immutable", formatHTTPTime(time.Now().Add(time.Hour).Unix())},
+		{"request override", "GET", "index.html", url.Values{"response-cache-control": {"no-store"}}, "no-store", ""},
+		{"request override on head", "HEAD", "index.html", url.Values{"response-cache-control": {"no-store"}}, "no-store", ""},
+		{"expires override", "GET", "index.html", url.Values{"response-expires": {"Thu, 01 Dec 1994 16:00:00 GMT"}}, "public, max-age=3600", "Thu, 01 Dec 1994 16:00:00 GMT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.key, tt.query)
+			assert.Equal(t, tt.cacheControl, w.Header().Get("Cache-Control"))
+			if tt.expires == "" {
+				assert.Empty(t, w.Header().Get("Expires"))
+				return
+			}
+			expected, err := http.ParseTime(tt.expires)
+			require.NoError(t, err)
+			expires, err := http.ParseTime(w.Header().Get("Expires"))
+			require.NoError(t, err)
+			assert.WithinDuration(t, expected, expires, 2*time.Second)
+		})
+	}
+
+	t.Run("not modified", func(t *testing.T) {
+		etag := serve("HEAD", "index.html", nil).Header().Get("ETag")
+		for _, method := range []string{"GET", "HEAD"} {
+			req := httptest.NewRequest(method, "/test-bucket/index.html", nil)
+			req.Header.Set("If-None-Match", etag)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "index.html"})
+			w := httptest.NewRecorder()
+			if method == "HEAD" {
+				s.handleHeadObject(w, req)
+			} else {
+				s.handleGetObject(w, req)
+			}
+			require.Equal(t, http.StatusNotModified, w.Code, method)
+			assert.Equal(t, etag, w.Header().Get("ETag"), method)
+			assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"), method)
+		}
+	})
+
+	t.Run("immutable without a default", func(t *testing.T) {
+		s.SetCacheControl("", regexp.MustCompile(`\.[0-9a-f]{8}\.js$`))
+		assert.Equal(t, "public, max-age=31536000, immutable", serve("GET", "app.0123abcd.js", nil).Header().Get("Cache-Control"))
+		assert.Empty(t, serve("GET", "index.html", nil).Header().Get("Cache-Control"))
+	})
+}
+
+func TestHandleGetObjectContentDisposition(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		key      string
+		query    string
+		header   string
+		filename string
+	}{
+		{name: "basename of the key", key: "docs/2024/report.pdf", header: `inline; filename="report.pdf"`, filename: "report.pdf"},
+		{name: "spaces", key: "my file.txt", header: `inline; filename="my file.txt"`, filename: "my file.txt"},
+		{name: "quotes", key: `say "hi".txt`, header: `inline; filename="say \"hi\".txt"`, filename: `say "hi".txt`},
+		{name: "backslash", key: `back\slash.txt`, header: `inline; filename="back\\slash.txt"`, filename: `back\slash.txt`},
+		{
+			name:     "non-ASCII",
+			key:      "zażółć gęślą.txt",
+			header:   `inline; filename="za____ g__l_.txt"; filename*=UTF-8''za%C5%BC%C3%B3%C5%82%C4%87%20g%C4%99%C5%9Bl%C4%85.txt`,
+			filename: "zażółć gęślą.txt",
+		},
+		{
+			name:     "overridden by the query",
+			key:      "docs/report.pdf",
+			query:    "response-content-disposition=" + url.QueryEscape(`attachment; filename="other.pdf"`),
+			header:   `attachment; filename="other.pdf"`,
+			filename: "other.pdf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webdav.AddFile("/test-bucket/"+tt.key, []byte("content"))
+			require.NoError(t, db.Insert(fs.EntryInfo{
+				Path:         "test-bucket/" + tt.key,
+				Size:         int64(len("content")),
+				LastModified: time.Now().Unix(),
+				Processed:    true,
+			}))
+
+			for _, rangeHeader := range []string{"", "bytes=0-2"} {
+				req := httptest.NewRequest("GET", "/test-bucket/"+url.PathEscape(tt.key)+"?"+tt.query, nil)
+				if rangeHeader != "" {
+					req.Header.Set("Range", rangeHeader)
+				}
+				req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": tt.key})
+				w := httptest.NewRecorder()
+
+				s.handleGetObject(w, req)
+
+				require.Less(t, w.Code, 300)
+				assert.Equal(t, tt.header, w.Header().Get("Content-Disposition"))
+
+				_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+				require.NoError(t, err)
+				assert.Equal(t, tt.filename, params["filename"])
+			}
+		})
+	}
+}
+
+func TestHandleGetObjectRange(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	content := []byte("0123456789")
+	webdav.AddFile("/test-bucket/range.bin", content)
+	require.NoError(t, db.Insert(fs.EntryInfo{
+		Path:         "test-bucket/range.bin",
+		Size:         int64(len(content)),
+		LastModified: time.Now().Unix(),
+		Processed:    true,
+	}))
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{name: "no range", status: http.StatusOK, body: "0123456789"},
+		{name: "first byte", rangeHeader: "bytes=0-0", status: http.StatusPartialContent, body: "0", contentRange: "bytes 0-0/10"},
+		{name: "closed range", rangeHeader: "bytes=2-5", status: http.StatusPartialContent, body: "2345", contentRange: "bytes 2-5/10"},
+		{name: "open range", rangeHeader: "bytes=7-", status: http.StatusPartialContent, body: "789", contentRange: "bytes 7-9/10"},
+		{name: "suffix range", rangeHeader: "bytes=-3", status: http.StatusPartialContent, body: "789", contentRange: "bytes 7-9/10"},
+		{name: "end past size", rangeHeader: "bytes=8-100", status: http.StatusPartialContent, body: "89", contentRange: "bytes 8-9/10"},
+		{name: "suffix past size", rangeHeader: "bytes=-100", status: http.StatusPartialContent, body: "0123456789", contentRange: "bytes 0-9/10"},
+		{name: "start past size", rangeHeader: "bytes=10-", status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */10"},
+		{name: "empty suffix", rangeHeader: "bytes=-0", status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */10"},
+		{name: "multiple ranges served whole", rangeHeader: "bytes=0-1,4-5", status: http.StatusOK, body: "0123456789"},
+		{name: "malformed range served whole", rangeHeader: "bytes=5-2", status: http.StatusOK, body: "0123456789"},
+		{name: "other unit served whole", rangeHeader: "items=0-1", status: http.StatusOK, body: "0123456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket/range.bin", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "range.bin"})
+			w := httptest.NewRecorder()
+
+			s.handleGetObject(w, req)
+
+			require.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.contentRange, w.Header().Get("Content-Range"))
+			if tt.status == http.StatusRequestedRangeNotSatisfiable {
+				assert.Contains(t, w.Body.String(), "<Code>InvalidRange</Code>")
+				return
+			}
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.Equal(t, strconv.Itoa(len(tt.body)), w.Header().Get("Content-Length"))
+			assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		})
+	}
+}
+
+func TestHandleGetObjectIfRange(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	content := []byte("0123456789")
+	modified := time.Now().Add(-time.Hour).Unix()
+	webdav.AddFile("/test-bucket/resume.bin", content)
+	require.NoError(t, db.Insert(fs.EntryInfo{
+		Path:         "test-bucket/resume.bin",
+		Size:         int64(len(content)),
+		LastModified: modified,
+		Processed:    true,
+	}))
+	etag := generateETag("test-bucket/resume.bin", int64(len(content)), modified)
+
+	tests := []struct {
+		name    string
+		ifRange string
+		status  int
+		body    string
+	}{
+		{name: "matching etag", ifRange: etag, status: http.StatusPartialContent, body: "56789"},
+		{name: "stale etag", ifRange: `"0123456789abcdef0123456789abcdef"`, status: http.StatusOK, body: "0123456789"},
+		{name: "weak etag", ifRange: "W/" + etag, status: http.StatusOK, body: "0123456789"},
+		{name: "matching date", ifRange: formatHTTPTime(modified), status: http.StatusPartialContent, body: "56789"},
+		{name: "older date", ifRange: formatHTTPTime(modified - 60), status: http.StatusOK, body: "0123456789"},
+		{name: "newer date", ifRange: formatHTTPTime(modified + 60), status: http.StatusOK, body: "0123456789"},
+		{name: "malformed validator", ifRange: "yesterday", status: http.StatusOK, body: "0123456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket/resume.bin", nil)
+			req.Header.Set("Range", "bytes=5-")
+			req.Header.Set("If-Range", tt.ifRange)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "resume.bin"})
+			w := httptest.NewRecorder()
+
+			s.handleGetObject(w, req)
+
+			require.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			if tt.status == http.StatusPartialContent {
+				assert.Equal(t, "bytes 5-9/10", w.Header().Get("Content-Range"))
+			} else {
+				assert.Empty(t, w.Header().Get("Content-Range"))
+			}
+		})
+	}
+}
+
+func TestHandleHeadObjectRange(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	now := time.Now().Unix()
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/file.bin", Size: 1000, LastModified: now, Processed: true},
+		fs.EntryInfo{Path: "test-bucket/dir/", IsDir: true, LastModified: now, Processed: true},
+	))
+
+	head := func(key, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/test-bucket/"+key, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": key})
+		w := httptest.NewRecorder()
+		s.handleHeadObject(w, req)
+		return w
+	}
+
+	t.Run("file advertises ranges", func(t *testing.T) {
+		w := head("file.bin", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "1000", w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Header().Get("Content-Range"))
+	})
+
+	t.Run("range probes the size", func(t *testing.T) {
+		w := head("file.bin", "bytes=0-0")
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "1", w.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 0-0/1000", w.Header().Get("Content-Range"))
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("range past the end", func(t *testing.T) {
+		w := head("file.bin", "bytes=1000-")
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+		assert.Equal(t, "bytes */1000", w.Header().Get("Content-Range"))
+	})
+
+	t.Run("directory marker", func(t *testing.T) {
+		w := head("dir/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Accept-Ranges"))
+
+		w = head("dir/", "bytes=0-0")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Range"))
+		assert.Equal(t, "0", w.Header().Get("Content-Length"))
+	})
+}
+
+func TestObjectTagging(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	getTags := func(t *testing.T, target string) []Tag {
+		w := serve("GET", target+"?tagging", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var tagging Tagging
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &tagging))
+		return tagging.TagSet
+	}
+
+	tagging := func(tags ...Tag) string {
+		data, err := xml.Marshal(Tagging{TagSet: tags})
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	w := serve("PUT", "/test-bucket/tagged.txt", "content", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("No tags", func(t *testing.T) {
+		assert.Empty(t, getTags(t, "/test-bucket/tagged.txt"))
+	})
+
+	t.Run("Put and get tags", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/tagged.txt?tagging", tagging(Tag{"team", "storage"}, Tag{"env", "prod"}), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, []Tag{{"env", "prod"}, {"team", "storage"}}, getTags(t, "/test-bucket/tagged.txt"))
+
+		// The object itself is left untouched
+		w = serve("GET", "/test-bucket/tagged.txt", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "content", w.Body.String())
+	})
+
+	t.Run("Invalid tags", func(t *testing.T) {
+		tooMany := make([]Tag, maxObjectTags+1)
+		for i := range tooMany {
+			tooMany[i] = Tag{Key: fmt.Sprintf("key-%d", i)}
+		}
+
+		tests := []struct {
+			name string
+			body string
+			code string
+		}{
+			{"malformed XML", "<Tagging><TagSet>", "MalformedXML"},
+			{"too many tags", tagging(tooMany...), "InvalidTag"},
+			{"empty key", tagging(Tag{"", "value"}), "InvalidTag"},
+			{"long key", tagging(Tag{strings.Repeat("k", maxTagKeyLength+1), ""}), "InvalidTag"},
+			{"long value", tagging(Tag{"key", strings.Repeat("v", maxTagValueLength+1)}), "InvalidTag"},
+			{"duplicate key", tagging(Tag{"key", "a"}, Tag{"key", "b"}), "InvalidTag"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := serve("PUT", "/test-bucket/tagged.txt?tagging", tt.body, nil)
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.Contains(t, w.Body.String(), "<Code>"+tt.code+"</Code>")
+			})
+		}
+
+		// Previous tags are kept
+		assert.Equal(t, []Tag{{"env", "prod"}, {"team", "storage"}}, getTags(t, "/test-bucket/tagged.txt"))
+	})
+
+	t.Run("Delete tags", func(t *testing.T) {
+		w := serve("DELETE", "/test-bucket/tagged.txt?tagging", "", nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, getTags(t, "/test-bucket/tagged.txt"))
+
+		// The object itself is not deleted
+		_, err := db.Stat("test-bucket/tagged.txt")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Missing object", func(t *testing.T) {
+		for _, method := range []string{"GET", "PUT", "DELETE"} {
+			w := serve(method, "/test-bucket/missing.txt?tagging", tagging(Tag{"env", "prod"}), nil)
+			assert.Equal(t, http.StatusNotFound, w.Code, method)
+			assert.Contains(t, w.Body.String(), "<Code>NoSuchKey</Code>", method)
+		}
+	})
+
+	t.Run("Tags given on upload", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/inline.txt", "content", map[string]string{
+			"X-Amz-Tagging": "env=prod&owner=J%C3%B6rg+M",
+		})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []Tag{{"env", "prod"}, {"owner", "Jörg M"}}, getTags(t, "/test-bucket/inline.txt"))
+
+		// Overwriting the object replaces its tags
+		w = serve("PUT", "/test-bucket/inline.txt", "new content", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, getTags(t, "/test-bucket/inline.txt"))
+	})
+
+	t.Run("Invalid tags given on upload", func(t *testing.T) {
+		tooMany := make([]string, maxObjectTags+1)
+		for i := range tooMany {
+			tooMany[i] = fmt.Sprintf("key-%d=value", i)
+		}
+
+		for _, header := range []string{"env=%zz", "=value", strings.Join(tooMany, "&"), "key=a&key=b"} {
+			w := serve("PUT", "/test-bucket/rejected.txt", "content", map[string]string{
+				"X-Amz-Tagging": header,
+			})
+			assert.Equal(t, http.StatusBadRequest, w.Code, header)
+			assert.Contains(t, w.Body.String(), "<Code>InvalidTag</Code>", header)
+		}
+
+		// Nothing is written
+		_, err := db.Stat("test-bucket/rejected.txt")
+		assert.Error(t, err)
+	})
+}
+
+func TestACL(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.NoError(t, db.Insert(
+		fs.EntryInfo{Path: "test-bucket/owned.txt", Size: 5, LastModified: time.Now().Unix(), Owner: "uploader"},
+		fs.EntryInfo{Path: "test-bucket/synced.txt", Size: 5, LastModified: time.Now().Unix()},
+	))
+
+	t.Run("Get ACL", func(t *testing.T) {
+		tests := []struct {
+			name          string
+			target        string
+			expectedOwner string
+		}{
+			{"bucket", "/test-bucket?acl", anonymousOwner},
+			{"bucket with slash", "/test-bucket/?acl", anonymousOwner},
+			{"object with owner", "/test-bucket/owned.txt?acl", "uploader"},
+			{"object without owner", "/test-bucket/synced.txt?acl", anonymousOwner},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := serve("GET", tt.target, nil)
+				require.Equal(t, http.StatusOK, w.Code)
+
+				var policy AccessControlPolicy
+				require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &policy))
+				require.NotNil(t, policy.Owner)
+				assert.Equal(t, newOwner(tt.expectedOwner), policy.Owner)
+				require.Len(t, policy.AccessControlList, 1)
+				assert.Equal(t, "FULL_CONTROL", policy.AccessControlList[0].Permission)
+				assert.Equal(t, policy.Owner.ID, policy.AccessControlList[0].Grantee.ID)
+			})
+		}
+	})
+
+	t.Run("Put canned ACL", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			target       string
+			acl          string
+			expectedCode int
+		}{
+			{"bucket private", "/test-bucket?acl", "private", http.StatusOK},
+			{"object public-read", "/test-bucket/owned.txt?acl", "public-read", http.StatusOK},
+			{"object without header", "/test-bucket/owned.txt?acl", "", http.StatusOK},
+			{"unknown canned ACL", "/test-bucket/owned.txt?acl", "everyone", http.StatusBadRequest},
+			{"missing object", "/test-bucket/missing.txt?acl", "private", http.StatusNotFound},
+			{"missing bucket", "/missing-bucket?acl", "private", http.StatusNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				requests := webdav.RequestCount()
+				w := serve("PUT", tt.target, map[string]string{"X-Amz-Acl": tt.acl})
+				assert.Equal(t, tt.expectedCode, w.Code)
+				assert.Equal(t, requests, webdav.RequestCount(), "ACL should not touch the backend")
+			})
+		}
+	})
+
+	t.Run("Object is left untouched", func(t *testing.T) {
+		entry, err := db.Stat("test-bucket/owned.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), entry.Size)
+		assert.Equal(t, "uploader", entry.Owner)
+	})
+}
+
+func TestCopyObject(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("PUT", "/test-bucket/source.txt", "source content", map[string]string{"X-Amz-Tagging": "env=prod"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("HEAD", "/test-bucket/source.txt", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	sourceETag := w.Header().Get("ETag")
+
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	t.Run("Copy", func(t *testing.T) {
+		w := serve("PUT", "/bucket2/copy.txt", "", map[string]string{"X-Amz-Copy-Source": "/test-bucket/source.txt"})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result CopyObjectResult
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		assert.NotEmpty(t, result.ETag)
+
+		w = serve("GET", "/bucket2/copy.txt", "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "source content", w.Body.String())
+		assert.Equal(t, result.ETag, w.Header().Get("ETag"))
+
+		tags, err := db.GetTags("bucket2/copy.txt")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod"}, tags, "Tags should be carried over")
+	})
+
+	t.Run("Conditional headers", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			headers        map[string]string
+			expectedStatus int
+		}{
+			{"if-match matching", map[string]string{"X-Amz-Copy-Source-If-Match": sourceETag}, http.StatusOK},
+			{"if-match not matching", map[string]string{"X-Amz-Copy-Source-If-Match": `"other"`}, http.StatusPreconditionFailed},
+			{"if-none-match matching", map[string]string{"X-Amz-Copy-Source-If-None-Match": sourceETag}, http.StatusPreconditionFailed},
+			{"if-none-match not matching", map[string]string{"X-Amz-Copy-Source-If-None-Match": `"other"`}, http.StatusOK},
+			{"if-modified-since past", map[string]string{"X-Amz-Copy-Source-If-Modified-Since": past}, http.StatusOK},
+			{"if-modified-since future", map[string]string{"X-Amz-Copy-Source-If-Modified-Since": future}, http.StatusPreconditionFailed},
+			{"if-unmodified-since past", map[string]string{"X-Amz-Copy-Source-If-Unmodified-Since": past}, http.StatusPreconditionFailed},
+			{"if-unmodified-since future", map[string]string{"X-Amz-Copy-Source-If-Unmodified-Since": future}, http.StatusOK},
+			{"if-match overrides if-unmodified-since", map[string]string{
+				"X-Amz-Copy-Source-If-Match":            sourceETag,
+				"X-Amz-Copy-Source-If-Unmodified-Since": past,
+			}, http.StatusOK},
+			{"if-none-match overrides if-modified-since", map[string]string{
+				"X-Amz-Copy-Source-If-None-Match":     `"other"`,
+				"X-Amz-Copy-Source-If-Modified-Since": future,
+			}, http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				require.NoError(t, db.Delete("bucket2/conditional.txt"))
+
+				tt.headers["X-Amz-Copy-Source"] = "test-bucket/source.txt"
+				w := serve("PUT", "/bucket2/conditional.txt", "", tt.headers)
+				assert.Equal(t, tt.expectedStatus, w.Code)
+
+				_, err := db.Stat("bucket2/conditional.txt")
+				if tt.expectedStatus == http.StatusOK {
+					assert.NoError(t, err)
+				} else {
+					assert.Error(t, err, "Failed precondition should not copy")
+				}
+			})
+		}
+	})
+
+	t.Run("Directives", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			target         string
+			headers        map[string]string
+			expectedStatus int
+			expectedTags   map[string]string
+		}{
+			{"metadata copy onto itself", "/test-bucket/source.txt", map[string]string{"X-Amz-Metadata-Directive": "COPY"}, http.StatusBadRequest, nil},
+			{"metadata replace onto itself", "/test-bucket/source.txt", map[string]string{"X-Amz-Metadata-Directive": "REPLACE"}, http.StatusOK, map[string]string{"env": "prod"}},
+			{"storage class change onto itself", "/test-bucket/source.txt", map[string]string{"X-Amz-Storage-Class": "STANDARD_IA"}, http.StatusOK, map[string]string{"env": "prod"}},
+			{"metadata copy", "/test-bucket/directive.txt", map[string]string{"X-Amz-Metadata-Directive": "COPY"}, http.StatusOK, map[string]string{"env": "prod"}},
+			{"unknown metadata directive", "/test-bucket/directive.txt", map[string]string{"X-Amz-Metadata-Directive": "MERGE"}, http.StatusBadRequest, nil},
+			{"tagging replace", "/test-bucket/directive.txt", map[string]string{"X-Amz-Tagging-Directive": "REPLACE", "X-Amz-Tagging": "team=storage"}, http.StatusOK, map[string]string{"team": "storage"}},
+			{"tagging replace without tags", "/test-bucket/directive.txt", map[string]string{"X-Amz-Tagging-Directive": "REPLACE"}, http.StatusOK, map[string]string{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.headers["X-Amz-Copy-Source"] = "test-bucket/source.txt"
+				w := serve("PUT", tt.target, "", tt.headers)
+				assert.Equal(t, tt.expectedStatus, w.Code)
+
+				if tt.expectedTags != nil {
+					tags, err := db.GetTags(strings.TrimPrefix(tt.target, "/"))
+					require.NoError(t, err)
+					assert.Equal(t, tt.expectedTags, tags)
+				}
+			})
+		}
+	})
+
+	t.Run("Invalid source", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			source         string
+			expectedStatus int
+		}{
+			{"missing object", "test-bucket/missing.txt", http.StatusNotFound},
+			{"missing bucket", "missing-bucket/source.txt", http.StatusNotFound},
+			{"no key", "test-bucket", http.StatusBadRequest},
+			{"directory", "test-bucket/dir/", http.StatusBadRequest},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := serve("PUT", "/bucket2/invalid.txt", "", map[string]string{"X-Amz-Copy-Source": tt.source})
+				assert.Equal(t, tt.expectedStatus, w.Code)
+			})
+		}
+	})
+}
+
+func TestParseCopySource(t *testing.T) {
+	tests := []struct {
+		header         string
+		expectedBucket string
+		expectedKey    string
+		expectedOK     bool
+	}{
+		{"bucket/key.txt", "bucket", "key.txt", true},
+		{"/bucket/dir/key.txt", "bucket", "dir/key.txt", true},
+		{"bucket/key%20with%20spaces.txt", "bucket", "key with spaces.txt", true},
+		{"bucket/key.txt?versionId=abc", "bucket", "key.txt", true},
+		{"bucket", "", "", false},
+		{"bucket/", "", "", false},
+		{"bucket/%zz", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			bucket, key, ok := parseCopySource(tt.header)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedBucket, bucket)
+			assert.Equal(t, tt.expectedKey, key)
+		})
+	}
+}
+
+func TestHandlePutObjectPreconditions(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	put := func(key, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	content := func(t *testing.T, key string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/test-bucket/"+key, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	t.Run("Create if absent", func(t *testing.T) {
+		w := put("created.txt", "first", map[string]string{"If-None-Match": "*"})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "first", content(t, "created.txt"))
+
+		w = put("created.txt", "second", map[string]string{"If-None-Match": "*"})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Contains(t, w.Body.String(), "<Code>PreconditionFailed</Code>")
+		assert.Equal(t, "first", content(t, "created.txt"))
+	})
+
+	t.Run("Overwrite if match", func(t *testing.T) {
+		w := put("swapped.txt", "first", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+
+		// Modification time has a second resolution, so the ETag changes only in the next second
+		time.Sleep(time.Second)
+
+		w = put("swapped.txt", "second", map[string]string{"If-Match": etag})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, "second", content(t, "swapped.txt"))
+
+		// The previous ETag no longer matches
+		w = put("swapped.txt", "third", map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, "second", content(t, "swapped.txt"))
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		w := put("kept.txt", "original", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		tests := []struct {
+			name    string
+			key     string
+			headers func(etag string) map[string]string
+			status  int
+		}{
+			{"if-match other etag", "kept.txt", func(string) map[string]string {
+				return map[string]string{"If-Match": `"0123456789abcdef0123456789abcdef"`}
+			}, http.StatusPreconditionFailed},
+			{"if-match missing object", "missing.txt", func(string) map[string]string {
+				return map[string]string{"If-Match": "*"}
+			}, http.StatusPreconditionFailed},
+			{"if-none-match etag", "kept.txt", func(etag string) map[string]string {
+				return map[string]string{"If-None-Match": etag}
+			}, http.StatusPreconditionFailed},
+			{"if-match etag in list", "kept.txt", func(etag string) map[string]string {
+				return map[string]string{"If-Match": `"other", ` + etag}
+			}, http.StatusOK},
+			{"if-match unquoted etag", "kept.txt", func(etag string) map[string]string {
+				return map[string]string{"If-Match": strings.Trim(etag, `"`)}
+			}, http.StatusOK},
+			{"if-match any", "kept.txt", func(string) map[string]string {
+				return map[string]string{"If-Match": "*"}
+			}, http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				// Every successful write may change the ETag
+				head := httptest.NewRecorder()
+				router.ServeHTTP(head, httptest.NewRequest("HEAD", "/test-bucket/kept.txt", nil))
+				require.Equal(t, http.StatusOK, head.Code)
+
+				w := put(tt.key, "original", tt.headers(head.Header().Get("ETag")))
+				assert.Equal(t, tt.status, w.Code)
+			})
+		}
+
+		// Failed writes never reach the cache
+		_, err := db.Stat("test-bucket/missing.txt")
+		assert.Error(t, err)
+	})
+}
+
+func TestDirectoryMarker(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	webdavFs, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("Create marker", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/folder/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+
+		info, err := webdavFs.Stat("test-bucket/folder")
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+
+		entry, err := db.Stat("test-bucket/folder/")
+		require.NoError(t, err)
+		assert.True(t, entry.IsDir)
+		assert.True(t, entry.Processed)
+
+		// Creating it again keeps the directory
+		w = serve("PUT", "/test-bucket/folder/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Create nested marker", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/parent/child/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		for _, path := range []string{"test-bucket/parent/", "test-bucket/parent/child/"} {
+			entry, err := db.Stat(path)
+			require.NoError(t, err, path)
+			assert.True(t, entry.IsDir, path)
+		}
+	})
+
+	t.Run("Marker with content", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/invalid/", "content")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		_, err := db.Stat("test-bucket/invalid/")
+		assert.Error(t, err)
+	})
+
+	t.Run("Get and head marker", func(t *testing.T) {
+		w := serve("GET", "/test-bucket/folder/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, "0", w.Header().Get("Content-Length"))
+		etag := w.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		w = serve("HEAD", "/test-bucket/folder/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0", w.Header().Get("Content-Length"))
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+
+		w = serve("GET", "/test-bucket/missing/", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("List marker", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/folder/file.txt", "content")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = serve("GET", "/test-bucket?list-type=2&delimiter=/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result ListBucketResultV2
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		var prefixes []string
+		for _, prefix := range result.CommonPrefixes {
+			prefixes = append(prefixes, prefix.Prefix)
+		}
+		assert.Equal(t, []string{"folder/", "parent/"}, prefixes)
+
+		w = serve("GET", "/test-bucket?list-type=2&delimiter=/&prefix=folder/", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		result = ListBucketResultV2{}
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		require.Len(t, result.Contents, 1)
+		assert.Equal(t, "folder/file.txt", result.Contents[0].Key)
+	})
+
+	t.Run("Delete non-empty marker", func(t *testing.T) {
+		w := serve("DELETE", "/test-bucket/folder/", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err := webdavFs.Stat("test-bucket/folder/file.txt")
+		assert.NoError(t, err, "Objects under the marker should be kept")
+		_, err = db.Stat("test-bucket/folder/file.txt")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Delete marker of unscanned directory", func(t *testing.T) {
+		webdav.AddFile("/test-bucket/unscanned/file.txt", []byte("content"))
+
+		w := serve("DELETE", "/test-bucket/unscanned/", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = serve("POST", "/test-bucket?delete", `<Delete><Object><Key>unscanned/</Key></Object></Delete>`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "<Error>")
+
+		_, err := webdavFs.Stat("test-bucket/unscanned/file.txt")
+		assert.NoError(t, err, "Objects under the marker should be kept")
+	})
+
+	t.Run("Delete empty marker", func(t *testing.T) {
+		w := serve("DELETE", "/test-bucket/parent/child/", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err := webdavFs.Stat("test-bucket/parent/child")
+		assert.True(t, fs.IsNotFound(err), "Empty directory should be removed")
+		_, err = db.Stat("test-bucket/parent/child/")
+		assert.Error(t, err)
+
+		w = serve("POST", "/test-bucket?delete", `<Delete><Object><Key>parent/</Key></Object></Delete>`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "<Key>parent/</Key>")
+
+		_, err = webdavFs.Stat("test-bucket/parent")
+		assert.True(t, fs.IsNotFound(err), "Empty directory should be removed")
+		_, err = db.Stat("test-bucket/parent/")
+		assert.Error(t, err)
+	})
+}
+
+func TestHandlePutObjectChecksums(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, key, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test-bucket/"+key, strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	content := "content with checksum"
+	checksum := func(algorithm string) string {
+		hasher := checksumAlgorithms[algorithm]()
+		hasher.Write([]byte(content))
+		return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+	}
+
+	t.Run("CRC32C round trip", func(t *testing.T) {
+		w := serve("PUT", "crc32c.txt", content, map[string]string{"X-Amz-Checksum-Algorithm": "crc32c"})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, checksum("CRC32C"), w.Header().Get("X-Amz-Checksum-Crc32c"))
+
+		entry, err := db.Stat("test-bucket/crc32c.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "CRC32C:"+checksum("CRC32C"), entry.Checksum)
+
+		for _, method := range []string{"GET", "HEAD"} {
+			w := serve(method, "crc32c.txt", "", nil)
+			require.Equal(t, http.StatusOK, w.Code, method)
+			assert.Equal(t, checksum("CRC32C"), w.Header().Get("X-Amz-Checksum-Crc32c"), method)
+		}
+
+		// The stored checksum is returned instead of the SHA256 trailer
+		w = serve("GET", "crc32c.txt", "", map[string]string{"X-Amz-Checksum-Mode": "ENABLED"})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, content, w.Body.String())
+		assert.Equal(t, checksum("CRC32C"), w.Header().Get("X-Amz-Checksum-Crc32c"))
+		assert.Empty(t, w.Header().Get("Trailer"))
+
+		// Overwriting without a checksum drops it
+		w = serve("PUT", "crc32c.txt", "other content", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		w = serve("HEAD", "crc32c.txt", "", nil)
+		assert.Empty(t, w.Header().Get("X-Amz-Checksum-Crc32c"))
+	})
+
+	t.Run("Expected checksum", func(t *testing.T) {
+		for algorithm := range checksumAlgorithms {
+			header := checksumHeader(algorithm)
+			w := serve("PUT", "verified.txt", content, map[string]string{header: checksum(algorithm)})
+			require.Equal(t, http.StatusOK, w.Code, algorithm)
+			assert.Equal(t, checksum(algorithm), w.Header().Get(header), algorithm)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		w := serve("PUT", "mismatch.txt", content, map[string]string{
+			"X-Amz-Checksum-Algorithm": "CRC32C",
+			"X-Amz-Checksum-Crc32c":    "AAAAAA==",
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "<Code>BadDigest</Code>")
+		assert.Contains(t, w.Body.String(), "The CRC32C you specified did not match the calculated checksum.")
+
+		_, err := db.Stat("test-bucket/mismatch.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid headers", func(t *testing.T) {
+		tests := []map[string]string{
+			{"X-Amz-Checksum-Algorithm": "MD4"},
+			{"X-Amz-Checksum-Crc32c": "not base64"},
+			{"X-Amz-Checksum-Sha256": checksum("CRC32")},
+			{"X-Amz-Checksum-Algorithm": "SHA1", "X-Amz-Checksum-Crc32": checksum("CRC32")},
+		}
+
+		for _, headers := range tests {
+			w := serve("PUT", "invalid.txt", content, headers)
+			assert.Equal(t, http.StatusBadRequest, w.Code, headers)
+			assert.Contains(t, w.Body.String(), "<Code>InvalidRequest</Code>", headers)
+		}
+	})
+}
+
+func TestHandlePutObjectStorageClass(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	serve := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader("content"))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	listedClasses := func() map[string]string {
+		w := serve("GET", "/test-bucket?list-type=2", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var result ListBucketResultV2
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		classes := make(map[string]string)
+		for _, object := range result.Contents {
+			classes[object.Key] = object.StorageClass
+		}
+		return classes
+	}
+
+	t.Run("Round trip", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/glacier.txt", map[string]string{"X-Amz-Storage-Class": "GLACIER"})
+		require.Equal(t, http.StatusOK, w.Code)
+		w = serve("PUT", "/test-bucket/standard.txt", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		entry, err := db.Stat("test-bucket/glacier.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "GLACIER", entry.StorageClass)
+
+		for _, method := range []string{"GET", "HEAD"} {
+			w := serve(method, "/test-bucket/glacier.txt", nil)
+			require.Equal(t, http.StatusOK, w.Code, method)
+			assert.Equal(t, "GLACIER", w.Header().Get("X-Amz-Storage-Class"), method)
+
+			// Like S3 the header is omitted for STANDARD
+			w = serve(method, "/test-bucket/standard.txt", nil)
+			require.Equal(t, http.StatusOK, w.Code, method)
+			assert.Empty(t, w.Header().Get("X-Amz-Storage-Class"), method)
+		}
+
+		w = serve("GET", "/test-bucket/glacier.txt", map[string]string{"Range": "bytes=0-2"})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "GLACIER", w.Header().Get("X-Amz-Storage-Class"))
+
+		classes := listedClasses()
+		assert.Equal(t, "GLACIER", classes["glacier.txt"])
+		assert.Equal(t, "STANDARD", classes["standard.txt"])
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/copy.txt", map[string]string{"X-Amz-Copy-Source": "test-bucket/glacier.txt"})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "STANDARD", listedClasses()["copy.txt"])
+
+		// Changing the class of the object in place
+		w = serve("PUT", "/test-bucket/copy.txt", map[string]string{
+			"X-Amz-Copy-Source":   "test-bucket/copy.txt",
+			"X-Amz-Storage-Class": "STANDARD_IA",
+		})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "STANDARD_IA", listedClasses()["copy.txt"])
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		w := serve("PUT", "/test-bucket/glacier.txt", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		w = serve("HEAD", "/test-bucket/glacier.txt", nil)
+		assert.Empty(t, w.Header().Get("X-Amz-Storage-Class"))
+		assert.Equal(t, "STANDARD", listedClasses()["glacier.txt"])
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, storageClass := range []string{"COLD", "glacier"} {
+			w := serve("PUT", "/test-bucket/invalid.txt", map[string]string{"X-Amz-Storage-Class": storageClass})
+			assert.Equal(t, http.StatusBadRequest, w.Code, storageClass)
+			assert.Contains(t, w.Body.String(), "<Code>InvalidStorageClass</Code>", storageClass)
+		}
+
+		_, err := db.Stat("test-bucket/invalid.txt")
+		assert.Error(t, err)
+	})
+}
+
+// countingWriteFs counts the writes to the backend
+type countingWriteFs struct {
+	fs.Fs
+	writes int
+}
+
+func (f *countingWriteFs) WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	f.writes++
+	return f.Fs.WriteStreamContext(ctx, path, stream, contentLength, mode)
+}
+
+func TestHandlePutObjectUnchanged(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	backend := &countingWriteFs{Fs: s.client}
+	s.client = backend
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	checksum := func(content string) string {
+		hasher := checksumAlgorithms["CRC32C"]()
+		hasher.Write([]byte(content))
+		return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+	}
+	put := func(key, content string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(content))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	putWithChecksum := func(key, content string) *httptest.ResponseRecorder {
+		return put(key, content, map[string]string{"X-Amz-Checksum-Crc32c": checksum(content)})
+	}
+
+	t.Run("identical content", func(t *testing.T) {
+		backend.writes = 0
+		first := putWithChecksum("same.txt", "content")
+		require.Equal(t, http.StatusOK, first.Code)
+		second := putWithChecksum("same.txt", "content")
+		require.Equal(t, http.StatusOK, second.Code)
+
+		assert.Equal(t, 1, backend.writes)
+		assert.Equal(t, first.Header().Get("ETag"), second.Header().Get("ETag"))
+		assert.Equal(t, checksum("content"), second.Header().Get("X-Amz-Checksum-Crc32c"))
+
+		entry, err := db.Stat("test-bucket/same.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "CRC32C:"+checksum("content"), entry.Checksum)
+	})
+
+	t.Run("metadata of the skipped upload is applied", func(t *testing.T) {
+		backend.writes = 0
+		w := put("same.txt", "content", map[string]string{
+			"X-Amz-Checksum-Crc32c": checksum("content"),
+			"X-Amz-Tagging":         "env=prod",
+			"X-Amz-Storage-Class":   "GLACIER",
+		})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 0, backend.writes)
+
+		tags, err := db.GetTags("test-bucket/same.txt")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod"}, tags)
+		entry, err := db.Stat("test-bucket/same.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "GLACIER", entry.StorageClass)
+	})
+
+	t.Run("written", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			setup func()
+			put   func() *httptest.ResponseRecorder
+		}{
+			{"different content", nil, func() *httptest.ResponseRecorder { return putWithChecksum("same.txt", "changed") }},
+			{"without checksum", nil, func() *httptest.ResponseRecorder { return put("same.txt", "content", nil) }},
+			{"changed on backend", func() {
+				webdav.AddFile("/test-bucket/same.txt", []byte("other!!"))
+				webdav.SetModTime("/test-bucket/same.txt", time.Now().Add(time.Hour))
+			}, func() *httptest.ResponseRecorder { return putWithChecksum("same.txt", "content") }},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				require.Equal(t, http.StatusOK, putWithChecksum("same.txt", "content").Code)
+				if tt.setup != nil {
+					tt.setup()
+				}
+				backend.writes = 0
+				require.Equal(t, http.StatusOK, tt.put().Code)
+				assert.Equal(t, 1, backend.writes)
+			})
+		}
+	})
+}
+
+// inPlaceFs writes uploads directly to the destination, like backends without MOVE,
+// keeping what was read before the body failed
+type inPlaceFs struct {
+	fs.Fs
+}
+
+func (f *inPlaceFs) WriteStreamContext(ctx context.Context, path string, stream io.Reader, contentLength int64, mode os.FileMode) error {
+	data, err := io.ReadAll(stream)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if writeErr := f.Fs.WriteStream(path, strings.NewReader(string(data)), int64(len(data)), mode); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// abortedBody returns the content, then fails like the body of a dropped connection
+type abortedBody struct {
+	content io.Reader
+}
+
+func (b *abortedBody) Read(p []byte) (int, error) {
+	n, err := b.content.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestHandlePutObjectClientAbort(t *testing.T) {
+	tests := []struct {
+		name             string
+		inPlace          bool
+		existing         bool
+		cached           bool
+		expectedExisting bool
+	}{
+		{"new object", false, false, false, false},
+		{"overwrite keeps previous object", false, true, true, true},
+		{"overwrite of uncached object keeps it", false, true, false, true},
+		{"new object written in place", true, false, false, false},
+		{"overwrite written in place", true, true, true, false},
+		{"overwrite of uncached object written in place", true, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			if tt.existing {
+				webdav.AddFile("/test-bucket/file.txt", []byte("previous"))
+			}
+			if tt.cached {
+				stat, err := s.client.Stat("test-bucket/file.txt")
+				require.NoError(t, err)
+				require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/file.txt", Size: stat.Size(), LastModified: stat.ModTime().Unix(), Processed: true}))
+			}
+			if tt.inPlace {
+				s.client = &inPlaceFs{Fs: s.client}
+			}
+
+			router := mux.NewRouter()
+			s.SetupWriteRoutes(router)
+
+			req := httptest.NewRequest("PUT", "/test-bucket/file.txt", &abortedBody{content: strings.NewReader("partial")})
+			req.ContentLength = 100
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "<Code>IncompleteBody</Code>")
+
+			entry, err := db.Stat("test-bucket/file.txt")
+			_, statErr := s.client.Stat("test-bucket/file.txt")
+			if tt.expectedExisting {
+				if tt.cached {
+					require.NoError(t, err)
+					assert.Equal(t, int64(len("previous")), entry.Size)
+				}
+				require.NoError(t, statErr)
+				reader, err := s.client.ReadStream("test-bucket/file.txt")
+				require.NoError(t, err)
+				defer reader.Close()
+				content, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, "previous", string(content))
+			} else {
+				assert.Error(t, err, "Partial upload should not be cached")
+				assert.True(t, fs.IsNotFound(statErr), "Partial upload should be removed from the backend")
+			}
+		})
+	}
+
+	t.Run("canceled request", func(t *testing.T) {
+		s, db, _, cleanup := setupTestServer(t)
+		defer cleanup()
+		s.client = &inPlaceFs{Fs: s.client}
+
+		router := mux.NewRouter()
+		s.SetupWriteRoutes(router)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("PUT", "/test-bucket/canceled.txt", strings.NewReader("content")).WithContext(ctx)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		_, err := db.Stat("test-bucket/canceled.txt")
+		assert.Error(t, err)
+		_, err = s.client.Stat("test-bucket/canceled.txt")
+		assert.True(t, fs.IsNotFound(err))
+	})
+}
+
+func TestHandlePutObjectChunked(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// Like servers that do not accept chunked uploads
+	webdav.RequireContentLength()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+
+	content := strings.Repeat("chunked upload content ", 10000)
+
+	// A reader of unknown length makes the client send the body chunked
+	req, err := http.NewRequest("PUT", httpServer.URL+"/test-bucket/chunked.txt", io.MultiReader(strings.NewReader(content)))
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	entry, err := db.Stat("test-bucket/chunked.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), entry.Size)
+
+	webdavFs, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+	reader, err := webdavFs.ReadStream("test-bucket/chunked.txt")
+	require.NoError(t, err)
+	defer reader.Close()
+	stored, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(stored))
+}
+
+func TestHandlePutObjectMaxSize(t *testing.T) {
+	const maxSize = 1024
+
+	tests := []struct {
+		name           string
+		size           int
+		chunked        bool
+		expectedStatus int
+	}{
+		{name: "within limit", size: maxSize, expectedStatus: http.StatusOK},
+		{name: "too large content length", size: maxSize + 1, expectedStatus: http.StatusBadRequest},
+		{name: "chunked within limit", size: maxSize, chunked: true, expectedStatus: http.StatusOK},
+		{name: "chunked overflowing mid-stream", size: 64 * maxSize, chunked: true, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, webdav, cleanup := setupTestServer(t)
+			defer cleanup()
+
+			s.SetMaxObjectSize(maxSize)
+
+			var body io.Reader = strings.NewReader(strings.Repeat("x", tt.size))
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest("PUT", "/test-bucket/object.bin", body)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "object.bin"})
+			w := httptest.NewRecorder()
+
+			s.handlePutObject(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			webdavFs, err := webdav.CreateWebDAVFs()
+			require.NoError(t, err)
+			infos, _ := webdavFs.ReadDir("test-bucket")
+
+			if tt.expectedStatus != http.StatusOK {
+				assert.Contains(t, w.Body.String(), "<Code>EntityTooLarge</Code>")
+				assert.Empty(t, infos, "Rejected upload should leave no partial file")
+
+				_, err := db.Stat("test-bucket/object.bin")
+				assert.Error(t, err)
+				return
+			}
+
+			require.Len(t, infos, 1)
+			assert.Equal(t, int64(tt.size), infos[0].Size())
+		})
+	}
+}
+
+func TestHandlePutObjectFileMode(t *testing.T) {
+	s, _, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	root := t.TempDir()
+	client, err := fs.NewLocalFsWithOptions(root, fs.LocalOptions{DirMode: 0750})
+	require.NoError(t, err)
+	s.client = client
+	s.SetFileMode(0600)
+
+	router := mux.NewRouter()
+	s.SetupWriteRoutes(router)
+
+	req := httptest.NewRequest("PUT", "/test-bucket/nested/dir/file.txt", strings.NewReader("content"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	info, err := os.Stat(filepath.Join(root, "test-bucket", "nested", "dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	for _, dir := range []string{"test-bucket", "test-bucket/nested", "test-bucket/nested/dir"} {
+		info, err := os.Stat(filepath.Join(root, dir))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), dir)
+	}
+}
+
+func TestMaxBandwidthPerRequest(t *testing.T) {
+	s, _, _, cleanup := setupTestServer(t)
+	defer cleanup()
+	s.SetMaxBandwidthPerRequest(10000)
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	// 5000 bytes at 10000 bytes per second take at least half a second each way
+	content := strings.Repeat("x", 5000)
+	floor := 450 * time.Millisecond
+
+	start := time.Now()
+	req := httptest.NewRequest("PUT", "/test-bucket/throttled.txt", strings.NewReader(content))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.GreaterOrEqual(t, time.Since(start), floor, "upload")
+
+	start = time.Now()
+	req = httptest.NewRequest("GET", "/test-bucket/throttled.txt", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.String())
+	assert.GreaterOrEqual(t, time.Since(start), floor, "download")
+}
+
+func TestETagScanModeMD5Lazy(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+	s.SetETagScanMode(ETagScanModeMD5Lazy)
+
+	content := []byte("scanned content")
+	digest := md5.Sum(content)
+	expectedETag := "\"" + hex.EncodeToString(digest[:]) + "\""
+
+	webdav.AddFile("/test-bucket/scanned.txt", content)
+	require.NoError(t, db.Insert(fs.EntryInfo{
+		Path:         "test-bucket/scanned.txt",
+		Size:         int64(len(content)),
+		LastModified: 1700000000,
+		Processed:    true,
+	}))
+
+	head := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/test-bucket/scanned.txt", nil)
+		req = mux.SetURLVars(req, map[string]string{"bucket": "test-bucket", "key": "scanned.txt"})
+		w := httptest.NewRecorder()
+		s.handleHeadObject(w, req)
+		return w
+	}
+
+	// The first HEAD reads the object and stores its MD5
+	w := head()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, expectedETag, w.Header().Get("ETag"))
+
+	entry, err := db.Stat("test-bucket/scanned.txt")
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(digest[:]), entry.MD5)
+
+	// Later requests serve the stored digest without reading the object
+	requests := webdav.RequestCount()
+	w = head()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, expectedETag, w.Header().Get("ETag"))
+	assert.Equal(t, requests, webdav.RequestCount())
+}
+
+func TestETagScanModeMD5Upload(t *testing.T) {
+	for _, mode := range []string{ETagScanModeSynthetic, ETagScanModeMD5Lazy} {
+		t.Run(mode, func(t *testing.T) {
+			s, db, _, cleanup := setupTestServer(t)
+			defer cleanup()
+			s.SetETagScanMode(mode)
+
+			router := mux.NewRouter()
+			s.SetupWriteRoutes(router)
+
+			req := httptest.NewRequest("PUT", "/test-bucket/uploaded.txt", strings.NewReader("uploaded content"))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			entry, err := db.Stat("test-bucket/uploaded.txt")
+			require.NoError(t, err)
+			if mode == ETagScanModeSynthetic {
+				assert.Empty(t, entry.MD5)
+				assert.Equal(t, generateETag(entry.Path, entry.Size, entry.LastModified), w.Header().Get("ETag"))
+				return
+			}
+			digest := md5.Sum([]byte("uploaded content"))
+			assert.Equal(t, hex.EncodeToString(digest[:]), entry.MD5)
+			assert.Equal(t, "\""+entry.MD5+"\"", w.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestGetObjectAttributes(t *testing.T) {
+	s, db, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+
+	entry := fs.EntryInfo{
+		Path:         "test-bucket/file.txt",
+		Size:         5,
+		LastModified: 1700000000,
+		Processed:    true,
+		Checksum:     "CRC32C:yZRlqg==",
+		StorageClass: "GLACIER",
+	}
+	require.NoError(t, db.Insert(entry))
+	etag := strings.Trim(ETag(entry), "\"")
+
+	tests := []struct {
+		name         string
+		target       string
+		attributes   string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "etag and size",
+			target:       "/test-bucket/file.txt?attributes",
+			attributes:   "ETag,ObjectSize",
+			expectedCode: http.StatusOK,
+			expectedBody: "<GetObjectAttributesResponse><ETag>" + etag + "</ETag><ObjectSize>5</ObjectSize></GetObjectAttributesResponse>",
+		},
+		{
+			name:         "checksum and storage class",
+			target:       "/test-bucket/file.txt?attributes",
+			attributes:   "Checksum, StorageClass, ObjectParts",
+			expectedCode: http.StatusOK,
+			expectedBody: "<GetObjectAttributesResponse><Checksum><ChecksumCRC32C>yZRlqg==</ChecksumCRC32C></Checksum><StorageClass>GLACIER</StorageClass></GetObjectAttributesResponse>",
+		},
+		{
+			name:         "missing header",
+			target:       "/test-bucket/file.txt?attributes",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unknown attribute",
+			target:       "/test-bucket/file.txt?attributes",
+			attributes:   "ETag,Owner",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing object",
+			target:       "/test-bucket/missing.txt?attributes",
+			attributes:   "ETag",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.attributes != "" {
+				req.Header.Set("X-Amz-Object-Attributes", tt.attributes)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+				assert.Equal(t, formatHTTPTime(entry.LastModified), w.Header().Get("Last-Modified"))
+			}
+		})
+	}
+}
+
+func TestBackendErrorLogging(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	var logs strings.Builder
+	s.SetBackendLogger(log.New(&logs, "", 0))
+
+	router := mux.NewRouter()
+	s.SetupReadRoutes(router)
+	s.SetupWriteRoutes(router)
+
+	webdav.AddFile("/test-bucket/dir/failing.txt", []byte("content"))
+	require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/dir/failing.txt", Size: 7, LastModified: time.Now().Unix()}))
+
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		expectedCode int
+		expectedLog  string
+	}{
+		{"get", "GET", "", http.StatusNotFound, "S3: Backend read failed"},
+		{"put", "PUT", "new content", http.StatusInternalServerError, "S3: Backend write failed"},
+		{"delete", "DELETE", "", http.StatusInternalServerError, "S3: Backend remove failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			webdav.FailRequests(10, http.StatusBadGateway)
+			defer webdav.FailRequests(0, 0)
+
+			req := httptest.NewRequest(tt.method, "/test-bucket/dir/failing.txt", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, tt.expectedCode, w.Code)
+
+			// The log has the cause, the client only the generic error with the same request id
+			requestId := w.Header().Get("X-Amz-Request-Id")
+			require.NotEmpty(t, requestId)
+			assert.Contains(t, logs.String(), tt.expectedLog)
+			assert.Contains(t, logs.String(), "request-id="+requestId)
+			assert.Contains(t, logs.String(), `bucket=test-bucket key="dir/failing.txt"`)
+			assert.Contains(t, logs.String(), "502")
+			assert.NotContains(t, w.Body.String(), "502")
+		})
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	s, db, webdav, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	router := mux.NewRouter()
+	s.SetupWriteRoutes(router)
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	files := []string{
+		"test-bucket/logs/2023/a.log",
+		"test-bucket/logs/2023/01/b.log",
+		"test-bucket/logs/2024/c.log",
+		"test-bucket/logs-old/d.log",
+	}
+	for _, file := range files {
+		webdav.AddFile("/"+file, []byte("content"))
+	}
+	// A file not scanned into the cache yet is removed too
+	webdav.AddFile("/test-bucket/logs/2023/unscanned.log", []byte("content"))
+	require.NoError(t, syncer.New(s.client, db).Sync("test-bucket"))
+	webdav.AddFile("/test-bucket/logs/2023/01/unscanned.log", []byte("content"))
+
+	t.Run("disabled", func(t *testing.T) {
+		w := serve("/test-bucket?delete-prefix&prefix=logs/2023/")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	s.SetPrefixDelete(true)
+
+	t.Run("invalid prefix", func(t *testing.T) {
+		for _, prefix := range []string{"", "logs", "/logs/", "logs/../", "logs//2023/"} {
+			w := serve("/test-bucket?delete-prefix&prefix=" + url.QueryEscape(prefix))
+			assert.Equal(t, http.StatusBadRequest, w.Code, prefix)
+		}
+	})
+
+	t.Run("removes subtree", func(t *testing.T) {
+		w := serve("/test-bucket?delete-prefix&prefix=logs/2023/")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result DeletePrefixResult
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, DeletePrefixResult{XMLName: result.XMLName, Prefix: "logs/2023/", Deleted: 4}, result)
+
+		for _, path := range []string{"test-bucket/logs/2023/", "test-bucket/logs/2023/a.log", "test-bucket/logs/2023/01/", "test-bucket/logs/2023/01/b.log"} {
+			_, err := db.Stat(path)
+			assert.True(t, cache.IsNotFound(err), path)
+			_, err = s.client.Stat(path)
+			assert.True(t, fs.IsNotFound(err), path)
+		}
+		for _, path := range []string{"test-bucket/logs/2024/c.log", "test-bucket/logs-old/d.log"} {
+			_, err := db.Stat(path)
+			assert.NoError(t, err, path)
+			_, err = s.client.Stat(path)
+			assert.NoError(t, err, path)
+		}
+	})
+}
@@ -0,0 +1,444 @@
+package s3
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+// maxPostFieldsSize limits the size of all form fields preceding the file, like S3 does
+const maxPostFieldsSize = 20 << 10
+
+type postPolicyKey struct{}
+
+type PostResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type postPolicy struct {
+	Expiration string `json:"expiration"`
+	Conditions []any  `json:"conditions"`
+}
+
+// isPostObject checks if the request is a browser form upload to the bucket,
+// which carries its signature in the form instead of the headers or the query
+func isPostObject(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.URL.RawQuery != "" {
+		return false
+	}
+	if strings.Contains(strings.Trim(r.URL.Path, "/"), "/") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// readPostFields reads the form fields up to the file, returning the fields with
+// lowercase names, as S3 matches them case-insensitively, and the file part
+func readPostFields(r *http.Request) (map[string]string, *multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := make(map[string]string)
+	remaining := int64(maxPostFieldsSize)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return fields, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		// The fields following the file are ignored
+		if part.FormName() == "file" {
+			return fields, part, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		if err != nil {
+			return nil, nil, err
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, nil, errors.New("form fields are too large")
+		}
+		fields[strings.ToLower(part.FormName())] = string(value)
+	}
+}
+
+// postPolicyAccessKey returns the access key the policy of the form is signed with
+func postPolicyAccessKey(fields map[string]string) string {
+	if fields["x-amz-algorithm"] != "" {
+		accessKey, _, _ := strings.Cut(fields["x-amz-credential"], "/")
+		return accessKey
+	}
+	return fields["awsaccesskeyid"]
+}
+
+// postPolicyExemptFields are the form fields not required to be covered by a condition
+// of the policy, as they carry the policy and its signature, and the uploaded file
+var postPolicyExemptFields = map[string]bool{
+	"policy":          true,
+	"x-amz-signature": true,
+	"signature":       true,
+	"awsaccesskeyid":  true,
+	"file":            true,
+}
+
+// validatePostPolicy checks the signature of the policy of the form, its expiration and
+// conditions, and that no other form fields are given, returning the content length
+// range allowed by the policy, -1 if unlimited
+func validatePostPolicy(fields map[string]string, bucket, key string, credentials CredentialProvider) (int64, int64, error) {
+	policyBase64 := fields["policy"]
+	if policyBase64 == "" {
+		return 0, 0, errors.New("missing policy")
+	}
+
+	if fields["x-amz-algorithm"] != "" {
+		if fields["x-amz-algorithm"] != "AWS4-HMAC-SHA256" {
+			return 0, 0, errors.New("unsupported signature algorithm")
+		}
+		credentialParts := strings.Split(fields["x-amz-credential"], "/")
+		if len(credentialParts) < 5 {
+			return 0, 0, errors.New("invalid credential")
+		}
+		secretKey, ok := credentials.Lookup(postPolicyAccessKey(fields))
+		if !ok {
+			return 0, 0, errors.New("invalid credential")
+		}
+		signingKey := signingKeyV4(secretKey, credentialParts[1], credentialParts[2], credentialParts[3])
+		expected := hex.EncodeToString(hmacSHA256(signingKey, policyBase64))
+		if !hmac.Equal([]byte(expected), []byte(fields["x-amz-signature"])) {
+			return 0, 0, errors.New("signature does not match")
+		}
+	} else {
+		secretKey, ok := credentials.Lookup(postPolicyAccessKey(fields))
+		if !ok {
+			return 0, 0, errors.New("invalid access key")
+		}
+		mac := hmac.New(sha1.New, []byte(secretKey))
+		mac.Write([]byte(policyBase64))
+		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(expected), []byte(fields["signature"])) {
+			return 0, 0, errors.New("signature does not match")
+		}
+	}
+
+	policyJSON, err := base64.StdEncoding.DecodeString(policyBase64)
+	if err != nil {
+		return 0, 0, errors.New("invalid policy encoding")
+	}
+	var policy postPolicy
+	if err := json.Unmarshal(policyJSON, &policy); err != nil {
+		return 0, 0, errors.New("invalid policy document")
+	}
+
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return 0, 0, errors.New("invalid policy expiration")
+	}
+	if time.Now().After(expiration) {
+		return 0, 0, errors.New("policy expired")
+	}
+
+	// Every form field must be covered by a condition, except the ones carrying the signature
+	covered := make(map[string]bool)
+	value := func(field string) string {
+		field = strings.ToLower(strings.TrimPrefix(field, "$"))
+		covered[field] = true
+		switch field {
+		case "bucket":
+			return bucket
+		case "key":
+			return key
+		default:
+			return fields[field]
+		}
+	}
+
+	minSize, maxSize := int64(0), int64(-1)
+	for _, condition := range policy.Conditions {
+		switch condition := condition.(type) {
+		case map[string]any:
+			for field, expected := range condition {
+				if value(field) != fmt.Sprint(expected) {
+					return 0, 0, fmt.Errorf("condition failed: %s", field)
+				}
+			}
+		case []any:
+			if len(condition) != 3 {
+				return 0, 0, errors.New("invalid policy condition")
+			}
+			op, _ := condition[0].(string)
+			switch strings.ToLower(op) {
+			case "eq":
+				field, _ := condition[1].(string)
+				if value(field) != fmt.Sprint(condition[2]) {
+					return 0, 0, fmt.Errorf("condition failed: %s", field)
+				}
+			case "starts-with":
+				field, _ := condition[1].(string)
+				if !strings.HasPrefix(value(field), fmt.Sprint(condition[2])) {
+					return 0, 0, fmt.Errorf("condition failed: %s", field)
+				}
+			case "content-length-range":
+				minSize, err = strconv.ParseInt(fmt.Sprint(condition[1]), 10, 64)
+				if err == nil {
+					maxSize, err = strconv.ParseInt(fmt.Sprint(condition[2]), 10, 64)
+				}
+				if err != nil {
+					return 0, 0, errors.New("invalid content-length-range")
+				}
+			default:
+				return 0, 0, fmt.Errorf("unsupported policy condition: %s", op)
+			}
+		default:
+			return 0, 0, errors.New("invalid policy condition")
+		}
+	}
+
+	var extra []string
+	for field := range fields {
+		if !covered[field] && !postPolicyExemptFields[field] && !strings.HasPrefix(field, "x-ignore-") {
+			extra = append(extra, field)
+		}
+	}
+	if len(extra) > 0 {
+		slices.Sort(extra)
+		return 0, 0, fmt.Errorf("Extra input fields: %s", strings.Join(extra, ", "))
+	}
+	return minSize, maxSize, nil
+}
+
+// objectURL returns the URL of the object on this server
+func objectURL(r *http.Request, bucket, key string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if isVirtualHosted(r) {
+		return (&url.URL{Scheme: scheme, Host: r.Host, Path: "/" + key}).String()
+	}
+	return (&url.URL{Scheme: scheme, Host: r.Host, Path: "/" + bucket + "/" + key}).String()
+}
+
+// handlePostObject stores the file of the browser form upload, authenticated by the signed policy of the form
+func (s *server) handlePostObject(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	access_log.AddLogContext(r, "post:%s", bucket)
+
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	fields, file, err := readPostFields(r)
+	if err != nil {
+		writeS3ErrorMessage(w, r, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.", http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-form")
+		return
+	}
+	if file == nil {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "POST requires exactly one file upload per request.", http.StatusBadRequest)
+		return
+	}
+
+	// The file name given by the browser replaces the placeholder of the key
+	key := strings.ReplaceAll(fields["key"], "${filename}", path.Base(file.FileName()))
+	if key == "" || strings.HasSuffix(key, "/") {
+		writeS3ErrorMessage(w, r, "InvalidArgument", "Bucket POST must contain a field named 'key'.  If it is specified, please check the order of the fields.", http.StatusBadRequest)
+		return
+	}
+	objectPath := fs.PathFromBucketAndKey(bucket, key)
+	access_log.AddLogContext(r, "key:%s", key)
+
+	storageClass, ok := parseStorageClass(fields["x-amz-storage-class"])
+	if !ok {
+		writeS3Error(w, r, "InvalidStorageClass", http.StatusBadRequest)
+		return
+	}
+
+	successStatus := http.StatusNoContent
+	switch fields["success_action_status"] {
+	case "200":
+		successStatus = http.StatusOK
+	case "201":
+		successStatus = http.StatusCreated
+	}
+
+	bodyReader := s.throttle(r, file)
+	if credentials, ok := r.Context().Value(postPolicyKey{}).(CredentialProvider); ok {
+		minSize, maxSize, err := validatePostPolicy(fields, bucket, key, credentials)
+		if err != nil {
+			writeS3ErrorMessage(w, r, "AccessDenied", "Invalid according to Policy: "+err.Error(), http.StatusForbidden)
+			access_log.AddLogContext(r, "policy-fail")
+			return
+		}
+		if maxSize >= 0 {
+			bodyReader = newSizeLimiter(bodyReader, maxSize)
+		}
+		if minSize > 0 {
+			bodyReader = newMinSizeChecker(bodyReader, minSize)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), accessKeyKey{}, postPolicyAccessKey(fields)))
+	}
+	if s.maxObjectSize > 0 {
+		bodyReader = newSizeLimiter(bodyReader, s.maxObjectSize)
+	}
+
+	// The MD5 of the content is stored to be served as the ETag
+	var md5Hasher *hashVerifier
+	if s.etagScanMode != ETagScanModeSynthetic {
+		md5Hasher = newMD5Hasher(bodyReader)
+		bodyReader = md5Hasher
+	}
+
+	// Check content type against the bucket allow-list, sniffing it if not provided
+	if allowed := s.allowedContentTypes[bucket]; len(allowed) > 0 {
+		contentType := fields["content-type"]
+		if contentType == "" {
+			contentType = file.Header.Get("Content-Type")
+		}
+		if contentType == "" {
+			buffered := bufio.NewReader(bodyReader)
+			head, _ := buffered.Peek(512)
+			contentType = http.DetectContentType(head)
+			bodyReader = buffered
+		}
+		if !isContentTypeAllowed(contentType, allowed) {
+			writeS3ErrorMessage(w, r, "AccessDenied", "Content type is not allowed in this bucket", http.StatusForbidden)
+			access_log.AddLogContext(r, "content-type-denied:%s", contentType)
+			return
+		}
+	}
+
+	// Upload to an alias follows the alias writes policy like PUT
+	if target, err := s.db.GetAlias(objectPath); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	} else if target != "" {
+		if s.aliasWrites != AliasWritesRedirect {
+			writeS3ErrorMessage(w, r, "OperationAborted", "Object is an alias", http.StatusConflict)
+			access_log.AddLogContext(r, "alias-rejected")
+			return
+		}
+		access_log.AddLogContext(r, "alias-redirect:%s", target)
+		objectPath = target
+	}
+
+	// Backend write and cache update of the same key must not interleave
+	if s.writeConflicts == WriteConflictsReject {
+		unlock, ok := s.writeLocks.tryLock(objectPath)
+		if !ok {
+			writeS3ErrorMessage(w, r, "OperationAborted", "A conflicting write to this object is in progress", http.StatusConflict)
+			access_log.AddLogContext(r, "write-conflict")
+			return
+		}
+		defer unlock()
+	} else {
+		defer s.writeLocks.lock(objectPath)()
+	}
+
+	err = s.client.WriteStreamContext(r.Context(), objectPath, bodyReader, -1, s.fileMode)
+	if errors.Is(err, ErrEntityTooLarge) {
+		writeS3Error(w, r, "EntityTooLarge", http.StatusBadRequest)
+		access_log.AddLogContext(r, "too-large")
+		return
+	} else if errors.Is(err, ErrEntityTooSmall) {
+		writeS3Error(w, r, "EntityTooSmall", http.StatusBadRequest)
+		access_log.AddLogContext(r, "too-small")
+		return
+	} else if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "write", objectPath, err)
+		access_log.AddLogContext(r, "remote-fail")
+		return
+	}
+
+	stat, err := s.client.Stat(objectPath)
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		s.logBackendError(w, r, "stat", objectPath, err)
+		access_log.AddLogContext(r, "stat-fail")
+		return
+	}
+
+	entryInfo := fs.EntryInfo{
+		Path:         objectPath,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime().Unix(),
+		Processed:    true,
+		Owner:        AccessKey(r),
+		StorageClass: storageClass,
+	}
+	if md5Hasher != nil {
+		entryInfo.MD5 = md5Hasher.Sum()
+	}
+
+	if err := s.db.Insert(append(fs.BaseDirEntries(objectPath), entryInfo)...); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to insert object metadata: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	s.negativeCache.invalidate(entryInfo)
+	defer s.listCache.invalidate(bucket)
+
+	// The new object replaces the tags of the previous one
+	if err := s.db.SetTags(objectPath, nil); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to update tags: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	etag := ETag(entryInfo)
+	location := objectURL(r, bucket, key)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Location", location)
+	w.Header().Set(versionIdHeader, generateVersionId(etag))
+
+	if successStatus != http.StatusCreated {
+		w.WriteHeader(successStatus)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(successStatus)
+	xml.NewEncoder(w).Encode(PostResponse{
+		Location: location,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag,
+	})
+}
@@ -0,0 +1,79 @@
+package s3
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+)
+
+type BucketStats struct {
+	Name      string     `json:"name"`
+	Processed int        `json:"processed"`
+	Pending   int        `json:"pending"`
+	TotalSize int64      `json:"totalSize"`
+	LastSync  *time.Time `json:"lastSync,omitempty"`
+}
+
+type StatsResult struct {
+	Buckets []BucketStats `json:"buckets"`
+}
+
+// handleStats returns object counts and sizes of all buckets,
+// or of the single bucket given in the path
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	bucket, single := mux.Vars(r)["bucket"]
+
+	var buckets []string
+	if single {
+		access_log.AddLogContext(r, "stats:%s", bucket)
+		if !s.isBucketAllowed(bucket) {
+			writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+			access_log.AddLogContext(r, "no-such-bucket:%s", bucket)
+			return
+		}
+		buckets = []string{bucket}
+	} else {
+		access_log.AddLogContext(r, "stats")
+		buckets = s.Buckets()
+	}
+
+	result := StatsResult{
+		Buckets: make([]BucketStats, 0, len(buckets)),
+	}
+
+	for _, bucket := range buckets {
+		processed, pending, totalSize, err := s.db.GetStats(bucket + "/")
+		if err != nil {
+			writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+			return
+		}
+
+		stats := BucketStats{
+			Name:      bucket,
+			Processed: processed,
+			Pending:   pending,
+			TotalSize: totalSize,
+		}
+		if s.sync != nil {
+			if lastSync := s.sync.LastSync(bucket); !lastSync.IsZero() {
+				stats.LastSync = &lastSync
+			}
+		}
+		result.Buckets = append(result.Buckets, stats)
+	}
+
+	if single {
+		writeJSON(w, result.Buckets[0])
+	} else {
+		writeJSON(w, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
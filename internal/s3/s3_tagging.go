@@ -0,0 +1,172 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"s3-to-webdav/internal/access_log"
+	"s3-to-webdav/internal/fs"
+)
+
+// Tag limits of S3
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// validateTags checks the tags against the limits of S3
+func validateTags(tags []Tag) (map[string]string, error) {
+	if len(tags) > maxObjectTags {
+		return nil, fmt.Errorf("Object tags cannot be greater than %d", maxObjectTags)
+	}
+
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if keyLength := utf8.RuneCountInString(tag.Key); keyLength == 0 || keyLength > maxTagKeyLength {
+			return nil, errors.New("The TagKey you have provided is invalid")
+		}
+		if utf8.RuneCountInString(tag.Value) > maxTagValueLength {
+			return nil, errors.New("The TagValue you have provided is invalid")
+		}
+		if _, ok := result[tag.Key]; ok {
+			return nil, errors.New("Cannot provide multiple Tags with the same key")
+		}
+		result[tag.Key] = tag.Value
+	}
+	return result, nil
+}
+
+// parseTaggingHeader parses the URL-encoded tags of the x-amz-tagging header
+func parseTaggingHeader(header string) (map[string]string, error) {
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, errors.New("The header 'x-amz-tagging' shall be encoded as UTF-8 then URLEncoded URL query parameters without tag name duplicates.")
+	}
+
+	var tags []Tag
+	for key, keyValues := range values {
+		for _, value := range keyValues {
+			tags = append(tags, Tag{Key: key, Value: value})
+		}
+	}
+	return validateTags(tags)
+}
+
+// subresourceObject resolves the object of the subresource request, like ?tagging or ?acl,
+// writing the error if it does not exist
+func (s *server) subresourceObject(w http.ResponseWriter, r *http.Request, operation string) (string, bool) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+	path := fs.PathFromBucketAndKey(bucket, key)
+
+	access_log.AddLogContext(r, "%s:%s/%s", operation, bucket, key)
+
+	// Validate bucket is allowed
+	if !s.isBucketAllowed(bucket) {
+		writeS3Error(w, r, "NoSuchBucket", http.StatusNotFound)
+		return "", false
+	}
+
+	entryInfo, err := s.db.Stat(path)
+	if err != nil || entryInfo.IsDir {
+		writeS3Error(w, r, "NoSuchKey", http.StatusNotFound)
+		return "", false
+	}
+	return path, true
+}
+
+func (s *server) handleGetObjectTagging(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.subresourceObject(w, r, "get-tagging")
+	if !ok {
+		return
+	}
+
+	tags, err := s.db.GetTags(path)
+	if err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+
+	result := Tagging{TagSet: make([]Tag, 0, len(tags))}
+	for key, value := range tags {
+		result.TagSet = append(result.TagSet, Tag{Key: key, Value: value})
+	}
+	sort.Slice(result.TagSet, func(i, j int) bool {
+		return result.TagSet[i].Key < result.TagSet[j].Key
+	})
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *server) handlePutObjectTagging(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.subresourceObject(w, r, "put-tagging")
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, r, "IncompleteBody", http.StatusBadRequest)
+		return
+	}
+
+	var tagging Tagging
+	if err := xml.Unmarshal(body, &tagging); err != nil {
+		writeS3Error(w, r, "MalformedXML", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := validateTags(tagging.TagSet)
+	if err != nil {
+		writeS3ErrorMessage(w, r, "InvalidTag", err.Error(), http.StatusBadRequest)
+		access_log.AddLogContext(r, "invalid-tags")
+		return
+	}
+
+	if err := s.db.SetTags(path, tags); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to update tags: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	access_log.AddLogContext(r, "tags:%d", len(tags))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handleDeleteObjectTagging(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.subresourceObject(w, r, "delete-tagging")
+	if !ok {
+		return
+	}
+
+	if err := s.db.SetTags(path, nil); err != nil {
+		writeS3Error(w, r, "InternalError", http.StatusInternalServerError)
+		log.Printf("Failed to remove tags: %v", err)
+		access_log.AddLogContext(r, "db-fail")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
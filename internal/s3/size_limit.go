@@ -0,0 +1,52 @@
+package s3
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrEntityTooLarge is returned when the body exceeds the maximum object size
+var ErrEntityTooLarge = errors.New("EntityTooLarge")
+
+// ErrEntityTooSmall is returned when the body is shorter than the minimum object size
+var ErrEntityTooSmall = errors.New("EntityTooSmall")
+
+// sizeLimiter fails reading the body once it exceeds the limit, so a body of unknown
+// length is aborted before it is stored
+type sizeLimiter struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func newSizeLimiter(reader io.Reader, limit int64) *sizeLimiter {
+	return &sizeLimiter{reader: reader, remaining: limit}
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrEntityTooLarge
+	}
+	return n, err
+}
+
+// minSizeChecker fails reading the end of the body if it is shorter than the minimum,
+// so the write is aborted instead of storing it
+type minSizeChecker struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func newMinSizeChecker(reader io.Reader, minimum int64) *minSizeChecker {
+	return &minSizeChecker{reader: reader, remaining: minimum}
+}
+
+func (c *minSizeChecker) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		return n, ErrEntityTooSmall
+	}
+	return n, err
+}
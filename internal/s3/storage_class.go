@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"net/http"
+	"slices"
+
+	"s3-to-webdav/internal/fs"
+)
+
+// defaultStorageClass is the storage class of objects uploaded without one,
+// and of the ones discovered by the sync
+const defaultStorageClass = "STANDARD"
+
+// storageClasses are the accepted values of x-amz-storage-class. All objects are
+// stored the same way, the class is only recorded and reported back to clients
+var storageClasses = []string{
+	"STANDARD",
+	"REDUCED_REDUNDANCY",
+	"STANDARD_IA",
+	"ONEZONE_IA",
+	"INTELLIGENT_TIERING",
+	"GLACIER",
+	"GLACIER_IR",
+	"DEEP_ARCHIVE",
+}
+
+// parseStorageClass returns the storage class requested on upload, STANDARD if none
+func parseStorageClass(value string) (string, bool) {
+	if value == "" {
+		return defaultStorageClass, true
+	}
+	return value, slices.Contains(storageClasses, value)
+}
+
+// storageClassOf returns the storage class of the object
+func storageClassOf(entryInfo fs.EntryInfo) string {
+	if entryInfo.StorageClass == "" {
+		return defaultStorageClass
+	}
+	return entryInfo.StorageClass
+}
+
+// setStorageClassHeader sets the header of the storage class of the object,
+// which like S3 is omitted for STANDARD
+func setStorageClassHeader(w http.ResponseWriter, entryInfo fs.EntryInfo) {
+	if storageClass := storageClassOf(entryInfo); storageClass != defaultStorageClass {
+		w.Header().Set("X-Amz-Storage-Class", storageClass)
+	}
+}
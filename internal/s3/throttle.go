@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttledReader limits the rate of reads with a token bucket, so a single request
+// cannot take all of the bandwidth. Tokens accrue at rate bytes per second, up to
+// a burst of one second, and a read waits off the bytes taken beyond the tokens
+type throttledReader struct {
+	reader io.Reader
+	ctx    context.Context
+	rate   int64
+	tokens int64
+	last   time.Time
+}
+
+func newThrottledReader(ctx context.Context, reader io.Reader, bytesPerSecond int64) *throttledReader {
+	return &throttledReader{reader: reader, ctx: ctx, rate: bytesPerSecond, last: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		if waitErr := t.take(int64(n)); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// take deducts n tokens, sleeping until the bucket is no longer in debt
+func (t *throttledReader) take(n int64) error {
+	now := time.Now()
+	elapsed := now.Sub(t.last)
+	if elapsed > time.Second {
+		elapsed = time.Second
+	}
+	t.tokens += int64(elapsed.Seconds() * float64(t.rate))
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+	t.tokens -= n
+	if t.tokens >= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(float64(-t.tokens) / float64(t.rate) * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"s3-to-webdav/internal/access_log"
+)
+
+type virtualHostKey struct{}
+
+// bucketFromHost returns the bucket of the virtual-hosted-style host, addressed
+// as bucket.domain with an optional port
+func bucketFromHost(host, domain string) (string, bool) {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	bucket, ok := strings.CutSuffix(strings.ToLower(host), "."+domain)
+	if !ok || !isValidBucketName(bucket) {
+		return "", false
+	}
+	return bucket, true
+}
+
+// VirtualHostMiddleware serves virtual-hosted-style requests, with the bucket in the
+// Host header under the domain, by prefixing their path with the bucket before routing.
+// The host is left as sent, and the v4 signature is checked against the original path
+func VirtualHostMiddleware(domain string, next http.Handler) http.Handler {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+
+	// Skip rewriting if no domain is configured
+	if domain == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, ok := bucketFromHost(r.Host, domain)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		access_log.AddLogContext(r, "virtual-host:%s", bucket)
+
+		rewritten := r.WithContext(context.WithValue(r.Context(), virtualHostKey{}, r.URL.Path))
+		rewrittenURL := *r.URL
+		if rewrittenURL.Path == "" || rewrittenURL.Path == "/" {
+			rewrittenURL.Path = "/" + bucket
+		} else {
+			rewrittenURL.Path = "/" + bucket + rewrittenURL.Path
+		}
+		if rewrittenURL.RawPath != "" {
+			rewrittenURL.RawPath = "/" + bucket + rewrittenURL.RawPath
+		}
+		rewritten.URL = &rewrittenURL
+		next.ServeHTTP(w, rewritten)
+	})
+}
+
+// isVirtualHosted returns whether the request was addressed virtual-hosted-style
+func isVirtualHosted(r *http.Request) bool {
+	_, ok := r.Context().Value(virtualHostKey{}).(string)
+	return ok
+}
+
+// signedPath returns the path as sent by the client, before the virtual-hosted-style
+// rewrite, which is the one covered by the v4 signature
+func signedPath(r *http.Request) string {
+	if path, ok := r.Context().Value(virtualHostKey{}).(string); ok {
+		return path
+	}
+	return r.URL.Path
+}
@@ -1,6 +1,7 @@
 package sync
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -13,21 +14,90 @@ import (
 	"s3-to-webdav/internal/fs"
 )
 
+const (
+	// DefaultConcurrency is the default number of directories read in parallel
+	DefaultConcurrency = 2
+	// DefaultBatchSize is the default number of pending directories fetched at once
+	DefaultBatchSize = 50
+)
+
 // Sync handles synchronization between WebDAV server and database
 type Sync struct {
 	client fs.Fs
 	db     cache.Cache
 
+	concurrency int
+	batchSize   int
+	dryRun      bool
+	computeMD5  bool
+	onInsert    func(entries ...fs.EntryInfo)
+
 	// Statistics
 	lastStatus time.Time
+
+	lastSyncMu sync.Mutex
+	lastSync   map[string]time.Time
 }
 
 // New creates a new WebDAV synchronizer
 func New(client fs.Fs, db cache.Cache) *Sync {
 	return &Sync{
-		client: client,
-		db:     db,
+		client:      client,
+		db:          db,
+		concurrency: DefaultConcurrency,
+		batchSize:   DefaultBatchSize,
+	}
+}
+
+// SetConcurrency sets the number of directories read in parallel and the number
+// of pending directories fetched at once, non-positive values use the defaults
+func (ws *Sync) SetConcurrency(workers, batchSize int) {
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	ws.concurrency = workers
+	ws.batchSize = batchSize
+}
+
+// SetDryRun makes Clean and Sync only log the changes they would make,
+// without modifying the backend or the database
+func (ws *Sync) SetDryRun(dryRun bool) {
+	ws.dryRun = dryRun
+}
+
+// SetComputeMD5 makes the scan read every new or changed file to store the MD5
+// of its content, which is then served as the ETag
+func (ws *Sync) SetComputeMD5(enabled bool) {
+	ws.computeMD5 = enabled
+}
+
+// SetOnInsert sets the function called with the entries the sync added or updated
+// in the database, e.g. to drop what callers cached about them
+func (ws *Sync) SetOnInsert(fn func(entries ...fs.EntryInfo)) {
+	ws.onInsert = fn
+}
+
+// insert stores the entries in the database and reports them to the insert callback
+func (ws *Sync) insert(entries ...fs.EntryInfo) error {
+	if err := ws.db.Insert(entries...); err != nil {
+		return err
+	}
+	if ws.onInsert != nil {
+		ws.onInsert(entries...)
+	}
+	return nil
+}
+
+// logChange logs the change made to the backend or the database,
+// or the one that would be made in dry run
+func (ws *Sync) logChange(format string, args ...any) {
+	if ws.dryRun {
+		format = "(dry run) " + format
 	}
+	log.Printf(format, args...)
 }
 
 func (ws *Sync) Clean(bucket string) error {
@@ -38,21 +108,33 @@ func (ws *Sync) Clean(bucket string) error {
 	rescanned := 0
 	errors := 0
 
+	skip := 0
+
 	for {
-		dirs, err := ws.db.ListDanglingDirs(bucket+"/", 50)
+		dirs, err := ws.db.ListDanglingDirs(bucket+"/", skip+50)
 		if err != nil {
 			return fmt.Errorf("failed to list empty dirs: %v", err)
-		} else if len(dirs) == 0 {
+		}
+
+		// Nothing is changed in dry run, so the dirs already seen are listed again
+		dirs = dirs[min(skip, len(dirs)):]
+		if len(dirs) == 0 {
 			break
 		}
+		if ws.dryRun {
+			skip += len(dirs)
+		}
 
 		for _, dir := range dirs {
 			infos, err := ws.client.ReadDir(dir.Path)
 
 			if fs.IsNotFound(err) {
-				if err := ws.db.Delete(dir.Path); err != nil {
-					log.Printf("Clean: Failed to delete missing dir %s from database: %v", dir.Path, err)
-					errors++
+				ws.logChange("Clean: Deleting missing dir %s from database", dir.Path)
+				if !ws.dryRun {
+					if err := ws.db.Delete(dir.Path); err != nil {
+						log.Printf("Clean: Failed to delete missing dir %s from database: %v", dir.Path, err)
+						errors++
+					}
 				}
 				missing++
 			} else if err != nil && !os.IsNotExist(err) {
@@ -60,14 +142,20 @@ func (ws *Sync) Clean(bucket string) error {
 				errors++
 			} else if len(infos) > 0 {
 				// Has files, re-process directory
-				if _, err := ws.db.SetProcessed(dir.Path, false, false); err != nil {
+				ws.logChange("Clean: Marking dir %s for rescan", dir.Path)
+				if ws.dryRun {
+					rescanned++
+				} else if _, err := ws.db.SetProcessed(dir.Path, false, false); err != nil {
 					log.Printf("Clean: Failed to mark dir %s as unprocessed: %v", dir.Path, err)
 					errors++
 				} else {
 					rescanned++
 				}
 			} else {
-				if err := ws.client.Remove(dir.Path + "/"); err == nil {
+				ws.logChange("Clean: Removing empty dir %s", dir.Path)
+				if ws.dryRun {
+					removed++
+				} else if err := ws.client.Remove(dir.Path + "/"); err == nil {
 					ws.db.Delete(dir.Path)
 					removed++
 				} else {
@@ -86,14 +174,19 @@ func (ws *Sync) Clean(bucket string) error {
 	return nil
 }
 
-// Sync performs a sync of WebDAV content to the database
+// Sync performs a sync of WebDAV content to the database,
+// in dry run it only logs the entries the sync would change
 func (ws *Sync) Sync(bucket string) error {
+	if ws.dryRun {
+		return ws.diff(bucket)
+	}
+
 	start := time.Now()
 	prefix := bucket + "/"
 
 	// Ensure root directory entry exists
 	if entry, err := ws.db.Stat(prefix); err != nil || !entry.IsDir {
-		err := ws.db.Insert(fs.EntryInfo{
+		err := ws.insert(fs.EntryInfo{
 			Path:         prefix,
 			Size:         0,
 			LastModified: time.Now().Unix(),
@@ -110,20 +203,19 @@ func (ws *Sync) Sync(bucket string) error {
 		return err
 	} else if unprocessedCount == 0 {
 		log.Printf("Sync: No unprocessed entries for %s, skipping sync", bucket)
+		ws.setLastSync(bucket, time.Now())
 		return nil
 	} else {
 		log.Printf("Sync: %d processed and %d unprocessed entries for %s, starting sync",
 			processedCount, unprocessedCount, bucket)
 	}
 
-	const maxParallel = 2
-
 	send := make(chan fs.EntryInfo)
 	recv := make(chan error)
 	wg := sync.WaitGroup{}
-	wg.Add(maxParallel)
+	wg.Add(ws.concurrency)
 
-	for i := 0; i < maxParallel; i++ {
+	for i := 0; i < ws.concurrency; i++ {
 		go func() {
 			defer wg.Done()
 			for dir := range send {
@@ -139,7 +231,7 @@ func (ws *Sync) Sync(bucket string) error {
 	pending := 0
 
 	for {
-		queue, err := ws.db.ListPendingDirs(prefix, 50)
+		queue, err := ws.db.ListPendingDirs(prefix, ws.batchSize)
 		if err != nil {
 			log.Printf("Sync: Failed to list unprocessed directories: %v", err)
 			break
@@ -184,6 +276,164 @@ func (ws *Sync) Sync(bucket string) error {
 	}
 
 	log.Printf("Sync: WebDAV sync completed in %v", time.Since(start))
+	ws.setLastSync(bucket, time.Now())
+	return nil
+}
+
+// Discrepancies counts the differences between the backend and the database of a bucket
+type Discrepancies struct {
+	// MissingFromCache are the entries on the backend not known to the database
+	MissingFromCache int
+	// MissingOnBackend are the entries of the database removed from the backend
+	MissingOnBackend int
+	// Mismatched are the files whose size or modification time differs
+	Mismatched int
+}
+
+// Total returns the number of all discrepancies
+func (d Discrepancies) Total() int {
+	return d.MissingFromCache + d.MissingOnBackend + d.Mismatched
+}
+
+// diff compares the whole bucket on the backend with the database, logging the entries
+// a full sync would add, update or delete, without modifying the database
+func (ws *Sync) diff(bucket string) error {
+	start := time.Now()
+
+	found, err := ws.compare(bucket, func(path string) {
+		ws.logChange("Sync: Adding %s", path)
+	}, func(path string) {
+		ws.logChange("Sync: Deleting %s", path)
+	}, func(path string) {
+		ws.logChange("Sync: Updating %s", path)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sync: Found %d to add, %d to update and %d to delete for %s in %v",
+		found.MissingFromCache, found.Mismatched, found.MissingOnBackend, bucket, time.Since(start))
+	return nil
+}
+
+// Verify compares the whole bucket on the backend with the database, logging every
+// discrepancy without modifying either of them
+func (ws *Sync) Verify(bucket string) (Discrepancies, error) {
+	start := time.Now()
+
+	found, err := ws.compare(bucket, func(path string) {
+		log.Printf("Verify: Missing from cache %s", path)
+	}, func(path string) {
+		log.Printf("Verify: Missing on backend %s", path)
+	}, func(path string) {
+		log.Printf("Verify: Size or modification time mismatch %s", path)
+	})
+	if err != nil {
+		return found, err
+	}
+
+	log.Printf("Verify: Found %d missing from cache, %d missing on backend and %d mismatched for %s in %v",
+		found.MissingFromCache, found.MissingOnBackend, found.Mismatched, bucket, time.Since(start))
+	return found, nil
+}
+
+// compare walks the bucket on the backend and the database side by side, calling
+// the function of each kind of discrepancy with the path of the entry
+func (ws *Sync) compare(bucket string, missingFromCache, missingOnBackend, mismatched func(path string)) (Discrepancies, error) {
+	var result Discrepancies
+	queue := []string{bucket + "/"}
+
+	for len(queue) > 0 {
+		dir := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		infos, err := ws.client.ReadDir(dir)
+		if fs.IsNotFound(err) {
+			infos = nil
+		} else if err != nil {
+			return result, fmt.Errorf("failed to read directory %s: %v", dir, err)
+		}
+
+		found := make(map[string]bool, len(infos))
+		for _, info := range infos {
+			path := dir + info.Name()
+			if info.IsDir() {
+				path += "/"
+				queue = append(queue, path)
+			}
+			found[path] = true
+
+			entry, err := ws.db.Stat(path)
+			if err != nil {
+				missingFromCache(path)
+				result.MissingFromCache++
+			} else if !info.IsDir() && (entry.Size != info.Size() || entry.LastModified != info.ModTime().Unix()) {
+				mismatched(path)
+				result.Mismatched++
+			}
+		}
+
+		for marker := ""; ; {
+			children, truncated, err := ws.db.List(dir, marker, true, ws.batchSize)
+			if err != nil {
+				return result, err
+			}
+
+			for _, child := range children {
+				if !found[child.Path] {
+					missingOnBackend(child.Path)
+					result.MissingOnBackend++
+				}
+			}
+
+			if !truncated || len(children) == 0 {
+				break
+			}
+			marker = children[len(children)-1].Path
+		}
+	}
+
+	return result, nil
+}
+
+// LastSync returns the time the bucket was last synced completely,
+// or zero time if it was not synced by this instance yet
+func (ws *Sync) LastSync(bucket string) time.Time {
+	ws.lastSyncMu.Lock()
+	defer ws.lastSyncMu.Unlock()
+	return ws.lastSync[bucket]
+}
+
+func (ws *Sync) setLastSync(bucket string, t time.Time) {
+	ws.lastSyncMu.Lock()
+	defer ws.lastSyncMu.Unlock()
+	if ws.lastSync == nil {
+		ws.lastSync = make(map[string]time.Time)
+	}
+	ws.lastSync[bucket] = t
+}
+
+// ScanDir reads the directory from the backend into the database unless it is already
+// processed, with recursive it also reads all its pending subdirectories
+func (ws *Sync) ScanDir(path string, recursive bool) error {
+	if err := ws.walkDir(path); err != nil {
+		return err
+	}
+
+	for recursive {
+		queue, err := ws.db.ListPendingDirs(path, ws.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list unprocessed directories: %v", err)
+		} else if len(queue) == 0 {
+			break
+		}
+
+		for _, dir := range queue {
+			if err := ws.walkDir(dir.Path); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -203,7 +453,20 @@ func (ws *Sync) walkDir(path string) error {
 		return err
 	}
 
-	batchInfos := make([]fs.EntryInfo, 0, len(infos))
+	return ws.storeDir(path, infos)
+}
+
+// storeDir stores the directory read from the backend with its entries, the new
+// subdirectories are left pending for the sync to read them
+func (ws *Sync) storeDir(path string, infos []os.FileInfo) error {
+	batchInfos := make([]fs.EntryInfo, 0, len(infos)+1)
+
+	// Ensure directory itself exists, as it might not be known yet
+	batchInfos = append(batchInfos, fs.EntryInfo{
+		Path:      path,
+		IsDir:     true,
+		Processed: true,
+	})
 
 	for _, info := range infos {
 		fullPath := filepath.Join(path, info.Name())
@@ -219,10 +482,13 @@ func (ws *Sync) walkDir(path string) error {
 			IsDir:        info.IsDir(),
 			Processed:    !info.IsDir(),
 		}
+		if ws.computeMD5 && !info.IsDir() {
+			fileInfo.MD5 = ws.contentMD5(fileInfo)
+		}
 		batchInfos = append(batchInfos, fileInfo)
 	}
 
-	err = ws.db.Insert(batchInfos...)
+	err := ws.insert(batchInfos...)
 	if err != nil {
 		return err
 	}
@@ -235,6 +501,195 @@ func (ws *Sync) walkDir(path string) error {
 	return nil
 }
 
+// contentMD5 returns the MD5 of the file, reading it only if the digest stored
+// for it is missing or of the previous content. Failures are logged and leave
+// the digest empty, so the file is served with the synthetic ETag
+func (ws *Sync) contentMD5(entryInfo fs.EntryInfo) string {
+	if cached, err := ws.db.Stat(entryInfo.Path); err == nil && cached.MD5 != "" &&
+		cached.Size == entryInfo.Size && cached.LastModified == entryInfo.LastModified {
+		return cached.MD5
+	}
+
+	digest, err := fs.ContentMD5(context.Background(), ws.client, entryInfo.Path)
+	if err != nil {
+		log.Printf("Sync: Failed to compute MD5 of %s: %v", entryInfo.Path, err)
+		return ""
+	}
+	return digest
+}
+
+// RunPeriodic re-syncs the buckets every interval until stop is closed, the resync
+// runs in this goroutine, so a slow resync delays the next one instead of overlapping.
+// The buckets are read on every run, so buckets created meanwhile are re-synced too
+func (ws *Sync) RunPeriodic(buckets func() []string, interval time.Duration, shallow bool, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, bucket := range buckets() {
+				if err := ws.Resync(bucket, shallow); err != nil {
+					log.Printf("Resync: Failed to resync bucket %s: %v", bucket, err)
+				}
+			}
+		}
+	}
+}
+
+// Resync re-scans the bucket to pick up changes made directly on the backend,
+// shallow only re-reads directories whose modification time changed. The known
+// directories are re-read in place, so the bucket is served as before meanwhile,
+// and the sync then reads the new ones
+func (ws *Sync) Resync(bucket string, shallow bool) error {
+	prefix := bucket + "/"
+
+	processedBefore, _, sizeBefore, err := ws.db.GetStats(prefix)
+	if err != nil {
+		return err
+	}
+
+	changed, err := ws.rescanDirs(prefix, !shallow)
+	if err != nil {
+		return fmt.Errorf("failed to re-read directories: %v", err)
+	}
+	log.Printf("Resync: Re-read %d directories for %s", changed, bucket)
+
+	if err := ws.Sync(bucket); err != nil {
+		return err
+	}
+
+	processedAfter, _, sizeAfter, err := ws.db.GetStats(prefix)
+	if err != nil {
+		return err
+	}
+	if processedAfter != processedBefore || sizeAfter != sizeBefore {
+		log.Printf("Resync: Drift detected for %s: %+d objects (%+.2f MB)",
+			bucket, processedAfter-processedBefore, float64(sizeAfter-sizeBefore)/1024/1024)
+	}
+	return nil
+}
+
+// rescanDirs re-reads the directories known under root from the backend, all of them or
+// only the ones modified since they were scanned, returning the number re-read. Directories
+// missing on the backend are removed from the cache with everything beneath them
+func (ws *Sync) rescanDirs(root string, all bool) (int, error) {
+	changed := 0
+	queue := []string{root}
+
+	for len(queue) > 0 {
+		dir := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		entry, err := ws.db.Stat(dir)
+		if err != nil {
+			// Not scanned yet, it will be read by the sync
+			continue
+		}
+
+		info, err := ws.client.Stat(dir)
+		if fs.IsNotFound(err) && dir != root {
+			ws.logChange("Resync: Deleting missing dir %s from database", dir)
+			if _, err := ws.db.DeleteDir(dir); err != nil {
+				return changed, err
+			}
+			changed++
+			continue
+		} else if err != nil {
+			return changed, err
+		}
+
+		if modified := info.ModTime().Unix() > entry.LastModified; modified || all {
+			if modified {
+				entry.LastModified = info.ModTime().Unix()
+				if err := ws.insert(entry); err != nil {
+					return changed, err
+				}
+			}
+			if err := ws.rescanDir(dir); err != nil {
+				return changed, err
+			}
+			changed++
+		}
+
+		for marker := ""; ; {
+			children, truncated, err := ws.db.List(dir, marker, true, ws.batchSize)
+			if err != nil {
+				return changed, err
+			}
+
+			for _, child := range children {
+				if child.IsDir {
+					queue = append(queue, child.Path)
+				}
+			}
+
+			if !truncated || len(children) == 0 {
+				break
+			}
+			marker = children[len(children)-1].Path
+		}
+	}
+
+	return changed, nil
+}
+
+// rescanDir re-reads the scanned directory, storing its new entries and removing the ones
+// gone from the backend. Each one is checked again before it is removed, so an entry
+// written after the directory was read is kept
+func (ws *Sync) rescanDir(dir string) error {
+	infos, err := ws.client.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := ws.storeDir(dir, infos); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+
+	for marker := ""; ; {
+		children, truncated, err := ws.db.List(dir, marker, true, ws.batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if names[strings.TrimSuffix(strings.TrimPrefix(child.Path, dir), "/")] {
+				continue
+			}
+			if _, err := ws.client.Stat(child.Path); !fs.IsNotFound(err) {
+				continue
+			}
+
+			ws.logChange("Resync: Deleting missing entry %s from database", child.Path)
+			if child.IsDir {
+				_, err = ws.db.DeleteDir(child.Path)
+			} else {
+				err = ws.db.Delete(child.Path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if !truncated || len(children) == 0 {
+			break
+		}
+		marker = children[len(children)-1].Path
+	}
+	return nil
+}
+
 func (ws *Sync) printStats(bucket string) {
 	if time.Since(ws.lastStatus) < time.Second {
 		return
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -124,10 +125,14 @@ func TestSyncAlreadyProcessed(t *testing.T) {
 	defer cleanup()
 
 	webdav.AddFile("/test-bucket/file1.txt", []byte("content1"))
+	assert.True(t, sync.LastSync("test-bucket").IsZero())
 
 	err := sync.Sync("test-bucket")
 	require.NoError(t, err)
 
+	lastSync := sync.LastSync("test-bucket")
+	assert.False(t, lastSync.IsZero())
+
 	processedBefore, unprocessedBefore, _, err := db.GetStats("test-bucket/")
 	require.NoError(t, err)
 	assert.Equal(t, 0, unprocessedBefore)
@@ -140,6 +145,10 @@ func TestSyncAlreadyProcessed(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 0, unprocessedAfter)
 	assert.Equal(t, processedBefore, processedAfter)
+
+	// Skipped sync still counts as the bucket being up to date
+	assert.False(t, sync.LastSync("test-bucket").Before(lastSync))
+	assert.True(t, sync.LastSync("other-bucket").IsZero())
 }
 
 func TestSyncNewFilesAdded(t *testing.T) {
@@ -250,6 +259,167 @@ func TestCleanMissingDirectories(t *testing.T) {
 	assert.Error(t, err, "Directory should be removed from cache after cleaning")
 }
 
+func TestCleanDryRun(t *testing.T) {
+	run := func(t *testing.T, dryRun bool) ([]string, cache.Cache, *tests.FakeWebDAVServer) {
+		sync, db, webdav, cleanup := setupSyncTest(t)
+		t.Cleanup(cleanup)
+
+		for _, dir := range []string{"test-bucket/missing-dir/", "test-bucket/empty-dir/", "test-bucket/dir-with-files/"} {
+			require.NoError(t, db.Insert(fs.EntryInfo{
+				Path:         dir,
+				LastModified: time.Now().Unix(),
+				IsDir:        true,
+				Processed:    true,
+			}))
+		}
+		webdav.AddFile("/test-bucket/empty-dir/file.txt", []byte("content"))
+		webdav.RemoveFile("/test-bucket/empty-dir/file.txt")
+		webdav.AddFile("/test-bucket/dir-with-files/file.txt", []byte("content"))
+
+		var output strings.Builder
+		log.SetOutput(&output)
+		log.SetFlags(0)
+		defer log.SetFlags(log.LstdFlags)
+
+		sync.SetDryRun(dryRun)
+		require.NoError(t, sync.Clean("test-bucket"))
+
+		var changes []string
+		for _, line := range strings.Split(output.String(), "\n") {
+			if strings.Contains(line, " dir test-bucket/") {
+				changes = append(changes, strings.TrimPrefix(line, "(dry run) "))
+			}
+		}
+		return changes, db, webdav
+	}
+
+	expected := []string{
+		"Clean: Deleting missing dir test-bucket/missing-dir/ from database",
+		"Clean: Removing empty dir test-bucket/empty-dir/",
+		"Clean: Marking dir test-bucket/dir-with-files/ for rescan",
+	}
+
+	t.Run("Dry run", func(t *testing.T) {
+		changes, db, webdav := run(t, true)
+		assert.ElementsMatch(t, expected, changes)
+
+		for _, dir := range []string{"test-bucket/missing-dir/", "test-bucket/empty-dir/", "test-bucket/dir-with-files/"} {
+			entry, err := db.Stat(dir)
+			require.NoError(t, err, dir)
+			assert.True(t, entry.Processed, dir)
+		}
+
+		webdavFs, err := webdav.CreateWebDAVFs()
+		require.NoError(t, err)
+		_, err = webdavFs.Stat("test-bucket/empty-dir")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Clean", func(t *testing.T) {
+		changes, db, _ := run(t, false)
+		assert.ElementsMatch(t, expected, changes)
+
+		_, err := db.Stat("test-bucket/missing-dir/")
+		assert.Error(t, err)
+		_, err = db.Stat("test-bucket/empty-dir/")
+		assert.Error(t, err)
+		entry, err := db.Stat("test-bucket/dir-with-files/")
+		require.NoError(t, err)
+		assert.False(t, entry.Processed)
+	})
+}
+
+func TestSyncDryRun(t *testing.T) {
+	sync, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/changed.txt", []byte("new content"))
+	webdav.AddFile("/test-bucket/same.txt", []byte("content"))
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	webdav.AddFile("/test-bucket/dir/added.txt", []byte("content"))
+	require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/removed.txt", Processed: true}))
+	changed, err := db.Stat("test-bucket/changed.txt")
+	require.NoError(t, err)
+	changed.Size = 1
+	require.NoError(t, db.Insert(changed))
+
+	var output strings.Builder
+	log.SetOutput(&output)
+	log.SetFlags(0)
+	defer log.SetFlags(log.LstdFlags)
+
+	sync.SetDryRun(true)
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	assert.Contains(t, output.String(), "(dry run) Sync: Adding test-bucket/dir/\n")
+	assert.Contains(t, output.String(), "(dry run) Sync: Adding test-bucket/dir/added.txt\n")
+	assert.Contains(t, output.String(), "(dry run) Sync: Updating test-bucket/changed.txt\n")
+	assert.Contains(t, output.String(), "(dry run) Sync: Deleting test-bucket/removed.txt\n")
+	assert.NotContains(t, output.String(), "same.txt")
+
+	// Nothing is changed in the database
+	_, err = db.Stat("test-bucket/dir/added.txt")
+	assert.Error(t, err)
+	_, err = db.Stat("test-bucket/removed.txt")
+	assert.NoError(t, err)
+	changed, err = db.Stat("test-bucket/changed.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), changed.Size)
+}
+
+func TestVerify(t *testing.T) {
+	sync, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/same.txt", []byte("content"))
+	webdav.AddFile("/test-bucket/dir/changed.txt", []byte("new content"))
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	t.Run("consistent", func(t *testing.T) {
+		found, err := sync.Verify("test-bucket")
+		require.NoError(t, err)
+		assert.Equal(t, Discrepancies{}, found)
+		assert.Zero(t, found.Total())
+	})
+
+	webdav.AddFile("/test-bucket/added.txt", []byte("content"))
+	webdav.AddFile("/test-bucket/dir/added.txt", []byte("content"))
+	require.NoError(t, db.Insert(fs.EntryInfo{Path: "test-bucket/removed.txt", Processed: true}))
+	changed, err := db.Stat("test-bucket/dir/changed.txt")
+	require.NoError(t, err)
+	changed.Size = 1
+	require.NoError(t, db.Insert(changed))
+
+	t.Run("discrepancies", func(t *testing.T) {
+		var output strings.Builder
+		log.SetOutput(&output)
+		log.SetFlags(0)
+		defer log.SetFlags(log.LstdFlags)
+
+		found, err := sync.Verify("test-bucket")
+		require.NoError(t, err)
+		assert.Equal(t, Discrepancies{MissingFromCache: 2, MissingOnBackend: 1, Mismatched: 1}, found)
+		assert.Equal(t, 4, found.Total())
+
+		assert.Contains(t, output.String(), "Verify: Missing from cache test-bucket/added.txt\n")
+		assert.Contains(t, output.String(), "Verify: Missing from cache test-bucket/dir/added.txt\n")
+		assert.Contains(t, output.String(), "Verify: Missing on backend test-bucket/removed.txt\n")
+		assert.Contains(t, output.String(), "Verify: Size or modification time mismatch test-bucket/dir/changed.txt\n")
+		assert.NotContains(t, output.String(), "same.txt")
+	})
+
+	t.Run("nothing is changed", func(t *testing.T) {
+		_, err := db.Stat("test-bucket/added.txt")
+		assert.Error(t, err)
+		_, err = db.Stat("test-bucket/removed.txt")
+		assert.NoError(t, err)
+		changed, err := db.Stat("test-bucket/dir/changed.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), changed.Size)
+	})
+}
+
 func TestWalkDir(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -317,6 +487,72 @@ func TestWalkDir(t *testing.T) {
 	}
 }
 
+func TestScanDir(t *testing.T) {
+	tests := []struct {
+		name        string
+		scanPath    string
+		recursive   bool
+		expected    []string
+		notExpected []string
+	}{
+		{
+			name:        "scan bucket root",
+			scanPath:    "test-bucket/",
+			expected:    []string{"test-bucket/", "test-bucket/file1.txt", "test-bucket/dir/"},
+			notExpected: []string{"test-bucket/dir/file2.txt", "test-bucket/dir/nested/"},
+		},
+		{
+			name:      "scan bucket root recursively",
+			scanPath:  "test-bucket/",
+			recursive: true,
+			expected: []string{
+				"test-bucket/", "test-bucket/file1.txt", "test-bucket/dir/",
+				"test-bucket/dir/file2.txt", "test-bucket/dir/nested/", "test-bucket/dir/nested/file3.txt",
+			},
+		},
+		{
+			name:        "scan subdirectory",
+			scanPath:    "test-bucket/dir/",
+			expected:    []string{"test-bucket/dir/", "test-bucket/dir/file2.txt", "test-bucket/dir/nested/"},
+			notExpected: []string{"test-bucket/file1.txt", "test-bucket/dir/nested/file3.txt"},
+		},
+		{
+			name:        "scan missing directory",
+			scanPath:    "test-bucket/missing/",
+			notExpected: []string{"test-bucket/missing/"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sync, db, webdav, cleanup := setupSyncTest(t)
+			defer cleanup()
+
+			webdav.AddFile("/test-bucket/file1.txt", []byte("content1"))
+			webdav.AddFile("/test-bucket/dir/file2.txt", []byte("content2"))
+			webdav.AddFile("/test-bucket/dir/nested/file3.txt", []byte("content3"))
+
+			err := sync.ScanDir(tt.scanPath, tt.recursive)
+			require.NoError(t, err)
+
+			for _, path := range tt.expected {
+				_, err := db.Stat(path)
+				assert.NoError(t, err, "Entry %s should exist in cache", path)
+			}
+			for _, path := range tt.notExpected {
+				_, err := db.Stat(path)
+				assert.Error(t, err, "Entry %s should not exist in cache", path)
+			}
+
+			if len(tt.expected) > 0 {
+				entry, err := db.Stat(tt.scanPath)
+				require.NoError(t, err)
+				assert.True(t, entry.Processed, "Scanned directory should be marked as processed")
+			}
+		})
+	}
+}
+
 func TestSyncConcurrency(t *testing.T) {
 	sync, db, webdav, cleanup := setupSyncTest(t)
 	defer cleanup()
@@ -338,6 +574,201 @@ func TestSyncConcurrency(t *testing.T) {
 	assert.Greater(t, processedCount, 100)
 }
 
+func TestSyncHigherConcurrency(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		batchSize int
+	}{
+		{name: "many workers", workers: 16, batchSize: 50},
+		{name: "small batches", workers: 8, batchSize: 3},
+		{name: "defaults for invalid values", workers: 0, batchSize: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sync, db, webdav, cleanup := setupSyncTest(t)
+			defer cleanup()
+
+			sync.SetConcurrency(tt.workers, tt.batchSize)
+			assert.Greater(t, sync.concurrency, 0)
+			assert.Greater(t, sync.batchSize, 0)
+
+			for i := 0; i < 10; i++ {
+				for j := 0; j < 10; j++ {
+					path := fmt.Sprintf("/test-bucket/dir%d/sub%d/file%d.txt", i, j%3, j)
+					content := fmt.Sprintf("content-%d-%d", i, j)
+					webdav.AddFile(path, []byte(content))
+				}
+			}
+
+			err := sync.Sync("test-bucket")
+			require.NoError(t, err)
+
+			processedCount, unprocessedCount, _, err := db.GetStats("test-bucket/")
+			require.NoError(t, err)
+			assert.Equal(t, 0, unprocessedCount)
+			// 100 files, 30 subdirectories, 10 directories and the bucket root
+			assert.Equal(t, 141, processedCount)
+
+			for i := 0; i < 10; i++ {
+				for j := 0; j < 10; j++ {
+					entry, err := db.Stat(fmt.Sprintf("test-bucket/dir%d/sub%d/file%d.txt", i, j%3, j))
+					require.NoError(t, err)
+					assert.Equal(t, int64(len(fmt.Sprintf("content-%d-%d", i, j))), entry.Size)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPeriodic(t *testing.T) {
+	sync, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/file1.txt", []byte("content1"))
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	// Added directly to the backend, outside of the bridge
+	webdav.AddFile("/test-bucket/dir/file2.txt", []byte("content2"))
+	_, err := db.Stat("test-bucket/dir/file2.txt")
+	require.Error(t, err)
+
+	var buckets atomic.Value
+	buckets.Store([]string{"test-bucket"})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sync.RunPeriodic(func() []string { return buckets.Load().([]string) }, 50*time.Millisecond, false, stop)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, err := db.Stat("test-bucket/dir/file2.txt")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Buckets created after the start are re-synced too
+	webdav.AddFile("/new-bucket/file3.txt", []byte("content3"))
+	buckets.Store([]string{"test-bucket", "new-bucket"})
+
+	assert.Eventually(t, func() bool {
+		_, err := db.Stat("new-bucket/file3.txt")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(stop)
+	<-done
+}
+
+func TestRunPeriodicDisabled(t *testing.T) {
+	sync, _, _, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sync.RunPeriodic(func() []string { return []string{"test-bucket"} }, 0, false, nil)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunPeriodic should return immediately when interval is zero")
+	}
+}
+
+func TestResync(t *testing.T) {
+	tests := []struct {
+		name       string
+		shallow    bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "full resync",
+			expected:   []string{"test-bucket/changed/new.txt", "test-bucket/unchanged/new.txt"},
+			unexpected: []string{"test-bucket/changed/removed.txt", "test-bucket/missing/file.txt", "test-bucket/missing/"},
+		},
+		{
+			name:       "shallow resync reads only changed directories",
+			shallow:    true,
+			expected:   []string{"test-bucket/changed/new.txt"},
+			unexpected: []string{"test-bucket/changed/removed.txt", "test-bucket/missing/file.txt", "test-bucket/missing/", "test-bucket/unchanged/new.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sync, db, webdav, cleanup := setupSyncTest(t)
+			defer cleanup()
+
+			webdav.AddFile("/test-bucket/changed/kept.txt", []byte("kept"))
+			webdav.AddFile("/test-bucket/changed/removed.txt", []byte("removed"))
+			webdav.AddFile("/test-bucket/unchanged/kept.txt", []byte("kept"))
+			webdav.AddFile("/test-bucket/missing/file.txt", []byte("missing"))
+			require.NoError(t, sync.Sync("test-bucket"))
+
+			webdav.AddFile("/test-bucket/changed/new.txt", []byte("new"))
+			webdav.RemoveFile("/test-bucket/changed/removed.txt")
+			webdav.SetModTime("/test-bucket/changed", time.Now().Add(time.Hour))
+			webdav.AddFile("/test-bucket/unchanged/new.txt", []byte("new"))
+			webdav.RemoveFile("/test-bucket/missing/file.txt")
+			webdav.RemoveFile("/test-bucket/missing")
+
+			require.NoError(t, sync.Resync("test-bucket", tt.shallow))
+
+			for _, path := range append(tt.expected, "test-bucket/changed/kept.txt", "test-bucket/unchanged/kept.txt") {
+				_, err := db.Stat(path)
+				assert.NoError(t, err, "Entry %s should exist in cache", path)
+			}
+			for _, path := range tt.unexpected {
+				_, err := db.Stat(path)
+				assert.Error(t, err, "Entry %s should not exist in cache", path)
+			}
+
+			_, unprocessedCount, _, err := db.GetStats("test-bucket/")
+			require.NoError(t, err)
+			assert.Equal(t, 0, unprocessedCount)
+		})
+	}
+}
+
+// readDirHookFs calls the hook before every directory read
+type readDirHookFs struct {
+	fs.Fs
+	hook func()
+}
+
+func (f *readDirHookFs) ReadDir(path string) ([]os.FileInfo, error) {
+	f.hook()
+	return f.Fs.ReadDir(path)
+}
+
+func TestResyncKeepsServedEntries(t *testing.T) {
+	sync, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	webdav.AddFile("/test-bucket/dir/file.txt", []byte("content"))
+	webdav.AddFile("/test-bucket/dir/nested/file.txt", []byte("content"))
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	// Files stay processed while the directories are re-read
+	reads := 0
+	sync.client = &readDirHookFs{Fs: sync.client, hook: func() {
+		reads++
+		for _, path := range []string{"test-bucket/dir/file.txt", "test-bucket/dir/nested/file.txt"} {
+			entry, err := db.Stat(path)
+			require.NoError(t, err)
+			assert.True(t, entry.Processed, "Entry %s should stay processed", path)
+		}
+	}}
+
+	require.NoError(t, sync.Resync("test-bucket", false))
+	assert.Equal(t, 3, reads)
+}
+
 func TestPrintStats(t *testing.T) {
 	sync, db, _, cleanup := setupSyncTest(t)
 	defer cleanup()
@@ -355,3 +786,80 @@ func TestPrintStats(t *testing.T) {
 
 	assert.True(t, sync.lastStatus.After(time.Time{}))
 }
+
+func TestSyncHashedLayout(t *testing.T) {
+	_, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	webdavFs, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+	sync := New(fs.NewHashedFs(webdavFs), db)
+
+	for _, path := range []string{"test-bucket/file1.txt", "test-bucket/dir/file2.txt", "test-bucket/dir/sub/file3.txt"} {
+		webdav.AddFile("/"+fs.HashedPath(path), []byte(path))
+	}
+
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	for _, path := range []string{"test-bucket/file1.txt", "test-bucket/dir/file2.txt", "test-bucket/dir/sub/file3.txt"} {
+		entry, err := db.Stat(path)
+		require.NoError(t, err, path)
+		assert.False(t, entry.IsDir)
+		assert.Equal(t, int64(len(path)), entry.Size)
+	}
+
+	for _, path := range []string{"test-bucket/dir/", "test-bucket/dir/sub/"} {
+		entry, err := db.Stat(path)
+		require.NoError(t, err, path)
+		assert.True(t, entry.IsDir)
+		assert.True(t, entry.Processed)
+	}
+
+	processed, unprocessed, _, err := db.GetStats("test-bucket/")
+	require.NoError(t, err)
+	assert.Equal(t, 0, unprocessed)
+	assert.Equal(t, 6, processed)
+}
+
+func TestSyncMappedBucket(t *testing.T) {
+	_, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+
+	webdavFs, err := webdav.CreateWebDAVFs()
+	require.NoError(t, err)
+	sync := New(fs.NewMappedFs(webdavFs, map[string]string{"photos": "media/images"}), db)
+
+	webdav.AddFile("/media/images/a.jpg", []byte("a"))
+	webdav.AddFile("/media/images/2024/b.jpg", []byte("bb"))
+	webdav.AddFile("/photos/ignored.jpg", []byte("ignored"))
+
+	require.NoError(t, sync.Sync("photos"))
+
+	for path, size := range map[string]int64{"photos/a.jpg": 1, "photos/2024/b.jpg": 2} {
+		entry, err := db.Stat(path)
+		require.NoError(t, err, path)
+		assert.Equal(t, size, entry.Size)
+	}
+
+	_, err = db.Stat("photos/ignored.jpg")
+	assert.Error(t, err)
+	_, err = db.Stat("media/images/a.jpg")
+	assert.Error(t, err)
+}
+
+func TestSyncComputeMD5(t *testing.T) {
+	sync, db, webdav, cleanup := setupSyncTest(t)
+	defer cleanup()
+	sync.SetComputeMD5(true)
+
+	webdav.AddFile("/test-bucket/dir/file.txt", []byte("hello"))
+	require.NoError(t, sync.Sync("test-bucket"))
+
+	entry, err := db.Stat("test-bucket/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", entry.MD5)
+
+	dir, err := db.Stat("test-bucket/dir/")
+	require.NoError(t, err)
+	assert.Empty(t, dir.MD5)
+}
@@ -1,12 +1,13 @@
 package tests
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,10 +16,19 @@ import (
 )
 
 type FakeWebDAVServer struct {
-	files   map[string]*fakeFile
-	mu      sync.RWMutex
-	server  *httptest.Server
-	baseURL string
+	files     map[string]*fakeFile
+	redirects map[string]string
+	mu        sync.RWMutex
+	server    *httptest.Server
+	baseURL   string
+
+	failures     int
+	failStatus   int
+	requestCount int
+	moveDisabled bool
+	copyDisabled bool
+
+	lengthRequired bool
 }
 
 type fakeFile struct {
@@ -30,7 +40,8 @@ type fakeFile struct {
 
 func NewFakeWebDAVServer() *FakeWebDAVServer {
 	f := &FakeWebDAVServer{
-		files: make(map[string]*fakeFile),
+		files:     make(map[string]*fakeFile),
+		redirects: make(map[string]string),
 	}
 
 	handler := http.HandlerFunc(f.handleRequest)
@@ -54,10 +65,74 @@ func (f *FakeWebDAVServer) URL() string {
 }
 
 func (f *FakeWebDAVServer) CreateWebDAVFs() (fs.Fs, error) {
-	return fs.NewWebDAVFs(f.server.URL, "", "", true)
+	return fs.NewWebDAVFs(f.server.URL, "", "", true, 0, fs.RetryPolicy{}, "/")
+}
+
+// FailRequests makes the next count requests fail with the status
+func (f *FakeWebDAVServer) FailRequests(count, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures = count
+	f.failStatus = status
+}
+
+// RedirectFile makes GET of the existing file redirect to the location
+func (f *FakeWebDAVServer) RedirectFile(filePath, location string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.redirects[filePath] = location
+}
+
+// DisableMove makes MOVE requests fail as not implemented
+func (f *FakeWebDAVServer) DisableMove() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.moveDisabled = true
+}
+
+// DisableCopy makes COPY requests fail as not implemented
+func (f *FakeWebDAVServer) DisableCopy() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.copyDisabled = true
+}
+
+// RequireContentLength makes chunked PUT requests fail with 411 Length Required,
+// like servers that do not accept uploads of unknown length
+func (f *FakeWebDAVServer) RequireContentLength() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lengthRequired = true
+}
+
+// RequestCount returns the number of requests received so far
+func (f *FakeWebDAVServer) RequestCount() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.requestCount
 }
 
 func (f *FakeWebDAVServer) handleRequest(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requestCount++
+	fail := f.failures > 0
+	if fail {
+		f.failures--
+	}
+	f.mu.Unlock()
+
+	if fail {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, http.StatusText(f.failStatus), f.failStatus)
+		return
+	}
+
 	switch r.Method {
 	case "PROPFIND":
 		f.handlePropFind(w, r)
@@ -69,6 +144,10 @@ func (f *FakeWebDAVServer) handleRequest(w http.ResponseWriter, r *http.Request)
 		f.handleDelete(w, r)
 	case "MKCOL":
 		f.handleMkCol(w, r)
+	case "MOVE":
+		f.handleMove(w, r)
+	case "COPY":
+		f.handleCopy(w, r)
 	case "OPTIONS":
 		f.handleOptions(w, r)
 	default:
@@ -189,20 +268,30 @@ func (f *FakeWebDAVServer) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if location, ok := f.redirects[filePath]; ok {
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
+
+	// Serves ranges like real servers
 	w.Header().Set("Content-Type", file.contentType)
-	w.Header().Set("Content-Length", strconv.Itoa(len(file.content)))
-	w.Header().Set("Last-Modified", file.modTime.Format(http.TimeFormat))
-	w.Write(file.content)
+	http.ServeContent(w, r, "", file.modTime, bytes.NewReader(file.content))
 }
 
 func (f *FakeWebDAVServer) handlePut(w http.ResponseWriter, r *http.Request) {
 	filePath := r.URL.Path
-	content, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+	f.mu.RLock()
+	lengthRequired := f.lengthRequired
+	f.mu.RUnlock()
+	if lengthRequired && r.ContentLength < 0 {
+		http.Error(w, "Length Required", http.StatusLengthRequired)
 		return
 	}
 
+	// Like naive servers, keep what was received of an interrupted upload
+	content, err := io.ReadAll(r.Body)
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -216,6 +305,10 @@ func (f *FakeWebDAVServer) handlePut(w http.ResponseWriter, r *http.Request) {
 		contentType: "application/octet-stream",
 	}
 
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -223,13 +316,23 @@ func (f *FakeWebDAVServer) handleDelete(w http.ResponseWriter, r *http.Request)
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	// Collections may be addressed with the trailing slash
 	filePath := r.URL.Path
+	if filePath != "/" {
+		filePath = strings.TrimSuffix(filePath, "/")
+	}
 	if _, exists := f.files[filePath]; !exists {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
+	// Like real servers, deleting a collection deletes everything beneath it
 	delete(f.files, filePath)
+	for childPath := range f.files {
+		if strings.HasPrefix(childPath, filePath+"/") {
+			delete(f.files, childPath)
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -237,7 +340,18 @@ func (f *FakeWebDAVServer) handleMkCol(w http.ResponseWriter, r *http.Request) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	filePath := r.URL.Path
+	filePath := strings.TrimSuffix(r.URL.Path, "/")
+	if _, exists := f.files[filePath]; exists {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Like real servers, require the parent collection to exist
+	if parent := path.Dir(filePath); parent != "/" {
+		if _, exists := f.files[parent]; !exists {
+			http.Error(w, "Conflict", http.StatusConflict)
+			return
+		}
+	}
 	f.files[filePath] = &fakeFile{
 		isDir:   true,
 		modTime: time.Now(),
@@ -245,6 +359,101 @@ func (f *FakeWebDAVServer) handleMkCol(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (f *FakeWebDAVServer) handleMove(w http.ResponseWriter, r *http.Request) {
+	f.mu.RLock()
+	moveDisabled := f.moveDisabled
+	f.mu.RUnlock()
+	if moveDisabled {
+		http.Error(w, "Not Implemented", http.StatusNotImplemented)
+		return
+	}
+
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || destination.Path == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	srcPath := strings.TrimSuffix(r.URL.Path, "/")
+	dstPath := strings.TrimSuffix(destination.Path, "/")
+
+	file, exists := f.files[srcPath]
+	if !exists {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if _, exists := f.files[dstPath]; exists && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	moved := map[string]*fakeFile{dstPath: file}
+	if file.isDir {
+		for childPath, child := range f.files {
+			if strings.HasPrefix(childPath, srcPath+"/") {
+				moved[dstPath+strings.TrimPrefix(childPath, srcPath)] = child
+				delete(f.files, childPath)
+			}
+		}
+	}
+	delete(f.files, srcPath)
+
+	f.ensureDir(path.Dir(dstPath))
+	for movedPath, movedFile := range moved {
+		f.files[movedPath] = movedFile
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *FakeWebDAVServer) handleCopy(w http.ResponseWriter, r *http.Request) {
+	f.mu.RLock()
+	copyDisabled := f.copyDisabled
+	f.mu.RUnlock()
+	if copyDisabled {
+		http.Error(w, "Not Implemented", http.StatusNotImplemented)
+		return
+	}
+
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || destination.Path == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	srcPath := strings.TrimSuffix(r.URL.Path, "/")
+	dstPath := strings.TrimSuffix(destination.Path, "/")
+
+	file, exists := f.files[srcPath]
+	if !exists {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	// Like real servers, reject copying collections and copying onto the source itself
+	if file.isDir || srcPath == dstPath {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if _, exists := f.files[dstPath]; exists && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	f.ensureDir(path.Dir(dstPath))
+	f.files[dstPath] = &fakeFile{
+		content:     append([]byte(nil), file.content...),
+		modTime:     time.Now(),
+		contentType: file.contentType,
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
 func (f *FakeWebDAVServer) handleOptions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Allow", "OPTIONS, GET, HEAD, POST, PUT, DELETE, TRACE, PROPFIND, PROPPATCH, COPY, MOVE, MKCOL, LOCK, UNLOCK")
 	w.Header().Set("DAV", "1, 2")
@@ -280,3 +489,19 @@ func (f *FakeWebDAVServer) AddFile(filePath string, content []byte) {
 		contentType: "application/octet-stream",
 	}
 }
+
+func (f *FakeWebDAVServer) RemoveFile(filePath string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.files, filePath)
+}
+
+func (f *FakeWebDAVServer) SetModTime(filePath string, modTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if file, exists := f.files[filePath]; exists {
+		file.modTime = modTime
+	}
+}
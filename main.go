@@ -1,21 +1,35 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"s3-to-webdav/internal/access_log"
 	"s3-to-webdav/internal/cache"
+	"s3-to-webdav/internal/compress"
+	"s3-to-webdav/internal/config"
 	"s3-to-webdav/internal/fs"
 	"s3-to-webdav/internal/helpers"
+	"s3-to-webdav/internal/inventory"
+	"s3-to-webdav/internal/listen"
 	"s3-to-webdav/internal/s3"
 	"s3-to-webdav/internal/sync"
 )
@@ -24,33 +38,75 @@ import (
 var browserHTML []byte
 
 var (
+	// Configuration file
+	configFile = flag.String("config", os.Getenv("CONFIG_FILE"), "JSON file of options named as the flags, e.g. {\"buckets\": \"a,b\"}, the command line takes precedence over it")
+
 	// WebDAV configuration
-	webdavURL      = flag.String("webdav-url", os.Getenv("WEBDAV_URL"), "WebDAV server URL")
-	webdavUser     = flag.String("webdav-user", os.Getenv("WEBDAV_USER"), "WebDAV username")
-	webdavPassword = flag.String("webdav-password", os.Getenv("WEBDAV_PASSWORD"), "WebDAV password")
-	webdavInsecure = flag.Bool("webdav-insecure", getEnvOrDefault("WEBDAV_INSECURE", "false") == "true", "Allow self-signed certificates for WebDAV")
+	webdavURL       = flag.String("webdav-url", os.Getenv("WEBDAV_URL"), "WebDAV server URL")
+	webdavUser      = flag.String("webdav-user", os.Getenv("WEBDAV_USER"), "WebDAV username")
+	webdavPassword  = flag.String("webdav-password", os.Getenv("WEBDAV_PASSWORD"), "WebDAV password")
+	webdavInsecure  = flag.Bool("webdav-insecure", getEnvOrDefault("WEBDAV_INSECURE", "false") == "true", "Allow self-signed certificates for WebDAV")
+	webdavRetries   = flag.Int("webdav-retries", getEnvIntOrDefault("WEBDAV_RETRIES", 2), "Number of retries of WebDAV operations failing with 5xx or connection errors")
+	webdavBackoff   = flag.Duration("webdav-retry-backoff", getEnvDurationOrDefault("WEBDAV_RETRY_BACKOFF", 200*time.Millisecond), "Delay before the first retry of WebDAV operation, doubled for every next one")
+	webdavTimeout   = flag.Duration("webdav-timeout", getEnvDurationOrDefault("WEBDAV_TIMEOUT", 30*time.Second), "Timeout of WebDAV metadata operations, and of waiting for the response of reads and writes (0 = no timeout)")
+	webdavFallbacks = flag.String("webdav-fallback-urls", os.Getenv("WEBDAV_FALLBACK_URLS"), "Comma-separated URLs of WebDAV servers serving reads when the primary fails, with the same credentials, writes go only to the primary")
+	webdavHealth    = flag.Duration("webdav-health-check-interval", getEnvDurationOrDefault("WEBDAV_HEALTH_CHECK_INTERVAL", 30*time.Second), "Interval of checking the failed primary WebDAV server to read from it again (0 = only on reads)")
+	webdavPreflight = flag.String("webdav-preflight-path", getEnvOrDefault("WEBDAV_PREFLIGHT_PATH", "/"), "Path read at startup to check the WebDAV credentials (none = skip)")
 
 	// Local filesystem configuration
 	localPath = flag.String("local-path", os.Getenv("LOCAL_PATH"), "Local filesystem path (alternative to WebDAV)")
 
+	// S3 backend configuration
+	s3Endpoint  = flag.String("s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 server URL (alternative to WebDAV)")
+	s3AccessKey = flag.String("s3-access-key", os.Getenv("S3_ACCESS_KEY"), "S3 backend access key")
+	s3SecretKey = flag.String("s3-secret-key", os.Getenv("S3_SECRET_KEY"), "S3 backend secret key")
+	s3Bucket    = flag.String("s3-bucket", os.Getenv("S3_BUCKET"), "S3 backend bucket storing all buckets")
+
+	// Backend layout
+	backendLayout = flag.String("backend-layout", getEnvOrDefault("BACKEND_LAYOUT", fs.LayoutFlat), "Layout of the files on the backend: flat or hashed (under directories named after the hash of the key)")
+
 	// S3/AWS configuration
 	accessKey      = flag.String("aws-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key")
 	secretKey      = flag.String("aws-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret key")
 	accessInsecure = flag.Bool("aws-access-insecure", getEnvOrDefault("AWS_ACCESS_INSECURE", "false") == "true", "Allow insecure, secret-less access")
 
+	region          = flag.String("region", getEnvOrDefault("REGION", s3.DefaultRegion), "Region reported for the buckets in the x-amz-bucket-region header")
+	headBucketStats = flag.Bool("head-bucket-stats", getEnvOrDefault("HEAD_BUCKET_STATS", "false") == "true", "Add the cached object count and size headers to HEAD of a bucket")
+
+	maxClockSkew      = flag.Duration("max-clock-skew", getEnvDurationOrDefault("MAX_CLOCK_SKEW", s3.DefaultMaxClockSkew), "Largest difference between the X-Amz-Date of v4 signed requests and the server time (0 = not checked)")
+	credentialsFile   = flag.String("credentials-file", os.Getenv("CREDENTIALS_FILE"), "File of additional access_key:secret_key lines, re-read while running")
+	credentialsReload = flag.Duration("credentials-reload-interval", getEnvDurationOrDefault("CREDENTIALS_RELOAD_INTERVAL", 30*time.Second), "Interval of re-reading the credentials file (0 = only at startup)")
+
 	// Server configuration
-	httpPort = flag.String("http-port", getEnvOrDefault("HTTP_PORT", "8080"), "HTTP/HTTPS server port")
-	httpOnly = flag.Bool("http-only", getEnvOrDefault("HTTP_ONLY", "false") == "true", "Enable HTTP only mode")
+	httpPort    = flag.String("http-port", getEnvOrDefault("HTTP_PORT", "8080"), "HTTP/HTTPS server port")
+	listenAddrs = flag.String("listen", os.Getenv("LISTEN"), "Comma-separated addresses to listen on: tcp://host:port, http://host:port, https://host:port or unix:///path (default: tcp://:<http-port>)")
+	httpOnly    = flag.Bool("http-only", getEnvOrDefault("HTTP_ONLY", "false") == "true", "Enable HTTP only mode")
+
+	// Response compression
+	compressObjects = flag.Bool("compress-objects", getEnvOrDefault("COMPRESS_OBJECTS", "false") == "true", "Compress object content with gzip for clients accepting it, not only listings and other XML or JSON responses")
+
+	// Access log
+	logFormat = flag.String("log-format", getEnvOrDefault("LOG_FORMAT", access_log.FormatApache), "Format of the access log: apache or json (one object per request)")
+	accessLog = flag.String("access-log", os.Getenv("ACCESS_LOG"), "File to append the access log to, reopened on SIGHUP for log rotation (empty = stdout)")
 
 	// TLS configuration
 	tlsCert = flag.String("tls-cert", os.Getenv("TLS_CERT"), "TLS certificate file path")
 	tlsKey  = flag.String("tls-key", os.Getenv("TLS_KEY"), "TLS key file path")
 
 	// Persistence configuration
-	persistDir = flag.String("persist-dir", getEnvOrDefault("PERSIST_DIR", "./data"), "Directory to store persistent data")
+	persistDir    = flag.String("persist-dir", getEnvOrDefault("PERSIST_DIR", "./data"), "Directory to store persistent data")
+	dbBusyTimeout = flag.Duration("db-busy-timeout", getEnvDurationOrDefault("DB_BUSY_TIMEOUT", cache.DefaultBusyTimeout), "Time a database query waits for the lock held by another connection before failing")
+	dbMaxConns    = flag.Int("db-max-conns", getEnvIntOrDefault("DB_MAX_CONNS", cache.DefaultMaxOpenConns), "Number of database connections, writes are serialized so only reads run in parallel (1 = serialize all queries)")
+
+	// Database maintenance
+	cacheOptimiseInterval = flag.Duration("cache-optimise-interval", getEnvDurationOrDefault("CACHE_OPTIMISE_INTERVAL", 0), "Interval of refreshing the query planner statistics of the database with ANALYZE (0 = disabled)")
+	cacheVacuumInterval   = flag.Duration("cache-vacuum-interval", getEnvDurationOrDefault("CACHE_VACUUM_INTERVAL", 0), "Interval of rebuilding the database with VACUUM to reclaim the space of deleted entries, blocking requests while it runs (0 = disabled)")
 
 	// Bucket configuration
-	buckets = flag.String("buckets", os.Getenv("BUCKETS"), "Comma-separated list of bucket names to sync (required)")
+	buckets           = flag.String("buckets", os.Getenv("BUCKETS"), "Comma-separated list of bucket names to sync, name=backend/prefix stores the bucket under that backend directory (required)")
+	autoCreateBuckets = flag.Bool("auto-create-buckets", getEnvOrDefault("AUTO_CREATE_BUCKETS", "false") == "true", "Allow creating and deleting buckets with the CreateBucket and DeleteBucket requests")
+	allowBucketOps    = flag.Bool("allow-bucket-ops", getEnvOrDefault("ALLOW_BUCKET_OPS", "false") == "true", "Same as -auto-create-buckets")
+	allowPrefixDelete = flag.Bool("allow-prefix-delete", getEnvOrDefault("ALLOW_PREFIX_DELETE", "false") == "true", "Allow the non-standard POST /{bucket}?delete-prefix&prefix=dir/ request removing a directory recursively")
 
 	// Help
 	help = flag.Bool("help", false, "Show help message")
@@ -58,13 +114,73 @@ var (
 	// Read-only mode
 	readOnly = flag.Bool("read-only", getEnvOrDefault("READ_ONLY", "false") == "true", "Enable read-only mode (disables PUT, DELETE operations)")
 
+	// Alias configuration
+	aliasWrites = flag.String("alias-writes", getEnvOrDefault("ALIAS_WRITES", s3.AliasWritesReject), "How to handle writes to an alias: reject or redirect")
+
+	// Concurrent writes
+	writeConflicts = flag.String("write-conflicts", getEnvOrDefault("WRITE_CONFLICTS", s3.WriteConflictsLastWriteWins), "How to handle concurrent writes to the same key: last-write-wins or reject")
+
+	// ETag computation
+	etagScanMode = flag.String("etag-scan-mode", getEnvOrDefault("ETAG_SCAN_MODE", s3.ETagScanModeSynthetic), "How ETags of objects are computed: synthetic, md5-lazy or md5-eager")
+
+	// Upload restrictions
+	bucketContentTypes = flag.String("bucket-content-types", os.Getenv("BUCKET_CONTENT_TYPES"), "Allowed upload content types per bucket, e.g. media=image/*|video/mp4;docs=application/pdf")
+
+	// Upload configuration
+	maxObjectSize = flag.String("max-object-size", os.Getenv("MAX_OBJECT_SIZE"), "Largest accepted upload, in bytes or with K, M, G or T suffix, e.g. 5G (0 = unlimited)")
+	maxBandwidth  = flag.String("max-bandwidth-per-request", os.Getenv("MAX_BANDWIDTH_PER_REQUEST"), "Bytes per second of each object download and upload, with K, M or G suffix, e.g. 10M (0 = unlimited)")
+	dirMode       = flag.String("dir-mode", getEnvOrDefault("DIR_MODE", "0755"), "Octal permissions of the directories created on the local filesystem")
+	durableWrites = flag.Bool("durable-writes", getEnvOrDefault("DURABLE_WRITES", "false") == "true", "Flush uploads to the disk of the local filesystem before acknowledging them (slower)")
+	fileMode      = flag.String("file-mode", getEnvOrDefault("FILE_MODE", "0644"), "Octal permissions of the uploaded objects")
+
+	// Bulk delete configuration
+	bulkDeleteRetries = flag.Int("bulk-delete-retries", getEnvIntOrDefault("BULK_DELETE_RETRIES", 2), "Number of retries for each key of the bulk delete")
+	bulkDeleteTimeout = flag.Duration("bulk-delete-timeout", getEnvDurationOrDefault("BULK_DELETE_TIMEOUT", 0), "Deadline for the whole bulk delete request (0 = no deadline)")
+
+	// Listing configuration
+	listBackendFallback = flag.Bool("list-backend-fallback", getEnvOrDefault("LIST_BACKEND_FALLBACK", "false") == "true", "List directly from the backend directories that are not scanned yet (slower)")
+	readThrough         = flag.Bool("read-through", getEnvOrDefault("READ_THROUGH", "false") == "true", "Serve objects missing from the cache from the backend, caching the ones found")
+	headValidate        = flag.Bool("head-validate", getEnvOrDefault("HEAD_VALIDATE", "false") == "true", "Check objects on the backend on HEAD when their cache entry is older than -head-validate-after, refreshing size and modification time")
+	headValidateAfter   = flag.Duration("head-validate-after", getEnvDurationOrDefault("HEAD_VALIDATE_AFTER", time.Minute), "Age of the cache entry after which HEAD checks the object on the backend")
+	listCacheTTL        = flag.Duration("list-cache-ttl", getEnvDurationOrDefault("LIST_CACHE_TTL", 0), "Time to serve repeated identical listings from memory, dropped on writes to the bucket (0 = disabled)")
+	negativeCacheTTL    = flag.Duration("negative-cache-ttl", getEnvDurationOrDefault("NEGATIVE_CACHE_TTL", 0), "Time to answer requests for objects found missing from memory, dropped on writes to the object (0 = disabled)")
+	negativeCacheSize   = flag.Int("negative-cache-size", getEnvIntOrDefault("NEGATIVE_CACHE_SIZE", 10000), "Number of missing objects remembered by the negative cache")
+
+	// Backend redirects
+	passthroughRedirects        = flag.Bool("passthrough-redirects", getEnvOrDefault("PASSTHROUGH_REDIRECTS", "false") == "true", "Answer GET with 307 to the location the backend redirected to, instead of proxying the content")
+	passthroughRedirectsBaseURL = flag.String("passthrough-redirects-base-url", os.Getenv("PASSTHROUGH_REDIRECTS_BASE_URL"), "Replace scheme and host of the passed through redirects, e.g. https://cdn.example.com")
+
 	// Browser mode
 	browser = flag.Bool("browser", getEnvOrDefault("BROWSER", "false") == "true", "Enable built-in browser")
 
+	// Caching headers of objects
+	defaultCacheControl = flag.String("default-cache-control", os.Getenv("DEFAULT_CACHE_CONTROL"), "Cache-Control header of objects, e.g. \"public, max-age=3600\", with Expires following its max-age (empty = not sent)")
+	immutableKeys       = flag.String("immutable-keys", os.Getenv("IMMUTABLE_KEYS"), "Regular expression of the keys of objects never changing, e.g. hashed filenames, sent with Cache-Control immutable")
+
+	// Virtual-hosted-style addressing
+	s3Domain = flag.String("s3-domain", os.Getenv("S3_DOMAIN"), "Domain of virtual-hosted-style requests, bucket.<domain> is served as the bucket (empty = path-style only)")
+
+	// CORS configuration
+	corsAllowedOrigins = flag.String("cors-allowed-origins", os.Getenv("CORS_ALLOWED_ORIGINS"), "Comma-separated list of origins allowed to make cross-origin requests, * allows any (empty = CORS disabled)")
+	corsMaxAge         = flag.Duration("cors-max-age", getEnvDurationOrDefault("CORS_MAX_AGE", 0), "Time browsers may cache the preflight response (0 = not sent)")
+
+	// Sync configuration
+	syncConcurrency = flag.Int("sync-concurrency", getEnvIntOrDefault("SYNC_CONCURRENCY", sync.DefaultConcurrency), "Number of directories scanned in parallel")
+	syncBatchSize   = flag.Int("sync-batch-size", getEnvIntOrDefault("SYNC_BATCH_SIZE", sync.DefaultBatchSize), "Number of pending directories fetched from the database at once")
+	syncInterval    = flag.Duration("sync-interval", getEnvDurationOrDefault("SYNC_INTERVAL", 0), "Interval of the background re-sync picking up changes made on the backend (0 = disabled)")
+	syncShallow     = flag.Bool("sync-shallow", getEnvOrDefault("SYNC_SHALLOW", "false") == "true", "Background re-sync reads only directories whose modification time changed")
+
 	// Maintenance commands
 	clean  = flag.Bool("clean", false, "Clean empty directories and exit")
 	scan   = flag.Bool("scan", true, "Scan on startup")
 	rescan = flag.Bool("rescan", false, "Re-scan and exit")
+	verify = flag.Bool("verify", false, "Compare the cache with the backend, report the discrepancies and exit, non-zero if any are found")
+	dryRun = flag.Bool("dry-run", false, "Only log what -clean and -rescan would change, without modifying the backend or the database")
+
+	// Inventory export
+	inventoryDir  = flag.String("inventory", "", "Export S3 Inventory CSV reports for all buckets to directory and exit")
+	inventoryGzip = flag.Bool("inventory-gzip", true, "Gzip-compress S3 Inventory data files")
+	inventoryRows = flag.Int("inventory-rows", 1000000, "Maximum number of rows per S3 Inventory data file")
 )
 
 func getEnvOrDefault(envKey, defaultValue string) string {
@@ -74,6 +190,60 @@ func getEnvOrDefault(envKey, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvIntOrDefault(envKey string, defaultValue int) int {
+	if value := os.Getenv(envKey); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envKey, err)
+		}
+		return intValue
+	}
+	return defaultValue
+}
+
+func getEnvDurationOrDefault(envKey string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(envKey); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envKey, err)
+		}
+		return duration
+	}
+	return defaultValue
+}
+
+// parseSize parses the size in bytes, optionally with a K, M, G or T suffix of powers of 1024
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(value, suffix) {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = strings.TrimSuffix(value, suffix)
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// parseMode parses octal permission bits, e.g. 0640
+func parseMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid mode %q", value)
+	}
+	return os.FileMode(mode), nil
+}
+
 func getMapKeys(m map[string]interface{}) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -91,22 +261,79 @@ func usage() {
 	flag.PrintDefaults()
 	fmt.Println()
 	fmt.Println("Environment variables (used as defaults for flags):")
+	fmt.Println("  CONFIG_FILE           - JSON file of options named as the flags, overriding the environment variables")
 	fmt.Println("  WEBDAV_URL            - WebDAV server URL")
+	fmt.Println("  WEBDAV_FALLBACK_URLS  - Comma-separated URLs of WebDAV servers serving reads when the primary fails (default: none)")
+	fmt.Println("  WEBDAV_HEALTH_CHECK_INTERVAL - Interval of checking the failed primary WebDAV server, e.g. 1m (default: 30s)")
 	fmt.Println("  WEBDAV_USER           - WebDAV username")
 	fmt.Println("  WEBDAV_PASSWORD       - WebDAV password")
 	fmt.Println("  WEBDAV_INSECURE       - Allow self-signed certificates for WebDAV (default: false)")
+	fmt.Println("  WEBDAV_RETRIES        - Number of retries of WebDAV operations failing with 5xx or connection errors (default: 2)")
+	fmt.Println("  WEBDAV_RETRY_BACKOFF  - Delay before the first retry of WebDAV operation, e.g. 200ms (default: 200ms)")
+	fmt.Println("  WEBDAV_TIMEOUT        - Timeout of WebDAV metadata operations and of waiting for responses, 0 to disable (default: 30s)")
+	fmt.Println("  WEBDAV_PREFLIGHT_PATH - Path read at startup to check the WebDAV credentials, none to skip (default: /)")
 	fmt.Println("  LOCAL_PATH            - Local filesystem path (alternative to WebDAV)")
+	fmt.Println("  S3_ENDPOINT           - S3 server URL (alternative to WebDAV)")
+	fmt.Println("  S3_ACCESS_KEY         - S3 backend access key")
+	fmt.Println("  S3_SECRET_KEY         - S3 backend secret key")
+	fmt.Println("  S3_BUCKET             - S3 backend bucket storing all buckets")
+	fmt.Println("  BACKEND_LAYOUT        - Layout of the files on the backend: flat or hashed (default: flat)")
 	fmt.Println("  AWS_ACCESS_KEY_ID     - S3 access key for authentication (optional)")
 	fmt.Println("  AWS_SECRET_ACCESS_KEY - S3 secret key for authentication (optional)")
 	fmt.Println("  AWS_ACCESS_INSECURE   - Allow insecure, secret-less access to S3 (default: false)")
+	fmt.Println("  REGION                - Region reported for the buckets in the x-amz-bucket-region header (default: us-east-1)")
+	fmt.Println("  HEAD_BUCKET_STATS     - Add x-amz-object-count and x-amz-bucket-size to HEAD of a bucket (default: false)")
+	fmt.Println("  MAX_CLOCK_SKEW        - Largest difference between the X-Amz-Date of v4 signed requests and the server time (default: 15m)")
+	fmt.Println("  CREDENTIALS_FILE      - File of additional access_key:secret_key lines, re-read while running (optional)")
+	fmt.Println("  CREDENTIALS_RELOAD_INTERVAL - Interval of re-reading the credentials file, 0 for only at startup (default: 30s)")
 	fmt.Println("  HTTP_PORT             - Server port (default: 8080)")
 	fmt.Println("  HTTP_ONLY             - Enable HTTP only (no HTTPS) (default: false)")
+	fmt.Println("  LISTEN                - Comma-separated addresses to listen on, e.g. tcp://:8080,unix:///var/run/s3.sock (default: tcp://:<HTTP_PORT>)")
+	fmt.Println("  COMPRESS_OBJECTS      - Compress object content with gzip for clients accepting it (default: false)")
+	fmt.Println("  LOG_FORMAT            - Format of the access log: apache or json (default: apache)")
+	fmt.Println("  ACCESS_LOG            - File to append the access log to, reopened on SIGHUP (default: stdout)")
 	fmt.Println("  TLS_CERT              - TLS certificate file path (optional)")
 	fmt.Println("  TLS_KEY               - TLS key file path (optional)")
 	fmt.Println("  PERSIST_DIR           - Directory for persistent data (certificates and keys) (default: ./data)")
-	fmt.Println("  BUCKETS               - Comma-separated list of bucket names to sync (required)")
+	fmt.Println("  DB_BUSY_TIMEOUT       - Time a database query waits for the lock of another connection (default: 5s)")
+	fmt.Println("  DB_MAX_CONNS          - Number of database connections, 1 to serialize all queries (default: 4)")
+	fmt.Println("  CACHE_OPTIMISE_INTERVAL - Interval of refreshing the database query planner statistics, e.g. 1h (default: disabled)")
+	fmt.Println("  CACHE_VACUUM_INTERVAL - Interval of rebuilding the database to reclaim space, e.g. 168h (default: disabled)")
+	fmt.Println("  BUCKETS               - Comma-separated list of bucket names to sync, name=backend/prefix stores the bucket under that backend directory (required)")
+	fmt.Println("  AUTO_CREATE_BUCKETS   - Allow creating and deleting buckets with the CreateBucket and DeleteBucket requests (default: false)")
+	fmt.Println("  ALLOW_BUCKET_OPS      - Same as AUTO_CREATE_BUCKETS (default: false)")
+	fmt.Println("  ALLOW_PREFIX_DELETE   - Allow the non-standard request removing a directory recursively (default: false)")
 	fmt.Println("  READ_ONLY             - Enable read-only mode (disables PUT, DELETE operations) (default: false)")
 	fmt.Println("  BROWSER               - Enable built-in browser under the `/-/browser/` (default: false)")
+	fmt.Println("  DEFAULT_CACHE_CONTROL - Cache-Control header of objects, e.g. public, max-age=3600 (default: not sent)")
+	fmt.Println("  IMMUTABLE_KEYS        - Regular expression of the keys of objects never changing, sent with Cache-Control immutable")
+	fmt.Println("  S3_DOMAIN             - Domain of virtual-hosted-style requests, bucket.<domain> is served as the bucket (default: path-style only)")
+	fmt.Println("  CORS_ALLOWED_ORIGINS  - Comma-separated list of origins allowed to make cross-origin requests, * allows any (default: disabled)")
+	fmt.Println("  CORS_MAX_AGE          - Time browsers may cache the preflight response, e.g. 1h (default: not sent)")
+	fmt.Println("  BUCKET_CONTENT_TYPES  - Allowed upload content types per bucket, e.g. media=image/*|video/mp4;docs=application/pdf")
+	fmt.Println("  MAX_OBJECT_SIZE       - Largest accepted upload, e.g. 5G (default: unlimited)")
+	fmt.Println("  MAX_BANDWIDTH_PER_REQUEST - Bytes per second of each object download and upload, e.g. 10M (default: unlimited)")
+	fmt.Println("  DIR_MODE              - Octal permissions of the directories created on the local filesystem (default: 0755)")
+	fmt.Println("  FILE_MODE             - Octal permissions of the uploaded objects (default: 0644)")
+	fmt.Println("  DURABLE_WRITES        - Flush uploads to the disk of the local filesystem before acknowledging them (default: false)")
+	fmt.Println("  BULK_DELETE_RETRIES   - Number of retries for each key of the bulk delete (default: 2)")
+	fmt.Println("  BULK_DELETE_TIMEOUT   - Deadline for the whole bulk delete request, e.g. 30s (default: no deadline)")
+	fmt.Println("  SYNC_CONCURRENCY      - Number of directories scanned in parallel (default: 2)")
+	fmt.Println("  SYNC_BATCH_SIZE       - Number of pending directories fetched from the database at once (default: 50)")
+	fmt.Println("  SYNC_INTERVAL         - Interval of the background re-sync, e.g. 1h (default: disabled)")
+	fmt.Println("  SYNC_SHALLOW          - Background re-sync reads only directories whose modification time changed (default: false)")
+	fmt.Println("  LIST_BACKEND_FALLBACK - List directly from the backend directories that are not scanned yet (default: false)")
+	fmt.Println("  READ_THROUGH          - Serve objects missing from the cache from the backend, caching the ones found (default: false)")
+	fmt.Println("  HEAD_VALIDATE         - Check objects on the backend on HEAD when their cache entry is stale (default: false)")
+	fmt.Println("  HEAD_VALIDATE_AFTER   - Age of the cache entry after which HEAD checks the object on the backend, e.g. 5m (default: 1m)")
+	fmt.Println("  LIST_CACHE_TTL        - Time to serve repeated identical listings from memory, e.g. 2s (default: disabled)")
+	fmt.Println("  NEGATIVE_CACHE_TTL    - Time to answer requests for objects found missing from memory, e.g. 5s (default: disabled)")
+	fmt.Println("  NEGATIVE_CACHE_SIZE   - Number of missing objects remembered by the negative cache (default: 10000)")
+	fmt.Println("  PASSTHROUGH_REDIRECTS - Answer GET with 307 to the location the backend redirected to (default: false)")
+	fmt.Println("  PASSTHROUGH_REDIRECTS_BASE_URL - Replace scheme and host of the passed through redirects")
+	fmt.Println("  ALIAS_WRITES          - How to handle writes to an alias: reject or redirect (default: reject)")
+	fmt.Println("  WRITE_CONFLICTS       - How to handle concurrent writes to the same key: last-write-wins or reject (default: last-write-wins)")
+	fmt.Println("  ETAG_SCAN_MODE        - How ETags of objects are computed: synthetic, md5-lazy or md5-eager (default: synthetic)")
 	fmt.Println()
 	os.Exit(0)
 }
@@ -147,6 +374,46 @@ func loadAccessKeys() s3.AuthConfig {
 	}
 }
 
+// loadCredentials returns the provider of the secret keys, nil if authentication is disabled
+func loadCredentials(config s3.AuthConfig) s3.CredentialProvider {
+	if config.AccessKey == "" {
+		if *credentialsFile != "" {
+			log.Fatalf("Cannot use -aws-access-insecure with a credentials file")
+		}
+		return nil
+	}
+	if *credentialsFile == "" {
+		return config
+	}
+
+	fileCredentials, err := s3.NewFileCredentials(*credentialsFile)
+	if err != nil {
+		log.Fatalf("Failed to load credentials: %v", err)
+	}
+	log.Printf("S3: Loaded access keys from %s, re-read every %v", *credentialsFile, *credentialsReload)
+	go fileCredentials.Watch(*credentialsReload, nil)
+	return s3.CredentialProviders{config, fileCredentials}
+}
+
+func parseBucketContentTypes(value string, bucketMap map[string]interface{}) map[string][]string {
+	contentTypes := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		bucket, types, ok := strings.Cut(entry, "=")
+		bucket = strings.TrimSpace(bucket)
+		if !ok || bucket == "" || types == "" {
+			log.Fatalf("Invalid bucket content types: %s", entry)
+		}
+		if _, exists := bucketMap[bucket]; !exists {
+			log.Fatalf("Content types configured for unknown bucket: %s", bucket)
+		}
+		contentTypes[bucket] = append(contentTypes[bucket], strings.Split(types, "|")...)
+	}
+	return contentTypes
+}
+
 func loadCerts() (string, string) {
 	if *tlsCert != "" || *tlsKey != "" {
 		return *tlsCert, *tlsKey
@@ -160,11 +427,115 @@ func loadCerts() (string, string) {
 	return tlsCert, tlsKey
 }
 
-func runServe(db cache.Cache, client fs.Fs, bucketMap map[string]interface{}) {
+// openAccessLog opens the access log file, reopening it on SIGHUP once logrotate moved it,
+// or returns nil to log to stdout
+func openAccessLog() io.Writer {
+	if *accessLog == "" {
+		return nil
+	}
+
+	file, err := access_log.OpenFile(*accessLog)
+	if err != nil {
+		log.Fatalf("Failed to open access log: %v", err)
+	}
+	log.Printf("Access Log: Writing to %s", *accessLog)
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		for range signals {
+			if err := file.Reopen(); err != nil {
+				log.Printf("Access Log: Failed to reopen %s: %v", *accessLog, err)
+			} else {
+				log.Printf("Access Log: Reopened %s", *accessLog)
+			}
+		}
+	}()
+	return file
+}
+
+func runServe(db cache.Cache, client fs.Fs, bucketSync *sync.Sync, bucketMap map[string]interface{}, filePerm os.FileMode) {
 	s3Server := s3.NewServer(db, client)
+	s3Server.SetFileMode(filePerm)
+	s3Server.SetRegion(*region)
+	s3Server.SetBucketStatsHeaders(*headBucketStats)
+	s3Server.SetSync(bucketSync)
 	s3Server.SetBucketMap(bucketMap)
+	s3Server.SetAliasWrites(*aliasWrites)
+	s3Server.SetWriteConflicts(*writeConflicts)
+	s3Server.SetETagScanMode(*etagScanMode)
+	s3Server.SetBulkDeleteBudget(*bulkDeleteRetries, *bulkDeleteTimeout)
+	if size, err := parseSize(*maxObjectSize); err != nil {
+		log.Fatalf("Invalid max object size: %v", err)
+	} else if size > 0 {
+		log.Printf("Uploads: Limited to %d bytes", size)
+		s3Server.SetMaxObjectSize(size)
+	}
+	if rate, err := parseSize(*maxBandwidth); err != nil {
+		log.Fatalf("Invalid max bandwidth per request: %v", err)
+	} else if rate > 0 {
+		log.Printf("Transfers: Limited to %d bytes per second per request", rate)
+		s3Server.SetMaxBandwidthPerRequest(rate)
+	}
+	s3Server.SetListBackendFallback(*listBackendFallback)
+	s3Server.SetListCacheTTL(*listCacheTTL)
+	if *negativeCacheTTL > 0 {
+		log.Printf("S3: Remembering up to %d missing objects for %v", *negativeCacheSize, *negativeCacheTTL)
+		s3Server.SetNegativeCache(*negativeCacheSize, *negativeCacheTTL)
+	}
+	if *readThrough {
+		log.Printf("S3: Reading objects missing from the cache through to the backend")
+		s3Server.SetReadThrough(true)
+	}
+	if *headValidate {
+		log.Printf("S3: Validating HEAD of objects cached longer than %v ago on the backend", *headValidateAfter)
+		s3Server.SetHeadValidate(true, *headValidateAfter)
+	}
+	if *defaultCacheControl != "" || *immutableKeys != "" {
+		var immutable *regexp.Regexp
+		if *immutableKeys != "" {
+			var err error
+			if immutable, err = regexp.Compile(*immutableKeys); err != nil {
+				log.Fatalf("Invalid immutable keys pattern: %v", err)
+			}
+		}
+		log.Printf("S3: Sending Cache-Control %q, immutable keys: %q", *defaultCacheControl, *immutableKeys)
+		s3Server.SetCacheControl(*defaultCacheControl, immutable)
+	}
+	s3Server.SetAutoCreateBuckets(*autoCreateBuckets)
+	s3Server.SetPrefixDelete(*allowPrefixDelete)
+	if *passthroughRedirects {
+		if _, ok := client.(fs.Redirector); !ok {
+			log.Fatalf("The backend does not support -passthrough-redirects")
+		}
+		var baseURL *url.URL
+		if *passthroughRedirectsBaseURL != "" {
+			var err error
+			baseURL, err = url.Parse(*passthroughRedirectsBaseURL)
+			if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+				log.Fatalf("Invalid -passthrough-redirects-base-url: %s", *passthroughRedirectsBaseURL)
+			}
+		}
+		log.Printf("S3: Passing backend redirects through to clients")
+		s3Server.SetPassthroughRedirects(true, baseURL)
+	}
+	for bucket, contentTypes := range parseBucketContentTypes(*bucketContentTypes, bucketMap) {
+		log.Printf("Bucket %s: Allowed content types: %v", bucket, contentTypes)
+		s3Server.SetAllowedContentTypes(bucket, contentTypes)
+	}
 
 	s3AuthConfig := loadAccessKeys()
+	s3Server.SetContinuationTokenKey(s3AuthConfig.SecretKey)
+
+	if *syncInterval > 0 {
+		log.Printf("Resync: Re-syncing buckets every %v (shallow: %v)", *syncInterval, *syncShallow)
+		go bucketSync.RunPeriodic(s3Server.Buckets, *syncInterval, *syncShallow, nil)
+	}
+
+	if *cacheOptimiseInterval > 0 || *cacheVacuumInterval > 0 {
+		log.Printf("Cache: Optimising every %v, vacuuming every %v", *cacheOptimiseInterval, *cacheVacuumInterval)
+		go cache.RunMaintenance(db, *cacheOptimiseInterval, *cacheVacuumInterval, nil)
+	}
 
 	// Setup S3 API routes with auth
 	s3Router := mux.NewRouter()
@@ -174,7 +545,28 @@ func runServe(db cache.Cache, client fs.Fs, bucketMap map[string]interface{}) {
 	} else {
 		log.Printf("Read-Only: Write operations are disabled")
 	}
-	s3Handler := s3.AuthMiddleware(s3AuthConfig, s3Router)
+	s3Handler := s3.AuthMiddleware(s3.AuthMiddlewareConfig{
+		Credentials:  loadCredentials(s3AuthConfig),
+		MaxClockSkew: *maxClockSkew,
+	}, s3Router)
+
+	// Answer CORS preflight requests before the authentication, as browsers send them without credentials
+	corsConfig := s3.CORSConfig{MaxAge: *corsMaxAge}
+	for _, origin := range strings.Split(*corsAllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			corsConfig.AllowedOrigins = append(corsConfig.AllowedOrigins, origin)
+		}
+	}
+	if len(corsConfig.AllowedOrigins) > 0 {
+		log.Printf("CORS: Allowed origins: %v", corsConfig.AllowedOrigins)
+	}
+	s3Handler = s3.CORSMiddleware(corsConfig, s3Handler)
+
+	// Route virtual-hosted-style requests by the bucket in the host
+	if *s3Domain != "" {
+		log.Printf("S3: Serving virtual-hosted-style requests under %s", *s3Domain)
+	}
+	s3Handler = s3.VirtualHostMiddleware(*s3Domain, s3Handler)
 
 	// Setup main router
 	mainRouter := mux.NewRouter()
@@ -213,29 +605,63 @@ func runServe(db cache.Cache, client fs.Fs, bucketMap map[string]interface{}) {
 	// Mount authenticated S3 routes
 	mainRouter.PathPrefix("/").Handler(s3Handler)
 
-	// Wrap with access logging middleware
-	handler := access_log.AccessLogMiddleware(mainRouter)
+	// Wrap with compression, and access logging middleware recording the compressed size
+	handler := access_log.AccessLogMiddleware(compress.CompressMiddleware(mainRouter, *compressObjects), openAccessLog())
 
-	// Start server with or without TLS
-	if *httpOnly {
-		log.Printf("HTTP: Server ready! Listening on http://:%s", *httpPort)
-		log.Fatal(http.ListenAndServe(":"+*httpPort, handler))
-		return
+	// Start server on every address, with or without TLS
+	listenValue := *listenAddrs
+	if listenValue == "" {
+		listenValue = "tcp://:" + *httpPort
+	}
+	addresses, err := listen.Parse(listenValue, !*httpOnly)
+	if err != nil {
+		log.Fatalf("Invalid -listen: %v", err)
 	}
 
-	tlsCert, tlsKey := loadCerts()
-	log.Printf("TLS: Certificate: %s / %s", tlsCert, tlsKey)
-	if fingerprint, err := helpers.GetCertificateFingerprint(tlsCert); err == nil {
-		log.Printf("TLS: Fingerprint: %s", fingerprint)
+	var certFile, keyFile string
+	for _, address := range addresses {
+		if address.TLS && certFile == "" {
+			certFile, keyFile = loadCerts()
+			log.Printf("TLS: Certificate: %s / %s", certFile, keyFile)
+			if fingerprint, err := helpers.GetCertificateFingerprint(certFile); err == nil {
+				log.Printf("TLS: Fingerprint: %s", fingerprint)
+			}
+		}
 	}
-	log.Printf("HTTPS: Server ready! Listening on https://:%s", *httpPort)
-	log.Fatal(http.ListenAndServeTLS(":"+*httpPort, tlsCert, tlsKey, handler))
-}
 
-func runScan(client fs.Fs, db cache.Cache, bucketMap map[string]interface{}) {
-	sync := sync.New(client, db)
+	server := &http.Server{Handler: handler}
+
+	// Shut down gracefully on signals, which also removes the socket files
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Printf("Server: Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+	}()
 
-	if *rescan {
+	for _, address := range addresses {
+		if address.TLS {
+			log.Printf("HTTPS: Server ready! Listening on %s", address)
+		} else {
+			log.Printf("HTTP: Server ready! Listening on %s", address)
+		}
+	}
+	if err := listen.Serve(server, addresses, certFile, keyFile); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-shutdown
+}
+
+func runScan(db cache.Cache, bucketSync *sync.Sync, bucketMap map[string]interface{}) {
+	if *rescan && !*dryRun {
 		// Reset marker files
 		for bucket := range bucketMap {
 			if _, err := db.SetProcessed(bucket+"/", true, false); err != nil {
@@ -245,7 +671,7 @@ func runScan(client fs.Fs, db cache.Cache, bucketMap map[string]interface{}) {
 	}
 
 	for bucket := range bucketMap {
-		if err := sync.Sync(bucket); err != nil {
+		if err := bucketSync.Sync(bucket); err != nil {
 			log.Fatalf("Failed to perform initial sync for bucket %s: %v", bucket, err)
 		}
 	}
@@ -256,11 +682,29 @@ func runScan(client fs.Fs, db cache.Cache, bucketMap map[string]interface{}) {
 	}
 }
 
-func runClean(client fs.Fs, db cache.Cache, bucketMap map[string]interface{}) {
-	sync := sync.New(client, db)
+func runVerify(bucketSync *sync.Sync, bucketMap map[string]interface{}) {
+	var total sync.Discrepancies
+	for bucket := range bucketMap {
+		found, err := bucketSync.Verify(bucket)
+		if err != nil {
+			log.Fatalf("Failed to verify bucket %s: %v", bucket, err)
+		}
+		total.MissingFromCache += found.MissingFromCache
+		total.MissingOnBackend += found.MissingOnBackend
+		total.Mismatched += found.Mismatched
+	}
+
+	log.Printf("Verify: %d missing from cache, %d missing on backend, %d mismatched in all buckets",
+		total.MissingFromCache, total.MissingOnBackend, total.Mismatched)
+	if total.Total() > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
 
+func runClean(bucketSync *sync.Sync, bucketMap map[string]interface{}) {
 	for bucket := range bucketMap {
-		if err := sync.Clean(bucket); err != nil {
+		if err := bucketSync.Clean(bucket); err != nil {
 			log.Fatalf("Failed to perform clean for bucket %s: %v", bucket, err)
 		}
 	}
@@ -269,75 +713,232 @@ func runClean(client fs.Fs, db cache.Cache, bucketMap map[string]interface{}) {
 	os.Exit(0)
 }
 
+func runInventory(db cache.Cache, bucketMap map[string]interface{}) {
+	for bucket := range bucketMap {
+		manifest, err := inventory.Export(db, bucket, *inventoryDir, inventory.Options{
+			Gzip:        *inventoryGzip,
+			RowsPerFile: *inventoryRows,
+			ETag:        s3.ETag,
+		})
+		if err != nil {
+			log.Fatalf("Failed to export inventory for bucket %s: %v", bucket, err)
+		}
+		log.Printf("Inventory: Exported %d files for bucket %s", len(manifest.Files), bucket)
+	}
+	log.Printf("Inventory: Completed export to %s", *inventoryDir)
+	os.Exit(0)
+}
+
 func main() {
 	log.SetOutput(os.Stderr)
 	flag.Parse()
 
+	// The configuration file overrides the environment but not the command line
+	if *configFile != "" {
+		if err := config.Load(*configFile, flag.CommandLine); err != nil {
+			log.Fatalf("Invalid configuration file %s: %v", *configFile, err)
+		}
+	}
+
 	if *help {
 		usage()
 	}
 
-	if *buckets == "" {
-		log.Fatal("Bucket list is required (use -buckets flag or BUCKETS environment variable)")
+	if err := access_log.SetFormat(*logFormat); err != nil {
+		log.Fatalf("Invalid log format: %v", err)
+	}
+
+	*autoCreateBuckets = *autoCreateBuckets || *allowBucketOps
+
+	if *buckets == "" && !*autoCreateBuckets {
+		log.Fatal("Bucket list is required (use -buckets flag, BUCKETS environment variable or buckets option of the configuration file)")
 	}
 	if *persistDir == "" {
-		log.Fatal("Persist directory is required (use -persist-dir flag or PERSIST_DIR environment variable)")
+		log.Fatal("Persist directory is required (use -persist-dir flag, PERSIST_DIR environment variable or persist-dir option of the configuration file)")
 	}
 
-	// Validate that either WebDAV or local path is configured, but not both
-	if *webdavURL != "" && *localPath != "" {
-		log.Fatal("Cannot use both WebDAV and local filesystem - choose one")
+	dirPerm, err := parseMode(*dirMode)
+	if err != nil {
+		log.Fatalf("Invalid directory mode: %v", err)
 	}
-	if *webdavURL == "" && *localPath == "" {
-		log.Fatal("Either WebDAV URL or local path is required")
+	filePerm, err := parseMode(*fileMode)
+	if err != nil {
+		log.Fatalf("Invalid file mode: %v", err)
+	}
+
+	// Validate that exactly one of WebDAV, local path or S3 is configured
+	backends := 0
+	for _, backend := range []string{*webdavURL, *localPath, *s3Endpoint} {
+		if backend != "" {
+			backends++
+		}
+	}
+	if backends > 1 {
+		log.Fatal("Cannot use more than one of WebDAV, local filesystem and S3 - choose one")
+	}
+	if backends == 0 {
+		log.Fatal("Either WebDAV URL, local path or S3 endpoint is required")
 	}
 
 	// Initialize filesystem client
 	var client fs.Fs
-	var err error
 
 	if *localPath != "" {
 		log.Printf("Starting S3-to-Local bridge server...")
-		client, err = fs.NewLocalFs(*localPath)
+		client, err = fs.NewLocalFsWithOptions(*localPath, fs.LocalOptions{
+			DirMode:       dirPerm,
+			DurableWrites: *durableWrites,
+		})
 		if err != nil {
 			log.Fatalf("Failed to create local filesystem: %v", err)
 		}
+	} else if *s3Endpoint != "" {
+		if *s3Bucket == "" {
+			log.Fatal("S3 bucket is required")
+		}
+		log.Printf("Starting S3-to-S3 bridge server...")
+		client, err = fs.NewS3Fs(*s3Endpoint, *s3AccessKey, *s3SecretKey, *s3Bucket)
+		if err != nil {
+			log.Fatalf("Failed to create S3 client: %v", err)
+		}
 	} else {
 		if *webdavUser == "" || *webdavPassword == "" {
 			log.Fatal("WebDAV username and password are required")
 		}
 		log.Printf("Starting S3-to-WebDAV bridge server...")
-		client, err = fs.NewWebDAVFs(*webdavURL, *webdavUser, *webdavPassword, *webdavInsecure)
+		preflightPath := *webdavPreflight
+		if preflightPath == "none" {
+			preflightPath = ""
+		}
+		client, err = fs.NewWebDAVFs(*webdavURL, *webdavUser, *webdavPassword, *webdavInsecure, *webdavTimeout, fs.RetryPolicy{
+			MaxAttempts:    *webdavRetries + 1,
+			InitialBackoff: *webdavBackoff,
+			MaxBackoff:     10 * time.Second,
+		}, preflightPath)
 		if err != nil {
 			log.Fatalf("Failed to create WebDAV client: %v", err)
 		}
+
+		var fallbacks []fs.Fs
+		for _, fallbackURL := range strings.Split(*webdavFallbacks, ",") {
+			if fallbackURL = strings.TrimSpace(fallbackURL); fallbackURL == "" {
+				continue
+			}
+			fallback, err := fs.NewWebDAVFs(fallbackURL, *webdavUser, *webdavPassword, *webdavInsecure, *webdavTimeout, fs.RetryPolicy{
+				MaxAttempts:    *webdavRetries + 1,
+				InitialBackoff: *webdavBackoff,
+				MaxBackoff:     10 * time.Second,
+			}, preflightPath)
+			if err != nil {
+				log.Fatalf("Failed to create fallback WebDAV client: %v", err)
+			}
+			fallbacks = append(fallbacks, fallback)
+		}
+		if len(fallbacks) > 0 {
+			log.Printf("WebDAV: Failing reads over to %d fallback servers", len(fallbacks))
+			failover := fs.NewFailoverFs(client, fallbacks...)
+			if *webdavHealth > 0 {
+				go failover.RunHealthCheck("/", *webdavHealth, nil)
+			}
+			client = failover
+		}
 	}
 
-	// Parse bucket list into map
+	// Parse bucket list into map, with the backend prefixes of the mapped buckets
 	bucketMap := make(map[string]interface{})
+	bucketPrefixes := make(map[string]string)
 	for _, bucket := range strings.Split(*buckets, ",") {
-		if bucket = strings.TrimSpace(bucket); bucket != "" {
-			bucketMap[bucket] = struct{}{}
+		bucket, prefix, mapped := strings.Cut(strings.TrimSpace(bucket), "=")
+		if bucket = strings.TrimSpace(bucket); bucket == "" {
+			continue
+		}
+		bucketMap[bucket] = struct{}{}
+		if mapped {
+			prefix, err := fs.CleanBackendPrefix(prefix)
+			if err != nil {
+				log.Fatalf("Invalid mapping of bucket %s: %v", bucket, err)
+			}
+			bucketPrefixes[bucket] = prefix
+			log.Printf("Bucket %s: Stored under backend prefix %s/", bucket, prefix)
 		}
 	}
 	log.Printf("Buckets: %v", getMapKeys(bucketMap))
 
+	if len(bucketPrefixes) > 0 {
+		client = fs.NewMappedFs(client, bucketPrefixes)
+	}
+
+	switch *backendLayout {
+	case fs.LayoutFlat:
+	case fs.LayoutHashed:
+		log.Printf("Backend: Storing files in the hashed layout")
+		client = fs.NewHashedFs(client)
+	default:
+		log.Fatalf("Invalid backend layout: %s", *backendLayout)
+	}
+
+	if *aliasWrites != s3.AliasWritesReject && *aliasWrites != s3.AliasWritesRedirect {
+		log.Fatalf("Invalid alias writes policy: %s", *aliasWrites)
+	}
+	if *writeConflicts != s3.WriteConflictsLastWriteWins && *writeConflicts != s3.WriteConflictsReject {
+		log.Fatalf("Invalid write conflicts policy: %s", *writeConflicts)
+	}
+	switch *etagScanMode {
+	case s3.ETagScanModeSynthetic, s3.ETagScanModeMD5Lazy, s3.ETagScanModeMD5Eager:
+	default:
+		log.Fatalf("Invalid ETag scan mode: %s", *etagScanMode)
+	}
+
 	// Create database cache
-	db, err := cache.NewCacheDB(filepath.Join(*persistDir, "metadata3.db"))
+	db, err := cache.NewCacheDBWithOptions(filepath.Join(*persistDir, "metadata3.db"), cache.Options{
+		BusyTimeout:  *dbBusyTimeout,
+		MaxOpenConns: *dbMaxConns,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize database cache: %v", err)
 	}
 
+	// Restore buckets created with the CreateBucket request
+	if *autoCreateBuckets {
+		created, err := db.ListBuckets()
+		if err != nil {
+			log.Fatalf("Failed to load created buckets: %v", err)
+		}
+		for _, bucket := range created {
+			bucketMap[bucket] = struct{}{}
+		}
+		log.Printf("Buckets: Created with the API: %v", created)
+	}
+
+	if *dryRun && !*clean && !*rescan {
+		log.Fatalf("Cannot use -dry-run without -clean or -rescan")
+	}
+
+	// Shared by the initial scan and the periodic resync to report the last sync time
+	bucketSync := sync.New(client, db)
+	bucketSync.SetConcurrency(*syncConcurrency, *syncBatchSize)
+	bucketSync.SetDryRun(*dryRun)
+	bucketSync.SetComputeMD5(*etagScanMode == s3.ETagScanModeMD5Eager)
+
+	// Verify before the scan would bring the cache up to date
+	if *verify {
+		runVerify(bucketSync, bucketMap)
+	}
+
 	// Perform sync
 	if *scan {
-		runScan(client, db, bucketMap)
+		runScan(db, bucketSync, bucketMap)
 	}
 	if *clean {
-		if *readOnly {
+		if *readOnly && !*dryRun {
 			log.Fatalf("Cannot use -clean in read-only mode")
 		}
-		runClean(client, db, bucketMap)
+		runClean(bucketSync, bucketMap)
+	}
+
+	if *inventoryDir != "" {
+		runInventory(db, bucketMap)
 	}
 
-	runServe(db, client, bucketMap)
+	runServe(db, client, bucketSync, bucketMap, filePerm)
 }